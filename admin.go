@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// adminAuthMiddleware only allows requests carrying the admin token (ADMIN_TOKEN), either as a
+// bearer token or as the password of HTTP basic auth (so the pages work in a browser)
+func (s *Server) adminAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		method, ok := s.adminCredential(r)
+		if !ok {
+			s.logAdminAction(r, "admin.auth", r.URL.Path, false)
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			s.respondError(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		setAuditCredential(r, method)
+
+		next(w, r)
+	}
+}
+
+// adminCredential checks the request's admin credential and describes how it was sent,
+// either "bearer" or "basic:<username>", without ever including the secret itself. Admin
+// routes are locked when no token is configured.
+func (s *Server) adminCredential(r *http.Request) (string, bool) {
+	if s.config.AdminToken == "" {
+		return "", false
+	}
+
+	var credential, method string
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		credential, method = token, "bearer"
+	} else if username, password, ok := r.BasicAuth(); ok {
+		credential, method = password, "basic:"+username
+	} else {
+		return "", false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(credential), []byte(s.config.AdminToken)) != 1 {
+		return "", false
+	}
+	return method, true
+}
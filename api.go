@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// APIVersion is the current version of the JSON API.
+//
+// Compatibility contract: within a version, fields are never removed or renamed and their
+// types never change; new fields and endpoints may be added at any time. Breaking changes
+// ship under a new version, and older versions keep their behavior. Clients select a version
+// with the /api/v1/ or /api/v2/ path prefix, or with an `API-Version: 1` or `API-Version: 2`
+// header on unversioned /api/ paths (defaulting to v1). Today v2 matches v1 except that its
+// counter increment endpoint accepts a delta body.
+const APIVersion = "v1"
+
+// apiVersionKey is the context key holding the API version serving a request
+type apiVersionKey struct{}
+
+// Stats represents a summary of the site's counters and content. Counters whose counting
+// is disabled are left out.
+type Stats struct {
+	WebhookCount     *int  `json:"webhookCount,omitempty"`
+	PageViewCount    *int  `json:"pageViewCount,omitempty"`
+	TotalClicks      *int  `json:"totalClicks,omitempty"`
+	QuoteCount       int64 `json:"quoteCount"`
+	ConnectedClients int   `json:"connectedClients"`
+}
+
+// newAPIMux returns the router serving the JSON API for the given version, with paths relative to its prefix
+func (s *Server) newAPIMux(version string) http.Handler {
+	mux := s.apiRoutes(version)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", version)
+		ctx := context.WithValue(r.Context(), apiVersionKey{}, version)
+		mux.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// apiRoutes registers the JSON API's routes for version, relative to its /api/<version> prefix
+func (s *Server) apiRoutes(version string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /counters", s.listCountersHandler)
+	mux.HandleFunc("POST /counters", s.writableMiddleware(s.scopedRateLimitMiddleware("counters.create", s.maxBytesMiddleware(s.createCounterHandler, maxFormBytes), 5)))
+	mux.HandleFunc("GET /counters/{name}", s.namedCounterHandler)
+	mux.HandleFunc("GET /counters/stream", s.counterStreamHandler)
+	mux.HandleFunc("GET /counters/{name}/highwater", s.highWaterHandler)
+	mux.HandleFunc("GET /counters/{name}/velocity", s.counterVelocityHandler)
+	mux.HandleFunc("GET /counters/{name}/forecast", s.counterForecastHandler)
+	mux.HandleFunc("GET /namespaces", s.listNamespacesHandler)
+	mux.HandleFunc("GET /namespaces/{ns}/counters", s.namespaceCountersHandler)
+	mux.HandleFunc("POST /counters/{name}/decrement", s.writableMiddleware(s.counterRateLimit(s.namedCounterDecrementHandler)))
+	mux.HandleFunc("POST /counters/merge", s.adminAuthMiddleware(s.maxBytesMiddleware(s.mergeCountersHandler, maxFormBytes)))
+	mux.HandleFunc("POST /counters/{name}/clone", s.adminAuthMiddleware(s.maxBytesMiddleware(s.cloneCounterHandler, maxFormBytes)))
+	mux.HandleFunc("GET /quotes", s.quotesAPIHandler)
+	mux.HandleFunc("GET /quotes/since", s.quotesSinceHandler)
+	mux.HandleFunc("POST /quotes/{id}/react", s.writableMiddleware(s.scopedRateLimitMiddleware("quotes.react", s.maxBytesMiddleware(s.reactHandler, maxFormBytes), quoteReactionsPerMinute)))
+	mux.HandleFunc("GET /stats", s.statsHandler)
+	mux.HandleFunc("GET /search", s.requireFeature(searchEnabled, s.searchHandler))
+	mux.HandleFunc("GET /repos", s.reposHandler)
+	mux.HandleFunc("GET /repos/languages", s.repoLanguagesHandler)
+	mux.HandleFunc("GET /analytics/pages", s.pageViewsHandler)
+	mux.HandleFunc("GET /analytics/countries", s.countryViewsHandler)
+	mux.HandleFunc("GET /version", s.versionHandler)
+
+	if version == "v2" {
+		mux.HandleFunc("POST /counters/{name}/increment", s.writableMiddleware(s.counterRateLimit(s.maxBytesMiddleware(s.namedCounterDeltaIncrementHandler, maxFormBytes))))
+	} else {
+		mux.HandleFunc("POST /counters/{name}/increment", s.writableMiddleware(s.counterRateLimit(s.namedCounterIncrementHandler)))
+	}
+	return mux
+}
+
+// versionMiddleware routes unversioned API requests to the version named in the API-Version header, defaulting to v1
+func (s *Server) versionMiddleware(v1, v2 http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimPrefix(strings.ToLower(r.Header.Get("API-Version")), "v") {
+		case "", "1":
+			v1.ServeHTTP(w, r)
+		case "2":
+			v2.ServeHTTP(w, r)
+		default:
+			s.apiError(w, r, http.StatusBadRequest, "Unsupported API-Version header")
+		}
+	})
+}
+
+// apiError writes a JSON error response including the API version serving the request
+func (s *Server) apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	body := newErrorResponse(r, status, message)
+	if body.Version == "" {
+		body.Version = APIVersion
+	}
+	respondJSON(w, status, body)
+}
+
+// statsHandler returns a summary of the site's counters and content
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	pageViews, err := s.pageViews.TotalPageViews(ctx)
+	if err != nil {
+		s.dbError(r.Context(), "api", "total page views", err)
+	}
+	webhookCount, totalClicks := s.getCounterValues(ctx)
+
+	quoteCount, err := s.quotes.CountQuotes(ctx)
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "api", "count quotes", err), "Error counting quotes")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, Stats{
+		WebhookCount:     optionalCount(s.config.Counting.Webhook, webhookCount),
+		PageViewCount:    optionalCount(s.config.Counting.PageViews, pageViews),
+		TotalClicks:      optionalCount(s.config.Counting.TotalClicks, totalClicks),
+		QuoteCount:       quoteCount,
+		ConnectedClients: s.hub.ClientCount(),
+	})
+}
+
+// checkOpenAPI reports an error if the OpenAPI document in fsys isn't valid JSON, so a bad edit
+// to the hand-written spec stops the server starting instead of breaking generated clients
+func checkOpenAPI(fsys fs.FS) error {
+	data, err := fs.ReadFile(fsys, "openapi.json")
+	if err != nil {
+		return err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing openapi.json: %w", err)
+	}
+	return nil
+}
+
+// openAPIHandler serves the OpenAPI document describing the JSON API
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFileFS(w, r, s.config.assetsFS(), "openapi.json")
+}
+
+// apiDocsHandler renders Swagger UI for the OpenAPI document
+func (s *Server) apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.renderTemplate(w, "swagger.html", nil)
+	if err != nil {
+		s.log(r.Context(), "api").Error("rendering API docs page", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error rendering page")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-openapi/spec"
+)
+
+// openAPIDocument is the part of an OpenAPI 3 document the tests check. go-openapi/spec models
+// Swagger 2.0, whose path, operation, parameter, and response objects OpenAPI 3 keeps.
+type openAPIDocument struct {
+	OpenAPI string     `json:"openapi"`
+	Info    spec.Info  `json:"info"`
+	Paths   spec.Paths `json:"paths"`
+}
+
+// openAPIPathParam matches a path template parameter, e.g. {name}
+var openAPIPathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPIOperations returns a path item's operations by HTTP method
+func openAPIOperations(item spec.PathItem) map[string]*spec.Operation {
+	operations := map[string]*spec.Operation{}
+	for method, op := range map[string]*spec.Operation{
+		http.MethodGet:    item.Get,
+		http.MethodPost:   item.Post,
+		http.MethodPut:    item.Put,
+		http.MethodPatch:  item.Patch,
+		http.MethodDelete: item.Delete,
+	} {
+		if op != nil {
+			operations[method] = op
+		}
+	}
+	return operations
+}
+
+// collectRefs returns every "$ref" value in a decoded JSON document
+func collectRefs(v any) []string {
+	var refs []string
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				refs = append(refs, ref)
+				continue
+			}
+			refs = append(refs, collectRefs(value)...)
+		}
+	case []any:
+		for _, value := range v {
+			refs = append(refs, collectRefs(value)...)
+		}
+	}
+	return refs
+}
+
+// resolveRef returns the value a local "$ref" points to within doc
+func resolveRef(doc map[string]any, ref string) (any, error) {
+	r, err := spec.NewRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := r.GetPointer().Get(doc)
+	return value, err
+}
+
+// fetchOpenAPI returns the OpenAPI document as served, decoded both generically and with
+// go-openapi/spec's types
+func fetchOpenAPI(t *testing.T) (map[string]any, openAPIDocument) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	(&Server{}).openAPIHandler(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("openapi.json isn't JSON: %v", err)
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding openapi.json: %v", err)
+	}
+	return raw, doc
+}
+
+func TestOpenAPIDocumentIsValid(t *testing.T) {
+	raw, doc := fetchOpenAPI(t)
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.0.") {
+		t.Errorf("openapi = %q, want 3.0.x", doc.OpenAPI)
+	}
+	if doc.Info.Title == "" || doc.Info.Version == "" {
+		t.Errorf("info = %+v, want a title and version", doc.Info.InfoProps)
+	}
+	if len(doc.Paths.Paths) == 0 {
+		t.Fatal("no paths described")
+	}
+
+	for _, ref := range collectRefs(raw) {
+		if !strings.HasPrefix(ref, "#/") {
+			t.Errorf("$ref %q isn't local to the document", ref)
+			continue
+		}
+		if _, err := resolveRef(raw, ref); err != nil {
+			t.Errorf("$ref %q doesn't resolve: %v", ref, err)
+		}
+	}
+
+	operationIDs := map[string]string{}
+	for _, path := range slices.Sorted(maps.Keys(doc.Paths.Paths)) {
+		item := doc.Paths.Paths[path]
+		if !strings.HasPrefix(path, "/") {
+			t.Errorf("path %q doesn't start with /", path)
+		}
+		operations := openAPIOperations(item)
+		if len(operations) == 0 {
+			t.Errorf("%s has no operations", path)
+		}
+
+		for method, op := range operations {
+			name := method + " " + path
+			if op.ID == "" {
+				t.Errorf("%s has no operationId", name)
+			} else if other, ok := operationIDs[op.ID]; ok {
+				t.Errorf("%s reuses operationId %q from %s", name, op.ID, other)
+			} else {
+				operationIDs[op.ID] = name
+			}
+			if op.Summary == "" {
+				t.Errorf("%s has no summary", name)
+			}
+
+			if op.Responses == nil || len(op.Responses.StatusCodeResponses) == 0 {
+				t.Errorf("%s has no responses", name)
+				continue
+			}
+			for code, response := range op.Responses.StatusCodeResponses {
+				if code < 100 || code > 599 {
+					t.Errorf("%s has response status %d", name, code)
+				}
+				if response.Ref.String() == "" && response.Description == "" {
+					t.Errorf("%s response %d has neither a description nor a $ref", name, code)
+				}
+			}
+
+			// Every template parameter is declared on the path or the operation
+			declared := map[string]bool{}
+			for _, param := range slices.Concat(item.Parameters, op.Parameters) {
+				if ref := param.Ref.String(); ref != "" {
+					resolved, err := resolveRef(raw, ref)
+					if err != nil {
+						continue // reported above
+					}
+					param.Name, _ = resolved.(map[string]any)["name"].(string)
+					param.In, _ = resolved.(map[string]any)["in"].(string)
+				}
+				if param.In == "path" {
+					declared[param.Name] = true
+				}
+			}
+			for _, match := range openAPIPathParam.FindAllStringSubmatch(path, -1) {
+				if !declared[match[1]] {
+					t.Errorf("%s doesn't declare path parameter %q", name, match[1])
+				}
+			}
+		}
+	}
+}
+
+func TestOpenAPIDescribesRegisteredRoutes(t *testing.T) {
+	_, doc := fetchOpenAPI(t)
+	s := newTestServer(t)
+	site := s.routes()
+
+	for path, item := range doc.Paths.Paths {
+		target := openAPIPathParam.ReplaceAllString(path, "x")
+		for method := range openAPIOperations(item) {
+			if path == "/" {
+				// The home page is served from the site root rather than under /api
+				if _, pattern := site.Handler(httptest.NewRequest(method, target, nil)); pattern == "" {
+					t.Errorf("%s %s isn't routed", method, path)
+				}
+				continue
+			}
+			for _, version := range []string{"v1", "v2"} {
+				if _, pattern := s.apiRoutes(version).Handler(httptest.NewRequest(method, target, nil)); pattern == "" {
+					t.Errorf("%s /api/%s%s isn't routed", method, version, path)
+				}
+			}
+		}
+	}
+}
+
+func TestOpenAPIServedAtSiteRoot(t *testing.T) {
+	w := serveRequest(newTestServer(t).routes(), http.MethodGet, "/openapi.json", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("GET /openapi.json isn't JSON: %v", err)
+	}
+	for _, path := range []string{"/counters", "/counters/{name}", "/counters/{name}/increment", "/quotes", "/repos", "/stats"} {
+		if _, ok := doc.Paths.Paths[path]; !ok {
+			t.Errorf("%s isn't described", path)
+		}
+	}
+}
+
+func TestCheckOpenAPI(t *testing.T) {
+	if err := checkOpenAPI(embeddedAssets); err != nil {
+		t.Errorf("checking the shipped openapi.json: %v", err)
+	}
+	broken := fstest.MapFS{"openapi.json": {Data: []byte(`{"openapi": "3.0.3",}`)}}
+	if err := checkOpenAPI(broken); err == nil {
+		t.Error("checking openapi.json with a trailing comma succeeded")
+	}
+}
+
+func TestAPIVersionHeader(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	if err := s.counters.CreateCounter(t.Context(), Counter{ID: "likes"}); err != nil {
+		t.Fatal(err)
+	}
+	h := s.routes()
+	// request sends a request to an unversioned API path with the given API-Version header, if any
+	request := func(method, target, body, version string) *httptest.ResponseRecorder {
+		r := newJSONRequest(method, target, body)
+		if version != "" {
+			r.Header.Set("API-Version", version)
+		}
+		return serve(h, r)
+	}
+
+	// Only v2's increment takes a delta, so the count shows which version handled each request
+	tests := []struct {
+		version     string
+		wantVersion string
+		wantCount   int
+	}{
+		{"", "v1", 1},
+		{"1", "v1", 2},
+		{"2", "v2", 5},
+		{"V2", "v2", 8},
+	}
+	for _, tt := range tests {
+		w := request(http.MethodPost, "/api/counters/likes/increment", `{"delta":3}`, tt.version)
+		var counter Counter
+		if err := json.NewDecoder(w.Body).Decode(&counter); err != nil || w.Code != http.StatusOK || counter.Count != tt.wantCount {
+			t.Errorf("increment with API-Version %q = %d %+v (%v), want count %d", tt.version, w.Code, counter, err, tt.wantCount)
+		}
+		if got := w.Header().Get("API-Version"); got != tt.wantVersion {
+			t.Errorf("increment with API-Version %q answered with API-Version %q, want %q", tt.version, got, tt.wantVersion)
+		}
+	}
+
+	// Errors name the version that served them, or the current one when the header is unsupported
+	errorTests := []struct {
+		target, version string
+		wantStatus      int
+		wantVersion     string
+	}{
+		{"/api/counters/missing", "2", http.StatusNotFound, "v2"},
+		{"/api/counters/likes", "3", http.StatusBadRequest, APIVersion},
+		{"/api/counters/likes", "latest", http.StatusBadRequest, APIVersion},
+	}
+	for _, tt := range errorTests {
+		w := request(http.MethodGet, tt.target, "", tt.version)
+		var body ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != tt.wantStatus || body.Status != tt.wantStatus || body.Version != tt.wantVersion {
+			t.Errorf("GET %s with API-Version %q = %d %+v (%v), want a %s JSON %d", tt.target, tt.version, w.Code, body, err, tt.wantVersion, tt.wantStatus)
+		}
+	}
+}
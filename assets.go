@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// loadAssetsDir reads ASSETS_DIR, which switches to reading assets from that directory, and
+// RELOAD_TEMPLATES, which does the same for the working directory. It returns the directory
+// and whether assets are read from disk at all.
+func loadAssetsDir(env *envReader) (string, bool) {
+	if dir := env.string("ASSETS_DIR", ""); dir != "" {
+		return dir, true
+	}
+	return ".", env.bool("RELOAD_TEMPLATES", false)
+}
+
+// assetsFS returns the filesystem containing the templates and static directories: AssetsDir
+// while reloading templates, which DEV_MODE also turns on, and otherwise the embedded copies
+func (c Config) assetsFS() fs.FS {
+	if c.ReloadTemplates {
+		return os.DirFS(c.AssetsDir)
+	}
+	return embeddedAssets
+}
+
+// staticFS returns the static directory of assets
+func staticFS(assets fs.FS) fs.FS {
+	sub, err := fs.Sub(assets, "static")
+	if err != nil {
+		panic(err) // only possible for an invalid path
+	}
+	return sub
+}
+
+// templateFuncs are the helper functions available to all templates
+var templateFuncs = template.FuncMap{
+	"assetURL":     assetURL,
+	"relTime":      relTime,
+	"truncate":     truncate,
+	"previewQuote": previewQuote,
+	"pluralize":    pluralize,
+	"commaNumber":  commaNumber,
+	"reactionEmoji": func() []string {
+		return reactionEmoji
+	},
+}
+
+// pages are the pages in templates/pages, each rendered inside the base layout by render. Every
+// page listed is parsed when the server starts, so one that's broken stops it starting rather than
+// failing when it's first visited.
+var pages = []string{"home", "404", "500", "contact"}
+
+// baseLayout is the template in templates/layouts that pages are rendered inside. It executes the
+// page's "content" template, and the page can also define "title", "head", and "scripts".
+const baseLayout = "base.html"
+
+// parsedTemplates are the standalone templates in templates/ and the pages, each in its own copy
+// of the layouts so their blocks don't overwrite one another
+type parsedTemplates struct {
+	*template.Template
+	pages map[string]*template.Template
+}
+
+// parseTemplates parses all HTML templates in fsys, and every page into a clone of the layouts
+func parseTemplates(fsys fs.FS) (*parsedTemplates, error) {
+	standalone, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	layouts, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, "templates/layouts/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &parsedTemplates{Template: standalone, pages: make(map[string]*template.Template, len(pages))}
+	for _, page := range pages {
+		tmpl, err := layouts.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.ParseFS(fsys, "templates/pages/"+page+".html"); err != nil {
+			return nil, fmt.Errorf("parsing page %s: %w", page, err)
+		}
+		if tmpl.Lookup("content") == nil {
+			return nil, fmt.Errorf("page %s doesn't define a content template", page)
+		}
+		parsed.pages[page] = tmpl
+	}
+	return parsed, nil
+}
+
+// ExecutePage renders page inside the base layout
+func (t *parsedTemplates) ExecutePage(w io.Writer, page string, data any) error {
+	tmpl, ok := t.pages[page]
+	if !ok {
+		return fmt.Errorf("no page named %q", page)
+	}
+	return tmpl.ExecuteTemplate(w, baseLayout, data)
+}
+
+// templateSet executes named templates and pages. Templates parsed once are used in production.
+type templateSet interface {
+	ExecuteTemplate(w io.Writer, name string, data any) error
+	ExecutePage(w io.Writer, page string, data any) error
+}
+
+// reloadingTemplates reparses the templates in fsys before every render, so edits show up
+// without a restart
+type reloadingTemplates struct {
+	fsys fs.FS
+}
+
+func (t reloadingTemplates) ExecuteTemplate(w io.Writer, name string, data any) error {
+	tmpl, err := reparseTemplates(t.fsys)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteTemplate(w, name, data)
+}
+
+func (t reloadingTemplates) ExecutePage(w io.Writer, page string, data any) error {
+	tmpl, err := reparseTemplates(t.fsys)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecutePage(w, page, data)
+}
+
+// reparseTemplates reloads the static file fingerprints and parses the templates again
+func reparseTemplates(fsys fs.FS) (*parsedTemplates, error) {
+	loadFingerprinter(staticFS(fsys))
+
+	tmpl, err := parseTemplates(fsys)
+	if err != nil {
+		return nil, &templateParseError{err: err}
+	}
+	return tmpl, nil
+}
+
+// templateParseError is returned when reloaded templates don't parse
+type templateParseError struct {
+	err error
+}
+
+func (e *templateParseError) Error() string { return "parsing templates: " + e.err.Error() }
+func (e *templateParseError) Unwrap() error { return e.err }
+
+// loadTemplates parses the templates once, or returns a set that reparses them on every render
+// when reloading is enabled, so a broken template doesn't stop the server starting
+func loadTemplates(config Config) (templateSet, error) {
+	if config.ReloadTemplates {
+		return reloadingTemplates{fsys: config.assetsFS()}, nil
+	}
+	return parseTemplates(config.assetsFS())
+}
+
+// renderTemplate executes the named standalone template
+func (s *Server) renderTemplate(w io.Writer, name string, data any) error {
+	return s.templates.ExecuteTemplate(w, name, data)
+}
+
+// render executes page, one of pages, inside the base layout
+func (s *Server) render(w io.Writer, page string, data any) error {
+	return s.templates.ExecutePage(w, page, data)
+}
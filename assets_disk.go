@@ -0,0 +1,9 @@
+//go:build noembed
+
+package main
+
+import "os"
+
+// embeddedAssets reads templates, static files, and the OpenAPI spec from the working directory
+// when the binary is built with the noembed tag
+var embeddedAssets = os.DirFS(".")
@@ -0,0 +1,10 @@
+//go:build !noembed
+
+package main
+
+import "embed"
+
+// embeddedAssets holds the templates, static files, and OpenAPI spec baked into the binary
+//
+//go:embed templates static openapi.json
+var embeddedAssets embed.FS
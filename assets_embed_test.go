@@ -0,0 +1,39 @@
+//go:build !noembed
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestEmbeddedAssetsServeWithoutFiles runs from an empty directory, so the templates and static
+// files can only have come from the copies embedded in the binary
+func TestEmbeddedAssetsServeWithoutFiles(t *testing.T) {
+	robots, err := os.ReadFile("static/robots.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+
+	tmpl, err := parseTemplates(embeddedAssets)
+	if err != nil {
+		t.Fatalf("parsing the embedded templates: %v", err)
+	}
+	for _, page := range pages {
+		if tmpl.pages[page] == nil {
+			t.Errorf("the embedded templates have no %s page", page)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	http.FileServerFS(staticFS(embeddedAssets)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /robots.txt status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != string(robots) {
+		t.Errorf("GET /robots.txt = %q, want the embedded robots.txt %q", got, robots)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeAssetsDir creates a directory whose templates directory holds the given files, which may
+// be in subdirectories such as pages/
+func writeAssetsDir(t *testing.T, templates map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range templates {
+		path := filepath.Join(dir, "templates", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestAssetsDirOverridesEmbeddedAssets(t *testing.T) {
+	dir := writeAssetsDir(t, map[string]string{
+		"error.html":         "{{.Message}}",
+		"layouts/base.html":  `{{template "content" .}}`,
+		"pages/home.html":    `{{define "content"}}from ASSETS_DIR{{end}}`,
+		"pages/404.html":     `{{define "content"}}not found{{end}}`,
+		"pages/contact.html": `{{define "content"}}contact{{end}}`,
+		"pages/500.html":     `{{define "content"}}failed{{end}}`,
+	})
+	t.Setenv("ASSETS_DIR", dir)
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AssetsDir != dir || !config.ReloadTemplates {
+		t.Fatalf("LoadConfig() assets = %q, %v; want %q, true", config.AssetsDir, config.ReloadTemplates, dir)
+	}
+
+	tmpl, err := parseTemplates(config.assetsFS())
+	if err != nil {
+		t.Fatalf("parsing templates from ASSETS_DIR: %v", err)
+	}
+	var b strings.Builder
+	if err := tmpl.ExecutePage(&b, "home", nil); err != nil || b.String() != "from ASSETS_DIR" {
+		t.Errorf("home page rendered %q, %v; want the copy in ASSETS_DIR", b.String(), err)
+	}
+}
+
+func TestBrokenReloadedTemplateShowsParseError(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{})
+	config := defaultConfig()
+	config.AssetsDir, config.ReloadTemplates = writeAssetsDir(t, map[string]string{
+		"error.html":        "{{.Message}}",
+		"layouts/base.html": `{{template "content" .}}`,
+		"pages/home.html":   `{{define "content"}}{{.Name}{{end}}`,
+	}), true
+
+	// The broken template doesn't stop the server starting
+	h := newTestServerWithConfig(t, config).routes()
+
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("GET / status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	for _, want := range []string{"Template error", "home.html"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("GET / body = %q, want it to contain %q", w.Body, want)
+		}
+	}
+}
+
+func TestEveryPageIsParsed(t *testing.T) {
+	tests := []struct {
+		name, home, want string
+	}{
+		{"broken", `{{define "content"}}{{.Name}{{end}}`, "home.html"},
+		{"without content", `{{define "title"}}Home{{end}}`, "doesn't define a content template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeAssetsDir(t, map[string]string{
+				"error.html":        "{{.Message}}",
+				"layouts/base.html": `{{template "content" .}}`,
+				"pages/home.html":   tt.home,
+			})
+			if _, err := parseTemplates(os.DirFS(dir)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseTemplates() error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPagesRenderInsideBaseLayout(t *testing.T) {
+	dir := writeAssetsDir(t, map[string]string{
+		"error.html":         "{{.Message}}",
+		"layouts/base.html":  `<title>{{block "title" .}}Default{{end}}</title><main>{{template "content" .}}</main>`,
+		"pages/home.html":    `{{define "title"}}Home of {{.}}{{end}}{{define "content"}}Hello, {{.}}{{end}}`,
+		"pages/404.html":     `{{define "content"}}Not found{{end}}`,
+		"pages/contact.html": `{{define "content"}}Contact{{end}}`,
+		"pages/500.html":     `{{define "content"}}Failed{{end}}`,
+	})
+	tmpl, err := parseTemplates(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.ExecutePage(&b, "home", "Ada"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<title>Home of Ada</title><main>Hello, Ada</main>"; b.String() != want {
+		t.Errorf("home page = %q, want %q", b.String(), want)
+	}
+	if err := tmpl.ExecutePage(&b, "blog", nil); err == nil {
+		t.Error("rendering a page that doesn't exist succeeded")
+	}
+}
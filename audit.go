@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// auditBufferSize is how many entries can wait for the writer before new ones are dropped
+	auditBufferSize = 256
+	// auditCollectionBytes is the size of the capped audit_log collection
+	auditCollectionBytes = 16 << 20
+	// auditDefaultLimit and auditMaxLimit bound the number of entries returned by the audit API
+	auditDefaultLimit = 50
+	auditMaxLimit     = 500
+)
+
+// AuditEntry represents a state-changing request recorded in the audit log.
+// Request bodies are never recorded.
+type AuditEntry struct {
+	Timestamp  time.Time `bson:"timestamp" json:"timestamp"`
+	Method     string    `bson:"method" json:"method"`
+	Route      string    `bson:"route" json:"route"`
+	Status     int       `bson:"status" json:"status"`
+	IPHash     string    `bson:"ipHash" json:"ipHash"`
+	UserAgent  string    `bson:"userAgent" json:"userAgent"`
+	LatencyMS  float64   `bson:"latencyMs" json:"latencyMs"`
+	Credential string    `bson:"credential,omitempty" json:"credential,omitempty"`
+}
+
+// auditEntries queues request entries for the audit writer
+var auditEntries = make(chan AuditEntry, auditBufferSize)
+
+// auditInfoKey is the context key holding the auditInfo for a request
+type auditInfoKey struct{}
+
+// auditInfo collects details about a request that are only known inside the handler chain
+type auditInfo struct {
+	credential string
+}
+
+// setAuditCredential records which admin credential authorized the request
+func setAuditCredential(r *http.Request, credential string) {
+	if info, ok := r.Context().Value(auditInfoKey{}).(*auditInfo); ok {
+		info.credential = credential
+	}
+}
+
+// hashIP returns a short one-way hash of an IP address so the audit log doesn't store raw IPs
+func (s *Server) hashIP(ip string) string {
+	return hashIPWithSalt(s.config.IPHashSalt, ip)
+}
+
+// hashIPWithSalt returns a short one-way hash of ip mixed with salt
+func hashIPWithSalt(salt, ip string) string {
+	sum := sha256.Sum256([]byte(salt + ip))
+	return hex.EncodeToString(sum[:8])
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// isStateChanging reports whether the method can change server state
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// auditMiddleware queues an audit entry for every state-changing request
+func (s *Server) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.AuditEnabled || !isStateChanging(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		info := &auditInfo{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), auditInfoKey{}, info)))
+
+		entry := AuditEntry{
+			Timestamp:  start,
+			Method:     r.Method,
+			Route:      r.URL.Path,
+			Status:     recorder.status,
+			IPHash:     s.hashIP(s.getIPAddress(r)),
+			UserAgent:  r.UserAgent(),
+			LatencyMS:  float64(time.Since(start).Microseconds()) / 1000,
+			Credential: info.credential,
+		}
+		queueAuditEntry(entry)
+	})
+}
+
+// queueAuditEntry hands an entry to the audit writer, dropping it if the buffer is full
+// so that a slow database never holds up requests
+func queueAuditEntry(entry AuditEntry) {
+	select {
+	case auditEntries <- entry:
+	default:
+		componentLogger("audit").Warn("audit log buffer full, dropping entry", "entry", entry.Method+" "+entry.Route)
+	}
+}
+
+// ensureAuditCollection creates the capped audit_log collection if it doesn't exist
+func (s *Server) ensureAuditCollection(ctx context.Context) error {
+	err := s.db.CreateCollection(ctx, s.config.Collections.AuditLog, options.CreateCollection().
+		SetCapped(true).
+		SetSizeInBytes(auditCollectionBytes))
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceExists" {
+		return nil
+	}
+	return err
+}
+
+var (
+	// auditWriterStop asks the audit writer to flush the queue and exit
+	auditWriterStop = make(chan struct{})
+	// auditWriterDone is closed once the audit writer has exited
+	auditWriterDone = make(chan struct{})
+)
+
+// startAuditWriter writes queued request entries to MongoDB from a single goroutine
+func (s *Server) startAuditWriter() {
+	go func() {
+		defer close(auditWriterDone)
+		for {
+			select {
+			case entry := <-auditEntries:
+				s.writeAuditEntry(entry)
+			case <-auditWriterStop:
+				for {
+					select {
+					case entry := <-auditEntries:
+						s.writeAuditEntry(entry)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// writeAuditEntry saves a single request entry
+func (s *Server) writeAuditEntry(entry AuditEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.MongoWriteTimeout)
+	defer cancel()
+
+	if err := s.audit.store.InsertAuditEntry(ctx, entry); err != nil {
+		s.log(context.Background(), "audit").Error("writing audit log", "err", err)
+	}
+}
+
+// stopAuditWriter writes out any queued request entries and stops the writer, giving up when ctx is done
+func (s *Server) stopAuditWriter(ctx context.Context) {
+	if !s.config.AuditEnabled {
+		return
+	}
+
+	close(auditWriterStop)
+	select {
+	case <-auditWriterDone:
+	case <-ctx.Done():
+		componentLogger("audit").Error("timed out flushing the audit log")
+	}
+}
+
+// listAuditHandler returns the most recent request entries, optionally only those for one route
+func (s *Server) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", auditDefaultLimit)
+	if err != nil || limit > auditMaxLimit {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+	entries, err := s.audit.store.ListAuditEntries(ctx, r.URL.Query().Get("route"), limit)
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "audit", "list audit log", err), "Error listing audit log")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// auditSubscriberBuffer is how many events a stream subscriber may fall behind before events are dropped for it
+	auditSubscriberBuffer = 16
+	// auditStreamHeartbeat is how often an idle audit stream sends a comment to keep proxies from closing it
+	auditStreamHeartbeat = 30 * time.Second
+)
+
+// AuditEvent represents a privileged or security-relevant action
+type AuditEvent struct {
+	Action    string    `bson:"action" json:"action"`
+	Actor     string    `bson:"actor" json:"actor"`
+	Resource  string    `bson:"resource" json:"resource"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	Success   bool      `bson:"success" json:"success"`
+	RequestID string    `bson:"requestId,omitempty" json:"requestId,omitempty"`
+}
+
+// AuditHub fans audit events out to the connected audit stream subscribers
+type AuditHub struct {
+	subscribers map[chan AuditEvent]struct{}
+	broadcast   chan AuditEvent
+	register    chan chan AuditEvent
+	unregister  chan chan AuditEvent
+}
+
+// NewAuditHub creates a new audit hub
+func NewAuditHub() *AuditHub {
+	return &AuditHub{
+		subscribers: make(map[chan AuditEvent]struct{}),
+		broadcast:   make(chan AuditEvent, auditBufferSize),
+		register:    make(chan chan AuditEvent),
+		unregister:  make(chan chan AuditEvent),
+	}
+}
+
+// Run starts the audit hub's main loop
+func (h *AuditHub) Run() {
+	for {
+		select {
+		case sub := <-h.register:
+			h.subscribers[sub] = struct{}{}
+
+		case sub := <-h.unregister:
+			if _, ok := h.subscribers[sub]; ok {
+				delete(h.subscribers, sub)
+				close(sub)
+			}
+
+		case event := <-h.broadcast:
+			for sub := range h.subscribers {
+				select {
+				case sub <- event:
+				default:
+					// The subscriber is too slow; skip the event rather than block everyone else
+				}
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every audit event until it is passed to Unsubscribe
+func (h *AuditHub) Subscribe() chan AuditEvent {
+	sub := make(chan AuditEvent, auditSubscriberBuffer)
+	h.register <- sub
+	return sub
+}
+
+// Unsubscribe stops and closes a subscription
+func (h *AuditHub) Unsubscribe(sub chan AuditEvent) {
+	h.unregister <- sub
+}
+
+// AuditLog records audit events and streams them to admins
+type AuditLog struct {
+	hub   *AuditHub
+	store AuditStore
+	// queue holds events waiting to be saved, so a slow database never holds up the request
+	// that logged them
+	queue        chan AuditEvent
+	writeTimeout time.Duration
+	// stop asks Run to save what's queued and exit, and done is closed once it has
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewAuditLog creates an audit log saving events to store, giving each write writeTimeout
+func NewAuditLog(store AuditStore, writeTimeout time.Duration) *AuditLog {
+	return &AuditLog{
+		hub:          NewAuditHub(),
+		store:        store,
+		queue:        make(chan AuditEvent, auditBufferSize),
+		writeTimeout: writeTimeout,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Log records an event, tagging it with the request ID from ctx. The event is saved to the
+// audit log and broadcast to audit stream subscribers. It never blocks: events are dropped with
+// a warning if the writer or hub falls behind.
+func (a *AuditLog) Log(ctx context.Context, event AuditEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if event.RequestID == "" {
+		event.RequestID = requestIDFromContext(ctx)
+	}
+
+	select {
+	case a.queue <- event:
+	default:
+		componentLogger("audit").Warn("audit event buffer full, dropping event", "action", event.Action)
+	}
+
+	select {
+	case a.hub.broadcast <- event:
+	default:
+		componentLogger("audit").Warn("audit stream buffer full, dropping event", "action", event.Action)
+	}
+}
+
+// Run saves queued events one at a time until Stop is called, then saves the rest and returns
+func (a *AuditLog) Run() {
+	defer close(a.done)
+	for {
+		select {
+		case event := <-a.queue:
+			a.save(event)
+		case <-a.stop:
+			for {
+				select {
+				case event := <-a.queue:
+					a.save(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// save writes a single event to the store
+func (a *AuditLog) save(event AuditEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.writeTimeout)
+	defer cancel()
+
+	if err := a.store.InsertAuditEvent(ctx, event); err != nil {
+		componentLogger("audit").Error("saving audit event", "action", event.Action, "err", err)
+	}
+}
+
+// Stop saves the queued events and stops Run, giving up when ctx is done
+func (a *AuditLog) Stop(ctx context.Context) {
+	close(a.stop)
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		componentLogger("audit").Error("timed out saving audit events")
+	}
+}
+
+// logAdminAction records the outcome of an admin request
+func (s *Server) logAdminAction(r *http.Request, action, resource string, success bool) {
+	s.audit.Log(r.Context(), AuditEvent{
+		Action:   action,
+		Actor:    s.getIPAddress(r),
+		Resource: resource,
+		Success:  success,
+	})
+}
+
+// listAuditEventsHandler returns the most recent audit events, optionally only those with one action
+func (s *Server) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", auditDefaultLimit)
+	if err != nil || limit > auditMaxLimit {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+	events, err := s.audit.store.ListAuditEvents(ctx, r.URL.Query().Get("action"), limit)
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "audit", "list audit events", err), "Error listing audit events")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// auditStreamHandler streams audit events to the client as server-sent events
+func (s *Server) auditStreamHandler(w http.ResponseWriter, r *http.Request) {
+	// The stream outlives the server's write timeout, so lift it for this response
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.log(r.Context(), "audit").Error("clearing write deadline for audit stream", "err", err)
+	}
+
+	// Subscribe before responding, so a client that has seen the response start gets every
+	// event logged after it
+	sub := s.audit.hub.Subscribe()
+	defer s.audit.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		s.log(r.Context(), "audit").Error("audit stream doesn't support flushing", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(auditStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-s.stopping:
+			return
+
+		case event := <-sub:
+			data, err := json.Marshal(event)
+			if err != nil {
+				s.log(r.Context(), "audit").Error("encoding audit event", "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: audit\ndata: %s\n\n", data); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
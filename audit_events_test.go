@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextAuditEvent returns the next event on sub, failing the test if none arrives within a couple
+// of seconds
+func nextAuditEvent(t *testing.T, sub chan AuditEvent) AuditEvent {
+	t.Helper()
+	select {
+	case event := <-sub:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no audit event within 2s")
+		return AuditEvent{}
+	}
+}
+
+func TestAuditHubFansOutEvents(t *testing.T) {
+	h := NewAuditHub()
+	go h.Run()
+	first, second := h.Subscribe(), h.Subscribe()
+
+	h.broadcast <- AuditEvent{Action: "ban.create"}
+	for _, sub := range []chan AuditEvent{first, second} {
+		if event := nextAuditEvent(t, sub); event.Action != "ban.create" {
+			t.Errorf("subscriber got %+v, want the ban.create event", event)
+		}
+	}
+
+	// Unsubscribing closes only that subscription
+	h.Unsubscribe(first)
+	if _, ok := <-first; ok {
+		t.Error("subscription still open after unsubscribing")
+	}
+	h.broadcast <- AuditEvent{Action: "ban.delete"}
+	if event := nextAuditEvent(t, second); event.Action != "ban.delete" {
+		t.Errorf("remaining subscriber got %+v, want the ban.delete event", event)
+	}
+}
+
+// testAuditEventsAreSaved checks saving and listing audit events against s's store
+func testAuditEventsAreSaved(t *testing.T, s *Server) {
+	s.config.AdminToken = "secret"
+	go s.audit.Run()
+	h := s.routes()
+
+	// Events are saved without AUDIT_ENABLED, which only covers request entries
+	if w := serveRequest(h, http.MethodGet, "/admin/audit/events", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /admin/audit/events without a token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	s.audit.Log(context.Background(), AuditEvent{Action: "ban.create", Actor: "192.0.2.1", Resource: "198.51.100.7", Success: true})
+	// Stopping writes out whatever is still queued
+	s.audit.Stop(context.Background())
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/admin/audit/events", []string{"ban.create", "admin.auth"}},
+		{"/admin/audit/events?action=admin.auth", []string{"admin.auth"}},
+		{"/admin/audit/events?limit=1", []string{"ban.create"}},
+		{"/admin/audit/events?action=ban.delete", nil},
+	}
+	for _, tt := range tests {
+		r := newJSONRequest(http.MethodGet, tt.target, "")
+		r.Header.Set("Authorization", "Bearer secret")
+		w := serve(h, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d: %s", tt.target, w.Code, http.StatusOK, w.Body)
+		}
+		var events []AuditEvent
+		if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, event := range events {
+			actions = append(actions, event.Action)
+		}
+		if strings.Join(actions, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("GET %s actions = %q, want %q", tt.target, actions, tt.want)
+		}
+	}
+}
+
+func TestAuditEventsAreSaved(t *testing.T) {
+	testAuditEventsAreSaved(t, newTestServer(t))
+}
+
+func TestAuditEventsAreSavedMongo(t *testing.T) {
+	testAuditEventsAreSaved(t, newMongoTestServer(t))
+}
+
+func TestAuditStreamSendsEvents(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	go s.audit.hub.Run()
+	site := startTestSite(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, site.URL+"/admin/audit/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer secret")
+	resp, err := site.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /admin/audit/stream = %d %q, want a 200 event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	stream := &eventStream{Reader: bufio.NewReader(resp.Body), resp: resp}
+
+	// A failed admin login is streamed as it happens
+	if status := site.post(t, "/admin/features", nil); status != http.StatusUnauthorized {
+		t.Fatalf("POST /admin/features without a token = %d, want %d", status, http.StatusUnauthorized)
+	}
+	event := stream.next(t)
+	data, ok := strings.CutPrefix(event[len(event)-1], "data: ")
+	if len(event) != 2 || event[0] != "event: audit" || !ok {
+		t.Fatalf("stream sent %q, want an audit event", event)
+	}
+	var got AuditEvent
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("decoding %q: %v", data, err)
+	}
+	if got.Action != "admin.auth" || got.Resource != "/admin/features" || got.Success {
+		t.Errorf("streamed event = %+v, want the failed admin.auth on /admin/features", got)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// drainAuditEntries empties the audit writer's queue, returning what was in it
+func drainAuditEntries() []AuditEntry {
+	var entries []AuditEntry
+	for {
+		select {
+		case entry := <-auditEntries:
+			entries = append(entries, entry)
+		default:
+			return entries
+		}
+	}
+}
+
+func TestAuditMiddlewareRecordsStateChangingRequests(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	s.config.AuditEnabled = true
+	s.config.AdminToken = "secret"
+	h := s.auditMiddleware(s.routes())
+	drainAuditEntries()
+	t.Cleanup(func() { drainAuditEntries() })
+
+	serveRequest(h, http.MethodGet, "/", "", "203.0.113.9:1000")
+	serveRequest(h, http.MethodPost, "/increment", "", "203.0.113.9:1000")
+	r := newJSONRequest(http.MethodPost, "/admin/features", `{"apiDocs": true}`)
+	r.RemoteAddr = "203.0.113.9:1000"
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("User-Agent", "audit-test")
+	serve(h, r)
+
+	// Reads aren't recorded, and admin requests note the credential without the token
+	entries := drainAuditEntries()
+	if len(entries) != 2 {
+		t.Fatalf("queued %d entries, want 2: %+v", len(entries), entries)
+	}
+	increment, admin := entries[0], entries[1]
+	if increment.Method != http.MethodPost || increment.Route != "/increment" || increment.Status != http.StatusOK || increment.Credential != "" {
+		t.Errorf("increment entry = %+v, want POST /increment, 200, and no credential", increment)
+	}
+	if admin.Route != "/admin/features" || admin.Status != http.StatusOK || admin.Credential != "bearer" || admin.UserAgent != "audit-test" {
+		t.Errorf("admin entry = %+v, want /admin/features, 200, a bearer credential, and the user agent", admin)
+	}
+	for _, entry := range entries {
+		if entry.IPHash != s.hashIP("203.0.113.9") || entry.Timestamp.IsZero() || entry.LatencyMS < 0 {
+			t.Errorf("entry = %+v, want the hashed IP, a timestamp, and a latency", entry)
+		}
+	}
+
+	// The writer saves entries for GET /admin/audit to list, newest first
+	for _, entry := range entries {
+		s.writeAuditEntry(entry)
+	}
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/admin/audit", []string{"/admin/features", "/increment"}},
+		{"/admin/audit?route=/increment", []string{"/increment"}},
+		{"/admin/audit?limit=1", []string{"/admin/features"}},
+	}
+	for _, tt := range tests {
+		r := newJSONRequest(http.MethodGet, tt.target, "")
+		r.Header.Set("Authorization", "Bearer secret")
+		w := serve(s.routes(), r)
+		var listed []AuditEntry
+		if err := json.NewDecoder(w.Body).Decode(&listed); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v; want a list of entries", tt.target, w.Code, err)
+		}
+		var routes []string
+		for _, entry := range listed {
+			routes = append(routes, entry.Route)
+		}
+		if !slices.Equal(routes, tt.want) {
+			t.Errorf("GET %s routes = %q, want %q", tt.target, routes, tt.want)
+		}
+	}
+
+	// Nothing is queued with the audit log switched off
+	s.config.AuditEnabled = false
+	serveRequest(h, http.MethodPost, "/increment", "", "203.0.113.9:1000")
+	if entries := drainAuditEntries(); len(entries) != 0 {
+		t.Errorf("queued %+v with AUDIT_ENABLED off", entries)
+	}
+}
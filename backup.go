@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxImportBytes is the largest backup accepted by the import endpoint
+const maxImportBytes = 256 << 20 // 256MB
+
+// importBatchSize is how many documents are inserted at once during an import
+const importBatchSize = 500
+
+// duplicateKeyCode is the MongoDB error code for a write that would duplicate a unique key
+const duplicateKeyCode = 11000
+
+// errInvalidBackup is returned for an import that isn't a well-formed backup
+var errInvalidBackup = errors.New("invalid backup")
+
+// backupMarker is the line that starts each collection's section of a backup
+type backupMarker struct {
+	Collection string `json:"collection"`
+}
+
+// ImportResult reports what an import did with one collection's documents
+type ImportResult struct {
+	Inserted int `json:"inserted"`
+	// Skipped counts documents whose _id was already in the collection
+	Skipped int `json:"skipped"`
+}
+
+// backupCollections returns the collections included in a backup, in the order they're written
+func backupCollections(collections CollectionNames) []string {
+	return []string{collections.Counters, collections.Quotes, collections.CounterEvents, collections.PageViews, collections.PageViewCountries}
+}
+
+// exportHandler streams every backed up collection as newline-delimited JSON: a
+// {"collection":"name"} marker line, then one document per line in MongoDB extended JSON so
+// dates and IDs keep their types when imported
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	// A large database takes longer to send than the server's write timeout allows
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.log(r.Context(), "backup").Error("clearing write deadline for export", "err", err)
+	}
+
+	filename := fmt.Sprintf("backup-%s.ndjson", time.Now().UTC().Format(time.DateOnly))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	out := bufio.NewWriter(w)
+	for _, name := range backupCollections(s.config.Collections) {
+		if err := exportCollection(r.Context(), out, s.db.Collection(name)); err != nil {
+			// The response has likely started, so abort it rather than end a truncated backup
+			// that looks complete
+			s.dbError(r.Context(), "backup", "export "+name, err)
+			s.logAdminAction(r, "backup.export", name, false)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		s.log(r.Context(), "backup").Warn("sending export", "err", err)
+		return
+	}
+	s.logAdminAction(r, "backup.export", filename, true)
+}
+
+// exportCollection writes coll's marker line and then each of its documents, reading them
+// from a cursor so the collection is never held in memory
+func exportCollection(ctx context.Context, w io.Writer, coll *mongo.Collection) error {
+	marker, err := json.Marshal(backupMarker{Collection: coll.Name()})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(marker, '\n')); err != nil {
+		return err
+	}
+
+	cursor, err := coll.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, false, false)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// importHandler loads a backup written by exportHandler, inserting its documents into the
+// collections named by its marker lines. Documents whose _id already exists are skipped, so
+// restoring the same backup twice is harmless. It responds with what was done per collection.
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	// Uploading and inserting a large backup takes longer than the server's timeouts allow
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		s.log(r.Context(), "backup").Error("clearing read deadline for import", "err", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.log(r.Context(), "backup").Error("clearing write deadline for import", "err", err)
+	}
+
+	results, err := importBackup(r.Context(), s.db, s.config.Collections, r.Body)
+	s.logAdminAction(r, "backup.import", "", err == nil)
+	// The import writes straight to the collections, so cached pages may be missing what it
+	// added. A failed import can still have inserted some batches, so they're dropped either way.
+	s.home.invalidate()
+	if cache, ok := s.quotes.(*QuotePageCache); ok {
+		cache.Invalidate()
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		s.respondError(w, r, http.StatusRequestEntityTooLarge, "Request body too large.")
+	case errors.Is(err, errInvalidBackup):
+		s.respondError(w, r, http.StatusBadRequest, err.Error())
+	case err != nil:
+		s.respondError(w, r, s.dbError(r.Context(), "backup", "import backup", err), "Error importing backup")
+	default:
+		respondJSON(w, http.StatusOK, results)
+	}
+}
+
+// importBackup reads a backup from body and inserts its documents in batches. Documents already
+// inserted stay in place if a later line turns out to be invalid.
+func importBackup(ctx context.Context, db *mongo.Database, collections CollectionNames, body io.Reader) (map[string]*ImportResult, error) {
+	results := map[string]*ImportResult{}
+	var current string
+	var batch []any
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		inserted, skipped, err := insertSkippingDuplicates(ctx, db.Collection(current), batch)
+		results[current].Inserted += inserted
+		results[current].Skipped += skipped
+		batch = batch[:0]
+		return err
+	}
+
+	reader := bufio.NewReader(body)
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return results, readErr
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(line, false, &doc); err != nil {
+				return results, fmt.Errorf("%w: line %d isn't a JSON document", errInvalidBackup, lineNumber)
+			}
+
+			if name, ok := markerCollection(doc); ok {
+				if !slices.Contains(backupCollections(collections), name) {
+					return results, fmt.Errorf("%w: line %d names unknown collection %q", errInvalidBackup, lineNumber, name)
+				}
+				if err := flush(); err != nil {
+					return results, err
+				}
+				current = name
+				if results[current] == nil {
+					results[current] = &ImportResult{}
+				}
+			} else {
+				if current == "" {
+					return results, fmt.Errorf("%w: line %d comes before any collection marker", errInvalidBackup, lineNumber)
+				}
+				batch = append(batch, doc)
+				if len(batch) == importBatchSize {
+					if err := flush(); err != nil {
+						return results, err
+					}
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			return results, flush()
+		}
+	}
+}
+
+// markerCollection returns the collection named by doc if it's a {"collection":"name"} marker
+func markerCollection(doc bson.D) (string, bool) {
+	if len(doc) != 1 || doc[0].Key != "collection" {
+		return "", false
+	}
+	name, ok := doc[0].Value.(string)
+	return name, ok
+}
+
+// insertSkippingDuplicates inserts docs into coll, counting those rejected for a duplicate _id
+// as skipped rather than failing the whole batch
+func insertSkippingDuplicates(ctx context.Context, coll *mongo.Collection, docs []any) (inserted, skipped int, err error) {
+	_, err = coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return len(docs), 0, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return 0, 0, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != duplicateKeyCode {
+			return 0, 0, err
+		}
+	}
+	skipped = len(bulkErr.WriteErrors)
+	return len(docs) - skipped, skipped, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestImportRejectsMalformedBackups(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "document before marker", body: `{"_id":"webhook","count":5}`},
+		{name: "unknown collection", body: `{"collection":"users"}`},
+		{name: "not JSON", body: "{\"collection\":\"quotes\"}\nnot json"},
+	}
+	for _, tt := range tests {
+		r := newJSONRequest(http.MethodPost, "/admin/import", tt.body)
+		r.Header.Set("Authorization", "Bearer secret")
+		if w := serve(h, r); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	for _, path := range []string{"/admin/export", "/admin/import"} {
+		method := http.MethodGet
+		if path == "/admin/import" {
+			method = http.MethodPost
+		}
+		if w := serveRequest(h, method, path, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token: status = %d, want %d", method, path, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestMarkerCollection(t *testing.T) {
+	tests := []struct {
+		doc  bson.D
+		want string
+		ok   bool
+	}{
+		{doc: bson.D{{Key: "collection", Value: "quotes"}}, want: "quotes", ok: true},
+		{doc: bson.D{{Key: "collection", Value: 5}}},
+		{doc: bson.D{{Key: "_id", Value: "x"}, {Key: "collection", Value: "quotes"}}},
+		{doc: bson.D{{Key: "name", Value: "quotes"}}},
+	}
+	for _, tt := range tests {
+		got, ok := markerCollection(tt.doc)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("markerCollection(%v) = %q, %v; want %q, %v", tt.doc, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	s := newMongoTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+	ctx := t.Context()
+
+	quote := Quote{Name: "Ada", Quote: "Backups are cheap", Timestamp: time.Now().UTC().Truncate(time.Millisecond)}
+	if err := s.quotes.InsertQuote(ctx, quote); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.counters.InitCounters(ctx, "webhook"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newJSONRequest(http.MethodGet, "/admin/export", "")
+	r.Header.Set("Authorization", "Bearer secret")
+	w := serve(h, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="backup-`) {
+		t.Errorf("Content-Disposition = %q, want an attachment named backup-<date>", cd)
+	}
+	backup := w.Body.String()
+
+	var markers []string
+	scanner := bufio.NewScanner(strings.NewReader(backup))
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), `{"collection":`) {
+			markers = append(markers, scanner.Text())
+		}
+	}
+	if len(markers) != len(backupCollections(s.config.Collections)) {
+		t.Errorf("export has markers %v, want one per collection in %v", markers, backupCollections(s.config.Collections))
+	}
+
+	// Everything exported is already there, so importing it again skips every document
+	r = newJSONRequest(http.MethodPost, "/admin/import", backup)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = serve(h, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var results map[string]ImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if got := results[s.config.Collections.Quotes]; got != (ImportResult{Skipped: 1}) {
+		t.Errorf("quotes import = %+v, want 1 skipped", got)
+	}
+
+	// Into an empty collection, every document is inserted with its types intact
+	if _, err := s.db.Collection(s.config.Collections.Quotes).DeleteMany(ctx, bson.M{}); err != nil {
+		t.Fatal(err)
+	}
+	// Cache the empty first page, which the import must invalidate
+	if page, err := s.listQuotes(ctx, 1, 10, ""); err != nil || len(page.Quotes) != 0 {
+		t.Fatalf("first page after deleting = %+v, %v; want no quotes", page, err)
+	}
+	r = newJSONRequest(http.MethodPost, "/admin/import", backup)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = serve(h, r)
+	results = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if got := results[s.config.Collections.Quotes]; got != (ImportResult{Inserted: 1}) {
+		t.Errorf("quotes import into an empty collection = %+v, want 1 inserted", got)
+	}
+	quotes, err := s.quotes.LatestQuotes(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotes) != 1 || !quotes[0].Timestamp.Equal(quote.Timestamp) || quotes[0].Quote != quote.Quote {
+		t.Errorf("quotes after import = %+v, want %+v", quotes, quote)
+	}
+	if page, err := s.listQuotes(ctx, 1, 10, ""); err != nil || len(page.Quotes) != 1 {
+		t.Errorf("first page after import = %+v, %v; want the imported quote", page, err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// banRefreshInterval is how often the in-memory ban list is reloaded from MongoDB
+const banRefreshInterval = time.Minute
+
+// Ban represents a banned IP address or CIDR range in MongoDB
+type Ban struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CIDR      string             `bson:"cidr" json:"cidr"`
+	Reason    string             `bson:"reason" json:"reason"`
+	ExpiresAt *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// compiledBan is a ban with its CIDR parsed for fast matching
+type compiledBan struct {
+	prefix    netip.Prefix
+	expiresAt *time.Time
+}
+
+// banList holds the compiled bans checked on every request
+type banList struct {
+	mu   sync.RWMutex
+	bans []compiledBan
+}
+
+// parseBanPrefix parses a CIDR range or a single IPv4/IPv6 address
+func parseBanPrefix(cidr string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(cidr); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// set replaces the compiled bans with the given ones, skipping any that fail to parse
+func (b *banList) set(banDocs []Ban) {
+	compiled := make([]compiledBan, 0, len(banDocs))
+	for _, ban := range banDocs {
+		prefix, err := parseBanPrefix(ban.CIDR)
+		if err != nil {
+			componentLogger("bans").Warn("skipping invalid ban", "cidr", ban.CIDR, "err", err)
+			continue
+		}
+		compiled = append(compiled, compiledBan{prefix: prefix, expiresAt: ban.ExpiresAt})
+	}
+
+	b.mu.Lock()
+	b.bans = compiled
+	b.mu.Unlock()
+}
+
+// isBanned reports whether the IP matches an unexpired ban
+func (b *banList) isBanned(ip string, now time.Time) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, ban := range b.bans {
+		if ban.expiresAt != nil && !now.Before(*ban.expiresAt) {
+			continue
+		}
+		if ban.prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// banLoader returns the bans that currently apply
+type banLoader func(ctx context.Context) ([]Ban, error)
+
+// refresh replaces the compiled bans with the ones load returns, keeping the current list if
+// it fails
+func (b *banList) refresh(ctx context.Context, load banLoader) error {
+	banDocs, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	b.set(banDocs)
+	return nil
+}
+
+// keepRefreshed refreshes the list with load every interval until ctx is done
+func (b *banList) keepRefreshed(ctx context.Context, interval time.Duration, load banLoader) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.refresh(ctx, load); err != nil && ctx.Err() == nil {
+				componentLogger("bans").Error("refreshing bans", "err", err)
+			}
+		}
+	}
+}
+
+// activeBans loads the unexpired bans from MongoDB
+func (s *Server) activeBans(ctx context.Context) ([]Ban, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gt": time.Now()}},
+	}}
+
+	cursor, err := s.db.Collection(s.config.Collections.Bans).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	banDocs := []Ban{}
+	if err := cursor.All(ctx, &banDocs); err != nil {
+		return nil, err
+	}
+	return banDocs, nil
+}
+
+// refreshBans reloads the unexpired bans from MongoDB into memory
+func (s *Server) refreshBans(ctx context.Context) error {
+	return s.bans.refresh(ctx, s.activeBans)
+}
+
+// startBanRefresher loads the ban list and keeps it refreshed in the background
+func (s *Server) startBanRefresher() {
+	if err := s.refreshBans(context.Background()); err != nil {
+		s.log(context.Background(), "bans").Error("loading bans", "err", err)
+	}
+
+	go s.bans.keepRefreshed(context.Background(), banRefreshInterval, s.activeBans)
+}
+
+// banMiddleware rejects requests from banned IPs using the in-memory ban list
+func (s *Server) banMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := s.getIPAddress(r); s.bans.isBanned(ip, time.Now()) {
+			s.audit.Log(r.Context(), AuditEvent{Action: "ban.blocked", Actor: ip, Resource: r.URL.Path})
+			s.respondError(w, r, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listBansHandler lists all bans
+func (s *Server) listBansHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+	bansCollection := s.db.Collection(s.config.Collections.Bans)
+
+	cursor, err := bansCollection.Find(ctx, bson.M{})
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "bans", "list bans", err), "Error listing bans")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	banDocs := []Ban{}
+	if err := cursor.All(ctx, &banDocs); err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "bans", "list bans", err), "Error listing bans")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, banDocs)
+}
+
+// createBanHandler creates a new ban
+func (s *Server) createBanHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+	bansCollection := s.db.Collection(s.config.Collections.Bans)
+
+	var ban Ban
+	if err := json.NewDecoder(r.Body).Decode(&ban); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid ban")
+		return
+	}
+
+	prefix, err := parseBanPrefix(ban.CIDR)
+	if err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid CIDR")
+		return
+	}
+
+	ban.ID = primitive.NewObjectID()
+	ban.CIDR = prefix.String()
+	ban.CreatedAt = time.Now()
+
+	if _, err := bansCollection.InsertOne(ctx, ban); err != nil {
+		s.logAdminAction(r, "ban.create", ban.CIDR, false)
+		s.respondError(w, r, s.dbError(r.Context(), "bans", "insert ban", err), "Error saving ban")
+		return
+	}
+
+	if err := s.refreshBans(ctx); err != nil {
+		s.log(r.Context(), "bans").Error("refreshing bans", "err", err)
+	}
+
+	s.logAdminAction(r, "ban.create", ban.CIDR, true)
+	respondJSON(w, http.StatusCreated, ban)
+}
+
+// deleteBanHandler deletes a single ban by ID
+func (s *Server) deleteBanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid ban ID")
+		return
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+
+	result, err := s.db.Collection(s.config.Collections.Bans).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		s.logAdminAction(r, "ban.delete", id.Hex(), false)
+		s.respondError(w, r, s.dbError(r.Context(), "bans", "delete ban", err), "Error deleting ban")
+		return
+	}
+	if result.DeletedCount == 0 {
+		s.respondError(w, r, http.StatusNotFound, "Not found")
+		return
+	}
+
+	if err := s.refreshBans(ctx); err != nil {
+		s.log(r.Context(), "bans").Error("refreshing bans", "err", err)
+	}
+
+	s.logAdminAction(r, "ban.delete", id.Hex(), true)
+	w.WriteHeader(http.StatusNoContent)
+}
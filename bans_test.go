@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBanListMatching(t *testing.T) {
+	list := &banList{}
+	list.set([]Ban{
+		{CIDR: "203.0.113.0/24"},
+		{CIDR: "198.51.100.7"},
+		{CIDR: "2001:db8::/32"},
+		{CIDR: "::ffff:192.0.2.0/120"},
+		{CIDR: "not a cidr"},
+	})
+
+	now := time.Now()
+	for ip, want := range map[string]bool{
+		"203.0.113.1":        true,
+		"203.0.113.255":      true,
+		"203.0.114.1":        false,
+		"198.51.100.7":       true,
+		"198.51.100.8":       false,
+		"2001:db8::1":        true,
+		"2001:db8:ffff::1":   true,
+		"2001:db9::1":        false,
+		"::ffff:203.0.113.9": true,
+		"192.0.2.44":         true,
+		"not an ip":          false,
+	} {
+		if got := list.isBanned(ip, now); got != want {
+			t.Errorf("isBanned(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestBanListExpiry(t *testing.T) {
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	list := &banList{}
+	list.set([]Ban{{CIDR: "203.0.113.0/24", ExpiresAt: &expiresAt}})
+
+	if !list.isBanned("203.0.113.1", now) {
+		t.Error("ban didn't apply before it expired")
+	}
+	if list.isBanned("203.0.113.1", expiresAt) {
+		t.Error("ban still applied when it expired")
+	}
+	if list.isBanned("203.0.113.1", expiresAt.Add(time.Minute)) {
+		t.Error("ban still applied after it expired")
+	}
+}
+
+func TestBanListRefresh(t *testing.T) {
+	list := &banList{}
+	list.set([]Ban{{CIDR: "203.0.113.1"}})
+
+	failing := func(context.Context) ([]Ban, error) { return nil, errors.New("store down") }
+	if err := list.refresh(context.Background(), failing); err == nil {
+		t.Fatal("refresh didn't return the load error")
+	}
+	if !list.isBanned("203.0.113.1", time.Now()) {
+		t.Error("a failed refresh dropped the current bans")
+	}
+
+	replacement := func(context.Context) ([]Ban, error) { return []Ban{{CIDR: "198.51.100.1"}}, nil }
+	if err := list.refresh(context.Background(), replacement); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if list.isBanned("203.0.113.1", time.Now()) {
+		t.Error("a removed ban still applied after refreshing")
+	}
+	if !list.isBanned("198.51.100.1", time.Now()) {
+		t.Error("a new ban didn't apply after refreshing")
+	}
+}
+
+func TestBanListKeepRefreshed(t *testing.T) {
+	var loads atomic.Int32
+	load := func(context.Context) ([]Ban, error) {
+		// The ban only shows up from the second refresh, as if it was created in between
+		if loads.Add(1) < 2 {
+			return nil, nil
+		}
+		return []Ban{{CIDR: "203.0.113.1"}}, nil
+	}
+
+	list := &banList{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		list.keepRefreshed(ctx, 5*time.Millisecond, load)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !list.isBanned("203.0.113.1", time.Now()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("ban wasn't picked up after %d refreshes", loads.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("refresher didn't stop when its context was canceled")
+	}
+}
+
+func TestBanMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	trustProxies(t, s, "10.0.0.0/8")
+	s.bans.set([]Ban{{CIDR: "203.0.113.0/24"}})
+
+	handler := s.banMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{name: "banned", remoteAddr: "203.0.113.5:1234", want: http.StatusForbidden},
+		{name: "allowed", remoteAddr: "198.51.100.5:1234", want: http.StatusNoContent},
+		{name: "banned forging a header", remoteAddr: "203.0.113.5:1234", forwarded: "198.51.100.5", want: http.StatusForbidden},
+		{name: "banned through a proxy", remoteAddr: "10.0.0.2:1234", forwarded: "203.0.113.5", want: http.StatusForbidden},
+		{name: "banned through a proxy forging a hop", remoteAddr: "10.0.0.2:1234", forwarded: "198.51.100.5, 203.0.113.5", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", "application/json")
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+	// Another server has its own ban list
+	other := newTestServer(t).banMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	if w := serve(other, r); w.Code != http.StatusNoContent {
+		t.Errorf("status from a server without the ban = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
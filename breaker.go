@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errStoreUnavailable is returned without calling the database while the circuit breaker is open
+var errStoreUnavailable = errors.New("database temporarily unavailable")
+
+// breakerState is whether a circuit breaker is letting calls through
+type breakerState int
+
+const (
+	// breakerClosed lets every call through
+	breakerClosed breakerState = iota
+	// breakerHalfOpen lets one probe call through to find out whether the database is back
+	breakerHalfOpen
+	// breakerOpen fails calls without trying them until the cool-down passes
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// circuitBreaker stops calling the database after it fails repeatedly, so requests fail fast
+// instead of each waiting out a timeout, and tries again with one call once a cool-down passes
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int // consecutive outage errors while closed
+	openedAt time.Time
+	probing  bool // a half-open probe is in flight
+}
+
+// newCircuitBreaker returns a closed breaker that opens after threshold failures in a row and
+// stays open for cooldown
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// State returns whether the breaker is closed, half-open, or open
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a call may go to the database: always while closed, never while open
+// until the cool-down passes, and then only one probe at a time until it succeeds or fails
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+	}
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record notes the result of a call that allow let through. Errors that mean the database
+// answered, like a missing document, count as successes.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The client went away, which says nothing about the database
+	case isStoreOutage(err):
+		b.failures++
+		if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+			componentLogger("storage").Warn("database calls failing, serving read-only",
+				"failures", b.failures, "cooldown", b.cooldown, "err", err)
+			b.openedAt = time.Now()
+			b.setState(breakerOpen)
+		}
+	default:
+		b.failures = 0
+		if b.state != breakerClosed {
+			componentLogger("storage").Info("database calls succeeding again, accepting writes")
+			b.setState(breakerClosed)
+		}
+	}
+}
+
+// setState moves the breaker to state. b.mu must be held.
+func (b *circuitBreaker) setState(state breakerState) {
+	b.state = state
+	storeBreakerState.Set(float64(state))
+}
+
+// isStoreOutage reports whether err suggests the database can't be reached, rather than that it
+// answered with an error about the request
+func isStoreOutage(err error) bool {
+	return err != nil &&
+		!errors.Is(err, mongo.ErrNoDocuments) &&
+		!errors.Is(err, errAlreadyReacted) &&
+		!errors.Is(err, errCounterExists) &&
+		!errors.Is(err, errMergeDestMissing) &&
+		!errors.As(err, new(*mergeSourceMissingError)) &&
+		!mongo.IsDuplicateKeyError(err)
+}
+
+// guard makes call through the breaker, failing with errStoreUnavailable without making it
+// while the breaker is open
+func guard[T any](b *circuitBreaker, call func() (T, error)) (T, error) {
+	if !b.allow() {
+		var zero T
+		return zero, errStoreUnavailable
+	}
+	value, err := call()
+	b.record(err)
+	return value, err
+}
+
+// guardErr is guard for calls that only return an error
+func guardErr(b *circuitBreaker, call func() error) error {
+	_, err := guard(b, func() (struct{}, error) { return struct{}{}, call() })
+	return err
+}
+
+// store is everything the site keeps in the database
+type store interface {
+	QuoteStore
+	CounterStore
+	PageViewStore
+	SessionStore
+	MessageStore
+	AuditStore
+}
+
+// breakerStore puts a circuit breaker in front of a store. While the database is unreachable the
+// home page's reads are answered from a snapshot of their last successful results, so the site
+// keeps showing the latest counters and quotes, and every other call fails with
+// errStoreUnavailable, which write handlers turn into a read-only notice.
+type breakerStore struct {
+	store   store
+	breaker *circuitBreaker
+
+	mu         sync.Mutex
+	counters   map[string]Counter
+	quotes     []Quote // newest first
+	haveQuotes bool
+	quoteCount *int64
+	pageViews  *int
+}
+
+// newBreakerStore returns store behind a breaker that opens after threshold failures in a row
+// and stays open for cooldown before letting a probe through
+func newBreakerStore(store store, threshold int, cooldown time.Duration) *breakerStore {
+	return &breakerStore{
+		store:    store,
+		breaker:  newCircuitBreaker(threshold, cooldown),
+		counters: make(map[string]Counter),
+	}
+}
+
+// snapshotRead makes a read through the breaker, remembering its result with save when it
+// succeeds and answering with load when the database can't be reached, if load has a result
+func snapshotRead[T any](s *breakerStore, call func() (T, error), save func(T), load func() (T, bool)) (T, error) {
+	value, err := guard(s.breaker, call)
+	if err == nil {
+		s.mu.Lock()
+		save(value)
+		s.mu.Unlock()
+		return value, nil
+	}
+	if isStoreOutage(err) {
+		s.mu.Lock()
+		snapshot, ok := load()
+		s.mu.Unlock()
+		if ok {
+			return snapshot, nil
+		}
+	}
+	return value, err
+}
+
+// rememberCounter updates the snapshot of a counter after it's read or changed
+func (s *breakerStore) rememberCounter(counter Counter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[counter.ID] = counter
+}
+
+func (s *breakerStore) GetCounter(ctx context.Context, id string) (Counter, error) {
+	return snapshotRead(s,
+		func() (Counter, error) { return s.store.GetCounter(ctx, id) },
+		func(counter Counter) { s.counters[id] = counter },
+		func() (Counter, bool) {
+			counter, ok := s.counters[id]
+			return counter, ok
+		})
+}
+
+func (s *breakerStore) GetLatestCounter(ctx context.Context, id string) (Counter, error) {
+	counter, err := guard(s.breaker, func() (Counter, error) { return s.store.GetLatestCounter(ctx, id) })
+	if err == nil {
+		s.rememberCounter(counter)
+	}
+	return counter, err
+}
+
+func (s *breakerStore) LatestQuotes(ctx context.Context, limit int64) ([]Quote, error) {
+	return snapshotRead(s,
+		func() ([]Quote, error) { return s.store.LatestQuotes(ctx, limit) },
+		func(quotes []Quote) {
+			// Keep the longest list seen, so a short read doesn't hide quotes a longer one needs
+			if !s.haveQuotes || limit == 0 || len(quotes) >= len(s.quotes) {
+				s.quotes, s.haveQuotes = quotes, true
+			}
+		},
+		func() ([]Quote, bool) {
+			if limit > 0 && int64(len(s.quotes)) > limit {
+				return s.quotes[:limit], s.haveQuotes
+			}
+			return s.quotes, s.haveQuotes
+		})
+}
+
+func (s *breakerStore) CountQuotes(ctx context.Context) (int64, error) {
+	return snapshotRead(s,
+		func() (int64, error) { return s.store.CountQuotes(ctx) },
+		func(count int64) { s.quoteCount = &count },
+		func() (int64, bool) {
+			if s.quoteCount == nil {
+				return 0, false
+			}
+			return *s.quoteCount, true
+		})
+}
+
+func (s *breakerStore) TotalPageViews(ctx context.Context) (int, error) {
+	return snapshotRead(s,
+		func() (int, error) { return s.store.TotalPageViews(ctx) },
+		func(total int) { s.pageViews = &total },
+		func() (int, bool) {
+			if s.pageViews == nil {
+				return 0, false
+			}
+			return *s.pageViews, true
+		})
+}
+
+func (s *breakerStore) InsertQuote(ctx context.Context, quote Quote) error {
+	err := guardErr(s.breaker, func() error { return s.store.InsertQuote(ctx, quote) })
+	if err == nil {
+		s.mu.Lock()
+		if s.haveQuotes {
+			s.quotes = append([]Quote{quote}, s.quotes...)
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *breakerStore) DeleteQuote(ctx context.Context, id primitive.ObjectID) error {
+	err := guardErr(s.breaker, func() error { return s.store.DeleteQuote(ctx, id) })
+	if err == nil {
+		s.mu.Lock()
+		s.quotes = slices.DeleteFunc(slices.Clone(s.quotes), func(quote Quote) bool { return quote.ID == id })
+		s.quoteCount = nil
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *breakerStore) ListQuotes(ctx context.Context, page, limit int, tag string) (QuotePage, error) {
+	return guard(s.breaker, func() (QuotePage, error) { return s.store.ListQuotes(ctx, page, limit, tag) })
+}
+
+func (s *breakerStore) NthOldestQuote(ctx context.Context, n int64) (Quote, error) {
+	return guard(s.breaker, func() (Quote, error) { return s.store.NthOldestQuote(ctx, n) })
+}
+
+func (s *breakerStore) QuotesSince(ctx context.Context, since time.Time, limit int64) ([]Quote, error) {
+	return guard(s.breaker, func() ([]Quote, error) { return s.store.QuotesSince(ctx, since, limit) })
+}
+
+func (s *breakerStore) ReactToQuote(ctx context.Context, reaction QuoteReaction) (map[string]int, error) {
+	return guard(s.breaker, func() (map[string]int, error) { return s.store.ReactToQuote(ctx, reaction) })
+}
+
+func (s *breakerStore) InsertQuoteIfNew(ctx context.Context, quote Quote) (bool, error) {
+	added, err := guard(s.breaker, func() (bool, error) { return s.store.InsertQuoteIfNew(ctx, quote) })
+	if added {
+		s.mu.Lock()
+		if s.haveQuotes {
+			s.quotes = append([]Quote{quote}, s.quotes...)
+		}
+		s.mu.Unlock()
+	}
+	return added, err
+}
+
+func (s *breakerStore) SearchQuotes(ctx context.Context, term string, limit int64) ([]Quote, error) {
+	return guard(s.breaker, func() ([]Quote, error) { return s.store.SearchQuotes(ctx, term, limit) })
+}
+
+func (s *breakerStore) GroupQuotesByAuthor(ctx context.Context) ([]QuoteAuthor, error) {
+	return guard(s.breaker, func() ([]QuoteAuthor, error) { return s.store.GroupQuotesByAuthor(ctx) })
+}
+
+func (s *breakerStore) InitCounters(ctx context.Context, ids ...string) error {
+	return guardErr(s.breaker, func() error { return s.store.InitCounters(ctx, ids...) })
+}
+
+func (s *breakerStore) IncrementCounter(ctx context.Context, id string) (Counter, error) {
+	counter, err := guard(s.breaker, func() (Counter, error) { return s.store.IncrementCounter(ctx, id) })
+	if err == nil {
+		s.rememberCounter(counter)
+	}
+	return counter, err
+}
+
+func (s *breakerStore) DecrementCounter(ctx context.Context, id string) (Counter, error) {
+	counter, err := guard(s.breaker, func() (Counter, error) { return s.store.DecrementCounter(ctx, id) })
+	if err == nil {
+		s.rememberCounter(counter)
+	}
+	return counter, err
+}
+
+func (s *breakerStore) ResetCounters(ctx context.Context, ids []string) ([]Counter, error) {
+	previous, err := guard(s.breaker, func() ([]Counter, error) { return s.store.ResetCounters(ctx, ids) })
+	if err == nil {
+		// The next read refills them
+		s.mu.Lock()
+		for _, id := range ids {
+			delete(s.counters, id)
+		}
+		s.mu.Unlock()
+	}
+	return previous, err
+}
+
+func (s *breakerStore) ListCounters(ctx context.Context, namespace string) ([]Counter, error) {
+	return guard(s.breaker, func() ([]Counter, error) { return s.store.ListCounters(ctx, namespace) })
+}
+
+func (s *breakerStore) ListNamespaces(ctx context.Context) ([]string, error) {
+	return guard(s.breaker, func() ([]string, error) { return s.store.ListNamespaces(ctx) })
+}
+
+func (s *breakerStore) CreateCounter(ctx context.Context, counter Counter) error {
+	return guardErr(s.breaker, func() error { return s.store.CreateCounter(ctx, counter) })
+}
+
+func (s *breakerStore) AdjustCounter(ctx context.Context, id string, delta int) (Counter, error) {
+	counter, err := guard(s.breaker, func() (Counter, error) { return s.store.AdjustCounter(ctx, id, delta) })
+	if err == nil {
+		s.rememberCounter(counter)
+	}
+	return counter, err
+}
+
+func (s *breakerStore) MergeCounters(ctx context.Context, sources []string, dest string) (Counter, error) {
+	merged, err := guard(s.breaker, func() (Counter, error) { return s.store.MergeCounters(ctx, sources, dest) })
+	if err == nil {
+		s.rememberCounter(merged)
+	}
+	return merged, err
+}
+
+func (s *breakerStore) RecordCounterEvent(ctx context.Context, event CounterEvent) error {
+	return guardErr(s.breaker, func() error { return s.store.RecordCounterEvent(ctx, event) })
+}
+
+func (s *breakerStore) SummarizeCounterEvents(ctx context.Context, id string, since time.Time) (CounterEventSummary, error) {
+	return guard(s.breaker, func() (CounterEventSummary, error) { return s.store.SummarizeCounterEvents(ctx, id, since) })
+}
+
+func (s *breakerStore) TrackPageView(ctx context.Context, path string) error {
+	return guardErr(s.breaker, func() error { return s.store.TrackPageView(ctx, path) })
+}
+
+func (s *breakerStore) ListPageViews(ctx context.Context) ([]PageView, error) {
+	return guard(s.breaker, func() ([]PageView, error) { return s.store.ListPageViews(ctx) })
+}
+
+func (s *breakerStore) SeedPageViews(ctx context.Context, path string, count int) error {
+	return guardErr(s.breaker, func() error { return s.store.SeedPageViews(ctx, path, count) })
+}
+
+func (s *breakerStore) TrackCountryView(ctx context.Context, country string) error {
+	return guardErr(s.breaker, func() error { return s.store.TrackCountryView(ctx, country) })
+}
+
+func (s *breakerStore) ListCountryViews(ctx context.Context) ([]CountryViews, error) {
+	return guard(s.breaker, func() ([]CountryViews, error) { return s.store.ListCountryViews(ctx) })
+}
+
+func (s *breakerStore) RecordVisitor(ctx context.Context, key string, now time.Time, window time.Duration) (bool, error) {
+	return guard(s.breaker, func() (bool, error) { return s.store.RecordVisitor(ctx, key, now, window) })
+}
+
+func (s *breakerStore) CreateSession(ctx context.Context, session Session) error {
+	return guardErr(s.breaker, func() error { return s.store.CreateSession(ctx, session) })
+}
+
+func (s *breakerStore) InsertMessage(ctx context.Context, message ContactMessage) error {
+	return guardErr(s.breaker, func() error { return s.store.InsertMessage(ctx, message) })
+}
+
+func (s *breakerStore) ListMessages(ctx context.Context, unreadOnly bool) ([]ContactMessage, error) {
+	return guard(s.breaker, func() ([]ContactMessage, error) { return s.store.ListMessages(ctx, unreadOnly) })
+}
+
+func (s *breakerStore) ListUndeliveredMessages(ctx context.Context) ([]ContactMessage, error) {
+	return guard(s.breaker, func() ([]ContactMessage, error) { return s.store.ListUndeliveredMessages(ctx) })
+}
+
+func (s *breakerStore) MarkMessageRead(ctx context.Context, id primitive.ObjectID) error {
+	return guardErr(s.breaker, func() error { return s.store.MarkMessageRead(ctx, id) })
+}
+
+func (s *breakerStore) MarkMessageDelivered(ctx context.Context, id primitive.ObjectID) error {
+	return guardErr(s.breaker, func() error { return s.store.MarkMessageDelivered(ctx, id) })
+}
+
+func (s *breakerStore) InsertAuditEntry(ctx context.Context, entry AuditEntry) error {
+	return guardErr(s.breaker, func() error { return s.store.InsertAuditEntry(ctx, entry) })
+}
+
+func (s *breakerStore) ListAuditEntries(ctx context.Context, route string, limit int) ([]AuditEntry, error) {
+	return guard(s.breaker, func() ([]AuditEntry, error) { return s.store.ListAuditEntries(ctx, route, limit) })
+}
+
+func (s *breakerStore) InsertAuditEvent(ctx context.Context, event AuditEvent) error {
+	return guardErr(s.breaker, func() error { return s.store.InsertAuditEvent(ctx, event) })
+}
+
+func (s *breakerStore) ListAuditEvents(ctx context.Context, action string, limit int) ([]AuditEvent, error) {
+	return guard(s.breaker, func() ([]AuditEvent, error) { return s.store.ListAuditEvents(ctx, action, limit) })
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errConnectionRefused stands in for MongoDB being unreachable
+var errConnectionRefused = errors.New("connection refused")
+
+// flakyStore is a memory store whose counter and quote calls fail while down is set
+type flakyStore struct {
+	*memoryStore
+	down atomic.Bool
+}
+
+func (f *flakyStore) GetCounter(ctx context.Context, id string) (Counter, error) {
+	if f.down.Load() {
+		return Counter{}, errConnectionRefused
+	}
+	return f.memoryStore.GetCounter(ctx, id)
+}
+
+func (f *flakyStore) IncrementCounter(ctx context.Context, id string) (Counter, error) {
+	if f.down.Load() {
+		return Counter{}, errConnectionRefused
+	}
+	return f.memoryStore.IncrementCounter(ctx, id)
+}
+
+func (f *flakyStore) LatestQuotes(ctx context.Context, limit int64) ([]Quote, error) {
+	if f.down.Load() {
+		return nil, errConnectionRefused
+	}
+	return f.memoryStore.LatestQuotes(ctx, limit)
+}
+
+func (f *flakyStore) InsertQuote(ctx context.Context, quote Quote) error {
+	if f.down.Load() {
+		return errConnectionRefused
+	}
+	return f.memoryStore.InsertQuote(ctx, quote)
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	b := newCircuitBreaker(3, cooldown)
+	fail := func() {
+		if !b.allow() {
+			t.Fatalf("call refused while %s", b.State())
+		}
+		b.record(errConnectionRefused)
+	}
+
+	// Errors from a database that answered don't count towards opening it
+	for range 5 {
+		b.allow()
+		b.record(mongo.ErrNoDocuments)
+	}
+	fail()
+	fail()
+	if b.State() != breakerClosed {
+		t.Fatalf("state after 2 failures = %s, want closed", b.State())
+	}
+	fail()
+	if b.State() != breakerOpen || b.allow() {
+		t.Fatalf("state after 3 failures = %s, want open and refusing calls", b.State())
+	}
+
+	// After the cool-down one probe is let through at a time, and its failure reopens it
+	time.Sleep(cooldown)
+	if !b.allow() || b.State() != breakerHalfOpen {
+		t.Fatalf("probe refused after the cool-down, state %s", b.State())
+	}
+	if b.allow() {
+		t.Error("second call let through while the probe was in flight")
+	}
+	b.record(errConnectionRefused)
+	if b.State() != breakerOpen || b.allow() {
+		t.Fatalf("state after a failed probe = %s, want open", b.State())
+	}
+
+	// A successful probe closes it, and it takes the full threshold to open it again
+	time.Sleep(cooldown)
+	if !b.allow() {
+		t.Fatal("probe refused after the second cool-down")
+	}
+	b.record(nil)
+	if b.State() != breakerClosed {
+		t.Fatalf("state after a successful probe = %s, want closed", b.State())
+	}
+	fail()
+	fail()
+	if b.State() != breakerClosed {
+		t.Errorf("state after 2 failures following recovery = %s, want closed", b.State())
+	}
+}
+
+func TestBreakerStoreServesSnapshot(t *testing.T) {
+	ctx := t.Context()
+	flaky := &flakyStore{memoryStore: newMemoryStore()}
+	s := newBreakerStore(flaky, 2, time.Hour)
+
+	quote, _ := newQuote("Ada", "Simplicity is prerequisite for reliability", "", nil)
+	if err := s.InsertQuote(ctx, quote); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.IncrementCounter(ctx, "webhook"); err != nil {
+		t.Fatal(err)
+	}
+	// Reading warms the snapshot
+	if _, err := s.LatestQuotes(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	flaky.down.Store(true)
+
+	// Reads are answered from the snapshot while the database is down, before and after the
+	// breaker opens
+	for range 3 {
+		counter, err := s.GetCounter(ctx, "webhook")
+		if err != nil || counter.Count != 1 {
+			t.Errorf("GetCounter() = %+v, %v; want the snapshot with count 1", counter, err)
+		}
+		quotes, err := s.LatestQuotes(ctx, 0)
+		if err != nil || len(quotes) != 1 || quotes[0].Quote != quote.Quote {
+			t.Errorf("LatestQuotes() = %+v, %v; want the snapshot's quote", quotes, err)
+		}
+	}
+	if s.breaker.State() != breakerOpen {
+		t.Fatalf("breaker = %s after the database failed 3 times, want open", s.breaker.State())
+	}
+
+	// Writes fail without reaching the database, as do reads with nothing in the snapshot
+	if _, err := s.IncrementCounter(ctx, "webhook"); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("IncrementCounter() error = %v, want errStoreUnavailable", err)
+	}
+	if _, err := s.GetCounter(ctx, "totalClicks"); !errors.Is(err, errStoreUnavailable) {
+		t.Errorf("GetCounter() of an unread counter error = %v, want errStoreUnavailable", err)
+	}
+}
+
+func TestWritesAreReadOnlyWhileBreakerIsOpen(t *testing.T) {
+	resetRateLimiters(t)
+	setGitHubRepos(t, nil)
+	s := newTestServer(t)
+	flaky := &flakyStore{memoryStore: newMemoryStore()}
+	store := newBreakerStore(flaky, 1, 50*time.Millisecond)
+	s.quotes, s.counters, s.pageViews, s.sessions, s.breaker = store, store, store, store, store.breaker
+	h := s.routes()
+
+	if w := serve(h, newJSONRequest(http.MethodPost, "/increment", "")); w.Code != http.StatusOK {
+		t.Fatalf("increment status = %d, want %d", w.Code, http.StatusOK)
+	}
+	flaky.down.Store(true)
+	// The first failure opens the breaker
+	serve(h, newJSONRequest(http.MethodPost, "/increment", ""))
+
+	r := newJSONRequest(http.MethodPost, "/increment", "")
+	r.Header.Set("Accept", "application/json")
+	w := serve(h, r)
+	var body ErrorResponse
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusServiceUnavailable || body.Error != readOnlyMessage {
+		t.Errorf("increment while open = %d %q, want %d with the read-only message", w.Code, body.Error, http.StatusServiceUnavailable)
+	}
+
+	// The home page still shows the last known count
+	if w := serveRequest(h, http.MethodGet, "/", "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `id="counter">1<`) {
+		t.Errorf("home page while open = %d, want 200 showing the snapshot count", w.Code)
+	}
+
+	// Readiness uses the monitor's result rather than pinging, and the monitor has MongoDB up
+	s.mongoHealth.monitored.Store(true)
+	readiness := s.checkReadiness(t.Context())
+	if readiness.Status != "degraded" || !readiness.Ready() || readiness.Checks["storage"] != "circuit open" {
+		t.Errorf("readiness while open = %+v, want degraded but ready", readiness)
+	}
+
+	// Once the database is back the next probe closes the breaker
+	flaky.down.Store(false)
+	time.Sleep(50 * time.Millisecond)
+	if w := serve(h, newJSONRequest(http.MethodPost, "/increment", "")); w.Code != http.StatusOK {
+		t.Errorf("increment after recovery status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if s.breaker.State() != breakerClosed {
+		t.Errorf("breaker after recovery = %s, want closed", s.breaker.State())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "go.mongodb.org/mongo-driver/mongo"
+
+// defaultDatabaseName is the MongoDB database used when MONGO_DB is unset
+const defaultDatabaseName = "personal_website"
+
+// database returns the MONGO_DB database on client
+func (c Config) database(client *mongo.Client) *mongo.Database {
+	return client.Database(c.MongoDB)
+}
+
+// CollectionNames holds the names of the MongoDB collections used by the site
+type CollectionNames struct {
+	Counters   string
+	Quotes     string
+	Bans       string
+	RateLimits string
+	AuditLog   string
+	// CounterEvents records each counter change, for computing how fast counters move
+	CounterEvents string
+	// PageViews counts views of each page, keyed by path
+	PageViews string
+	// PageViewCountries counts page views by the visitor's country, keyed by country code
+	PageViewCountries string
+	// PageViewVisitors records the visitors whose page views were counted recently, so each is
+	// counted once per window
+	PageViewVisitors string
+	// Sessions records the anonymous sessions handed out to visitors
+	Sessions string
+	// QuoteReactions records which sessions reacted to which quotes, so each reacts once per emoji
+	QuoteReactions string
+	// Messages holds the messages sent through the contact form
+	Messages string
+}
+
+// defaultCollectionNames returns the collection names used when no overrides are set
+func defaultCollectionNames() CollectionNames {
+	return CollectionNames{
+		Counters:          "counters",
+		Quotes:            "quotes",
+		Bans:              "bans",
+		RateLimits:        "rate_limits",
+		AuditLog:          "audit_log",
+		CounterEvents:     "counter_events",
+		PageViews:         "page_views",
+		PageViewCountries: "page_view_countries",
+		PageViewVisitors:  "page_view_visitors",
+		Sessions:          "sessions",
+		QuoteReactions:    "quote_reactions",
+		Messages:          "messages",
+	}
+}
+
+// loadCollectionNames reads per-collection overrides such as MONGO_COLLECTION_QUOTES, keeping
+// the default for any that aren't set
+func loadCollectionNames(env *envReader) CollectionNames {
+	defaults := defaultCollectionNames()
+	return CollectionNames{
+		Counters:          env.string("MONGO_COLLECTION_COUNTERS", defaults.Counters),
+		Quotes:            env.string("MONGO_COLLECTION_QUOTES", defaults.Quotes),
+		Bans:              env.string("MONGO_COLLECTION_BANS", defaults.Bans),
+		RateLimits:        env.string("MONGO_COLLECTION_RATE_LIMITS", defaults.RateLimits),
+		AuditLog:          env.string("MONGO_COLLECTION_AUDIT_LOG", defaults.AuditLog),
+		CounterEvents:     env.string("MONGO_COLLECTION_COUNTER_EVENTS", defaults.CounterEvents),
+		PageViews:         env.string("MONGO_COLLECTION_PAGE_VIEWS", defaults.PageViews),
+		PageViewCountries: env.string("MONGO_COLLECTION_PAGE_VIEW_COUNTRIES", defaults.PageViewCountries),
+		PageViewVisitors:  env.string("MONGO_COLLECTION_PAGE_VIEW_VISITORS", defaults.PageViewVisitors),
+		Sessions:          env.string("MONGO_COLLECTION_SESSIONS", defaults.Sessions),
+		QuoteReactions:    env.string("MONGO_COLLECTION_QUOTE_REACTIONS", defaults.QuoteReactions),
+		Messages:          env.string("MONGO_COLLECTION_MESSAGES", defaults.Messages),
+	}
+}
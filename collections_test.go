@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestLoadCollectionNames(t *testing.T) {
+	if got := loadCollectionNames(&envReader{}); got != defaultCollectionNames() {
+		t.Errorf("default collections = %+v, want %+v", got, defaultCollectionNames())
+	}
+
+	tests := []struct {
+		key, value string
+		set        func(*CollectionNames)
+	}{
+		{"MONGO_COLLECTION_COUNTERS", "site_counters", func(c *CollectionNames) { c.Counters = "site_counters" }},
+		{"MONGO_COLLECTION_QUOTES", "site_quotes", func(c *CollectionNames) { c.Quotes = "site_quotes" }},
+		{"MONGO_COLLECTION_BANS", "site_bans", func(c *CollectionNames) { c.Bans = "site_bans" }},
+		{"MONGO_COLLECTION_RATE_LIMITS", "site_rate_limits", func(c *CollectionNames) { c.RateLimits = "site_rate_limits" }},
+		{"MONGO_COLLECTION_AUDIT_LOG", "site_audit_log", func(c *CollectionNames) { c.AuditLog = "site_audit_log" }},
+		{"MONGO_COLLECTION_PAGE_VIEWS", "site_page_views", func(c *CollectionNames) { c.PageViews = "site_page_views" }},
+		{"MONGO_COLLECTION_PAGE_VIEW_COUNTRIES", "site_countries", func(c *CollectionNames) { c.PageViewCountries = "site_countries" }},
+		{"MONGO_COLLECTION_PAGE_VIEW_VISITORS", "site_visitors", func(c *CollectionNames) { c.PageViewVisitors = "site_visitors" }},
+		{"MONGO_COLLECTION_SESSIONS", "site_sessions", func(c *CollectionNames) { c.Sessions = "site_sessions" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+
+			// Only the overridden collection changes
+			want := defaultCollectionNames()
+			tt.set(&want)
+			if got := loadCollectionNames(&envReader{}); got != want {
+				t.Errorf("with %s=%q collections = %+v, want %+v", tt.key, tt.value, got, want)
+			}
+		})
+	}
+}
+
+func TestConfigDatabase(t *testing.T) {
+	// Connecting doesn't reach the server, so nothing needs to listen there
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", defaultDatabaseName},
+		{"site_test", "site_test"},
+	}
+	for _, tt := range tests {
+		t.Setenv("MONGO_DB", tt.value)
+		c, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() with MONGO_DB=%q = %v", tt.value, err)
+		}
+		if got := c.database(client).Name(); got != tt.want {
+			t.Errorf("database with MONGO_DB=%q = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// healthcheckTimeout is how long the healthcheck command waits for the server to answer
+const healthcheckTimeout = 5 * time.Second
+
+// initDatabase creates the indexes queries rely on and the configured counters
+func (s *Server) initDatabase(ctx context.Context) error {
+	if err := ensureIndexes(ctx, s.db, s.config); err != nil {
+		return fmt.Errorf("creating indexes: %w", err)
+	}
+	if err := s.initializeCounters(ctx); err != nil {
+		return fmt.Errorf("creating counters: %w", err)
+	}
+	return nil
+}
+
+// exportCollectionNames returns the collections to export given the export command's
+// -collection flag: just that one, or every backed up collection if it's empty. Only backed up
+// collections can be exported, since those are the ones an import accepts.
+func exportCollectionNames(collections CollectionNames, collection string) ([]string, error) {
+	names := backupCollections(collections)
+	if collection == "" {
+		return names, nil
+	}
+	if !slices.Contains(names, collection) {
+		return nil, fmt.Errorf("can't export %q, expected one of %s", collection, strings.Join(names, ", "))
+	}
+	return []string{collection}, nil
+}
+
+// exportCollections writes the named collections to w in the format exportHandler uses, so
+// they can be restored with POST /admin/import
+func exportCollections(ctx context.Context, w io.Writer, db *mongo.Database, names []string) error {
+	out := bufio.NewWriter(w)
+	for _, name := range names {
+		if err := exportCollection(ctx, out, db.Collection(name)); err != nil {
+			return fmt.Errorf("exporting %s: %w", name, err)
+		}
+	}
+	return out.Flush()
+}
+
+// exportToFile writes the named collections to a new file at path. If the export fails the
+// file is removed, rather than left looking like a complete backup.
+func exportToFile(ctx context.Context, db *mongo.Database, names []string, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := exportCollections(ctx, file, db, names); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// checkReady returns an error unless the server at baseURL answers its readiness check with 200
+func checkReady(ctx context.Context, baseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/readyz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("checking readiness: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("not ready: /readyz returned status %d", resp.StatusCode)
+	}
+	return nil
+}
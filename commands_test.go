@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUnknownCommandPrintsUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := runCommand([]string{"frobnicate"}, &stderr); code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), `unknown command "frobnicate"`) || !strings.Contains(stderr.String(), "Usage:") {
+		t.Errorf("output = %q, want the unknown command and usage", stderr.String())
+	}
+}
+
+func TestCommandFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{args: []string{"help"}, code: 0},
+		{args: []string{"export", "-h"}, code: 0},
+		{args: []string{"-nope"}, code: 2},
+		{args: []string{"init-db", "-nope"}, code: 2},
+		{args: []string{"healthcheck", "extra"}, code: 2},
+	}
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		if code := runCommand(tt.args, &stderr); code != tt.code {
+			t.Errorf("%v exit code = %d, want %d (output %q)", tt.args, code, tt.code, stderr.String())
+		}
+	}
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	var ready atomic.Bool
+	ready.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			t.Errorf("healthcheck requested %s, want /readyz", r.URL.Path)
+		}
+		if !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	u, _ := url.Parse(server.URL)
+
+	var stderr bytes.Buffer
+	if code := runCommand([]string{"healthcheck", "-port", u.Port()}, &stderr); code != 0 {
+		t.Errorf("ready server: exit code = %d, want 0 (output %q)", code, stderr.String())
+	}
+	ready.Store(false)
+	if code := runCommand([]string{"healthcheck", "-port", u.Port()}, &stderr); code != 1 {
+		t.Errorf("unready server: exit code = %d, want 1", code)
+	}
+
+	server.Close()
+	if err := checkReady(context.Background(), server.URL); err == nil {
+		t.Error("checkReady() of a stopped server = nil, want an error")
+	}
+}
+
+func TestExportCollectionNames(t *testing.T) {
+	collections := defaultCollectionNames()
+	if names, err := exportCollectionNames(collections, ""); err != nil || len(names) != len(backupCollections(collections)) {
+		t.Errorf(`exportCollectionNames("") = %v, %v; want every backed up collection`, names, err)
+	}
+	if names, err := exportCollectionNames(collections, "quotes"); err != nil || len(names) != 1 || names[0] != "quotes" {
+		t.Errorf(`exportCollectionNames(collections, "quotes") = %v, %v; want just quotes`, names, err)
+	}
+	if _, err := exportCollectionNames(collections, "sessions"); err == nil {
+		t.Error(`exportCollectionNames(collections, "sessions") = nil error, want sessions refused`)
+	}
+}
+
+func TestInitDatabaseAndExport(t *testing.T) {
+	s := newMongoTestServer(t)
+	ctx := context.Background()
+	if err := s.initDatabase(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range builtInCounters {
+		if _, err := s.counters.GetCounter(ctx, id); err != nil {
+			t.Errorf("counter %s after initDatabase: %v", id, err)
+		}
+	}
+
+	quote, _ := newQuote("Ada", "Simplicity is prerequisite for reliability", "", nil)
+	quote.Timestamp = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.quotes.InsertQuote(ctx, quote); err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if err := exportCollections(ctx, &backup, s.db, []string{s.config.Collections.Quotes}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(backup.String()), "\n")
+	if len(lines) != 2 || lines[0] != `{"collection":"quotes"}` || !strings.Contains(lines[1], "Simplicity") {
+		t.Errorf("export = %q, want the quotes marker and the quote", backup.String())
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Config holds every setting read from the environment. It's loaded and validated once at
+// startup, so a bad setting stops the server before it serves anything.
+type Config struct {
+	LogFormat string
+	LogLevel  slog.Level
+
+	HTTPReadHeaderTimeout time.Duration
+	HTTPReadTimeout       time.Duration
+	HTTPWriteTimeout      time.Duration
+	HTTPIdleTimeout       time.Duration
+
+	MongoURI             string
+	MongoDB              string
+	MongoPool            MongoPoolSettings
+	MongoConnectDeadline time.Duration
+	MongoReadTimeout     time.Duration
+	MongoWriteTimeout    time.Duration
+	// StoreBreakerThreshold is how many storage calls in a row must fail before the site goes
+	// read-only for StoreBreakerCooldown
+	StoreBreakerThreshold int
+	StoreBreakerCooldown  time.Duration
+	MongoReadPreference   readpref.Mode
+	MongoWriteConcern     *writeconcern.WriteConcern
+	Collections           CollectionNames
+
+	// Environment is GO_ENV, e.g. "production". Only the demo seeder checks it.
+	Environment   string
+	Port          string
+	ShutdownGrace time.Duration
+
+	DevMode         bool
+	AssetsDir       string
+	ReloadTemplates bool
+
+	AdminToken   string
+	AuditEnabled bool
+	IPHashSalt   string
+	// SessionSecret signs session cookies
+	SessionSecret []byte
+
+	Features    FeatureFlags
+	Maintenance MaintenanceStatus
+	// ReadOnly starts the site refusing writes to counters and quotes
+	ReadOnly bool
+
+	Counting CounterSettings
+	// InitCounters are the counters created at startup if they don't exist
+	InitCounters         []string
+	DailyResetCounterIDs []string
+	PageViewWindow       time.Duration
+	// MaxMindDBPath is a GeoLite2-Country database for counting page views by country
+	MaxMindDBPath  string
+	SeedQuotesFile string
+	// UploadDir is where images attached to quotes are saved, or "" to not accept them
+	UploadDir string
+	Slack     SlackConfig
+	Contact   ContactConfig
+
+	// SiteURL is the site's public address, without a trailing slash. It's empty unless
+	// SITE_URL is set.
+	SiteURL string
+
+	RateLimitBackend            string
+	RateLimitRPM                int
+	RateLimitBurst              int
+	CounterRateLimitRPM         int
+	RateLimitAllowlist          []netip.Prefix
+	RateLimitBypassSecret       []byte
+	TrustedProxies              []netip.Prefix
+	APITokens                   []string
+	APITokenRateLimitMultiplier int
+
+	GitHubUsername   string
+	GitHubMaxDisplay int
+	GitHubAPIBase    string
+	// GitHubFallbackFile is a JSON snapshot of repos shown when GitHub can't be reached and
+	// none have been fetched yet
+	GitHubFallbackFile string
+
+	WSBroadcastBuffer   int
+	WSReadBufferSize    int
+	WSWriteBufferSize   int
+	WSEnableCompression bool
+}
+
+// LoadConfig reads the configuration from the environment, using defaults for unset settings.
+// The error lists every invalid setting, not just the first.
+func LoadConfig() (Config, error) {
+	return readConfig(&envReader{})
+}
+
+// defaultConfig returns the configuration used when nothing is set in the environment
+func defaultConfig() Config {
+	c, _ := readConfig(&envReader{getenv: func(string) string { return "" }})
+	return c
+}
+
+// readConfig reads the configuration from env
+func readConfig(env *envReader) (Config, error) {
+	c := Config{
+		LogFormat: env.string("LOG_FORMAT", "text"),
+		LogLevel:  env.level("LOG_LEVEL", slog.LevelInfo),
+
+		HTTPReadHeaderTimeout: time.Duration(env.int("HTTP_READ_HEADER_TIMEOUT_MS", 2000)) * time.Millisecond,
+		HTTPReadTimeout:       env.seconds("HTTP_READ_TIMEOUT_SECONDS", 5),
+		HTTPWriteTimeout:      env.seconds("HTTP_WRITE_TIMEOUT_SECONDS", 10),
+		HTTPIdleTimeout:       env.seconds("HTTP_IDLE_TIMEOUT_SECONDS", 120),
+
+		MongoURI:              env.string("MONGO_URI", "mongodb://localhost:27017"),
+		MongoDB:               env.string("MONGO_DB", defaultDatabaseName),
+		MongoPool:             loadMongoPoolSettings(env),
+		MongoConnectDeadline:  env.seconds("MONGO_CONNECT_DEADLINE_SECONDS", 60),
+		MongoReadTimeout:      env.seconds("MONGO_READ_TIMEOUT_SECONDS", 3),
+		MongoWriteTimeout:     env.seconds("MONGO_WRITE_TIMEOUT_SECONDS", 5),
+		StoreBreakerThreshold: env.int("STORE_BREAKER_THRESHOLD", 5),
+		StoreBreakerCooldown:  env.seconds("STORE_BREAKER_COOLDOWN_SECONDS", 30),
+		MongoReadPreference:   loadReadPreference(env),
+		MongoWriteConcern:     loadWriteConcern(env),
+		Collections:           loadCollectionNames(env),
+
+		Environment:   env.string("GO_ENV", ""),
+		Port:          env.string("PORT", "8080"),
+		ShutdownGrace: env.seconds("SHUTDOWN_GRACE_SECONDS", 15),
+
+		DevMode: env.bool("DEV_MODE", false),
+
+		AdminToken:   env.string("ADMIN_TOKEN", ""),
+		AuditEnabled: env.bool("AUDIT_ENABLED", false),
+		IPHashSalt:   env.string("IP_HASH_SALT", ""),
+
+		SessionSecret: loadSessionSecret(env),
+
+		Maintenance: MaintenanceStatus{
+			Enabled: env.bool("MAINTENANCE_MODE", false),
+			Message: env.string("MAINTENANCE_MESSAGE", ""),
+		},
+		ReadOnly: env.bool("READ_ONLY", false),
+
+		Counting:             loadCounterSettings(env),
+		InitCounters:         loadInitCounters(env),
+		DailyResetCounterIDs: loadCounterIDs(env, "DAILY_RESET_COUNTER_IDS"),
+		PageViewWindow:       time.Duration(env.int("PAGEVIEW_DEDUP_MINUTES", 30)) * time.Minute,
+		MaxMindDBPath:        env.string("MAXMIND_DB_PATH", ""),
+		SeedQuotesFile:       env.string("SEED_QUOTES_FILE", ""),
+		UploadDir:            env.string("UPLOAD_DIR", ""),
+		Slack:                loadSlackConfig(env),
+		Contact:              loadContactConfig(env),
+
+		SiteURL: strings.TrimRight(env.string("SITE_URL", ""), "/"),
+
+		RateLimitBackend:            env.string("RATE_LIMIT_BACKEND", "memory"),
+		RateLimitRPM:                env.int("RATELIMIT_RPM", 5),
+		RateLimitBurst:              env.int("RATELIMIT_BURST", 5),
+		CounterRateLimitRPM:         env.int("COUNTERS_RATELIMIT_RPM", 60),
+		RateLimitAllowlist:          loadPrefixes(env, "RATE_LIMIT_ALLOWLIST"),
+		RateLimitBypassSecret:       []byte(env.string("RATE_LIMIT_BYPASS_SECRET", "")),
+		TrustedProxies:              loadPrefixes(env, "TRUSTED_PROXIES"),
+		APITokens:                   loadAPITokens(env),
+		APITokenRateLimitMultiplier: env.int("API_TOKEN_RATELIMIT_MULTIPLIER", 10),
+
+		GitHubUsername:     env.string("GITHUB_USERNAME", "wsoule"),
+		GitHubMaxDisplay:   env.int("GITHUB_MAX_DISPLAY", 12),
+		GitHubAPIBase:      strings.TrimRight(env.string("GITHUB_API_BASE", "https://api.github.com"), "/"),
+		GitHubFallbackFile: env.string("GITHUB_FALLBACK_FILE", ""),
+
+		WSBroadcastBuffer:   env.int("WS_BROADCAST_BUFFER", 64),
+		WSReadBufferSize:    env.int("WS_READ_BUFFER_SIZE", 1024),
+		WSWriteBufferSize:   env.int("WS_WRITE_BUFFER_SIZE", 1024),
+		WSEnableCompression: env.bool("WS_ENABLE_COMPRESSION", false),
+	}
+	c.AssetsDir, c.ReloadTemplates = loadAssetsDir(env)
+	c.ReloadTemplates = c.ReloadTemplates || c.DevMode
+
+	// Feature flags are a JSON object rather than a single value
+	flags, err := parseFeatureFlags(env.string("FEATURES", ""))
+	if err != nil {
+		env.problem("FEATURES", "must be a JSON object of flags: %v", err)
+	}
+	c.Features = flags
+
+	return c, errors.Join(append(env.problems, c.Validate())...)
+}
+
+// Validate checks the settings that depend on each other or need more than parsing
+func (c Config) Validate() error {
+	var problems []error
+	if !strings.EqualFold(c.LogFormat, "text") && !strings.EqualFold(c.LogFormat, "json") {
+		problems = append(problems, fmt.Errorf("LOG_FORMAT %q must be text or json", c.LogFormat))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Errorf("PORT %q must be a port number", c.Port))
+	}
+	if c.RateLimitBackend != "memory" && c.RateLimitBackend != "mongo" {
+		problems = append(problems, fmt.Errorf("RATE_LIMIT_BACKEND %q must be memory or mongo", c.RateLimitBackend))
+	}
+	if c.AuditEnabled && c.IPHashSalt == "" {
+		problems = append(problems, errors.New("IP_HASH_SALT must be set when AUDIT_ENABLED is true, so hashed IPs can't be reversed"))
+	}
+	if err := validateURL(c.GitHubAPIBase); err != nil {
+		problems = append(problems, fmt.Errorf("GITHUB_API_BASE %w", err))
+	}
+	if c.SiteURL != "" {
+		if err := validateURL(c.SiteURL); err != nil {
+			problems = append(problems, fmt.Errorf("SITE_URL %w", err))
+		}
+	}
+	if c.Slack.WebhookURL != "" {
+		if err := validateURL(c.Slack.WebhookURL); err != nil {
+			problems = append(problems, fmt.Errorf("SLACK_WEBHOOK_URL %w", err))
+		}
+	}
+	if c.Contact.SMTPHost != "" {
+		if _, err := mail.ParseAddress(c.Contact.To); err != nil {
+			problems = append(problems, fmt.Errorf("CONTACT_EMAIL %q must be an email address when SMTP_HOST is set", c.Contact.To))
+		}
+		if port, err := strconv.Atoi(c.Contact.SMTPPort); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Errorf("SMTP_PORT %q must be a port number", c.Contact.SMTPPort))
+		}
+	}
+	if c.Contact.WebhookURL != "" {
+		if err := validateURL(c.Contact.WebhookURL); err != nil {
+			problems = append(problems, fmt.Errorf("CONTACT_WEBHOOK_URL %w", err))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// validateURL checks that raw is an absolute http or https URL
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an http or https URL", raw)
+	}
+	return nil
+}
+
+// envReader reads settings from the environment, noting every invalid one so they can all be
+// reported together
+type envReader struct {
+	// getenv looks up a setting, or os.Getenv when nil
+	getenv   func(string) string
+	problems []error
+}
+
+// get returns the setting's raw value, which is empty when it's unset
+func (e *envReader) get(key string) string {
+	if e.getenv == nil {
+		return os.Getenv(key)
+	}
+	return e.getenv(key)
+}
+
+// problem notes an invalid setting
+func (e *envReader) problem(key, format string, args ...any) {
+	e.problems = append(e.problems, fmt.Errorf("%s %s", key, fmt.Sprintf(format, args...)))
+}
+
+// string reads a setting, falling back to the default when it is unset or empty
+func (e *envReader) string(key, fallback string) string {
+	if value := e.get(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// int reads a positive integer
+func (e *envReader) int(key string, fallback int) int {
+	value := e.get(key)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		e.problem(key, "%q must be a positive whole number", value)
+		return fallback
+	}
+	return n
+}
+
+// seconds reads a positive duration given as a whole number of seconds
+func (e *envReader) seconds(key string, fallback int) time.Duration {
+	return time.Duration(e.int(key, fallback)) * time.Second
+}
+
+// bool reads true or false, also accepting the other spellings strconv.ParseBool does
+func (e *envReader) bool(key string, fallback bool) bool {
+	value := e.get(key)
+	if value == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.problem(key, "%q must be true or false", value)
+		return fallback
+	}
+	return b
+}
+
+// level reads a log level: debug, info, warn, or error
+func (e *envReader) level(key string, fallback slog.Level) slog.Level {
+	value := e.get(key)
+	if value == "" {
+		return fallback
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		e.problem(key, "%q must be debug, info, warn, or error", value)
+		return fallback
+	}
+	return level
+}
+
+// loadPrefixes reads a comma-separated list of IPs and CIDRs
+func loadPrefixes(env *envReader, key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for entry := range strings.SplitSeq(env.get(key), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, err := parseBanPrefix(entry)
+		if err != nil {
+			env.problem(key, "entry %q: %v", entry, err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
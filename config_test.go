@@ -0,0 +1,238 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() with no settings = %v, want no error", err)
+	}
+
+	if c.Port != "8080" || c.LogFormat != "text" || c.LogLevel != slog.LevelInfo {
+		t.Errorf("port, log format, level = %q, %q, %v; want 8080, text, INFO", c.Port, c.LogFormat, c.LogLevel)
+	}
+	if c.MongoReadTimeout != 3*time.Second || c.MongoWriteTimeout != 5*time.Second || c.ShutdownGrace != 15*time.Second {
+		t.Errorf("timeouts = %v, %v, %v; want 3s, 5s, 15s", c.MongoReadTimeout, c.MongoWriteTimeout, c.ShutdownGrace)
+	}
+	if c.RateLimitBackend != "memory" || c.RateLimitRPM != 5 || c.CounterRateLimitRPM != 60 {
+		t.Errorf("rate limits = %q, %d, %d; want memory, 5, 60", c.RateLimitBackend, c.RateLimitRPM, c.CounterRateLimitRPM)
+	}
+	if c.MongoReadPreference != readpref.PrimaryMode {
+		t.Errorf("MongoReadPreference = %v, want primary", c.MongoReadPreference)
+	}
+	if c.MongoWriteConcern != nil {
+		t.Errorf("MongoWriteConcern = %+v, want the driver default", c.MongoWriteConcern)
+	}
+	if c.Counting != (CounterSettings{PageViews: true, Webhook: true, TotalClicks: true}) {
+		t.Errorf("Counting = %+v, want every counter on", c.Counting)
+	}
+	if !reflect.DeepEqual(c.InitCounters, builtInCounters) {
+		t.Errorf("InitCounters = %v, want the built-in counters", c.InitCounters)
+	}
+	if c.Features != defaultFeatureFlags() {
+		t.Errorf("Features = %+v, want the defaults", c.Features)
+	}
+	if c.HTTPReadTimeout != 5*time.Second || c.HTTPWriteTimeout != 10*time.Second || c.HTTPIdleTimeout != 2*time.Minute {
+		t.Errorf("HTTP timeouts = %s read, %s write, %s idle; want 5s, 10s, 2m", c.HTTPReadTimeout, c.HTTPWriteTimeout, c.HTTPIdleTimeout)
+	}
+	if c.GitHubUsername != "wsoule" || c.GitHubAPIBase != "https://api.github.com" {
+		t.Errorf("GitHub = %q, %q; want wsoule and the public API", c.GitHubUsername, c.GitHubAPIBase)
+	}
+}
+
+func TestLoadConfigParsesSettings(t *testing.T) {
+	tests := []struct {
+		key, value string
+		check      func(Config) bool
+	}{
+		{"PORT", "9090", func(c Config) bool { return c.Port == "9090" }},
+		{"LOG_FORMAT", "json", func(c Config) bool { return c.LogFormat == "json" }},
+		{"LOG_LEVEL", "debug", func(c Config) bool { return c.LogLevel == slog.LevelDebug }},
+		{"HTTP_READ_HEADER_TIMEOUT_MS", "500", func(c Config) bool { return c.HTTPReadHeaderTimeout == 500*time.Millisecond }},
+		{"HTTP_READ_TIMEOUT_SECONDS", "3", func(c Config) bool { return c.HTTPReadTimeout == 3*time.Second }},
+		{"HTTP_WRITE_TIMEOUT_SECONDS", "30", func(c Config) bool { return c.HTTPWriteTimeout == 30*time.Second }},
+		{"HTTP_IDLE_TIMEOUT_SECONDS", "300", func(c Config) bool { return c.HTTPIdleTimeout == 5*time.Minute }},
+		{"MONGO_READ_SECONDARY", "true", func(c Config) bool { return c.MongoReadPreference == readpref.SecondaryPreferredMode }},
+		{"MONGO_READ_PREFERENCE", "nearest", func(c Config) bool { return c.MongoReadPreference == readpref.NearestMode }},
+		{"MONGO_CONNECT_DEADLINE_SECONDS", "120", func(c Config) bool { return c.MongoConnectDeadline == 2*time.Minute }},
+		{"MONGO_READ_TIMEOUT_SECONDS", "7", func(c Config) bool { return c.MongoReadTimeout == 7*time.Second }},
+		{"STORE_BREAKER_THRESHOLD", "3", func(c Config) bool { return c.StoreBreakerThreshold == 3 }},
+		{"STORE_BREAKER_COOLDOWN_SECONDS", "10", func(c Config) bool { return c.StoreBreakerCooldown == 10*time.Second }},
+		{"DEV_MODE", "true", func(c Config) bool { return c.DevMode && c.ReloadTemplates }},
+		{"COUNT_WEBHOOK", "false", func(c Config) bool { return !c.Counting.Webhook && c.Counting.PageViews }},
+		{"PAGEVIEW_DEDUP_MINUTES", "5", func(c Config) bool { return c.PageViewWindow == 5*time.Minute }},
+		{"MAXMIND_DB_PATH", "GeoLite2-Country.mmdb", func(c Config) bool { return c.MaxMindDBPath == "GeoLite2-Country.mmdb" }},
+		{"INIT_COUNTERS", "webhook, Likes,TOTALCLICKS, likes", func(c Config) bool {
+			return reflect.DeepEqual(c.InitCounters, []string{"webhook", "likes", "totalClicks"})
+		}},
+		{"DAILY_RESET_COUNTER_IDS", "Daily, hits", func(c Config) bool {
+			return reflect.DeepEqual(c.DailyResetCounterIDs, []string{"daily", "hits"})
+		}},
+		{"TRUSTED_PROXIES", "10.0.0.1, 192.168.0.0/16", func(c Config) bool {
+			return reflect.DeepEqual(c.TrustedProxies, []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.1/32"), netip.MustParsePrefix("192.168.0.0/16"),
+			})
+		}},
+		{"GITHUB_USERNAME", "octocat", func(c Config) bool { return c.GitHubUsername == "octocat" }},
+		{"GITHUB_API_BASE", "http://localhost:9000/", func(c Config) bool { return c.GitHubAPIBase == "http://localhost:9000" }},
+		{"GITHUB_FALLBACK_FILE", "repos.json", func(c Config) bool { return c.GitHubFallbackFile == "repos.json" }},
+		{"WS_BROADCAST_BUFFER", "8", func(c Config) bool { return c.WSBroadcastBuffer == 8 }},
+		{"FEATURES", `{"graphql": false}`, func(c Config) bool { return !c.Features.GraphQL && c.Features.QuoteSubmissions }},
+		{"SITE_URL", "https://example.com/", func(c Config) bool { return c.SiteURL == "https://example.com" }},
+		{"API_TOKENS", "first-token-0123456789, second-token-0123456789", func(c Config) bool {
+			return reflect.DeepEqual(c.APITokens, []string{"first-token-0123456789", "second-token-0123456789"})
+		}},
+		{"MONGO_WRITE_CONCERN", "majority", func(c Config) bool {
+			return c.MongoWriteConcern.W == "majority" && c.MongoWriteConcern.WTimeout == 5*time.Second
+		}},
+		{"MONGO_WRITE_CONCERN", "w1", func(c Config) bool { return c.MongoWriteConcern.W == 1 }},
+		{"MONGO_WRITE_CONCERN", "3", func(c Config) bool { return c.MongoWriteConcern.W == 3 }},
+		{"MAINTENANCE_MODE", "1", func(c Config) bool { return c.Maintenance.Enabled }},
+		{"READ_ONLY", "true", func(c Config) bool { return c.ReadOnly }},
+		{"UPLOAD_DIR", "/var/lib/site/uploads", func(c Config) bool { return c.UploadDir == "/var/lib/site/uploads" }},
+		{"CONTACT_EMAIL", "me@example.com", func(c Config) bool {
+			return c.Contact.To == "me@example.com" && c.Contact.SMTPFrom == "me@example.com" && c.Contact.SMTPPort == "587"
+		}},
+		{"CONTACT_WEBHOOK_URL", "https://hooks.example.com/contact", func(c Config) bool {
+			return c.Contact.WebhookURL == "https://hooks.example.com/contact"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			c, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() with %s=%q = %v, want no error", tt.key, tt.value, err)
+			}
+			if !tt.check(c) {
+				t.Errorf("LoadConfig() with %s=%q = %+v", tt.key, tt.value, c)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"RATELIMIT_RPM": "lots"}, "RATELIMIT_RPM"},
+		{map[string]string{"MONGO_MAX_POOL_SIZE": "0"}, "MONGO_MAX_POOL_SIZE"},
+		{map[string]string{"AUDIT_ENABLED": "yes"}, "AUDIT_ENABLED"},
+		{map[string]string{"LOG_LEVEL": "loud"}, "LOG_LEVEL"},
+		{map[string]string{"LOG_FORMAT": "xml"}, "LOG_FORMAT"},
+		{map[string]string{"MONGO_READ_PREFERENCE": "secondaries"}, "MONGO_READ_PREFERENCE"},
+		{map[string]string{"MONGO_READ_PREFERENCE": "nearest", "MONGO_READ_SECONDARY": "true"}, "MONGO_READ_SECONDARY"},
+		{map[string]string{"TRUSTED_PROXIES": "10.0.0.1, proxy"}, "TRUSTED_PROXIES"},
+		{map[string]string{"RATE_LIMIT_ALLOWLIST": "10.0.0.0/33"}, "RATE_LIMIT_ALLOWLIST"},
+		{map[string]string{"DAILY_RESET_COUNTER_IDS": "ok,no spaces"}, "DAILY_RESET_COUNTER_IDS"},
+		{map[string]string{"INIT_COUNTERS": "ok,no spaces"}, "INIT_COUNTERS"},
+		{map[string]string{"FEATURES": "graphql"}, "FEATURES"},
+		{map[string]string{"PORT": "http"}, "PORT"},
+		{map[string]string{"PORT": "70000"}, "PORT"},
+		{map[string]string{"RATE_LIMIT_BACKEND": "redis"}, "RATE_LIMIT_BACKEND"},
+		{map[string]string{"AUDIT_ENABLED": "true"}, "IP_HASH_SALT"},
+		{map[string]string{"GITHUB_API_BASE": "api.github.com"}, "GITHUB_API_BASE"},
+		{map[string]string{"SITE_URL": "example.com"}, "SITE_URL"},
+		{map[string]string{"SMTP_HOST": "smtp.example.com"}, "CONTACT_EMAIL"},
+		{map[string]string{"SMTP_HOST": "smtp.example.com", "CONTACT_EMAIL": "me@example.com", "SMTP_PORT": "smtp"}, "SMTP_PORT"},
+		{map[string]string{"CONTACT_WEBHOOK_URL": "hooks.example.com"}, "CONTACT_WEBHOOK_URL"},
+		{map[string]string{"MONGO_WRITE_CONCERN": "all"}, "MONGO_WRITE_CONCERN"},
+		{map[string]string{"MONGO_WRITE_CONCERN": "0"}, "MONGO_WRITE_CONCERN"},
+		{map[string]string{"MONGO_WRITE_CONCERN_TIMEOUT_MS": "soon"}, "MONGO_WRITE_CONCERN_TIMEOUT_MS"},
+		{map[string]string{"API_TOKENS": "long-enough-token-1234, short"}, "API_TOKENS"},
+		{map[string]string{"API_TOKEN_RATELIMIT_MULTIPLIER": "0"}, "API_TOKEN_RATELIMIT_MULTIPLIER"},
+		{map[string]string{"SESSION_SECRET": "too-short"}, "SESSION_SECRET"},
+		{map[string]string{"SLACK_WEBHOOK_URL": "hooks.slack.com/x"}, "SLACK_WEBHOOK_URL"},
+		{map[string]string{"MONGO_MIN_POOL_SIZE": "50", "MONGO_MAX_POOL_SIZE": "10"}, "MONGO_MIN_POOL_SIZE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() with %v = %v, want an error naming %s", tt.env, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	t.Setenv("PORT", "0")
+	t.Setenv("RATELIMIT_BURST", "-1")
+	t.Setenv("AUDIT_ENABLED", "true")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() = nil, want an error")
+	}
+	for _, want := range []string{"PORT", "RATELIMIT_BURST", "IP_HASH_SALT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadConfig() = %q, want it to mention %s", err, want)
+		}
+	}
+	if lines := strings.Count(err.Error(), "\n") + 1; lines != 3 {
+		t.Errorf("LoadConfig() reported %d problems, want 3: %q", lines, err)
+	}
+}
+
+func TestDefaultConfigIgnoresEnvironment(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "from-the-environment")
+	t.Setenv("RATELIMIT_RPM", "99")
+	c := defaultConfig()
+	if c.AdminToken != "" || c.RateLimitRPM != 5 {
+		t.Errorf("defaultConfig() admin token, rate = %q, %d; want the defaults", c.AdminToken, c.RateLimitRPM)
+	}
+}
+
+func TestServersKeepTheirOwnConfig(t *testing.T) {
+	first, second := defaultConfig(), defaultConfig()
+	first.AdminToken = "first-token"
+	first.Counting.Webhook = false
+	second.AdminToken = "second-token"
+	firstHandler := newTestServerWithConfig(t, first).routes()
+	secondHandler := newTestServerWithConfig(t, second).routes()
+
+	admin := func(h http.Handler, token string) int {
+		r := newJSONRequest(http.MethodGet, "/admin/runtime", "")
+		r.Header.Set("Authorization", "Bearer "+token)
+		return serve(h, r).Code
+	}
+	for _, tt := range []struct {
+		name  string
+		h     http.Handler
+		token string
+		want  int
+	}{
+		{"first server, its token", firstHandler, "first-token", http.StatusOK},
+		{"first server, second's token", firstHandler, "second-token", http.StatusUnauthorized},
+		{"second server, its token", secondHandler, "second-token", http.StatusOK},
+		{"second server, first's token", secondHandler, "first-token", http.StatusUnauthorized},
+	} {
+		if got := admin(tt.h, tt.token); got != tt.want {
+			t.Errorf("%s: GET /admin/runtime = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Only the first server has the webhook counter turned off
+	if w := serveRequest(firstHandler, http.MethodPost, "/increment", "", "192.0.2.150:1000"); w.Code != http.StatusNotFound {
+		t.Errorf("POST /increment on the first server = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serveRequest(secondHandler, http.MethodPost, "/increment", "", "192.0.2.151:1000"); w.Code != http.StatusOK {
+		t.Errorf("POST /increment on the second server = %d, want %d", w.Code, http.StatusOK)
+	}
+}
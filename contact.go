@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// maxContactNameLength, maxContactEmailLength, and maxContactMessageLength are the longest
+	// name, email address, and message the contact form accepts, in characters
+	maxContactNameLength    = 100
+	maxContactEmailLength   = 254
+	maxContactMessageLength = 5000
+	// contactRateLimitRPM is how many messages a visitor can send a minute, less than quotes
+	// since each one lands in an inbox
+	contactRateLimitRPM = 1
+)
+
+var (
+	errContactNameInvalid    = errors.New("name must be 1 to 100 characters")
+	errContactEmailInvalid   = errors.New("email must be a valid email address")
+	errContactMessageInvalid = errors.New("message must be 1 to 5,000 characters")
+)
+
+// ContactMessage is a message sent through the contact form
+type ContactMessage struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name    string             `bson:"name" json:"name"`
+	Email   string             `bson:"email" json:"email"`
+	Message string             `bson:"message" json:"message"`
+	// IPHash is the hash of the sender's IP address, from quoteAuthorIPHash
+	IPHash    string    `bson:"ipHash,omitempty" json:"ipHash,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	// Read is set by an admin once they've seen the message
+	Read bool `bson:"read" json:"read"`
+	// Delivered is set once the message has been emailed or posted to the webhook
+	Delivered bool `bson:"delivered" json:"delivered"`
+}
+
+// ContactPageData is what the contact page is rendered from
+type ContactPageData struct {
+	PageData
+	// FormToken records when the form was rendered, for the timing check
+	FormToken string
+	// Sent thanks the visitor for the message they just sent
+	Sent bool
+	// Error is why the last submission was refused, shown above the form with its values kept
+	Error string
+	Form  ContactMessage
+}
+
+// newContactMessage checks and tidies the contact form's fields
+func newContactMessage(name, email, message string) (ContactMessage, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxContactNameLength || strings.ContainsFunc(name, unicode.IsControl) {
+		return ContactMessage{}, errContactNameInvalid
+	}
+
+	// Only a bare address is accepted, since it ends up in the Reply-To header
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > maxContactEmailLength {
+		return ContactMessage{}, errContactEmailInvalid
+	}
+
+	message = strings.TrimSpace(message)
+	if message == "" || utf8.RuneCountInString(message) > maxContactMessageLength {
+		return ContactMessage{}, errContactMessageInvalid
+	}
+
+	return ContactMessage{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Email:     email,
+		Message:   message,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// contactPageHandler renders the contact form
+func (s *Server) contactPageHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderContactPage(w, r, http.StatusOK, ContactPageData{Sent: r.URL.Query().Get("sent") == "1"})
+}
+
+// renderContactPage renders the contact page with data, filling in the parts every page shares
+// and a fresh form token
+func (s *Server) renderContactPage(w http.ResponseWriter, r *http.Request, status int, data ContactPageData) {
+	data.PageData = PageData{
+		Name:     siteName,
+		Commit:   currentBuildInfo().ShortCommit(),
+		Theme:    requestTheme(r),
+		ReadOnly: s.isReadOnly(),
+	}
+	data.FormToken = newFormToken(time.Now())
+
+	var page bytes.Buffer
+	if err := s.render(&page, "contact", data); err != nil {
+		s.log(r.Context(), "contact").Error("rendering contact page", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error rendering page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(page.Bytes())
+}
+
+// contactHandler saves a message sent through the contact form and delivers it in the
+// background, so a slow or failing mail server doesn't hold up the visitor. Submissions caught
+// by the honeypot or the timing check get the same response as real ones, so bots don't learn
+// what gave them away, but are dropped.
+func (s *Server) contactHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Error parsing form")
+		return
+	}
+
+	message, err := newContactMessage(r.PostFormValue("name"), r.PostFormValue("email"), r.PostFormValue("message"))
+	if err != nil {
+		s.refuseContact(w, r, http.StatusBadRequest, capitalize(err.Error()))
+		return
+	}
+
+	reason := ""
+	switch err := checkFormToken(r.PostFormValue(formTokenField), time.Now()); {
+	case filledHoneypot(r):
+		reason = "honeypot"
+	case errors.Is(err, errFormTooFast):
+		reason = "too_fast"
+	case err != nil:
+		s.refuseContact(w, r, http.StatusBadRequest, "The form expired, please send your message again")
+		return
+	}
+	if reason != "" {
+		spamRejections.WithLabelValues("contact", reason).Inc()
+		s.log(r.Context(), "contact").Info("dropping contact message as spam", "reason", reason)
+		s.respondContactSent(w, r, message)
+		return
+	}
+
+	message.IPHash = s.quoteAuthorIPHash(s.getIPAddress(r))
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+	if err := s.messages.InsertMessage(ctx, message); err != nil {
+		status, text := s.writeError(r.Context(), "contact", "insert message", err, "Error saving message")
+		s.refuseContact(w, r, status, text)
+		return
+	}
+
+	go s.deliverContactMessage(s.config.Contact, contactRetryDelay, message)
+	s.respondContactSent(w, r, message)
+}
+
+// refuseContact responds to a contact form submission that wasn't accepted, showing the form
+// again with the visitor's values so they don't have to retype their message
+func (s *Server) refuseContact(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if wantsJSON(r) {
+		s.respondError(w, r, status, message)
+		return
+	}
+	s.renderContactPage(w, r, status, ContactPageData{
+		Error: message,
+		Form: ContactMessage{
+			Name:    r.PostFormValue("name"),
+			Email:   r.PostFormValue("email"),
+			Message: r.PostFormValue("message"),
+		},
+	})
+}
+
+// respondContactSent tells the visitor their message was sent
+func (s *Server) respondContactSent(w http.ResponseWriter, r *http.Request, message ContactMessage) {
+	if wantsJSON(r) {
+		respondJSON(w, http.StatusAccepted, map[string]string{"id": message.ID.Hex()})
+		return
+	}
+	http.Redirect(w, r, "/contact?sent=1", http.StatusSeeOther)
+}
+
+// capitalize upper-cases the first letter of s, for showing an error's text to a visitor
+func capitalize(s string) string {
+	first, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(first)) + s[size:]
+}
+
+// listMessagesHandler lists contact messages, newest first, or only unread ones with ?unread=true
+func (s *Server) listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	messages, err := s.messages.ListMessages(ctx, r.URL.Query().Get("unread") == "true")
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "contact", "list messages", err), "Error listing messages")
+		return
+	}
+	respondJSON(w, http.StatusOK, messages)
+}
+
+// markMessageReadHandler marks a contact message as read
+func (s *Server) markMessageReadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, r, http.StatusNotFound, "Message not found")
+		return
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+	err = s.messages.MarkMessageRead(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		s.respondError(w, r, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		status, message := s.writeError(r.Context(), "contact", "mark message read", err, "Error updating message")
+		s.respondError(w, r, status, message)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
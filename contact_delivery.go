@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// contactMaxAttempts is how many times delivering a contact message is tried before giving up
+const contactMaxAttempts = 5
+
+// contactDeliveryTimeout bounds each attempt to deliver a contact message
+const contactDeliveryTimeout = 30 * time.Second
+
+// contactRetryDelay is how long the first retry of a failed delivery waits, doubling after each
+var contactRetryDelay = 2 * time.Second
+
+// ContactConfig holds where contact form messages are delivered: by email through SMTP_HOST
+// when it's set, otherwise to CONTACT_WEBHOOK_URL when that's set. Without either, messages
+// are only saved.
+type ContactConfig struct {
+	// To is the address messages are emailed to
+	To           string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPFrom is the sender of the emails, To unless it's set
+	SMTPFrom   string
+	WebhookURL string
+}
+
+// loadContactConfig reads the contact form's delivery settings
+func loadContactConfig(env *envReader) ContactConfig {
+	c := ContactConfig{
+		To:           env.string("CONTACT_EMAIL", ""),
+		SMTPHost:     env.string("SMTP_HOST", ""),
+		SMTPPort:     env.string("SMTP_PORT", "587"),
+		SMTPUsername: env.string("SMTP_USERNAME", ""),
+		SMTPPassword: env.string("SMTP_PASSWORD", ""),
+		SMTPFrom:     env.string("SMTP_FROM", ""),
+		WebhookURL:   env.string("CONTACT_WEBHOOK_URL", ""),
+	}
+	if c.SMTPFrom == "" {
+		c.SMTPFrom = c.To
+	}
+	return c
+}
+
+// sender returns how messages are delivered, or nil if they're only saved
+func (c ContactConfig) sender() func(ContactConfig, ContactMessage) error {
+	switch {
+	case c.SMTPHost != "":
+		return sendContactEmail
+	case c.WebhookURL != "":
+		return postContactWebhook
+	}
+	return nil
+}
+
+// deliverContactMessage sends a saved contact message on as config says, retrying with
+// exponential backoff starting at retryDelay, and marks it delivered once it's through. A
+// message that can't be delivered stays saved for admins to read. When the server starts
+// shutting down it stops waiting to retry, leaving the message for redeliverContactMessages
+// after the restart.
+func (s *Server) deliverContactMessage(config ContactConfig, retryDelay time.Duration, message ContactMessage) {
+	send := config.sender()
+	if send == nil {
+		return
+	}
+
+	logger := componentLogger("contact").With("message_id", message.ID.Hex())
+	backoff := retryDelay
+	for attempt := 1; attempt <= contactMaxAttempts; attempt++ {
+		err := send(config, message)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), s.config.MongoWriteTimeout)
+			defer cancel()
+			if err := s.messages.MarkMessageDelivered(ctx, message.ID); err != nil {
+				logger.Warn("marking contact message delivered", "err", err)
+			}
+			return
+		}
+
+		if attempt == contactMaxAttempts {
+			logger.Error("giving up delivering contact message", "attempts", attempt, "err", err)
+			return
+		}
+		logger.Warn("delivering contact message failed", "attempt", attempt, "max_attempts", contactMaxAttempts, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-s.stopping:
+			logger.Info("shutting down, leaving contact message to be delivered after a restart")
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// redeliverContactMessages delivers, one at a time in the background, the saved messages that
+// a restart or failed delivery left undelivered. The messages are listed before it returns, so
+// it must be called before the server starts accepting new ones, which are delivered as
+// they're sent.
+func (s *Server) redeliverContactMessages(ctx context.Context) error {
+	if s.config.Contact.sender() == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.MongoReadTimeout)
+	defer cancel()
+	messages, err := s.messages.ListUndeliveredMessages(ctx)
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	componentLogger("contact").Info("redelivering contact messages", "count", len(messages))
+	go func() {
+		for _, message := range messages {
+			select {
+			case <-s.stopping:
+				return
+			default:
+			}
+			s.deliverContactMessage(s.config.Contact, contactRetryDelay, message)
+		}
+	}()
+	return nil
+}
+
+// contactEmail returns a contact message as an email from config.SMTPFrom to config.To, with
+// replies going to the visitor
+func contactEmail(config ContactConfig, message ContactMessage) []byte {
+	var body bytes.Buffer
+	qp := quotedprintable.NewWriter(&body)
+	fmt.Fprintf(qp, "From %s <%s>, sent %s:\r\n\r\n%s\r\n", message.Name, message.Email,
+		message.CreatedAt.Format(time.RFC1123), strings.ReplaceAll(message.Message, "\n", "\r\n"))
+	qp.Close()
+
+	var email bytes.Buffer
+	headers := []struct{ name, value string }{
+		{"From", config.SMTPFrom},
+		{"To", config.To},
+		{"Reply-To", (&mail.Address{Name: message.Name, Address: message.Email}).String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", "Contact form message from "+message.Name)},
+		{"Date", message.CreatedAt.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&email, "%s: %s\r\n", header.name, header.value)
+	}
+	email.WriteString("\r\n")
+	email.Write(body.Bytes())
+	return email.Bytes()
+}
+
+// sendContactEmail emails a contact message through the SMTP server, upgrading to TLS when the
+// server offers STARTTLS
+func sendContactEmail(config ContactConfig, message ContactMessage) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(config.SMTPHost, config.SMTPPort), contactDeliveryTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(contactDeliveryTimeout))
+	client, err := smtp.NewClient(conn, config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: config.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if config.SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(config.SMTPFrom); err != nil {
+		return err
+	}
+	if err := client.Rcpt(config.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(contactEmail(config, message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// contactWebhookPayload is what's posted to CONTACT_WEBHOOK_URL for each message
+type contactWebhookPayload struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// postContactWebhook posts a contact message to the webhook as JSON
+func postContactWebhook(config ContactConfig, message ContactMessage) error {
+	body, err := json.Marshal(contactWebhookPayload{
+		ID:        message.ID.Hex(),
+		Name:      message.Name,
+		Email:     message.Email,
+		Message:   message.Message,
+		CreatedAt: message.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: contactDeliveryTimeout}
+	resp, err := client.Post(config.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
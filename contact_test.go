@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setContactConfig sets where s delivers contact messages, retrying quickly for the rest of the
+// test
+func setContactConfig(t *testing.T, s *Server, config ContactConfig) {
+	t.Helper()
+	previousDelay := contactRetryDelay
+	t.Cleanup(func() { contactRetryDelay = previousDelay })
+	s.config.Contact, contactRetryDelay = config, time.Millisecond
+}
+
+// contactForm returns the contact form's fields filled in with a token old enough to pass the
+// timing check
+func contactForm(name, email, message string) url.Values {
+	return url.Values{
+		"name":         {name},
+		"email":        {email},
+		"message":      {message},
+		formTokenField: {newFormToken(time.Now().Add(-10 * time.Second))},
+	}
+}
+
+// submitContact posts the contact form from remoteAddr and returns the response
+func submitContact(h http.Handler, form url.Values, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/contact", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = remoteAddr
+	return serve(h, r)
+}
+
+// waitForMessages polls s's store until done reports true for its messages, failing the test
+// if it doesn't within a few seconds
+func waitForMessages(t *testing.T, s *Server, done func([]ContactMessage) bool) []ContactMessage {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages, err := s.messages.ListMessages(t.Context(), false)
+		if err != nil {
+			t.Fatalf("ListMessages() error = %v", err)
+		}
+		if done(messages) {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("messages = %+v, still not as expected", messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestContactPageRendersForm(t *testing.T) {
+	s := newTestServer(t)
+	w := serveRequest(s.routes(), http.MethodGet, "/contact", "", "")
+	body := w.Body.String()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	for _, want := range []string{`name="form_token" value="`, `name="website"`, `action="/contact"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("contact page is missing %q", want)
+		}
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store so the form token is fresh", w.Header().Get("Cache-Control"))
+	}
+
+	w = serveRequest(s.routes(), http.MethodGet, "/contact?sent=1", "", "")
+	if !strings.Contains(w.Body.String(), "your message was sent") {
+		t.Error("contact page after sending doesn't thank the visitor")
+	}
+}
+
+// testContactSubmission checks sending a message and an admin reading it against s's store
+func testContactSubmission(t *testing.T, s *Server) {
+	resetRateLimiters(t)
+	s.config.AdminToken = "secret"
+	setContactConfig(t, s, ContactConfig{})
+	h := s.routes()
+
+	w := submitContact(h, contactForm(" Ada ", "ada@example.com", "Hello there\nHow are you?"), "203.0.113.100:1000")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/contact?sent=1" {
+		t.Fatalf("response = %d to %q, want a redirect to /contact?sent=1", w.Code, w.Header().Get("Location"))
+	}
+	submitContact(h, contactForm("Grace", "grace@example.com", "Second message"), "203.0.113.101:1000")
+
+	// The admin endpoints need the token
+	if w := serveRequest(h, http.MethodGet, "/admin/messages", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("listing without the token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	list := func(target string) []ContactMessage {
+		t.Helper()
+		r := newJSONRequest(http.MethodGet, target, "")
+		r.Header.Set("Authorization", "Bearer secret")
+		w := serve(h, r)
+		var messages []ContactMessage
+		if err := json.NewDecoder(w.Body).Decode(&messages); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, %v; want a list of messages", target, w.Code, err)
+		}
+		return messages
+	}
+
+	messages := list("/admin/messages")
+	if len(messages) != 2 || messages[0].Name != "Grace" || messages[1].Name != "Ada" {
+		t.Fatalf("messages = %+v, want Grace's then Ada's", messages)
+	}
+	if ada := messages[1]; ada.Email != "ada@example.com" || ada.Message != "Hello there\nHow are you?" || ada.Read || ada.Delivered {
+		t.Errorf("Ada's message = %+v, want it trimmed, unread, and undelivered", ada)
+	}
+
+	markRead := func(id string) int {
+		r := newJSONRequest(http.MethodPost, "/admin/messages/"+id+"/read", "")
+		r.Header.Set("Authorization", "Bearer secret")
+		return serve(h, r).Code
+	}
+	if status := markRead(messages[1].ID.Hex()); status != http.StatusNoContent {
+		t.Errorf("marking read status = %d, want %d", status, http.StatusNoContent)
+	}
+	for _, id := range []string{"not-an-id", "000000000000000000000000"} {
+		if status := markRead(id); status != http.StatusNotFound {
+			t.Errorf("marking %q read status = %d, want %d", id, status, http.StatusNotFound)
+		}
+	}
+	if unread := list("/admin/messages?unread=true"); len(unread) != 1 || unread[0].Name != "Grace" {
+		t.Errorf("unread messages = %+v, want only Grace's", unread)
+	}
+}
+
+func TestContactSubmission(t *testing.T) {
+	testContactSubmission(t, newTestServer(t))
+}
+
+func TestContactSubmissionMongo(t *testing.T) {
+	testContactSubmission(t, newMongoTestServer(t))
+}
+
+func TestContactRejectsInvalidFields(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	setContactConfig(t, s, ContactConfig{})
+	h := s.routes()
+
+	tests := []struct {
+		name, email, message string
+		want                 string
+	}{
+		{"", "ada@example.com", "Hello", "Name must be"},
+		{strings.Repeat("a", maxContactNameLength+1), "ada@example.com", "Hello", "Name must be"},
+		{"Ada\r\nBcc: someone", "ada@example.com", "Hello", "Name must be"},
+		{"Ada", "not an address", "Hello", "Email must be"},
+		{"Ada", "Ada <ada@example.com>", "Hello", "Email must be"},
+		{"Ada", "ada@example.com", "   ", "Message must be"},
+		{"Ada", "ada@example.com", strings.Repeat("a", maxContactMessageLength+1), "Message must be"},
+	}
+	for i, tt := range tests {
+		remoteAddr := fmt.Sprintf("198.51.100.%d:1000", 140+i)
+		w := submitContact(h, contactForm(tt.name, tt.email, tt.message), remoteAddr)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("submitting %q, %q: status = %d, want %d with %q", tt.name, tt.email, w.Code, http.StatusBadRequest, tt.want)
+		}
+		// The form is shown again with what was typed
+		if tt.email == "not an address" && !strings.Contains(w.Body.String(), `value="not an address"`) {
+			t.Error("refused form doesn't keep the visitor's values")
+		}
+	}
+	if messages, _ := s.messages.ListMessages(t.Context(), false); len(messages) != 0 {
+		t.Errorf("%d invalid messages were saved", len(messages))
+	}
+}
+
+func TestContactDropsSpam(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	setContactConfig(t, s, ContactConfig{})
+	h := s.routes()
+
+	honeypot := contactForm("Bot", "bot@example.com", "Buy now")
+	honeypot.Set(honeypotField, "https://spam.example")
+	tooFast := contactForm("Bot", "bot@example.com", "Buy now")
+	tooFast.Set(formTokenField, newFormToken(time.Now()))
+
+	for i, test := range []struct {
+		reason string
+		form   url.Values
+	}{{"honeypot", honeypot}, {"too_fast", tooFast}} {
+		before := testutil.ToFloat64(spamRejections.WithLabelValues("contact", test.reason))
+		w := submitContact(h, test.form, fmt.Sprintf("192.0.2.%d:1000", 140+i))
+		// Bots get the same answer as real visitors
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("%s: status = %d, want %d", test.reason, w.Code, http.StatusSeeOther)
+		}
+		if got := testutil.ToFloat64(spamRejections.WithLabelValues("contact", test.reason)) - before; got != 1 {
+			t.Errorf("%s: spam_rejections_total rose by %v, want 1", test.reason, got)
+		}
+	}
+
+	for i, token := range []string{"", "garbage", newFormToken(time.Now().Add(-maxFormAge - time.Minute))} {
+		form := contactForm("Ada", "ada@example.com", "Hello")
+		form.Set(formTokenField, token)
+		w := submitContact(h, form, fmt.Sprintf("192.0.2.%d:1000", 150+i))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "The form expired") {
+			t.Errorf("token %q: status = %d, want %d asking to send again", token, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	if messages, _ := s.messages.ListMessages(t.Context(), false); len(messages) != 0 {
+		t.Errorf("%d spam messages were saved", len(messages))
+	}
+}
+
+func TestContactRateLimit(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	setContactConfig(t, s, ContactConfig{})
+	h := s.routes()
+
+	if w := submitContact(h, contactForm("Ada", "ada@example.com", "First"), "203.0.113.110:1000"); w.Code != http.StatusSeeOther {
+		t.Fatalf("first message status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if w := submitContact(h, contactForm("Ada", "ada@example.com", "Second"), "203.0.113.110:2000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second message status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestContactWebhookDeliveryRetries(t *testing.T) {
+	resetRateLimiters(t)
+	var attempts atomic.Int32
+	var payload contactWebhookPayload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, and the retry gets through
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	t.Cleanup(webhook.Close)
+	s := newTestServer(t)
+	setContactConfig(t, s, ContactConfig{WebhookURL: webhook.URL})
+
+	submitContact(s.routes(), contactForm("Ada", "ada@example.com", "Hello"), "203.0.113.120:1000")
+	messages := waitForMessages(t, s, func(messages []ContactMessage) bool {
+		return len(messages) == 1 && messages[0].Delivered
+	})
+	if attempts.Load() != 2 {
+		t.Errorf("webhook called %d times, want 2", attempts.Load())
+	}
+	if payload.ID != messages[0].ID.Hex() || payload.Name != "Ada" || payload.Email != "ada@example.com" || payload.Message != "Hello" {
+		t.Errorf("webhook payload = %+v, want Ada's message", payload)
+	}
+}
+
+func TestContactDeliveryFailureKeepsMessage(t *testing.T) {
+	resetRateLimiters(t)
+	var attempts atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(webhook.Close)
+	s := newTestServer(t)
+	setContactConfig(t, s, ContactConfig{WebhookURL: webhook.URL})
+
+	// The visitor isn't told about a delivery failure, and the message stays saved for admins
+	w := submitContact(s.routes(), contactForm("Ada", "ada@example.com", "Hello"), "203.0.113.130:1000")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for attempts.Load() < contactMaxAttempts && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if attempts.Load() != contactMaxAttempts {
+		t.Fatalf("webhook called %d times, want %d", attempts.Load(), contactMaxAttempts)
+	}
+	messages, err := s.messages.ListMessages(t.Context(), false)
+	if err != nil || len(messages) != 1 || messages[0].Delivered {
+		t.Errorf("messages = %+v, %v; want Ada's message saved and undelivered", messages, err)
+	}
+}
+
+// testRedeliverContactMessages checks delivering the messages left undelivered against s's store
+func testRedeliverContactMessages(t *testing.T, s *Server) {
+	var (
+		mu        sync.Mutex
+		delivered []string
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload contactWebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		delivered = append(delivered, payload.Name)
+		mu.Unlock()
+	}))
+	t.Cleanup(webhook.Close)
+	setContactConfig(t, s, ContactConfig{WebhookURL: webhook.URL})
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	for i, message := range []ContactMessage{
+		{Name: "Ada", Delivered: true},
+		{Name: "Grace"},
+		{Name: "Linus"},
+	} {
+		message.ID, message.CreatedAt = primitive.NewObjectID(), start.Add(time.Duration(i)*time.Minute)
+		if err := s.messages.InsertMessage(t.Context(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.redeliverContactMessages(t.Context()); err != nil {
+		t.Fatalf("redeliverContactMessages() = %v", err)
+	}
+	waitForMessages(t, s, func(messages []ContactMessage) bool {
+		for _, message := range messages {
+			if !message.Delivered {
+				return false
+			}
+		}
+		return true
+	})
+	// Only the undelivered messages are sent, oldest first
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(delivered, ",") != "Grace,Linus" {
+		t.Errorf("webhook got %q, want Grace's then Linus's messages", delivered)
+	}
+}
+
+func TestRedeliverContactMessages(t *testing.T) {
+	testRedeliverContactMessages(t, newTestServer(t))
+}
+
+func TestRedeliverContactMessagesMongo(t *testing.T) {
+	testRedeliverContactMessages(t, newMongoTestServer(t))
+}
+
+// fakeSMTPServer accepts one SMTP session at a time on localhost, without STARTTLS or AUTH,
+// sending each email's data to the returned channel
+func fakeSMTPServer(t *testing.T) (port string, emails <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 1)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			text := textproto.NewConn(conn)
+			text.PrintfLine("220 localhost ESMTP")
+			for {
+				line, err := text.ReadLine()
+				if err != nil {
+					break
+				}
+				switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+				case "EHLO", "HELO":
+					text.PrintfLine("250 localhost")
+				case "DATA":
+					text.PrintfLine("354 go ahead")
+					data, _ := io.ReadAll(text.DotReader())
+					received <- string(data)
+					text.PrintfLine("250 queued")
+				case "QUIT":
+					text.PrintfLine("221 bye")
+				default:
+					text.PrintfLine("250 OK")
+				}
+			}
+			text.Close()
+		}
+	}()
+	_, port, _ = net.SplitHostPort(listener.Addr().String())
+	return port, received
+}
+
+func TestContactEmailDelivery(t *testing.T) {
+	resetRateLimiters(t)
+	port, emails := fakeSMTPServer(t)
+	s := newTestServer(t)
+	setContactConfig(t, s, ContactConfig{
+		To:       "me@example.com",
+		SMTPHost: "localhost",
+		SMTPPort: port,
+		SMTPFrom: "site@example.com",
+	})
+
+	submitContact(s.routes(), contactForm("Ada Lovelace", "ada@example.com", "Hello there"), "203.0.113.140:1000")
+	var email string
+	select {
+	case email = <-emails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(email))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("parsing the email's headers: %v", err)
+	}
+	if msg.Get("To") != "me@example.com" || msg.Get("From") != "site@example.com" || msg.Get("Reply-To") != `"Ada Lovelace" <ada@example.com>` {
+		t.Errorf("email headers = %v, want it to me from the site, replying to Ada", msg)
+	}
+	if !strings.Contains(email, "Hello there") {
+		t.Errorf("email = %q, want it to include the message", email)
+	}
+	waitForMessages(t, s, func(messages []ContactMessage) bool {
+		return len(messages) == 1 && messages[0].Delivered
+	})
+}
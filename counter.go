@@ -2,161 +2,357 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"log"
 	"net/http"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"slices"
+	"strings"
+	"time"
 )
 
 // Counter represents a counter document in MongoDB
 type Counter struct {
 	ID    string `bson:"_id" json:"id"`
 	Count int    `bson:"count" json:"count"`
+	// MaxSeen is the highest count reached by an increment, and MaxSeenAt when it was first reached
+	MaxSeen   int       `bson:"maxSeen" json:"maxSeen"`
+	MaxSeenAt time.Time `bson:"maxSeenAt,omitempty" json:"maxSeenAt,omitzero"`
+	// Namespace groups related named counters. It's kept outside the ID so counters created
+	// before namespaces existed are unaffected.
+	Namespace string `bson:"namespace,omitempty" json:"namespace,omitempty"`
 }
 
-// initializeCounters creates counter documents if they don't exist
-func initializeCounters() {
-	ctx := context.Background()
-	countersCollection := db.Collection("counters")
+// builtInCounters are the counters the site keeps itself, created at startup
+var builtInCounters = []string{"webhook", "pageviews", "totalClicks"}
 
-	// Initialize webhook counter
-	_, err := countersCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": "webhook"},
-		bson.M{"$setOnInsert": bson.M{"count": 0}},
-		options.Update().SetUpsert(true),
-	)
-	if err != nil {
-		log.Println("Error initializing webhook counter:", err)
+// getCounter returns a counter by ID like CounterStore.GetCounter, except that the pageviews
+// counter is the total of every page's views. Views have been counted per page since they moved
+// out of the counters collection, so the pageviews document itself no longer changes.
+func (s *Server) getCounter(ctx context.Context, id string) (Counter, error) {
+	counter, err := s.counters.GetCounter(ctx, id)
+	if err != nil || id != "pageviews" {
+		return counter, err
 	}
+	return s.withPageViewTotal(ctx, counter)
+}
 
-	// Initialize page view counter
-	_, err = countersCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": "pageviews"},
-		bson.M{"$setOnInsert": bson.M{"count": 0}},
-		options.Update().SetUpsert(true),
-	)
+// listCounters returns the counters in a namespace like CounterStore.ListCounters, with the
+// pageviews counter's total filled in as in getCounter
+func (s *Server) listCounters(ctx context.Context, namespace string) ([]Counter, error) {
+	counters, err := s.counters.ListCounters(ctx, namespace)
 	if err != nil {
-		log.Println("Error initializing pageview counter:", err)
+		return nil, err
 	}
+	for i, counter := range counters {
+		if counter.ID == "pageviews" {
+			if counters[i], err = s.withPageViewTotal(ctx, counter); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return counters, nil
+}
 
-	// Initialize total clicks counter
-	_, err = countersCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": "totalClicks"},
-		bson.M{"$setOnInsert": bson.M{"count": 0}},
-		options.Update().SetUpsert(true),
-	)
+// withPageViewTotal returns the pageviews counter with its count set to the total page views.
+// Views only ever go up, so the total is also its high-water mark.
+func (s *Server) withPageViewTotal(ctx context.Context, counter Counter) (Counter, error) {
+	total, err := s.pageViews.TotalPageViews(ctx)
 	if err != nil {
-		log.Println("Error initializing total clicks counter:", err)
+		return Counter{}, err
+	}
+	if total > counter.MaxSeen {
+		counter.MaxSeen, counter.MaxSeenAt = total, time.Time{}
+	}
+	counter.Count = total
+	return counter, nil
+}
+
+// initializeCounters creates the configured counter documents that don't exist yet, leaving the
+// counts of existing ones alone
+func (s *Server) initializeCounters(ctx context.Context) error {
+	return s.counters.InitCounters(ctx, s.config.InitCounters...)
+}
+
+// getCounterValues returns the current webhook and total clicks counts, using zero for any that
+// can't be read or whose counting is disabled
+func (s *Server) getCounterValues(ctx context.Context) (webhookCount, totalClicks int) {
+	var webhookCounter, totalClicksCounter Counter
+	if s.config.Counting.Webhook {
+		webhookCounter, _ = s.counters.GetCounter(ctx, "webhook")
+	}
+	if s.config.Counting.TotalClicks {
+		totalClicksCounter, _ = s.counters.GetCounter(ctx, "totalClicks")
+	}
+
+	return webhookCounter.Count, totalClicksCounter.Count
+}
+
+// incrementTotalClicks adds one to the total clicks counter, creating it if it's missing, using
+// a detached context so the update isn't cancelled when the request that triggered it finishes
+func (s *Server) incrementTotalClicks(ctx context.Context) {
+	ctx, cancel := s.detachedWriteContext(ctx)
+	defer cancel()
+
+	if _, err := s.counters.IncrementCounter(ctx, "totalClicks"); err != nil {
+		s.dbError(ctx, "counter", "increment total clicks", err)
+		return
 	}
+	s.home.invalidate()
+	s.recordCounterEvent(ctx, "totalClicks", 1)
 }
 
 // incrementHandler handles increment requests
-func incrementHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (s *Server) incrementHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.config.Counting.Webhook {
+		s.respondError(w, r, http.StatusNotFound, "Not found")
 		return
 	}
 
-	ctx := context.Background()
-	countersCollection := db.Collection("counters")
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
 
-	// Atomic increment and get updated value in one operation
-	var webhookCounter Counter
-	err := countersCollection.FindOneAndUpdate(
-		ctx,
-		bson.M{"_id": "webhook"},
-		bson.M{"$inc": bson.M{"count": 1}},
-		options.FindOneAndUpdate().SetReturnDocument(options.After),
-	).Decode(&webhookCounter)
+	// Atomic increment and get updated value in one operation, recreating the counter if it's missing
+	webhookCounter, err := s.counters.IncrementCounter(ctx, "webhook")
 	if err != nil {
-		http.Error(w, "Error incrementing counter", http.StatusInternalServerError)
+		status, message := s.writeError(r.Context(), "counter", "increment webhook counter", err, "Error incrementing counter")
+		s.respondError(w, r, status, message)
 		return
 	}
+	s.home.invalidate()
+	s.recordCounterEvent(ctx, "webhook", 1)
 
-	// Async increment total clicks counter (non-blocking)
-	go func() {
-		countersCollection.FindOneAndUpdate(
-			context.Background(),
-			bson.M{"_id": "totalClicks"},
-			bson.M{"$inc": bson.M{"count": 1}},
-			options.FindOneAndUpdate().SetReturnDocument(options.After),
-		)
-	}()
+	// Async increment total clicks counter (non-blocking), allowed to outlive the request
+	if s.config.Counting.TotalClicks {
+		go s.incrementTotalClicks(r.Context())
+	}
 
-	// Get total clicks for broadcast
+	// Get total clicks for broadcast from the primary, even when MONGO_READ_PREFERENCE sends
+	// other reads to secondaries that may lag behind
 	var totalClicksCounter Counter
-	err = countersCollection.FindOne(ctx, bson.M{"_id": "totalClicks"}).Decode(&totalClicksCounter)
-	if err != nil {
-		log.Println("Error getting total clicks:", err)
-		totalClicksCounter.Count = 0
+	if s.config.Counting.TotalClicks {
+		totalClicksCounter, err = s.counters.GetLatestCounter(ctx, "totalClicks")
+		if err != nil {
+			s.dbError(r.Context(), "counter", "get total clicks", err)
+			totalClicksCounter.Count = 0
+		}
+	}
+
+	// Notify Slack when the counter hits a milestone
+	if s.milestones.claim(s.config.Slack, "webhook", webhookCounter.Count, webhookCounter.MaxSeen) {
+		go s.notifySlackMilestone(s.stoppingContext(), webhookCounter.Count, totalClicksCounter.Count)
 	}
 
 	// Broadcast to all WebSocket clients
 	update := CounterUpdate{
-		Count:       webhookCounter.Count,
-		TotalClicks: totalClicksCounter.Count,
+		Count:       &webhookCounter.Count,
+		MaxSeen:     webhookCounter.MaxSeen,
+		TotalClicks: optionalCount(s.config.Counting.TotalClicks, totalClicksCounter.Count),
+		Velocity:    s.broadcastVelocity(ctx),
 	}
-	hub.broadcast <- update
+	s.hub.Broadcast(update)
 
 	// Return JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(update)
+	respondJSON(w, http.StatusOK, update)
 }
 
 // decrementHandler handles decrement requests
-func decrementHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (s *Server) decrementHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.config.Counting.Webhook {
+		s.respondError(w, r, http.StatusNotFound, "Not found")
 		return
 	}
 
-	ctx := context.Background()
-	countersCollection := db.Collection("counters")
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
 
-	// Atomic decrement and get updated value in one operation
-	var webhookCounter Counter
-	err := countersCollection.FindOneAndUpdate(
-		ctx,
-		bson.M{"_id": "webhook"},
-		bson.M{"$inc": bson.M{"count": -1}},
-		options.FindOneAndUpdate().SetReturnDocument(options.After),
-	).Decode(&webhookCounter)
+	// Atomic decrement and get updated value in one operation, recreating the counter if it's missing
+	webhookCounter, err := s.counters.DecrementCounter(ctx, "webhook")
 	if err != nil {
-		http.Error(w, "Error decrementing counter", http.StatusInternalServerError)
+		status, message := s.writeError(r.Context(), "counter", "decrement webhook counter", err, "Error decrementing counter")
+		s.respondError(w, r, status, message)
 		return
 	}
+	s.home.invalidate()
+	s.recordCounterEvent(ctx, "webhook", -1)
 
-	// Async increment total clicks counter (non-blocking)
-	go func() {
-		countersCollection.FindOneAndUpdate(
-			context.Background(),
-			bson.M{"_id": "totalClicks"},
-			bson.M{"$inc": bson.M{"count": 1}},
-			options.FindOneAndUpdate().SetReturnDocument(options.After),
-		)
-	}()
+	// Async increment total clicks counter (non-blocking), allowed to outlive the request
+	if s.config.Counting.TotalClicks {
+		go s.incrementTotalClicks(r.Context())
+	}
 
-	// Get total clicks for broadcast
+	// Get total clicks for broadcast from the primary, even when MONGO_READ_PREFERENCE sends
+	// other reads to secondaries that may lag behind
 	var totalClicksCounter Counter
-	err = countersCollection.FindOne(ctx, bson.M{"_id": "totalClicks"}).Decode(&totalClicksCounter)
-	if err != nil {
-		log.Println("Error getting total clicks:", err)
-		totalClicksCounter.Count = 0
+	if s.config.Counting.TotalClicks {
+		totalClicksCounter, err = s.counters.GetLatestCounter(ctx, "totalClicks")
+		if err != nil {
+			s.dbError(r.Context(), "counter", "get total clicks", err)
+			totalClicksCounter.Count = 0
+		}
 	}
 
 	// Broadcast to all WebSocket clients
 	update := CounterUpdate{
-		Count:       webhookCounter.Count,
-		TotalClicks: totalClicksCounter.Count,
+		Count:       &webhookCounter.Count,
+		MaxSeen:     webhookCounter.MaxSeen,
+		TotalClicks: optionalCount(s.config.Counting.TotalClicks, totalClicksCounter.Count),
+		Velocity:    s.broadcastVelocity(ctx),
 	}
-	hub.broadcast <- update
+	s.hub.Broadcast(update)
 
 	// Return JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(update)
+	respondJSON(w, http.StatusOK, update)
+}
+
+// loadCounterIDs reads a comma-separated list of counter names
+func loadCounterIDs(env *envReader, key string) []string {
+	var ids []string
+	for _, name := range strings.Split(env.get(key), ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+
+		id, err := normalizeCounterName(name)
+		if err != nil {
+			env.problem(key, "counter ID %q: %v", name, err)
+			continue
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// loadInitCounters reads INIT_COUNTERS, falling back to the built-in counters when it's unset
+func loadInitCounters(env *envReader) []string {
+	if ids := loadCounterIDs(env, "INIT_COUNTERS"); len(ids) > 0 {
+		return ids
+	}
+	return slices.Clone(builtInCounters)
+}
+
+// nextMidnightUTC returns the first midnight UTC strictly after t
+func nextMidnightUTC(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// startDailyResetScheduler resets the configured counters every day at midnight UTC until ctx is done
+func (s *Server) startDailyResetScheduler(ctx context.Context) {
+	if len(s.config.DailyResetCounterIDs) == 0 {
+		return
+	}
+
+	go s.runDailyResets(ctx, systemClock{})
+}
+
+// clock tells the time and waits, so tests can run the daily reset without waiting for midnight
+type clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the real clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (s *Server) runDailyResets(ctx context.Context, clk clock) {
+	next := nextMidnightUTC(clk.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clk.After(next.Sub(clk.Now())):
+		}
+
+		s.resetCounters(ctx, s.config.DailyResetCounterIDs)
+		// Step from the scheduled time rather than the current one, so a timer that
+		// fires a moment early can't schedule the same midnight twice
+		next = nextMidnightUTC(next)
+	}
+}
+
+// resetCounters sets the given counters to zero, records the reset in the audit log and as a
+// counter event, so velocity and forecasts account for the drop, and broadcasts the new values
+// if the webhook or total clicks counter was reset
+func (s *Server) resetCounters(ctx context.Context, ids []string) {
+	writeCtx, cancel := s.detachedWriteContext(ctx)
+	defer cancel()
+
+	previous, err := s.counters.ResetCounters(writeCtx, ids)
+	if err != nil {
+		s.dbError(ctx, "counter", "reset counters", err)
+		return
+	}
+	s.home.invalidate()
+
+	for _, counter := range previous {
+		if counter.Count > 0 {
+			s.recordCounterEvent(writeCtx, counter.ID, -counter.Count)
+		}
+	}
+
+	for _, id := range ids {
+		s.audit.Log(ctx, AuditEvent{Action: "counter.reset", Actor: "scheduler", Resource: id, Success: true})
+	}
+	s.log(ctx, "counter").Info("reset counters", "ids", ids)
+
+	if slices.Contains(ids, "webhook") || slices.Contains(ids, "totalClicks") {
+		webhookCount, totalClicks := s.getCounterValues(writeCtx)
+		s.hub.Broadcast(CounterUpdate{
+			Count:       optionalCount(s.config.Counting.Webhook, webhookCount),
+			TotalClicks: optionalCount(s.config.Counting.TotalClicks, totalClicks),
+		})
+	}
+}
+
+// CounterSettings controls which built-in counters are counted and shown.
+// A disabled counter is never incremented and its value is left out of pages and APIs.
+type CounterSettings struct {
+	PageViews   bool `json:"pageViews"`
+	Webhook     bool `json:"webhook"`
+	TotalClicks bool `json:"totalClicks"`
+}
+
+// loadCounterSettings reads COUNT_PAGEVIEWS, COUNT_WEBHOOK, and COUNT_TOTAL_CLICKS, which
+// count by default
+func loadCounterSettings(env *envReader) CounterSettings {
+	return CounterSettings{
+		PageViews:   env.bool("COUNT_PAGEVIEWS", true),
+		Webhook:     env.bool("COUNT_WEBHOOK", true),
+		TotalClicks: env.bool("COUNT_TOTAL_CLICKS", true),
+	}
+}
+
+// hides reports whether the counter with the given ID is a disabled built-in counter
+func (s CounterSettings) hides(id string) bool {
+	switch id {
+	case "pageviews":
+		return !s.PageViews
+	case "webhook":
+		return !s.Webhook
+	case "totalClicks":
+		return !s.TotalClicks
+	}
+	return false
+}
+
+// visibleCounters filters out disabled built-in counters
+func (s CounterSettings) visibleCounters(counters []Counter) []Counter {
+	visible := make([]Counter, 0, len(counters))
+	for _, counter := range counters {
+		if !s.hides(counter.ID) {
+			visible = append(visible, counter)
+		}
+	}
+	return visible
+}
+
+// optionalCount returns a pointer to n if the counter is enabled, or nil so it's left out of JSON
+func optionalCount(enabled bool, n int) *int {
+	if !enabled {
+		return nil
+	}
+	return &n
 }
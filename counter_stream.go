@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// counterStreamHeartbeat is how often an idle counter stream sends a comment to keep proxies
+// from closing it
+var counterStreamHeartbeat = 30 * time.Second
+
+// counterStreamHandler streams counter updates as server-sent events, for clients that would
+// rather not use the WebSocket. Each update is a data event holding the same JSON the WebSocket
+// sends, starting with the current values.
+func (s *Server) counterStreamHandler(w http.ResponseWriter, r *http.Request) {
+	// Subscribe before reading the current values, so an update in between is still sent
+	updates := s.hub.Subscribe()
+	defer s.hub.Unsubscribe(updates)
+
+	ctx, cancel := s.readContext(r)
+	webhookCount, totalClicks := s.getCounterValues(ctx)
+	cancel()
+	current := CounterUpdate{
+		Type:        messageTypeUpdate,
+		Count:       optionalCount(s.config.Counting.Webhook, webhookCount),
+		TotalClicks: optionalCount(s.config.Counting.TotalClicks, totalClicks),
+		Seq:         s.hub.CurrentSeq(),
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := writeCounterEvent(w, current); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		s.log(r.Context(), "hub").Error("counter stream doesn't support flushing", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(counterStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-s.stopping:
+			return
+
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := writeCounterEvent(w, update); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeCounterEvent writes update to an event stream as a data event
+func writeCounterEvent(w http.ResponseWriter, update CounterUpdate) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
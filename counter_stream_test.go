@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventStream is a response from an event stream endpoint, read an event at a time
+type eventStream struct {
+	*bufio.Reader
+	resp *http.Response
+}
+
+// openEventStream opens path on the site as an event stream, which is closed when the test ends
+// or cancel is called
+func openEventStream(t *testing.T, site *testSite, path string) (stream *eventStream, cancel func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, site.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := site.Client().Do(r)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("GET %s = %d %q, want a 200 event stream", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return &eventStream{Reader: bufio.NewReader(resp.Body), resp: resp}, cancel
+}
+
+// next returns the lines of the next event or comment, failing the test if none arrives within
+// a couple of seconds
+func (s *eventStream) next(t *testing.T) []string {
+	t.Helper()
+	lines := make(chan []string, 1)
+	go func() {
+		var event []string
+		for {
+			line, err := s.ReadString('\n')
+			if err != nil {
+				lines <- nil
+				return
+			}
+			if line = strings.TrimSuffix(line, "\n"); line == "" {
+				lines <- event
+				return
+			}
+			event = append(event, line)
+		}
+	}()
+	select {
+	case event := <-lines:
+		if event == nil {
+			t.Fatal("event stream ended")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event within 2s")
+		return nil
+	}
+}
+
+// nextUpdate returns the counter update in the next event
+func (s *eventStream) nextUpdate(t *testing.T) CounterUpdate {
+	t.Helper()
+	event := s.next(t)
+	data, ok := strings.CutPrefix(event[0], "data: ")
+	if len(event) != 1 || !ok {
+		t.Fatalf("event = %q, want a single data line", event)
+	}
+	var update CounterUpdate
+	if err := json.Unmarshal([]byte(data), &update); err != nil {
+		t.Fatalf("decoding %q: %v", data, err)
+	}
+	return update
+}
+
+func TestCounterStreamSendsUpdates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, site *testSite) {
+		stream, cancel := openEventStream(t, site, "/api/counters/stream")
+		if stream.resp.Header.Get("Cache-Control") != "no-store" {
+			t.Errorf("Cache-Control = %q, want no-store", stream.resp.Header.Get("Cache-Control"))
+		}
+		if update := stream.nextUpdate(t); update.Type != messageTypeUpdate || countOf(update.Count) != seededWebhookCount {
+			t.Fatalf("first event = %+v, want the seeded count %d", update, seededWebhookCount)
+		}
+
+		if status := site.post(t, "/increment", nil); status != http.StatusOK {
+			t.Fatalf("POST /increment status = %d, want %d", status, http.StatusOK)
+		}
+		update := stream.nextUpdate(t)
+		if countOf(update.Count) != seededWebhookCount+1 || update.Seq == 0 {
+			t.Errorf("event after increment = %+v, want a numbered update with count %d", update, seededWebhookCount+1)
+		}
+
+		// Disconnecting unsubscribes the stream
+		cancel()
+		deadline := time.Now().Add(2 * time.Second)
+		for site.server.hub.StreamCount() != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d streams still subscribed after the client disconnected", site.server.hub.StreamCount())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestCounterStreamSendsHeartbeats(t *testing.T) {
+	previous := counterStreamHeartbeat
+	counterStreamHeartbeat = 20 * time.Millisecond
+	t.Cleanup(func() { counterStreamHeartbeat = previous })
+	site := startTestSite(t, newTestServer(t))
+
+	stream, _ := openEventStream(t, site, "/api/v1/counters/stream")
+	stream.nextUpdate(t)
+	if event := stream.next(t); len(event) != 1 || event[0] != ": heartbeat" {
+		t.Errorf("idle stream sent %q, want a heartbeat comment", event)
+	}
+}
+
+func TestHubShutdownEndsStreams(t *testing.T) {
+	h := NewHub(64)
+	go h.Run()
+	sub := h.Subscribe()
+
+	h.Broadcast(CounterUpdate{Count: optionalCount(true, 7)})
+	if update := <-sub; countOf(update.Count) != 7 || update.Seq != 1 {
+		t.Errorf("subscriber got %+v, want the broadcast numbered 1", update)
+	}
+	if err := h.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if _, ok := <-sub; ok {
+		t.Error("subscription still open after shutdown")
+	}
+	// Unsubscribing after shutdown is harmless, and subscribing gets a closed channel
+	h.Unsubscribe(sub)
+	if _, ok := <-h.Subscribe(); ok {
+		t.Error("subscription made after shutdown is open")
+	}
+}
+
+func TestCounterStreamLeavesOutUncountedWebhook(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Counting = CounterSettings{PageViews: true, TotalClicks: true}
+	s.counters.IncrementCounter(context.Background(), "webhook")
+	site := startTestSite(t, s)
+
+	stream, _ := openEventStream(t, site, "/api/v1/counters/stream")
+	if update := stream.nextUpdate(t); update.Count != nil || update.TotalClicks == nil {
+		t.Errorf("first event = %+v, want total clicks without the webhook count", update)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fakeClock is a clock whose time only moves when a test says so. Each After call is reported
+// on waits, and returns once the test fires it.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits chan fakeWait
+}
+
+// fakeWait is a pending After call
+type fakeWait struct {
+	d    time.Duration
+	fire chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waits: make(chan fakeWait, 1)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	fire := make(chan time.Time, 1)
+	c.waits <- fakeWait{d: d, fire: fire}
+	return fire
+}
+
+// set moves the clock to t
+func (c *fakeClock) set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// nextWait returns the next After call, failing if none is made
+func (c *fakeClock) nextWait(t *testing.T) fakeWait {
+	t.Helper()
+	select {
+	case wait := <-c.waits:
+		return wait
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing waited on the clock")
+		return fakeWait{}
+	}
+}
+
+func TestNextMidnightUTC(t *testing.T) {
+	tests := []struct {
+		t, want time.Time
+	}{
+		{t: time.Date(2026, 10, 16, 23, 59, 30, 0, time.UTC), want: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{t: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{t: time.Date(2026, 12, 31, 12, 0, 0, 0, time.UTC), want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{t: time.Date(2026, 10, 16, 20, 0, 0, 0, time.FixedZone("UTC-5", -5*3600)), want: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextMidnightUTC(tt.t); !got.Equal(tt.want) {
+			t.Errorf("nextMidnightUTC(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestDailyResetAtMidnight(t *testing.T) {
+	s := newTestServer(t)
+	s.config.DailyResetCounterIDs = []string{"webhook", "likes", "missing"}
+	ctx := context.Background()
+	s.counters.InitCounters(ctx, "likes")
+	for range 3 {
+		s.counters.IncrementCounter(ctx, "webhook")
+	}
+	sub := s.hub.Subscribe()
+	t.Cleanup(func() { s.hub.Unsubscribe(sub) })
+
+	clk := newFakeClock(time.Date(2026, 10, 16, 23, 59, 30, 0, time.UTC))
+	runCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	go s.runDailyResets(runCtx, clk)
+
+	wait := clk.nextWait(t)
+	if wait.d != 30*time.Second {
+		t.Fatalf("first wait = %v, want the 30s until midnight", wait.d)
+	}
+	midnight := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	clk.set(midnight)
+	wait.fire <- midnight
+
+	// The next wait is only made once the reset is done
+	if wait := clk.nextWait(t); wait.d != 24*time.Hour {
+		t.Errorf("wait after the reset = %v, want a day", wait.d)
+	}
+	if counter, err := s.counters.GetCounter(ctx, "webhook"); err != nil || counter.Count != 0 {
+		t.Errorf("webhook after midnight = %+v, %v; want 0", counter, err)
+	}
+	select {
+	case update := <-sub:
+		if countOf(update.Count) != 0 {
+			t.Errorf("broadcast after the reset = %+v, want count 0", update)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("the reset wasn't broadcast")
+	}
+
+	// Only the webhook counter had a count to take away
+	summary, err := s.counters.SummarizeCounterEvents(ctx, "webhook", time.Now().Add(-time.Minute))
+	if err != nil || summary.Events != 1 || summary.Delta != -3 {
+		t.Errorf("webhook events = %+v, %v; want one taking away its 3", summary, err)
+	}
+	if summary, err := s.counters.SummarizeCounterEvents(ctx, "likes", time.Time{}); err != nil || summary.Events != 0 {
+		t.Errorf("likes events = %+v, %v; want none for a counter already at 0", summary, err)
+	}
+}
+
+func TestDailyResetStopsWithContext(t *testing.T) {
+	s := &Server{config: Config{DailyResetCounterIDs: []string{"webhook"}}}
+	clk := newFakeClock(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runDailyResets(ctx, clk)
+	}()
+
+	clk.nextWait(t)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("runDailyResets kept running after its context was done")
+	}
+}
+
+func TestCounterUpdateKeepsZeroTotalClicks(t *testing.T) {
+	tests := []struct {
+		update CounterUpdate
+		want   string
+	}{
+		{update: CounterUpdate{Count: optionalCount(true, 1), TotalClicks: optionalCount(true, 0)}, want: `{"type":"","count":1,"totalClicks":0}`},
+		{update: CounterUpdate{Count: optionalCount(true, 1), TotalClicks: optionalCount(true, 5)}, want: `{"type":"","count":1,"totalClicks":5}`},
+		{update: CounterUpdate{Count: optionalCount(true, 1), TotalClicks: optionalCount(false, 5)}, want: `{"type":"","count":1}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.update)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("json.Marshal(%+v) = %s, %v; want %s", tt.update, got, err, tt.want)
+		}
+	}
+}
+
+func TestCounterHandlersWithoutWebhookCounting(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Counting = CounterSettings{PageViews: true, TotalClicks: true}
+	h := s.routes()
+	for _, target := range []string{"/increment", "/decrement"} {
+		if w := serveRequest(h, http.MethodPost, target, "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("POST %s status = %d, want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+// testCounterHandlersRecreateMissingWebhookCounter checks that the webhook handlers recreate the
+// webhook counter against s's store, using deleteCounter to remove it first
+func testCounterHandlersRecreateMissingWebhookCounter(t *testing.T, s *Server, deleteCounter func(id string)) {
+	s.config.Counting = CounterSettings{PageViews: true, Webhook: true}
+	h := s.routes()
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{target: "/increment", want: 1},
+		// A recreated counter still stops at zero
+		{target: "/decrement", want: 0},
+	}
+	for _, tt := range tests {
+		deleteCounter("webhook")
+		if _, err := s.counters.GetCounter(context.Background(), "webhook"); err == nil {
+			t.Fatal("the webhook counter still exists after deleting it")
+		}
+
+		if w := serveRequest(h, http.MethodPost, tt.target, "", ""); w.Code != http.StatusOK {
+			t.Fatalf("POST %s status = %d, want %d", tt.target, w.Code, http.StatusOK)
+		}
+		counter, err := s.counters.GetCounter(context.Background(), "webhook")
+		if err != nil {
+			t.Fatalf("after POST %s, reading the webhook counter: %v", tt.target, err)
+		}
+		if counter.Count != tt.want {
+			t.Errorf("after POST %s, count = %d, want %d", tt.target, counter.Count, tt.want)
+		}
+	}
+}
+
+func TestCounterHandlersRecreateMissingWebhookCounter(t *testing.T) {
+	s := newTestServer(t)
+	store := s.counters.(*memoryStore)
+	testCounterHandlersRecreateMissingWebhookCounter(t, s, func(id string) {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		delete(store.counters, id)
+	})
+}
+
+func TestCounterHandlersRecreateMissingWebhookCounterMongo(t *testing.T) {
+	s := newMongoTestServer(t)
+	counters := s.db.Collection(s.config.Collections.Counters)
+	testCounterHandlersRecreateMissingWebhookCounter(t, s, func(id string) {
+		if _, err := counters.DeleteOne(context.Background(), bson.M{"_id": id}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestIncrementBroadcastsCounts(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	for range 3 {
+		s.counters.IncrementCounter(ctx, "webhook")
+	}
+	s.counters.IncrementCounter(ctx, "totalClicks")
+
+	h := s.routes()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	client := dialHub(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws")
+	if update, err := client.NextUpdate(2 * time.Second); err != nil || countOf(update.Count) != 3 {
+		t.Fatalf("first update = %+v, %v; want count 3", update, err)
+	}
+
+	if w := serveRequest(h, http.MethodPost, "/increment", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("POST /increment status = %d, want %d", w.Code, http.StatusOK)
+	}
+	update, err := client.NextUpdate(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Type != messageTypeUpdate || countOf(update.Count) != 4 || update.MaxSeen != 4 {
+		t.Errorf("broadcast = %+v, want an update with count and maxSeen 4", update)
+	}
+	// Total clicks goes up in the background, so the broadcast may come before or after it
+	if update.TotalClicks == nil || (*update.TotalClicks != 1 && *update.TotalClicks != 2) {
+		t.Errorf("broadcast total clicks = %v, want 1 or 2", update.TotalClicks)
+	}
+}
+
+// staleReads answers GetCounter with every counter at zero, standing in for a secondary that
+// hasn't caught up with the primary
+type staleReads struct {
+	*memoryStore
+}
+
+func (staleReads) GetCounter(ctx context.Context, id string) (Counter, error) {
+	return Counter{ID: id}, nil
+}
+
+func TestCounterHandlersReadTotalClicksFromThePrimary(t *testing.T) {
+	s := newTestServer(t)
+	store := s.counters.(*memoryStore)
+	ctx := context.Background()
+	for range 5 {
+		store.IncrementCounter(ctx, "totalClicks")
+	}
+	s.counters = staleReads{store}
+
+	h := s.routes()
+	for _, target := range []string{"/increment", "/decrement"} {
+		w := serveRequest(h, http.MethodPost, target, "", "")
+		var update CounterUpdate
+		if err := json.NewDecoder(w.Body).Decode(&update); err != nil {
+			t.Fatalf("POST %s: decoding the response: %v", target, err)
+		}
+		// Total clicks goes up in the background, so the response may come before or after it
+		if clicks := countOf(update.TotalClicks); clicks < 5 {
+			t.Errorf("POST %s total clicks = %d, want at least 5 from the primary", target, clicks)
+		}
+	}
+}
+
+// testInitializeConfiguredCounters checks creating the INIT_COUNTERS counters against s's store
+func testInitializeConfiguredCounters(t *testing.T, s *Server) {
+	s.config.InitCounters = []string{"webhook", "likes", "signups"}
+	ctx := context.Background()
+
+	if err := s.initializeCounters(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range s.config.InitCounters {
+		if counter, err := s.counters.GetCounter(ctx, id); err != nil || counter.Count != 0 {
+			t.Errorf("counter %s = %+v, %v; want it created at 0", id, counter, err)
+		}
+	}
+	if _, err := s.counters.GetCounter(ctx, "totalClicks"); err == nil {
+		t.Error("totalClicks was created, want only the configured counters")
+	}
+
+	// Initializing again, as every restart does, leaves existing counts alone
+	for range 2 {
+		s.counters.IncrementCounter(ctx, "likes")
+	}
+	if err := s.initializeCounters(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if counter, err := s.counters.GetCounter(ctx, "likes"); err != nil || counter.Count != 2 {
+		t.Errorf("likes after initializing again = %+v, %v; want count 2", counter, err)
+	}
+}
+
+func TestInitializeConfiguredCounters(t *testing.T) {
+	testInitializeConfiguredCounters(t, newTestServer(t))
+}
+
+func TestInitializeConfiguredCountersMongo(t *testing.T) {
+	testInitializeConfiguredCounters(t, newMongoTestServer(t))
+}
+
+func TestDisabledCountersAreSkipped(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{{Name: "site"}})
+	tests := []struct {
+		name     string
+		counting CounterSettings
+		// hidden is the stats field and page text that disappear with the counter
+		field, text string
+	}{
+		{"page views", CounterSettings{Webhook: true, TotalClicks: true}, "pageViewCount", "Page views:"},
+		{"webhook", CounterSettings{PageViews: true, TotalClicks: true}, "webhookCount", `id="counter"`},
+		{"total clicks", CounterSettings{PageViews: true, Webhook: true}, "totalClicks", `id="total-clicks"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.config.Counting = tt.counting
+			h := s.routes()
+			ctx := context.Background()
+
+			w := serveRequest(h, http.MethodGet, "/", "", "192.0.2.10:1000")
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET / status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if strings.Contains(w.Body.String(), tt.text) {
+				t.Errorf("home page shows %q", tt.text)
+			}
+			serveRequest(h, http.MethodPost, "/increment", "", "")
+
+			w = serveRequest(h, http.MethodGet, "/api/v1/stats", "", "")
+			var stats map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+				t.Fatalf("decoding %s: %v", w.Body, err)
+			}
+			if _, ok := stats[tt.field]; ok {
+				t.Errorf("stats = %s, want %s left out", w.Body, tt.field)
+			}
+
+			// Nothing is counted for the disabled counter
+			views, err := s.pageViews.TotalPageViews(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := boolCount(tt.counting.PageViews); views != want {
+				t.Errorf("page views = %d, want %d", views, want)
+			}
+			if w := serveRequest(h, http.MethodGet, "/api/v1/analytics/pages", "", ""); !tt.counting.PageViews && w.Code != http.StatusNotFound {
+				t.Errorf("GET /api/v1/analytics/pages = %d, want %d", w.Code, http.StatusNotFound)
+			}
+			webhook, _ := s.counters.GetCounter(ctx, "webhook")
+			if want := boolCount(tt.counting.Webhook); webhook.Count != want {
+				t.Errorf("webhook count = %d, want %d", webhook.Count, want)
+			}
+			if !tt.counting.TotalClicks {
+				// Give a background increment, were one started, time to land
+				time.Sleep(50 * time.Millisecond)
+				if counter, err := s.counters.GetCounter(ctx, "totalClicks"); err == nil {
+					t.Errorf("total clicks = %+v, want no counter", counter)
+				}
+			}
+		})
+	}
+}
+
+// boolCount is 1 if counted and 0 otherwise
+func boolCount(counted bool) int {
+	if counted {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DashboardData summarizes the state of the site for the admin dashboard. There's no count of
+// reported quotes because quotes can't be reported; admins delete them directly.
+type DashboardData struct {
+	Counters              []Counter         `json:"counters"`
+	QuoteCount            int64             `json:"quoteCount"`
+	ConnectedClients      int               `json:"connectedClients"`
+	GitHubCachedRepos     int               `json:"githubCachedRepos"`
+	GitHubCacheAgeSeconds *int64            `json:"githubCacheAgeSeconds"`
+	RateLimitBackend      string            `json:"rateLimitBackend"`
+	RateLimiters          int               `json:"rateLimiters"`
+	Maintenance           MaintenanceStatus `json:"maintenance"`
+	Features              FeatureFlags      `json:"features"`
+	GeneratedAt           time.Time         `json:"generatedAt"`
+}
+
+// stats returns the number of cached repos and when they were fetched, which is zero if they never were
+func (c *repoCache) stats() (int, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.repos), c.fetchedAt
+}
+
+// memoryLimiterCount returns the number of IPs tracked by the in-memory rate limiter
+func memoryLimiterCount() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(limiters)
+}
+
+// gatherDashboard collects the data shown on the admin dashboard
+func (s *Server) gatherDashboard(ctx context.Context) (DashboardData, error) {
+	counters, err := s.listCounters(ctx, "")
+	if err != nil {
+		return DashboardData{}, err
+	}
+
+	quoteCount, err := s.quotes.CountQuotes(ctx)
+	if err != nil {
+		return DashboardData{}, err
+	}
+
+	now := time.Now()
+	data := DashboardData{
+		Counters:         counters,
+		QuoteCount:       quoteCount,
+		ConnectedClients: s.hub.ClientCount(),
+		RateLimitBackend: s.config.RateLimitBackend,
+		RateLimiters:     memoryLimiterCount(),
+		Maintenance:      s.getMaintenance(),
+		Features:         s.getFeatures(),
+		GeneratedAt:      now,
+	}
+
+	cachedRepos, fetchedAt := githubCache.stats()
+	data.GitHubCachedRepos = cachedRepos
+	if !fetchedAt.IsZero() {
+		age := int64(now.Sub(fetchedAt).Seconds())
+		data.GitHubCacheAgeSeconds = &age
+	}
+
+	return data, nil
+}
+
+// adminDashboardHandler renders the admin dashboard page
+func (s *Server) adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	data, err := s.gatherDashboard(ctx)
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "admin", "gather dashboard data", err), "Error loading dashboard")
+		return
+	}
+
+	if err := s.renderTemplate(w, "admin.html", data); err != nil {
+		s.log(r.Context(), "admin").Error("rendering admin dashboard", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error rendering page")
+	}
+}
+
+// adminDashboardJSONHandler returns the admin dashboard data as JSON
+func (s *Server) adminDashboardJSONHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	data, err := s.gatherDashboard(ctx)
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "admin", "gather dashboard data", err), "Error loading dashboard")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, data)
+}
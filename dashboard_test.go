@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAdminDashboardRequiresToken(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+
+	get := func(target, authorization string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept", "application/json")
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, target := range []string{"/admin", "/admin.json"} {
+		if code := get(target, ""); code != http.StatusUnauthorized {
+			t.Errorf("GET %s without a token status = %d, want %d", target, code, http.StatusUnauthorized)
+		}
+		if code := get(target, "Bearer wrong"); code != http.StatusUnauthorized {
+			t.Errorf("GET %s with the wrong token status = %d, want %d", target, code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestAdminDashboardJSON(t *testing.T) {
+	resetRateLimiters(t)
+	setGitHubRepos(t, []GitHubRepo{{Name: "site"}, {Name: "dotfiles"}})
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+	quote, _ := newQuote("Ada", "Simplicity is prerequisite for reliability", "", nil)
+	s.quotes.InsertQuote(context.Background(), quote)
+	for range 2 {
+		serveRequest(h, http.MethodPost, "/increment", "", "")
+	}
+	// Creating a counter is rate limited, so the limiter starts tracking the client
+	serveRequest(h, http.MethodPost, "/api/v1/counters", `{"name":"likes"}`, "203.0.113.1:1000")
+
+	r := httptest.NewRequest(http.MethodGet, "/admin.json", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	for _, name := range []string{"counters", "quoteCount", "connectedClients", "githubCachedRepos", "githubCacheAgeSeconds", "rateLimitBackend", "rateLimiters", "maintenance", "features", "generatedAt"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("dashboard is missing %q: %s", name, w.Body)
+		}
+	}
+
+	var data DashboardData
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.QuoteCount != 1 || data.ConnectedClients != 0 || data.GitHubCachedRepos != 2 || data.GitHubCacheAgeSeconds == nil {
+		t.Errorf("dashboard = %+v, want 1 quote, no clients, and 2 freshly cached repos", data)
+	}
+	if data.RateLimitBackend != "memory" || data.RateLimiters == 0 {
+		t.Errorf("dashboard rate limiting = %q with %d limiters, want the memory backend tracking a client", data.RateLimitBackend, data.RateLimiters)
+	}
+	webhook := slices.IndexFunc(data.Counters, func(c Counter) bool { return c.ID == "webhook" })
+	if webhook < 0 || data.Counters[webhook].Count != 2 {
+		t.Errorf("dashboard counters = %+v, want the webhook counter at 2", data.Counters)
+	}
+}
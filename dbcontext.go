@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// readContext returns a context for MongoDB reads that ends when the request does or the read timeout passes
+func (s *Server) readContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), s.config.MongoReadTimeout)
+}
+
+// writeContext returns a context for MongoDB writes that ends when the request does or the write timeout passes
+func (s *Server) writeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), s.config.MongoWriteTimeout)
+}
+
+// detachedWriteContext returns a context for a write that should finish even after the request
+// that started it has ended. It keeps ctx's values, such as the request ID, but not its cancellation.
+func (s *Server) detachedWriteContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), s.config.MongoWriteTimeout)
+}
+
+// isTimeout reports whether a database error was caused by a deadline passing
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)
+}
+
+// dbError logs a failed database operation for a component and returns the status to respond
+// with: 503 Service Unavailable if the circuit breaker refused it, 504 Gateway Timeout if the
+// operation timed out, or 500 otherwise
+func (s *Server) dbError(ctx context.Context, component, operation string, err error) int {
+	if errors.Is(err, errStoreUnavailable) {
+		s.log(ctx, component).Warn("database operation skipped while unavailable", "operation", operation)
+		return http.StatusServiceUnavailable
+	}
+	if isTimeout(err) {
+		s.log(ctx, component).Error("database operation timed out", "operation", operation, "err", err)
+		return http.StatusGatewayTimeout
+	}
+	s.log(ctx, component).Error("database operation failed", "operation", operation, "err", err)
+	return http.StatusInternalServerError
+}
+
+// readOnlyMessage is shown for writes refused while the database is unavailable
+const readOnlyMessage = "The site is temporarily read-only while its database is unavailable. Please try again in a minute."
+
+// writeError is dbError for writes a visitor made, also returning the message to show them:
+// readOnlyMessage if the circuit breaker refused the write, since trying again later will work,
+// or message otherwise
+func (s *Server) writeError(ctx context.Context, component, operation string, err error, message string) (int, string) {
+	status := s.dbError(ctx, component, operation, err)
+	if errors.Is(err, errStoreUnavailable) {
+		return status, readOnlyMessage
+	}
+	return status, message
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestDBErrorStatus(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errStoreUnavailable, http.StatusServiceUnavailable},
+		{fmt.Errorf("list quotes: %w", errStoreUnavailable), http.StatusServiceUnavailable},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{fmt.Errorf("search quotes: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		// MaxTimeMSExpired, which the server returns when an operation runs out of time
+		{mongo.CommandError{Code: 50, Message: "operation exceeded time limit"}, http.StatusGatewayTimeout},
+		{context.Canceled, http.StatusInternalServerError},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := s.dbError(context.Background(), "test", "test operation", tt.err); got != tt.want {
+			t.Errorf("dbError(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// hangingQuotes is a quote store whose searches never finish, returning only once their context ends
+type hangingQuotes struct {
+	*memoryStore
+}
+
+func (hangingQuotes) SearchQuotes(ctx context.Context, term string, limit int64) ([]Quote, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSlowReadTimesOut(t *testing.T) {
+	s := newTestServer(t)
+	s.config.MongoReadTimeout = 20 * time.Millisecond
+	s.quotes = hangingQuotes{newMemoryStore()}
+
+	start := time.Now()
+	w := serveRequest(s.routes(), http.MethodGet, "/api/v1/search?q=ada", "", "")
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("search with a hanging store = %d, want %d: %s", w.Code, http.StatusGatewayTimeout, w.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("search took %v, want it cut off at the 20ms read timeout", elapsed)
+	}
+}
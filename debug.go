@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// RuntimeStats is a snapshot of what the process is doing, for diagnosing CPU or memory spikes
+type RuntimeStats struct {
+	Goroutines       int       `json:"goroutines"`
+	Heap             HeapStats `json:"heap"`
+	GC               GCStats   `json:"gc"`
+	ConnectedClients int       `json:"connectedClients"`
+	StreamClients    int       `json:"streamClients"`
+	RateLimiters     int       `json:"rateLimiters"`
+	GeneratedAt      time.Time `json:"generatedAt"`
+}
+
+// HeapStats summarizes the heap from runtime.MemStats
+type HeapStats struct {
+	AllocBytes      uint64 `json:"allocBytes"`
+	InUseBytes      uint64 `json:"inUseBytes"`
+	SysBytes        uint64 `json:"sysBytes"`
+	Objects         uint64 `json:"objects"`
+	TotalAllocBytes uint64 `json:"totalAllocBytes"`
+}
+
+// GCStats summarizes garbage collection pauses from runtime.MemStats
+type GCStats struct {
+	Cycles       uint32  `json:"cycles"`
+	PauseTotalMs float64 `json:"pauseTotalMs"`
+	LastPauseMs  float64 `json:"lastPauseMs"`
+	// MaxRecentPauseMs is the longest of the last 256 pauses
+	MaxRecentPauseMs float64 `json:"maxRecentPauseMs"`
+	// LastGC is when the last collection finished, or null if there hasn't been one
+	LastGC      *time.Time `json:"lastGC"`
+	CPUFraction float64    `json:"cpuFraction"`
+}
+
+// milliseconds converts nanoseconds to fractional milliseconds
+func milliseconds(ns uint64) float64 {
+	return float64(ns) / float64(time.Millisecond)
+}
+
+// newGCStats summarizes the collections recorded in m
+func newGCStats(m *runtime.MemStats) GCStats {
+	stats := GCStats{
+		Cycles:       m.NumGC,
+		PauseTotalMs: milliseconds(m.PauseTotalNs),
+		CPUFraction:  m.GCCPUFraction,
+	}
+	if m.NumGC == 0 {
+		return stats
+	}
+
+	// PauseNs is a circular buffer with the most recent pause at (NumGC+255)%256
+	stats.LastPauseMs = milliseconds(m.PauseNs[(m.NumGC+255)%256])
+	recent := min(int(m.NumGC), len(m.PauseNs))
+	for _, pause := range m.PauseNs[:recent] {
+		stats.MaxRecentPauseMs = max(stats.MaxRecentPauseMs, milliseconds(pause))
+	}
+	lastGC := time.Unix(0, int64(m.LastGC))
+	stats.LastGC = &lastGC
+	return stats
+}
+
+// runtimeHandler returns the goroutine count, heap and GC stats, WebSocket and counter stream
+// clients, and rate limiter count as JSON
+func (s *Server) runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	respondJSON(w, http.StatusOK, RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		Heap: HeapStats{
+			AllocBytes:      m.HeapAlloc,
+			InUseBytes:      m.HeapInuse,
+			SysBytes:        m.HeapSys,
+			Objects:         m.HeapObjects,
+			TotalAllocBytes: m.TotalAlloc,
+		},
+		GC:               newGCStats(&m),
+		ConnectedClients: s.hub.ClientCount(),
+		StreamClients:    s.hub.StreamCount(),
+		RateLimiters:     memoryLimiterCount(),
+		GeneratedAt:      time.Now(),
+	})
+}
+
+// withoutWriteDeadline lets a profile take longer to collect than the server's write timeout,
+// such as the 30 seconds go tool pprof asks for by default
+func (s *Server) withoutWriteDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			s.log(r.Context(), "debug").Error("clearing write deadline for profile", "err", err)
+			next(w, r)
+			return
+		}
+		// pprof refuses durations past the server's WriteTimeout, which it finds in the context,
+		// not knowing the deadline has been cleared
+		next(w, r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, nil)))
+	}
+}
+
+// registerDebugRoutes serves the pprof profiles under /debug/pprof/ on mux, for admins only.
+// Importing net/http/pprof also registers them on http.DefaultServeMux, which the site never
+// serves, so these routes are the only way to reach them.
+func (s *Server) registerDebugRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/pprof/", s.adminAuthMiddleware(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", s.adminAuthMiddleware(pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", s.adminAuthMiddleware(s.withoutWriteDeadline(pprof.Profile)))
+	mux.HandleFunc("GET /debug/pprof/symbol", s.adminAuthMiddleware(pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", s.adminAuthMiddleware(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", s.adminAuthMiddleware(s.withoutWriteDeadline(pprof.Trace)))
+}
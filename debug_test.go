@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestDebugEndpointsRequireToken(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+
+	targets := []string{"/admin/runtime", "/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline", "/debug/pprof/symbol"}
+	for _, target := range targets {
+		if w := serveRequest(h, http.MethodGet, target, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without a token status = %d, want %d", target, w.Code, http.StatusUnauthorized)
+		}
+		r := newJSONRequest(http.MethodGet, target, "")
+		r.Header.Set("Authorization", "Bearer wrong")
+		if w := serve(h, r); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s with the wrong token status = %d, want %d", target, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestRuntimeStats(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+	runtime.GC()
+
+	r := newJSONRequest(http.MethodGet, "/admin/runtime", "")
+	r.Header.Set("Authorization", "Bearer secret")
+	w := serve(h, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/runtime status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var stats RuntimeStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Goroutines < 1 || stats.Heap.AllocBytes == 0 {
+		t.Errorf("stats = %+v, want goroutines and heap allocations", stats)
+	}
+	if stats.GC.Cycles == 0 || stats.GC.LastGC == nil {
+		t.Errorf("GC stats = %+v after a collection, want it recorded", stats.GC)
+	}
+
+	r = newJSONRequest(http.MethodGet, "/debug/pprof/heap?debug=1", "")
+	r.Header.Set("Authorization", "Bearer secret")
+	if w := serve(h, r); w.Code != http.StatusOK {
+		t.Errorf("GET /debug/pprof/heap status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestNewGCStatsBeforeFirstCollection(t *testing.T) {
+	stats := newGCStats(&runtime.MemStats{})
+	if stats.LastGC != nil || stats.LastPauseMs != 0 {
+		t.Errorf("newGCStats() = %+v with no collections, want no last pause", stats)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// FeatureFlags toggles optional features at runtime. Every feature defaults to on,
+// so a flag missing from the configuration leaves its feature enabled.
+type FeatureFlags struct {
+	GraphQL          bool `json:"graphql"`
+	QuoteSubmissions bool `json:"quoteSubmissions"`
+	Search           bool `json:"search"`
+	APIDocs          bool `json:"apiDocs"`
+}
+
+// defaultFeatureFlags returns the flags used when FEATURES doesn't mention a feature
+func defaultFeatureFlags() FeatureFlags {
+	return FeatureFlags{
+		GraphQL:          true,
+		QuoteSubmissions: true,
+		Search:           true,
+		APIDocs:          true,
+	}
+}
+
+// featureState holds a server's current feature flags, which admins can change at runtime
+type featureState struct {
+	mu    sync.RWMutex
+	flags FeatureFlags
+}
+
+// parseFeatureFlags reads flags from a JSON object, keeping the defaults for any it doesn't set.
+// Invalid JSON returns the zero FeatureFlags, not whatever was decoded before the error.
+func parseFeatureFlags(raw string) (FeatureFlags, error) {
+	flags := defaultFeatureFlags()
+	if raw == "" {
+		return flags, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &flags); err != nil {
+		return FeatureFlags{}, err
+	}
+	return flags, nil
+}
+
+// getFeatures returns the current feature flags
+func (s *Server) getFeatures() FeatureFlags {
+	s.features.mu.RLock()
+	defer s.features.mu.RUnlock()
+	return s.features.flags
+}
+
+// setFeatures replaces the feature flags, logging who changed them
+func (s *Server) setFeatures(flags FeatureFlags, actor string) {
+	s.features.mu.Lock()
+	old := s.features.flags
+	s.features.flags = flags
+	s.features.mu.Unlock()
+
+	componentLogger("features").Info("feature flags changed", "actor", actor, "old", old, "new", flags)
+}
+
+// requireFeature responds with 404 when the feature picked out by enabled is turned off.
+// Flags are checked on every request, so toggling one takes effect without re-registering routes.
+func (s *Server) requireFeature(enabled func(FeatureFlags) bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enabled(s.getFeatures()) {
+			if r.Context().Value(apiVersionKey{}) != nil {
+				s.apiError(w, r, http.StatusNotFound, "Not found")
+			} else {
+				s.respondError(w, r, http.StatusNotFound, "Not found")
+			}
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// getFeaturesHandler returns the current feature flags
+func (s *Server) getFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, s.getFeatures())
+}
+
+// setFeaturesHandler updates the feature flags. Flags missing from the body keep their current value.
+func (s *Server) setFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	flags := s.getFeatures()
+	if err := json.NewDecoder(r.Body).Decode(&flags); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	s.setFeatures(flags, s.getIPAddress(r))
+	s.logAdminAction(r, "features.update", fmt.Sprintf("%+v", flags), true)
+
+	s.getFeaturesHandler(w, r)
+}
+
+// Feature selectors for requireFeature
+func graphQLEnabled(f FeatureFlags) bool          { return f.GraphQL }
+func quoteSubmissionsEnabled(f FeatureFlags) bool { return f.QuoteSubmissions }
+func searchEnabled(f FeatureFlags) bool           { return f.Search }
+func apiDocsEnabled(f FeatureFlags) bool          { return f.APIDocs }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	allOn := defaultFeatureFlags()
+	noSearch := allOn
+	noSearch.Search = false
+
+	tests := []struct {
+		raw     string
+		want    FeatureFlags
+		wantErr bool
+	}{
+		{"", allOn, false},
+		{"{}", allOn, false},
+		{`{"search": false}`, noSearch, false},
+		{`{"search": false, "unknown": true}`, noSearch, false},
+		// A partly decoded object isn't returned alongside its error
+		{`{"search": false, "graphql": "no"}`, FeatureFlags{}, true},
+		{"search=false", FeatureFlags{}, true},
+		{`["search"]`, FeatureFlags{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFeatureFlags(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseFeatureFlags(%q) = %+v, %v; want %+v, error %t", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSetFeaturesTogglesRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+
+	if w := serveRequest(h, http.MethodGet, "/api/docs", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("API docs before disabling = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	r := newJSONRequest(http.MethodPost, "/admin/features", `{"apiDocs": false}`)
+	r.Header.Set("Authorization", "Bearer secret")
+	if w := serve(h, r); w.Code != http.StatusOK {
+		t.Fatalf("POST /admin/features = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if flags := s.getFeatures(); flags.APIDocs || !flags.Search {
+		t.Errorf("flags after disabling the API docs = %+v, want only the API docs off", flags)
+	}
+	if w := serveRequest(h, http.MethodGet, "/api/docs", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("API docs after disabling = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// A malformed update leaves the flags alone
+	r = newJSONRequest(http.MethodPost, "/admin/features", `{"apiDocs": "yes"}`)
+	r.Header.Set("Authorization", "Bearer secret")
+	if w := serve(h, r); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /admin/features with a bad body = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if s.getFeatures().APIDocs {
+		t.Error("a rejected update changed the flags")
+	}
+}
+
+func TestFeatureFlagsArePerServer(t *testing.T) {
+	noDocs := defaultFeatureFlags()
+	noDocs.APIDocs = false
+	config := defaultConfig()
+	config.Features = noDocs
+	first, second := newTestServerWithConfig(t, config), newTestServer(t)
+
+	if w := serveRequest(first.routes(), http.MethodGet, "/api/docs", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("API docs with FEATURES turning them off = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	second.setFeatures(noDocs, "test")
+	first.setFeatures(defaultFeatureFlags(), "test")
+	if first.getFeatures() != defaultFeatureFlags() || second.getFeatures() != noDocs {
+		t.Errorf("flags = %+v and %+v, want each server's own", first.getFeatures(), second.getFeatures())
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// feedPath is where the quotes feed is served
+	feedPath = "/feed"
+	// feedMaxItems is how many of the newest quotes the feed lists
+	feedMaxItems = 50
+	// feedCacheTTL is how long the feed's quotes are reused, and how long clients may cache it
+	feedCacheTTL = 60 * time.Second
+	// jsonFeedVersion identifies the JSON Feed version the feed follows
+	jsonFeedVersion = "https://jsonfeed.org/version/1"
+)
+
+// jsonFeed is a JSON Feed 1.0 document, https://www.jsonfeed.org/version/1/
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+// jsonFeedItem is one quote in the feed
+type jsonFeedItem struct {
+	// ID is the quote's permalink, which is also its URL
+	ID            string         `json:"id"`
+	URL           string         `json:"url"`
+	ExternalURL   string         `json:"external_url,omitempty"`
+	ContentText   string         `json:"content_text"`
+	DatePublished string         `json:"date_published"`
+	Author        jsonFeedAuthor `json:"author"`
+	Tags          []string       `json:"tags,omitempty"`
+}
+
+// jsonFeedAuthor is who a feed item is by
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// feedCache holds the quotes most recently fetched for the feed
+type feedCache struct {
+	mu        sync.Mutex
+	quotes    []Quote
+	fetchedAt time.Time
+}
+
+// feedQuotes returns the newest quotes for the feed, fetching them again once they're older than
+// feedCacheTTL
+func (s *Server) feedQuotes(ctx context.Context) ([]Quote, error) {
+	// Requests arriving during a fetch wait for it rather than repeating it
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	if s.feed.quotes != nil && time.Since(s.feed.fetchedAt) < feedCacheTTL {
+		return s.feed.quotes, nil
+	}
+
+	quotes, err := s.quotes.LatestQuotes(ctx, feedMaxItems)
+	if err != nil {
+		return nil, err
+	}
+	if quotes == nil {
+		quotes = []Quote{}
+	}
+	s.feed.quotes, s.feed.fetchedAt = quotes, time.Now()
+	return quotes, nil
+}
+
+// newJSONFeed builds the feed of quotes, with URLs made absolute with siteURL
+func newJSONFeed(siteURL string, quotes []Quote) jsonFeed {
+	feed := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       siteName + "'s quotes",
+		HomePageURL: siteURL + "/",
+		FeedURL:     siteURL + feedPath,
+		Description: "Quotes left by visitors to " + siteName + "'s site",
+		Items:       make([]jsonFeedItem, 0, len(quotes)),
+	}
+	for _, quote := range quotes {
+		permalink := siteURL + "/#quote-" + quote.ID.Hex()
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            permalink,
+			URL:           permalink,
+			ExternalURL:   quote.Source,
+			ContentText:   quote.Quote,
+			DatePublished: quote.Timestamp.UTC().Format(time.RFC3339),
+			Author:        jsonFeedAuthor{Name: quote.Name},
+			Tags:          quote.Tags,
+		})
+	}
+	return feed
+}
+
+// feedSiteURL returns the address feed URLs are built from: SITE_URL, or without it the host the
+// request was sent to
+func (s *Server) feedSiteURL(r *http.Request) string {
+	if s.config.SiteURL != "" {
+		return s.config.SiteURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + strings.TrimSuffix(r.Host, "/")
+}
+
+// feedHandler serves the newest quotes as a JSON Feed
+func (s *Server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	quotes, err := s.feedQuotes(ctx)
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "feed", "get feed quotes", err), "Error building feed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(feedCacheTTL.Seconds())))
+	if err := json.NewEncoder(w).Encode(newJSONFeed(s.feedSiteURL(r), quotes)); err != nil {
+		s.log(r.Context(), "feed").Warn("sending feed", "err", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// getFeed requests the feed from s and decodes it
+func getFeed(t *testing.T, s *Server) (*http.Response, jsonFeed) {
+	t.Helper()
+	w := serveRequest(s.routes(), http.MethodGet, "/feed", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /feed status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var feed jsonFeed
+	if err := json.NewDecoder(w.Body).Decode(&feed); err != nil {
+		t.Fatalf("decoding feed: %v", err)
+	}
+	return w.Result(), feed
+}
+
+func TestFeedListsNewestQuotes(t *testing.T) {
+	s := newTestServer(t)
+	s.config.SiteURL = "https://example.com"
+	start := time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("EST", -5*60*60))
+	var newest Quote
+	for i := range feedMaxItems + 5 {
+		newest = Quote{ID: primitive.NewObjectID(), Name: "Ada", Quote: "Quote " + string(rune('A'+i%26)), Tags: []string{"go"}, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		if err := s.quotes.InsertQuote(t.Context(), newest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, feed := getFeed(t, s)
+	if got := resp.Header.Get("Content-Type"); got != "application/feed+json" {
+		t.Errorf("Content-Type = %q, want application/feed+json", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q, want it cacheable for 60 seconds", got)
+	}
+	if feed.Version != jsonFeedVersion || feed.Title == "" || feed.HomePageURL != "https://example.com/" || feed.FeedURL != "https://example.com/feed" {
+		t.Errorf("feed = %+v, want its version, title, and URLs on SITE_URL", feed)
+	}
+	if len(feed.Items) != feedMaxItems {
+		t.Fatalf("feed has %d items, want %d", len(feed.Items), feedMaxItems)
+	}
+
+	item := feed.Items[0]
+	want := jsonFeedItem{
+		ID:            "https://example.com/#quote-" + newest.ID.Hex(),
+		URL:           "https://example.com/#quote-" + newest.ID.Hex(),
+		ContentText:   newest.Quote,
+		DatePublished: newest.Timestamp.UTC().Format(time.RFC3339),
+		Author:        jsonFeedAuthor{Name: "Ada"},
+		Tags:          []string{"go"},
+	}
+	if item.ID != want.ID || item.URL != want.URL || item.ContentText != want.ContentText ||
+		item.DatePublished != want.DatePublished || item.Author != want.Author || len(item.Tags) != 1 {
+		t.Errorf("newest item = %+v, want %+v", item, want)
+	}
+}
+
+func TestFeedIsCached(t *testing.T) {
+	s := newTestServer(t)
+	if _, feed := getFeed(t, s); len(feed.Items) != 0 || feed.Items == nil {
+		t.Fatalf("feed without quotes has items %v, want an empty list", feed.Items)
+	}
+
+	quote, _ := newQuote("Ada", "Added after the feed was fetched", "", nil)
+	if err := s.quotes.InsertQuote(t.Context(), quote); err != nil {
+		t.Fatal(err)
+	}
+	if _, feed := getFeed(t, s); len(feed.Items) != 0 {
+		t.Errorf("feed within its cache TTL has %d items, want the cached empty list", len(feed.Items))
+	}
+
+	s.feed.fetchedAt = time.Now().Add(-feedCacheTTL)
+	if _, feed := getFeed(t, s); len(feed.Items) != 1 {
+		t.Errorf("feed after its cache TTL has %d items, want the new quote", len(feed.Items))
+	}
+}
+
+func TestFeedURLsUseRequestHostWithoutSiteURL(t *testing.T) {
+	_, feed := getFeed(t, newTestServer(t))
+	if feed.FeedURL != "http://example.com/feed" {
+		t.Errorf("feed_url = %q, want it on the request's host", feed.FeedURL)
+	}
+}
+
+func TestHomePageLinksFeed(t *testing.T) {
+	setGitHubRepos(t, nil)
+	s := newTestServer(t)
+	s.config.SiteURL = "https://example.com"
+
+	w := serveRequest(s.routes(), http.MethodGet, "/", "", "")
+	if want := `<link rel="alternate" type="application/feed+json" title="Wyat's quotes" href="https://example.com/feed">`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("home page doesn't link the feed with %s", want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// fingerprintHashLength is the number of hex characters of the content hash put in file names
+const fingerprintHashLength = 12
+
+// Fingerprinter maps static file paths to content-hashed paths for cache-busting
+type Fingerprinter struct {
+	hashed   map[string]string // /static/style.css -> /static/style.abc123.css
+	original map[string]string // style.abc123.css -> style.css
+	etags    map[string]string // style.css -> "abc123"
+}
+
+var (
+	fingerprinter   *Fingerprinter
+	fingerprinterMu sync.RWMutex
+)
+
+// NewFingerprinter hashes every file in the static filesystem
+func NewFingerprinter(fsys fs.FS) (*Fingerprinter, error) {
+	f := &Fingerprinter{
+		hashed:   make(map[string]string),
+		original: make(map[string]string),
+		etags:    make(map[string]string),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:fingerprintHashLength]
+
+		ext := path.Ext(name)
+		hashedName := strings.TrimSuffix(name, ext) + "." + hash + ext
+
+		f.hashed["/static/"+name] = "/static/" + hashedName
+		f.original[hashedName] = name
+		f.etags[name] = `"` + hash + `"`
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// AssetURL returns the fingerprinted URL for a static path, such as /static/style.css or just
+// style.css, or the path unchanged if it isn't a known file
+func (f *Fingerprinter) AssetURL(p string) string {
+	if hashed, ok := f.hashed[p]; ok {
+		return hashed
+	}
+	if hashed, ok := f.hashed["/static/"+p]; ok && !strings.HasPrefix(p, "/") {
+		return hashed
+	}
+	return p
+}
+
+// loadFingerprinter rebuilds the fingerprint map from the current static files
+func loadFingerprinter(static fs.FS) {
+	f, err := NewFingerprinter(static)
+	if err != nil {
+		componentLogger("assets").Error("fingerprinting static files", "err", err)
+		return
+	}
+
+	fingerprinterMu.Lock()
+	fingerprinter = f
+	fingerprinterMu.Unlock()
+}
+
+// currentFingerprinter returns the active fingerprinter
+func currentFingerprinter() *Fingerprinter {
+	fingerprinterMu.RLock()
+	defer fingerprinterMu.RUnlock()
+	return fingerprinter
+}
+
+// assetURL is the template function mapping a static path to its fingerprinted URL
+func assetURL(p string) string {
+	f := currentFingerprinter()
+	if f == nil {
+		return p
+	}
+	return f.AssetURL(p)
+}
+
+// fingerprintMiddleware serves fingerprinted paths as their original files with long-lived
+// cache headers, since the content behind a hashed name never changes. Files requested by their
+// plain name must be revalidated with no-cache, so a changed file is picked up on the next visit.
+// Both get a strong ETag from the content hash, which the file server answers If-None-Match with
+// a 304 for; the file's modification time can't be used since embedded files don't have one.
+// It expects paths with the /static/ prefix already stripped.
+func fingerprintMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := currentFingerprinter()
+		if f != nil {
+			name := strings.TrimPrefix(r.URL.Path, "/")
+			if original, ok := f.original[name]; ok {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+				r2 := r.Clone(r.Context())
+				r2.URL.Path = "/" + original
+				r2.URL.RawPath = ""
+				r, name = r2, original
+			} else if _, ok := f.etags[name]; ok {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+			if etag, ok := f.etags[name]; ok {
+				w.Header().Set("ETag", etag)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
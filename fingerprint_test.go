@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// setFingerprinter makes f the active fingerprinter for the rest of the test
+func setFingerprinter(t *testing.T, f *Fingerprinter) {
+	t.Helper()
+	fingerprinterMu.Lock()
+	previous := fingerprinter
+	fingerprinter = f
+	fingerprinterMu.Unlock()
+	t.Cleanup(func() {
+		fingerprinterMu.Lock()
+		fingerprinter = previous
+		fingerprinterMu.Unlock()
+	})
+}
+
+// testStaticFS is a static directory with a stylesheet, its gzipped copy, and a nested file
+var testStaticFS = fstest.MapFS{
+	"style.css":    {Data: []byte("body { color: black; }")},
+	"style.css.gz": {Data: []byte("pretend gzip")},
+	"js/app.js":    {Data: []byte("console.log('hi');")},
+}
+
+// contentHash is the fingerprint hash of data
+func contentHash(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])[:fingerprintHashLength]
+}
+
+func TestFingerprinterMapsAssetsToHashedNames(t *testing.T) {
+	f, err := NewFingerprinter(testStaticFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cssHash, jsHash := contentHash("body { color: black; }"), contentHash("console.log('hi');")
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/static/style.css", "/static/style." + cssHash + ".css"},
+		{"style.css", "/static/style." + cssHash + ".css"},
+		{"/static/js/app.js", "/static/js/app." + jsHash + ".js"},
+		{"js/app.js", "/static/js/app." + jsHash + ".js"},
+		{"/static/missing.css", "/static/missing.css"},
+		{"missing.css", "missing.css"},
+		{"/style.css", "/style.css"},
+	}
+	for _, tt := range tests {
+		if got := f.AssetURL(tt.path); got != tt.want {
+			t.Errorf("AssetURL(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	// Changing a file changes its hashed name
+	changed := fstest.MapFS{"style.css": {Data: []byte("body { color: red; }")}}
+	g, err := NewFingerprinter(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.AssetURL("style.css") == g.AssetURL("style.css") {
+		t.Error("hashed name didn't change with the file's content")
+	}
+}
+
+func TestStaticFilesCacheHeaders(t *testing.T) {
+	f, err := NewFingerprinter(testStaticFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFingerprinter(t, f)
+	h := http.StripPrefix("/static/", fingerprintMiddleware(precompressedFileServer(testStaticFS, http.FileServer(http.FS(testStaticFS)))))
+	etag := `"` + contentHash("body { color: black; }") + `"`
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		return serve(h, r)
+	}
+
+	tests := []struct {
+		path, cacheControl string
+	}{
+		{f.AssetURL("style.css"), "public, max-age=31536000, immutable"},
+		{"/static/style.css", "no-cache"},
+	}
+	for _, tt := range tests {
+		w := get(tt.path, nil)
+		if w.Code != http.StatusOK || w.Body.String() != "body { color: black; }" {
+			t.Errorf("GET %s = %d %q, want the stylesheet", tt.path, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("GET %s Cache-Control = %q, want %q", tt.path, got, tt.cacheControl)
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Errorf("GET %s ETag = %q, want %s", tt.path, got, etag)
+		}
+
+		// Revalidating with the ETag gets a 304 without the body
+		w = get(tt.path, http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("GET %s with If-None-Match = %d %q, want an empty %d", tt.path, w.Code, w.Body, http.StatusNotModified)
+		}
+		if w := get(tt.path, http.Header{"If-None-Match": {`"stale"`}}); w.Code != http.StatusOK {
+			t.Errorf("GET %s with an old ETag = %d, want %d", tt.path, w.Code, http.StatusOK)
+		}
+	}
+
+	// The gzipped copy is its own representation, with its own ETag
+	w := get("/static/style.css", http.Header{"Accept-Encoding": {"gzip"}})
+	gzipETag := w.Header().Get("ETag")
+	if w.Header().Get("Content-Encoding") != "gzip" || gzipETag == etag || !strings.HasPrefix(gzipETag, `"`) {
+		t.Errorf("gzipped GET Content-Encoding = %q, ETag %q; want gzip with a different strong ETag", w.Header().Get("Content-Encoding"), gzipETag)
+	}
+	if w := get("/static/style.css", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {gzipETag}}); w.Code != http.StatusNotModified {
+		t.Errorf("gzipped GET with If-None-Match = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	// Unknown files aren't given caching headers
+	if w := get("/static/missing.css", nil); w.Code != http.StatusNotFound || w.Header().Get("Cache-Control") != "" || w.Header().Get("ETag") != "" {
+		t.Errorf("GET missing file = %d with Cache-Control %q, ETag %q; want a bare 404", w.Code, w.Header().Get("Cache-Control"), w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// forecastHistory is how far back counter events are averaged to forecast a counter
+const forecastHistory = 7 * 24 * time.Hour
+
+// minForecastEvents is the fewest events in forecastHistory a forecast is made from
+const minForecastEvents = 10
+
+// forecastHorizons are the values the forecast endpoint accepts for how far ahead to project
+var forecastHorizons = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// ForecastResponse is a counter's value projected Horizon ahead at its recent rate
+type ForecastResponse struct {
+	CurrentValue   int     `json:"currentValue"`
+	ProjectedValue int     `json:"projectedValue"`
+	Horizon        string  `json:"horizon"`
+	RatePerHour    float64 `json:"ratePerHour"`
+}
+
+// counterForecastHandler projects a counter's value ahead by the in query parameter (default
+// 24h), extrapolating linearly from its net change per hour over the last forecastHistory. The
+// rate is averaged over the time since the oldest of those events, so a counter that's only been
+// changing for a day isn't diluted by six quiet days it didn't exist for.
+func (s *Server) counterForecastHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := normalizeCounterName(r.PathValue("name"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	horizonName := r.URL.Query().Get("in")
+	if horizonName == "" {
+		horizonName = "24h"
+	}
+	horizon, ok := forecastHorizons[horizonName]
+	if !ok {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid in, expected 1h, 24h, 7d, or 30d")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	counter, err := s.getCounter(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) || s.config.Counting.hides(name) {
+		s.apiError(w, r, http.StatusNotFound, "Counter not found")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "get counter", err), "Error getting counter")
+		return
+	}
+
+	now := time.Now()
+	summary, err := s.counters.SummarizeCounterEvents(ctx, name, now.Add(-forecastHistory))
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "summarize counter events", err), "Error getting counter history")
+		return
+	}
+	if summary.Events < minForecastEvents {
+		s.apiError(w, r, http.StatusUnprocessableEntity, "Not enough history to forecast, the counter needs at least 10 changes in the last 7 days")
+		return
+	}
+
+	// Events all within the last minute are averaged over a minute rather than next to no time
+	history := max(now.Sub(summary.Oldest), time.Minute)
+	if horizon > history {
+		s.log(r.Context(), "counter").Warn("forecasting further ahead than the counter's history",
+			"counter", name, "horizon", horizonName, "history", history.Round(time.Second).String())
+	}
+	ratePerHour := float64(summary.Delta) / history.Hours()
+	// Counters never go below zero, so a falling one bottoms out there rather than going negative
+	projected := max(0, counter.Count+int(math.Round(ratePerHour*horizon.Hours())))
+
+	respondJSON(w, http.StatusOK, ForecastResponse{
+		CurrentValue:   counter.Count,
+		ProjectedValue: projected,
+		Horizon:        horizonName,
+		RatePerHour:    math.Round(ratePerHour*100) / 100,
+	})
+}
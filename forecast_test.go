@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testCounterForecast checks forecasting the webhook counter against s's store
+func testCounterForecast(t *testing.T, s *Server) {
+	ctx := context.Background()
+	s.counters.InitCounters(ctx, "webhook", "likes", "stars")
+	for range 50 {
+		s.counters.IncrementCounter(ctx, "webhook")
+	}
+	for range 5 {
+		s.counters.IncrementCounter(ctx, "stars")
+	}
+	// 24 events adding 48 over the last two days, a net change of 1 an hour
+	now := time.Now()
+	for i := range 24 {
+		s.counters.RecordCounterEvent(ctx, CounterEvent{CounterID: "webhook", Delta: 2, Timestamp: now.Add(-time.Duration(i+1) * 2 * time.Hour)})
+	}
+	s.counters.RecordCounterEvent(ctx, CounterEvent{CounterID: "webhook", Delta: 1000, Timestamp: now.Add(-8 * 24 * time.Hour)}) // too old to count
+	for range minForecastEvents - 1 {
+		s.counters.RecordCounterEvent(ctx, CounterEvent{CounterID: "likes", Delta: 1, Timestamp: now.Add(-time.Hour)})
+	}
+	// stars has been falling by 1 an hour, so it would pass zero within a day
+	for i := range 24 {
+		s.counters.RecordCounterEvent(ctx, CounterEvent{CounterID: "stars", Delta: -2, Timestamp: now.Add(-time.Duration(i+1) * 2 * time.Hour)})
+	}
+	records := captureLogs(t, s)
+	h := s.routes()
+
+	tests := []struct {
+		target string
+		want   ForecastResponse
+	}{
+		{"/api/v1/counters/webhook/forecast", ForecastResponse{CurrentValue: 50, ProjectedValue: 74, Horizon: "24h", RatePerHour: 1}},
+		{"/api/v1/counters/webhook/forecast?in=1h", ForecastResponse{CurrentValue: 50, ProjectedValue: 51, Horizon: "1h", RatePerHour: 1}},
+		{"/api/v1/counters/webhook/forecast?in=7d", ForecastResponse{CurrentValue: 50, ProjectedValue: 218, Horizon: "7d", RatePerHour: 1}},
+		{"/api/v1/counters/stars/forecast?in=1h", ForecastResponse{CurrentValue: 5, ProjectedValue: 4, Horizon: "1h", RatePerHour: -1}},
+		{"/api/v1/counters/stars/forecast", ForecastResponse{CurrentValue: 5, ProjectedValue: 0, Horizon: "24h", RatePerHour: -1}},
+	}
+	for _, tt := range tests {
+		w := serveRequest(h, http.MethodGet, tt.target, "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d: %s", tt.target, w.Code, http.StatusOK, w.Body)
+		}
+		var forecast ForecastResponse
+		if err := json.NewDecoder(w.Body).Decode(&forecast); err != nil {
+			t.Fatal(err)
+		}
+		if forecast != tt.want {
+			t.Errorf("GET %s = %+v, want %+v", tt.target, forecast, tt.want)
+		}
+	}
+
+	// Only the 7 day forecast reaches past the two days of history
+	var warnings int
+	for _, record := range records() {
+		if record["msg"] == "forecasting further ahead than the counter's history" {
+			warnings++
+			if record["horizon"] != "7d" {
+				t.Errorf("warned about the %v forecast, want only 7d", record["horizon"])
+			}
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("logged %d warnings about the horizon, want 1", warnings)
+	}
+
+	for _, tt := range []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/counters/webhook/forecast?in=2d", http.StatusBadRequest},
+		{"/api/v1/counters/likes/forecast", http.StatusUnprocessableEntity},
+		{"/api/v1/counters/missing/forecast", http.StatusNotFound},
+	} {
+		if w := serveRequest(h, http.MethodGet, tt.target, "", ""); w.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.target, w.Code, tt.status)
+		}
+	}
+}
+
+func TestCounterForecast(t *testing.T) {
+	testCounterForecast(t, newTestServer(t))
+}
+
+func TestCounterForecastMongo(t *testing.T) {
+	testCounterForecast(t, newMongoTestServer(t))
+}
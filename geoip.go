@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// countryLookup finds the country an IP address is in
+type countryLookup interface {
+	// Country returns the ISO 3166-1 alpha-2 code of ip's country, or "" if it isn't known
+	Country(ip netip.Addr) string
+}
+
+// maxmindCountries looks countries up in a MaxMind GeoLite2-Country or GeoIP2-Country database
+type maxmindCountries struct {
+	reader *maxminddb.Reader
+}
+
+func (m *maxmindCountries) Country(ip netip.Addr) string {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := m.reader.Lookup(net.IP(ip.AsSlice()), &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// openCountryDatabase opens the MaxMind database at path for looking up visitors' countries. It
+// returns nil, so page views are counted without a country, if path is empty or the database
+// can't be opened.
+func openCountryDatabase(path string) countryLookup {
+	if path == "" {
+		return nil
+	}
+	reader, err := maxminddb.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		componentLogger("geoip").Warn("country database not found, page views won't be counted by country", "path", path)
+		return nil
+	}
+	if err != nil {
+		componentLogger("geoip").Error("opening country database", "path", path, "err", err)
+		return nil
+	}
+	return &maxmindCountries{reader: reader}
+}
+
+// visitorCountry returns the country code of the visitor making r, or "" without a country
+// database or if their address isn't in it
+func (s *Server) visitorCountry(r *http.Request) string {
+	if s.countries == nil {
+		return ""
+	}
+	ip, err := netip.ParseAddr(s.getIPAddress(r))
+	if err != nil {
+		return ""
+	}
+	return s.countries.Country(ip.Unmap())
+}
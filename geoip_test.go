@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeCountries looks countries up in a map instead of a MaxMind database
+type fakeCountries map[string]string
+
+func (f fakeCountries) Country(ip netip.Addr) string {
+	return f[ip.String()]
+}
+
+// testCountryViews checks counting page views by country against s's store
+func testCountryViews(t *testing.T, s *Server) {
+	s.countries = fakeCountries{"203.0.113.5": "US", "198.51.100.7": "DE", "192.0.2.44": "US"}
+	for _, ip := range []string{"203.0.113.5", "198.51.100.7", "192.0.2.44", "192.0.2.200", "::ffff:198.51.100.7"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = netip.AddrPortFrom(netip.MustParseAddr(ip), 1000).String()
+		s.trackPageView(r, "/")
+	}
+
+	w := serveRequest(s.routes(), http.MethodGet, "/api/analytics/countries", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var views []CountryViews
+	if err := json.NewDecoder(w.Body).Decode(&views); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	// The visitor whose country isn't known is still counted as a page view, just not by country
+	want := []CountryViews{{Country: "DE", Views: 2}, {Country: "US", Views: 2}}
+	if !slices.Equal(views, want) {
+		t.Errorf("country views = %+v, want %+v", views, want)
+	}
+	if total, err := s.pageViews.TotalPageViews(t.Context()); err != nil || total != 5 {
+		t.Errorf("TotalPageViews() = %d, %v; want 5", total, err)
+	}
+}
+
+func TestCountryViews(t *testing.T) {
+	testCountryViews(t, newTestServer(t))
+}
+
+func TestCountryViewsMongo(t *testing.T) {
+	testCountryViews(t, newMongoTestServer(t))
+}
+
+func TestCountryViewsWithoutDatabase(t *testing.T) {
+	s := newTestServer(t)
+	s.trackPageView(httptest.NewRequest(http.MethodGet, "/", nil), "/")
+
+	w := serveRequest(s.routes(), http.MethodGet, "/api/v1/analytics/countries", "", "")
+	if w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Errorf("response = %d %q, want an empty list", w.Code, w.Body.String())
+	}
+}
+
+func TestOpenCountryDatabaseSkipsMissingFile(t *testing.T) {
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")} {
+		if countries := openCountryDatabase(path); countries != nil {
+			t.Errorf("openCountryDatabase(%q) = %v, want nil", path, countries)
+		}
+	}
+}
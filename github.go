@@ -0,0 +1,279 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultReposPerPage is the page size used by the repos API when none is given
+const defaultReposPerPage = 30
+
+// githubCacheTTL is how long fetched repositories are reused before refetching
+const githubCacheTTL = 10 * time.Minute
+
+// GitHubRepo represents a GitHub repository
+type GitHubRepo struct {
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	HTMLURL         string    `json:"html_url"`
+	Language        string    `json:"language"`
+	StargazersCount int       `json:"stargazers_count"`
+	UpdatedAt       time.Time `json:"updated_at,omitzero"`
+	PushedAt        time.Time `json:"pushed_at,omitzero"`
+}
+
+// RepoPage represents a single page of repositories returned by the repos API
+type RepoPage struct {
+	Repos   []GitHubRepo `json:"repos"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"perPage"`
+	Total   int          `json:"total"`
+}
+
+// LanguageCount represents the number of repositories using a language
+type LanguageCount struct {
+	Language string `json:"language"`
+	Count    int    `json:"count"`
+}
+
+// repoCache holds the most recently fetched repositories
+type repoCache struct {
+	mu        sync.Mutex
+	repos     []GitHubRepo
+	fetchedAt time.Time
+}
+
+var githubCache = &repoCache{}
+
+// githubFallbackRepos are shown when GitHub can't be reached and no repos have been fetched
+// yet, such as right after a deploy. They're loaded once at startup from GITHUB_FALLBACK_FILE.
+var githubFallbackRepos []GitHubRepo
+
+// readGitHubFallback reads a JSON array of repos, in the format the GitHub API returns them
+func readGitHubFallback(path string) ([]GitHubRepo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := []GitHubRepo{}
+	if err := json.Unmarshal(data, &repos); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// loadGitHubFallback loads the fallback repos from path, if set. A missing or invalid file is
+// logged and the site carries on without a fallback.
+func loadGitHubFallback(path string) {
+	if path == "" {
+		return
+	}
+
+	repos, err := readGitHubFallback(path)
+	if err != nil {
+		componentLogger("github").Error("loading GitHub fallback repos", "path", path, "err", err)
+		return
+	}
+	githubFallbackRepos = repos
+	componentLogger("github").Info("loaded GitHub fallback repos", "count", len(repos), "path", path)
+}
+
+// getCachedGitHubRepos returns cached repositories, refetching them once the cache expires.
+// If a refetch fails, the previously cached repositories are kept, or the fallback repos are
+// used if nothing has been fetched yet. The fallback isn't cached, so the next call tries
+// GitHub again.
+func (s *Server) getCachedGitHubRepos(username string) []GitHubRepo {
+	githubCache.mu.Lock()
+	defer githubCache.mu.Unlock()
+
+	if githubCache.repos != nil && time.Since(githubCache.fetchedAt) < githubCacheTTL {
+		return githubCache.repos
+	}
+
+	repos, err := s.getGitHubRepos(username)
+	if err != nil {
+		componentLogger("github").Error("refreshing GitHub repos", "username", username, "err", err)
+		if githubCache.repos != nil {
+			return githubCache.repos
+		}
+		if githubFallbackRepos != nil {
+			return githubFallbackRepos
+		}
+		return []GitHubRepo{}
+	}
+
+	githubCache.repos = repos
+	githubCache.fetchedAt = time.Now()
+	return repos
+}
+
+// getGitHubRepos fetches repositories for a given GitHub username
+func (s *Server) getGitHubRepos(username string) ([]GitHubRepo, error) {
+	reposURL := fmt.Sprintf("%s/users/%s/repos?sort=updated&per_page=100", s.config.GitHubAPIBase, url.PathEscape(username))
+
+	req, err := http.NewRequest("GET", reposURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating GitHub request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching GitHub repos: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+
+	var repos []GitHubRepo
+	err = json.NewDecoder(resp.Body).Decode(&repos)
+	if err != nil {
+		return nil, fmt.Errorf("decoding GitHub response: %w", err)
+	}
+
+	return repos, nil
+}
+
+// topReposByStars returns up to limit repos, most starred first, without modifying the input
+func topReposByStars(repos []GitHubRepo, limit int) []GitHubRepo {
+	sorted := make([]GitHubRepo, len(repos))
+	copy(sorted, repos)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].StargazersCount > sorted[j].StargazersCount
+	})
+
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// repoSortFields maps each field the repos API can sort on to a comparator putting repos in
+// ascending order. Sorting is limited to these so clients can't sort on arbitrary fields.
+var repoSortFields = map[string]func(a, b GitHubRepo) int{
+	"stars":   func(a, b GitHubRepo) int { return cmp.Compare(a.StargazersCount, b.StargazersCount) },
+	"name":    func(a, b GitHubRepo) int { return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) },
+	"updated": func(a, b GitHubRepo) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+	"pushed":  func(a, b GitHubRepo) int { return a.PushedAt.Compare(b.PushedAt) },
+}
+
+// sortRepos returns a copy of repos sorted on the given field, descending if desc is set. Ties
+// keep their original order. The field must be in repoSortFields.
+func sortRepos(repos []GitHubRepo, field string, desc bool) []GitHubRepo {
+	compare := repoSortFields[field]
+	sorted := slices.Clone(repos)
+	slices.SortStableFunc(sorted, func(a, b GitHubRepo) int {
+		if desc {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+	return sorted
+}
+
+// repoSortDescending reports whether a repos sort on field should be descending given the dir
+// query parameter. Without dir, names sort A to Z and everything else largest or newest first.
+func repoSortDescending(field, dir string) (desc, ok bool) {
+	switch dir {
+	case "":
+		return field != "name", true
+	case "asc":
+		return false, true
+	case "desc":
+		return true, true
+	}
+	return false, false
+}
+
+// reposHandler returns all cached repos, paginated with the page and per_page query parameters.
+// With sort, they're ordered on that field in the direction given by dir; otherwise they keep
+// GitHub's order, most recently updated first.
+func (s *Server) reposHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid page")
+		return
+	}
+	perPage, err := queryInt(r, "per_page", defaultReposPerPage)
+	if err != nil || perPage > 100 {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid per_page")
+		return
+	}
+
+	field := r.URL.Query().Get("sort")
+	if _, ok := repoSortFields[field]; field != "" && !ok {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid sort, expected stars, name, updated, or pushed")
+		return
+	}
+	desc, ok := repoSortDescending(field, r.URL.Query().Get("dir"))
+	if !ok {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid dir, expected asc or desc")
+		return
+	}
+
+	repos := s.getCachedGitHubRepos(s.config.GitHubUsername)
+	if field != "" {
+		repos = sortRepos(repos, field, desc)
+	}
+
+	start := (page - 1) * perPage
+	if start > len(repos) {
+		start = len(repos)
+	}
+	end := start + perPage
+	if end > len(repos) {
+		end = len(repos)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RepoPage{
+		Repos:   repos[start:end],
+		Page:    page,
+		PerPage: perPage,
+		Total:   len(repos),
+	})
+}
+
+// languageBreakdown counts how many repositories use each language, ignoring repos without one
+func languageBreakdown(repos []GitHubRepo) map[string]int {
+	counts := make(map[string]int)
+	for _, repo := range repos {
+		if repo.Language == "" {
+			continue
+		}
+		counts[repo.Language]++
+	}
+	return counts
+}
+
+// repoLanguagesHandler returns the language breakdown of the cached repos, most used first
+func (s *Server) repoLanguagesHandler(w http.ResponseWriter, r *http.Request) {
+	counts := languageBreakdown(s.getCachedGitHubRepos(s.config.GitHubUsername))
+
+	languages := make([]LanguageCount, 0, len(counts))
+	for language, count := range counts {
+		languages = append(languages, LanguageCount{Language: language, Count: count})
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if languages[i].Count != languages[j].Count {
+			return languages[i].Count > languages[j].Count
+		}
+		return languages[i].Language < languages[j].Language
+	})
+
+	respondJSON(w, http.StatusOK, languages)
+}
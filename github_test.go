@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLanguageBreakdown(t *testing.T) {
+	repos := []GitHubRepo{
+		{Name: "site", Language: "Go"},
+		{Name: "cli", Language: "Go"},
+		{Name: "game", Language: "Rust"},
+		{Name: "dotfiles", Language: ""},
+		{Name: "notes"},
+		{Name: "widget", Language: "TypeScript"},
+		{Name: "api", Language: "Go"},
+	}
+
+	got := languageBreakdown(repos)
+	want := map[string]int{"Go": 3, "Rust": 1, "TypeScript": 1}
+	if !maps.Equal(got, want) {
+		t.Errorf("languageBreakdown = %v, want %v", got, want)
+	}
+	if _, ok := got[""]; ok {
+		t.Error("repos without a language were counted")
+	}
+}
+
+func TestLanguageBreakdownEmpty(t *testing.T) {
+	if got := languageBreakdown(nil); len(got) != 0 {
+		t.Errorf("languageBreakdown(nil) = %v, want no languages", got)
+	}
+}
+
+func TestRepoLanguagesHandlerSortsByCount(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{
+		{Language: "Rust"}, {Language: "Go"}, {Language: "Go"}, {Language: "C"}, {Language: ""},
+	})
+
+	w := httptest.NewRecorder()
+	newTestServer(t).repoLanguagesHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/repos/languages", nil))
+
+	var languages []LanguageCount
+	if err := json.NewDecoder(w.Body).Decode(&languages); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := []LanguageCount{{"Go", 2}, {"C", 1}, {"Rust", 1}}
+	if !slices.Equal(languages, want) {
+		t.Errorf("languages = %v, want %v", languages, want)
+	}
+}
+
+func TestGetGitHubReposUsesAPIBase(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/octocat/repos" || r.URL.Query().Get("per_page") != "100" {
+			t.Errorf("request for %s, want /users/octocat/repos with per_page=100", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"name": "site", "description": "My site", "html_url": "https://github.com/octocat/site", "language": "Go", "stargazers_count": 7},
+			{"name": "notes", "description": null, "html_url": "https://github.com/octocat/notes", "language": null, "stargazers_count": 0}
+		]`))
+	}))
+	t.Cleanup(server.Close)
+	s := &Server{config: Config{GitHubAPIBase: server.URL}}
+
+	repos, err := s.getGitHubRepos("octocat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GitHubRepo{
+		{Name: "site", Description: "My site", HTMLURL: "https://github.com/octocat/site", Language: "Go", StargazersCount: 7},
+		{Name: "notes", HTMLURL: "https://github.com/octocat/notes"},
+	}
+	if !slices.Equal(repos, want) {
+		t.Errorf("repos = %+v, want %+v", repos, want)
+	}
+}
+
+func TestGitHubFallbackWhenFetchFailsWithColdCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	s := &Server{config: Config{GitHubAPIBase: server.URL}}
+	setGitHubRepos(t, nil)
+
+	path := filepath.Join(t.TempDir(), "repos.json")
+	snapshot := `[{"name": "site", "html_url": "https://github.com/octocat/site", "language": "Go", "stargazers_count": 7}]`
+	if err := os.WriteFile(path, []byte(snapshot), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	previousFallback := githubFallbackRepos
+	t.Cleanup(func() { githubFallbackRepos = previousFallback })
+	loadGitHubFallback(path)
+
+	want := []GitHubRepo{{Name: "site", HTMLURL: "https://github.com/octocat/site", Language: "Go", StargazersCount: 7}}
+	if repos := s.getCachedGitHubRepos("octocat"); !slices.Equal(repos, want) {
+		t.Errorf("repos = %+v, want the fallback %+v", repos, want)
+	}
+	if githubCache.repos != nil {
+		t.Errorf("cached repos = %+v, want the fallback left out of the cache", githubCache.repos)
+	}
+
+	page := serveRequest(newTestServer(t).routes(), http.MethodGet, "/", "", "")
+	if !strings.Contains(page.Body.String(), "https://github.com/octocat/site") {
+		t.Error("home page doesn't show the fallback repo")
+	}
+}
+
+func TestReposHandlerSorts(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	setGitHubRepos(t, []GitHubRepo{
+		{Name: "beta", StargazersCount: 5, UpdatedAt: day(3), PushedAt: day(1)},
+		{Name: "Alpha", StargazersCount: 1, UpdatedAt: day(1), PushedAt: day(2)},
+		{Name: "gamma", StargazersCount: 9, UpdatedAt: day(2), PushedAt: day(3)},
+	})
+	h := newTestServer(t).routes()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"beta", "Alpha", "gamma"}},
+		{"sort=stars", []string{"gamma", "beta", "Alpha"}},
+		{"sort=stars&dir=desc", []string{"gamma", "beta", "Alpha"}},
+		{"sort=stars&dir=asc", []string{"Alpha", "beta", "gamma"}},
+		{"sort=name", []string{"Alpha", "beta", "gamma"}},
+		{"sort=name&dir=asc", []string{"Alpha", "beta", "gamma"}},
+		{"sort=name&dir=desc", []string{"gamma", "beta", "Alpha"}},
+		{"sort=updated", []string{"beta", "gamma", "Alpha"}},
+		{"sort=updated&dir=asc", []string{"Alpha", "gamma", "beta"}},
+		{"sort=pushed", []string{"gamma", "Alpha", "beta"}},
+		{"sort=pushed&dir=asc", []string{"beta", "Alpha", "gamma"}},
+	}
+	for _, tt := range tests {
+		w := serveRequest(h, http.MethodGet, "/api/v1/repos?"+tt.query, "", "")
+		if w.Code != http.StatusOK {
+			t.Errorf("%q: status = %d, want %d", tt.query, w.Code, http.StatusOK)
+			continue
+		}
+		var page RepoPage
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		var names []string
+		for _, repo := range page.Repos {
+			names = append(names, repo.Name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%q: repos = %v, want %v", tt.query, names, tt.want)
+		}
+	}
+}
+
+func TestReposHandlerRejectsInvalidSort(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{{Name: "site"}})
+	h := newTestServer(t).routes()
+
+	for _, query := range []string{"sort=description", "sort=StargazersCount", "sort=stars&dir=up", "dir=sideways"} {
+		if w := serveRequest(h, http.MethodGet, "/api/v1/repos?"+query, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// repoNames returns the names of repos, in order
+func repoNames(repos []GitHubRepo) []string {
+	names := []string{}
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	return names
+}
+
+func TestTopReposByStars(t *testing.T) {
+	repos := []GitHubRepo{
+		{Name: "beta", StargazersCount: 5},
+		{Name: "alpha", StargazersCount: 1},
+		{Name: "gamma", StargazersCount: 9},
+		{Name: "delta", StargazersCount: 5},
+	}
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{0, []string{}},
+		{1, []string{"gamma"}},
+		// Ties keep their original order
+		{3, []string{"gamma", "beta", "delta"}},
+		{10, []string{"gamma", "beta", "delta", "alpha"}},
+	}
+	for _, tt := range tests {
+		if got := repoNames(topReposByStars(repos, tt.limit)); !slices.Equal(got, tt.want) {
+			t.Errorf("topReposByStars(limit %d) = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+	if repos[0].Name != "beta" || repos[2].Name != "gamma" {
+		t.Errorf("topReposByStars reordered its input: %v", repoNames(repos))
+	}
+}
+
+func TestHomeShowsMostStarredRepos(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{
+		{Name: "beta", StargazersCount: 5},
+		{Name: "alpha", StargazersCount: 1},
+		{Name: "gamma", StargazersCount: 9},
+	})
+	s := newTestServer(t)
+	s.config.GitHubMaxDisplay = 2
+
+	r := newJSONRequest(http.MethodGet, "/", "")
+	r.Header.Set("Accept", "application/json")
+	w := serve(s.routes(), r)
+	var data PageData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+		t.Fatalf("decoding the home page data: %v", err)
+	}
+	if got, want := repoNames(data.GitHubRepos), []string{"gamma", "beta"}; !slices.Equal(got, want) {
+		t.Errorf("home page repos = %v, want the %d most starred %v", got, s.config.GitHubMaxDisplay, want)
+	}
+}
+
+func TestReposHandlerPaginates(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}})
+	h := newTestServer(t).routes()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"per_page=2", []string{"a", "b"}},
+		{"page=2&per_page=2", []string{"c", "d"}},
+		{"page=3&per_page=2", []string{"e"}},
+		{"page=4&per_page=2", []string{}},
+		{"per_page=100", []string{"a", "b", "c", "d", "e"}},
+	}
+	for _, tt := range tests {
+		w := serveRequest(h, http.MethodGet, "/api/v1/repos?"+tt.query, "", "")
+		var page RepoPage
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("%q: decoding response: %v", tt.query, err)
+		}
+		if got := repoNames(page.Repos); !slices.Equal(got, tt.want) || page.Total != 5 {
+			t.Errorf("%q: repos = %v of %d, want %v of 5", tt.query, got, page.Total, tt.want)
+		}
+	}
+
+	for _, query := range []string{"page=0", "page=-1", "page=x", "per_page=0", "per_page=101"} {
+		if w := serveRequest(h, http.MethodGet, "/api/v1/repos?"+query, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
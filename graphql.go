@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// graphqlDefaultLimit is the page size used by the quotes query when none is given
+const graphqlDefaultLimit = 20
+
+// graphqlRequestKey is the context key holding the HTTP request a GraphQL operation came in, which
+// its resolvers rate limit by
+type graphqlRequestKey struct{}
+
+// errGraphQLRateLimited is returned by a mutation the client has used up its budget for
+var errGraphQLRateLimited = errors.New("rate limit exceeded, please try again later")
+
+// graphqlRequest represents a GraphQL request body
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+var counterType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Counter",
+	Fields: graphql.Fields{
+		"id":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"count": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var quoteType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Quote",
+	Fields: graphql.Fields{
+		"name":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"quote":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"tags":      &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+		"source":    &graphql.Field{Type: graphql.String},
+		"timestamp": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+	},
+})
+
+var quotePageType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "QuotePage",
+	Fields: graphql.Fields{
+		"quotes": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(quoteType)))},
+		"page":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"limit":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"total":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+// newGraphQLSchema builds the schema for counter and quote queries and mutations
+func (s *Server) newGraphQLSchema() (graphql.Schema, error) {
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"counter": &graphql.Field{
+				Type: counterType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: s.resolveCounter,
+			},
+			"counters": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(counterType)),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					counters, err := s.listCounters(p.Context, "")
+					return s.config.Counting.visibleCounters(counters), err
+				},
+			},
+			"quotes": &graphql.Field{
+				Type: quotePageType,
+				Args: graphql.FieldConfigArgument{
+					"limit": &graphql.ArgumentConfig{Type: graphql.Int},
+					"page":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"tag":   &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: s.resolveQuotes,
+			},
+			"dailyQuote": &graphql.Field{
+				Type:    quoteType,
+				Resolve: s.resolveDailyQuote,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"incrementCounter": &graphql.Field{
+				Type: counterType,
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"delta": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: s.resolveIncrementCounter,
+			},
+			"submitQuote": &graphql.Field{
+				Type: quoteType,
+				Args: graphql.FieldConfigArgument{
+					"name":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"quote":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"tags":   &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+					"source": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: s.resolveSubmitQuote,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// resolveCounter returns a counter by name, or null if it doesn't exist
+func (s *Server) resolveCounter(p graphql.ResolveParams) (any, error) {
+	id, err := normalizeCounterName(p.Args["id"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	counter, err := s.getCounter(p.Context, id)
+	if errors.Is(err, mongo.ErrNoDocuments) || s.config.Counting.hides(id) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return counter, nil
+}
+
+// resolveQuotes returns a page of quotes, optionally filtered by tag
+func (s *Server) resolveQuotes(p graphql.ResolveParams) (any, error) {
+	limit, _ := p.Args["limit"].(int)
+	if limit <= 0 {
+		limit = graphqlDefaultLimit
+	}
+	if limit > 100 {
+		return nil, errors.New("limit must be at most 100")
+	}
+
+	page, _ := p.Args["page"].(int)
+	if page <= 0 {
+		page = 1
+	}
+
+	tag, _ := p.Args["tag"].(string)
+	return s.listQuotes(p.Context, page, limit, tag)
+}
+
+// resolveDailyQuote returns the quote of the day, or null if there are no quotes
+func (s *Server) resolveDailyQuote(p graphql.ResolveParams) (any, error) {
+	quote, err := s.getDailyQuote(p.Context)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// allowGraphQL reports whether the client making a GraphQL operation is within the budget for
+// scope that the HTTP route doing the same thing has, so /graphql isn't a way around it
+func (s *Server) allowGraphQL(ctx context.Context, scope string, requestsPerMinute, burst int) bool {
+	r := ctx.Value(graphqlRequestKey{}).(*http.Request)
+	key, requestsPerMinute, burst := s.rateLimitKey(r, requestsPerMinute, burst)
+	if !s.allowRequest(r, scope, key, requestsPerMinute, burst) {
+		rateLimitRejections.WithLabelValues(rateLimitRoute(r)).Inc()
+		return false
+	}
+	return true
+}
+
+// resolveIncrementCounter adds delta (default 1) to a named counter, sharing the rate limit of
+// the named counter endpoints
+func (s *Server) resolveIncrementCounter(p graphql.ResolveParams) (any, error) {
+	if s.isReadOnly() {
+		return nil, errReadOnlyMode
+	}
+	if !s.allowGraphQL(p.Context, "counters", s.config.CounterRateLimitRPM, s.config.CounterRateLimitRPM) {
+		return nil, errGraphQLRateLimited
+	}
+	id, err := normalizeCounterName(p.Args["id"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	delta := 1
+	if d, ok := p.Args["delta"].(int); ok {
+		delta = d
+	}
+	if err := validateCounterDelta(delta); err != nil {
+		return nil, err
+	}
+
+	counter, err := s.adjustCounter(p.Context, id, delta)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.New("counter not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.recordCounterEvent(p.Context, id, delta)
+	return counter, nil
+}
+
+// resolveSubmitQuote saves a new quote, sharing the rate limit of the quote form
+func (s *Server) resolveSubmitQuote(p graphql.ResolveParams) (any, error) {
+	if s.isReadOnly() {
+		return nil, errReadOnlyMode
+	}
+	if !s.allowGraphQL(p.Context, "", s.config.RateLimitRPM, s.config.RateLimitBurst) {
+		return nil, errGraphQLRateLimited
+	}
+
+	var tags []string
+	if rawTags, ok := p.Args["tags"].([]any); ok {
+		for _, tag := range rawTags {
+			if t, ok := tag.(string); ok {
+				tags = append(tags, t)
+			}
+		}
+	}
+
+	source, _ := p.Args["source"].(string)
+	quote, err := newQuote(p.Args["name"].(string), p.Args["quote"].(string), source, tags)
+	if err != nil {
+		return nil, err
+	}
+	quote.AuthorIPHash, _ = p.Context.Value(authorIPHashKey{}).(string)
+
+	if err := s.insertQuote(p.Context, quote); err != nil {
+		s.log(p.Context, "quotes").Error("saving quote from GraphQL", "err", err)
+		return nil, errors.New("error saving quote")
+	}
+	return quote, nil
+}
+
+// graphqlHandler executes GraphQL queries and mutations
+func (s *Server) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid GraphQL request")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlRequestKey{}, r)
+	ctx = context.WithValue(ctx, authorIPHashKey{}, s.quoteAuthorIPHash(s.getIPAddress(r)))
+	result := graphql.Do(graphql.Params{
+		Schema:         s.graphqlSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// graphiqlHandler serves the GraphiQL playground
+func (s *Server) graphiqlHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.renderTemplate(w, "graphiql.html", nil)
+	if err != nil {
+		s.log(r.Context(), "graphql").Error("rendering GraphiQL page", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error rendering page")
+	}
+}
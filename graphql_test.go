@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// graphqlResponse is the body of a GraphQL response
+type graphqlResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// doGraphQL posts a GraphQL query to h from remoteAddr and decodes the response
+func doGraphQL(t *testing.T, h http.Handler, query, remoteAddr string) graphqlResponse {
+	t.Helper()
+	body, err := json.Marshal(graphqlRequest{Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serveRequest(h, http.MethodPost, "/graphql", string(body), remoteAddr)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /graphql status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp graphqlResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// field decodes a field of a successful response into v
+func (resp graphqlResponse) field(t *testing.T, name string, v any) {
+	t.Helper()
+	if len(resp.Errors) > 0 {
+		t.Fatalf("GraphQL errors: %+v", resp.Errors)
+	}
+	if err := json.Unmarshal(resp.Data[name], v); err != nil {
+		t.Fatalf("decoding %s: %v", name, err)
+	}
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.counters.InitCounters(ctx, "likes")
+	s.counters.IncrementCounter(ctx, "likes")
+	for _, text := range []string{"First", "Second", "Third"} {
+		quote, _ := newQuote("Ada", text, "", []string{"go"})
+		if err := s.quotes.InsertQuote(ctx, quote); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.routes()
+
+	var counter Counter
+	doGraphQL(t, h, `{ counter(id: "Likes") { id count } }`, "").field(t, "counter", &counter)
+	if counter.ID != "likes" || counter.Count != 1 {
+		t.Errorf("counter = %+v, want likes at 1", counter)
+	}
+	if resp := doGraphQL(t, h, `{ counter(id: "missing") { id } }`, ""); string(resp.Data["counter"]) != "null" {
+		t.Errorf("missing counter = %s, want null", resp.Data["counter"])
+	}
+
+	var page struct {
+		Quotes []Quote `json:"quotes"`
+		Total  int     `json:"total"`
+		Limit  int     `json:"limit"`
+	}
+	doGraphQL(t, h, `{ quotes(limit: 2, tag: "go") { quotes { name quote } total limit } }`, "").field(t, "quotes", &page)
+	if len(page.Quotes) != 2 || page.Total != 3 || page.Limit != 2 {
+		t.Errorf("quotes page = %+v, want 2 of 3 quotes", page)
+	}
+	if resp := doGraphQL(t, h, `{ quotes(limit: 101) { total } }`, ""); len(resp.Errors) == 0 {
+		t.Error("quotes with a limit over 100 succeeded")
+	}
+}
+
+func TestGraphQLIncrementCounter(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	s.counters.InitCounters(context.Background(), "likes")
+	h := s.routes()
+
+	var counter Counter
+	doGraphQL(t, h, `mutation { incrementCounter(id: "likes", delta: 2) { id count } }`, "").field(t, "incrementCounter", &counter)
+	if counter.ID != "likes" || counter.Count != 2 {
+		t.Errorf("incremented counter = %+v, want likes at 2", counter)
+	}
+	for _, query := range []string{
+		`mutation { incrementCounter(id: "missing") { count } }`,
+		`mutation { incrementCounter(id: "webhook") { count } }`,
+	} {
+		if resp := doGraphQL(t, h, query, ""); len(resp.Errors) == 0 {
+			t.Errorf("%s succeeded, want an error", query)
+		}
+	}
+}
+
+func TestGraphQLIncrementCounterIsRateLimited(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	s.config.CounterRateLimitRPM = 2
+	s.counters.InitCounters(context.Background(), "likes")
+	h := s.routes()
+	const client, other = "203.0.113.7:1000", "203.0.113.8:1000"
+	const increment = `mutation { incrementCounter(id: "likes") { count } }`
+
+	// The mutation and the HTTP endpoint share a budget
+	if resp := doGraphQL(t, h, increment, client); len(resp.Errors) > 0 {
+		t.Fatalf("first increment: %+v", resp.Errors)
+	}
+	if w := serveRequest(h, http.MethodPost, "/api/v1/counters/likes/increment", "", client); w.Code != http.StatusOK {
+		t.Fatalf("HTTP increment status = %d, want %d", w.Code, http.StatusOK)
+	}
+	resp := doGraphQL(t, h, increment, client)
+	if len(resp.Errors) != 1 || resp.Errors[0].Message != errGraphQLRateLimited.Error() {
+		t.Errorf("increment over the limit = %+v, want the rate limit error", resp.Errors)
+	}
+
+	if resp := doGraphQL(t, h, increment, other); len(resp.Errors) > 0 {
+		t.Errorf("another client's increment: %+v", resp.Errors)
+	}
+	counter, err := s.counters.GetCounter(context.Background(), "likes")
+	if err != nil || counter.Count != 3 {
+		t.Errorf("likes = %+v, %v; want 3 increments counted", counter, err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	// readinessPingTimeout bounds the MongoDB ping made by the readiness check
+	readinessPingTimeout = 2 * time.Second
+	// readinessCacheTTL is how long a readiness result is reused, so a burst of probes
+	// doesn't turn into a burst of pings
+	readinessCacheTTL = 2 * time.Second
+	// mongoHealthInterval is how often the background monitor pings MongoDB
+	mongoHealthInterval = 10 * time.Second
+)
+
+// startedAt is when the process started, for reporting uptime
+var startedAt = time.Now()
+
+// HealthStatus represents the response from the liveness endpoint
+type HealthStatus struct {
+	Status  string  `json:"status"`
+	Uptime  float64 `json:"uptime"`
+	Version string  `json:"version"`
+	Commit  string  `json:"commit,omitempty"`
+	// ReadOnly is whether read-only mode is refusing writes, which doesn't affect readiness
+	ReadOnly bool `json:"readOnly"`
+}
+
+// ReadinessStatus represents the response from the readiness endpoint
+type ReadinessStatus struct {
+	Status    string            `json:"status"`
+	Checks    map[string]string `json:"checks"`
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit,omitempty"`
+	ReadOnly  bool              `json:"readOnly"`
+	CheckedAt time.Time         `json:"checkedAt"`
+}
+
+// Ready reports whether the site can serve requests: every dependency check passed, or the site
+// is degraded to read-only but still serving pages
+func (s ReadinessStatus) Ready() bool {
+	return s.Status == "ok" || s.Status == "degraded"
+}
+
+var (
+	readinessMu     sync.Mutex
+	readinessCached *ReadinessStatus
+)
+
+// mongoHealth is the state kept by the background MongoDB health monitor
+type mongoHealth struct {
+	// monitored is set once the monitor is running, after which readiness uses its result
+	monitored atomic.Bool
+	// down is set while the monitor's last ping failed
+	down atomic.Bool
+}
+
+// checkReadiness checks each dependency the site needs to serve requests
+func (s *Server) checkReadiness(ctx context.Context) ReadinessStatus {
+	build := currentBuildInfo()
+	status := ReadinessStatus{
+		Status:    "ok",
+		Checks:    map[string]string{"mongo": "ok", "templates": "ok"},
+		Version:   build.Version,
+		Commit:    build.Commit,
+		ReadOnly:  s.isReadOnly(),
+		CheckedAt: time.Now(),
+	}
+
+	if s.mongoHealth.monitored.Load() {
+		if s.mongoHealth.down.Load() {
+			status.Checks["mongo"] = "unavailable"
+			status.Status = "unavailable"
+		}
+	} else if err := s.pingMongo(ctx); err != nil {
+		s.log(ctx, "health").Warn("readiness check: MongoDB ping failed", "err", err)
+		status.Checks["mongo"] = "unavailable"
+		status.Status = "unavailable"
+	}
+
+	// While the circuit breaker isn't closed pages are served from a snapshot and writes are
+	// refused, which is degraded but still ready
+	if s.breaker != nil {
+		if state := s.breaker.State(); state != breakerClosed {
+			status.Checks["storage"] = "circuit " + state.String()
+			if status.Status == "ok" {
+				status.Status = "degraded"
+			}
+		}
+	}
+
+	if s.templates == nil {
+		status.Checks["templates"] = "not parsed"
+		status.Status = "unavailable"
+	}
+
+	return status
+}
+
+// pingMongo pings the MongoDB primary, giving up after readinessPingTimeout
+func (s *Server) pingMongo(ctx context.Context) error {
+	pingCtx, cancel := context.WithTimeout(ctx, readinessPingTimeout)
+	defer cancel()
+	return s.client.Ping(pingCtx, readpref.Primary())
+}
+
+// startMongoHealthMonitor pings MongoDB every mongoHealthInterval until ctx is cancelled. While
+// pings fail the readiness check reports MongoDB unavailable, so the proxy stops routing here
+// until the connection recovers rather than the process crashing.
+func (s *Server) startMongoHealthMonitor(ctx context.Context) {
+	s.mongoHealth.monitored.Store(true)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(mongoHealthInterval):
+			}
+			s.checkMongoHealth(ctx)
+		}
+	}()
+}
+
+// checkMongoHealth pings MongoDB and records the result, logging when it goes down or recovers
+func (s *Server) checkMongoHealth(ctx context.Context) {
+	err := s.pingMongo(ctx)
+	wasDown := s.mongoHealth.down.Swap(err != nil)
+	switch {
+	case err != nil && !wasDown:
+		s.log(ctx, "health").Warn("MongoDB ping failed, reporting not ready", "err", err)
+	case err == nil && wasDown:
+		s.log(ctx, "health").Info("MongoDB reachable again, reporting ready")
+	}
+}
+
+// cachedReadiness returns the last readiness result if it is recent enough, or checks again.
+// Concurrent probes wait for a single check rather than each pinging MongoDB.
+func (s *Server) cachedReadiness(ctx context.Context) ReadinessStatus {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+
+	if readinessCached != nil && time.Since(readinessCached.CheckedAt) < readinessCacheTTL {
+		return *readinessCached
+	}
+
+	status := s.checkReadiness(ctx)
+	readinessCached = &status
+	return status
+}
+
+// healthzHandler reports that the process is up without touching any dependency
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	build := currentBuildInfo()
+	respondJSON(w, http.StatusOK, HealthStatus{
+		Status:   "ok",
+		Uptime:   time.Since(startedAt).Seconds(),
+		Version:  build.Version,
+		Commit:   build.Commit,
+		ReadOnly: s.isReadOnly(),
+	})
+}
+
+// readyzHandler reports whether MongoDB and the templates are available, with 503 if not
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	status := s.cachedReadiness(r.Context())
+	if !status.Ready() {
+		respondJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	respondJSON(w, http.StatusOK, status)
+}
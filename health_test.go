@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// resetReadiness forgets the cached readiness result now and when the test ends
+func resetReadiness(t *testing.T) {
+	t.Helper()
+	clear := func() {
+		readinessMu.Lock()
+		readinessCached = nil
+		readinessMu.Unlock()
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+// newHealthTestServer returns a test server whose MongoDB health comes from the background
+// monitor, reported down if mongoDown
+func newHealthTestServer(t *testing.T, mongoDown bool) *Server {
+	t.Helper()
+	resetReadiness(t)
+	s := newTestServer(t)
+	s.mongoHealth.monitored.Store(true)
+	s.mongoHealth.down.Store(mongoDown)
+	return s
+}
+
+// getReadiness requests /readyz from h
+func getReadiness(t *testing.T, h http.Handler) (int, ReadinessStatus) {
+	t.Helper()
+	w := serveRequest(h, http.MethodGet, "/readyz", "", "")
+	var status ReadinessStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	return w.Code, status
+}
+
+func TestHealthzDoesNotCheckDependencies(t *testing.T) {
+	s := newHealthTestServer(t, true)
+	s.setMaintenance(MaintenanceStatus{Enabled: true})
+
+	w := serveRequest(s.handler(), http.MethodGet, "/healthz", "", "")
+	var health HealthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if w.Code != http.StatusOK || health.Status != "ok" || health.Uptime <= 0 {
+		t.Errorf("GET /healthz with MongoDB down during maintenance = %d %+v, want 200 ok", w.Code, health)
+	}
+}
+
+func TestReadyzReportsDependencies(t *testing.T) {
+	openBreaker := newCircuitBreaker(1, time.Hour)
+	openBreaker.allow()
+	openBreaker.record(errConnectionRefused)
+
+	tests := []struct {
+		name       string
+		mongoDown  bool
+		breaker    *circuitBreaker
+		noTemplate bool
+		wantCode   int
+		wantStatus string
+		check      string // the check that failed, if any
+		wantCheck  string
+	}{
+		{name: "ready", wantCode: http.StatusOK, wantStatus: "ok", check: "mongo", wantCheck: "ok"},
+		{name: "mongo down", mongoDown: true, wantCode: http.StatusServiceUnavailable, wantStatus: "unavailable", check: "mongo", wantCheck: "unavailable"},
+		{name: "breaker open", breaker: openBreaker, wantCode: http.StatusOK, wantStatus: "degraded", check: "storage", wantCheck: "circuit open"},
+		{name: "no templates", noTemplate: true, wantCode: http.StatusServiceUnavailable, wantStatus: "unavailable", check: "templates", wantCheck: "not parsed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newHealthTestServer(t, tt.mongoDown)
+			s.breaker = tt.breaker
+			if tt.noTemplate {
+				s.templates = nil
+			}
+			code, status := getReadiness(t, s.routes())
+			if code != tt.wantCode || status.Status != tt.wantStatus || status.Checks[tt.check] != tt.wantCheck {
+				t.Errorf("GET /readyz = %d %+v, want %d %s with %s %q", code, status, tt.wantCode, tt.wantStatus, tt.check, tt.wantCheck)
+			}
+		})
+	}
+}
+
+func TestReadyzReusesRecentResult(t *testing.T) {
+	s := newHealthTestServer(t, false)
+	h := s.routes()
+	if code, _ := getReadiness(t, h); code != http.StatusOK {
+		t.Fatalf("GET /readyz = %d, want %d", code, http.StatusOK)
+	}
+
+	// A probe right after MongoDB goes down gets the cached result, and one after it expires doesn't
+	s.mongoHealth.down.Store(true)
+	if code, _ := getReadiness(t, h); code != http.StatusOK {
+		t.Errorf("GET /readyz within the cache TTL = %d, want the cached %d", code, http.StatusOK)
+	}
+	resetReadiness(t)
+	if code, _ := getReadiness(t, h); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz after the cache expired = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
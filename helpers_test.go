@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newJSONRequest returns a request that accepts JSON, with body sent as JSON when it isn't empty
+func newJSONRequest(method, target, body string) *http.Request {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+// serve sends r to h and returns the recorded response
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// serveRequest sends a JSON request to h from remoteAddr and returns the recorded response
+func serveRequest(h http.Handler, method, target, body, remoteAddr string) *httptest.ResponseRecorder {
+	r := newJSONRequest(method, target, body)
+	if remoteAddr != "" {
+		r.RemoteAddr = remoteAddr
+	}
+	return serve(h, r)
+}
+
+// resetRateLimiters forgets the in-memory rate limiters now and when the test ends, so tests
+// don't use up each other's budgets
+func resetRateLimiters(t testing.TB) {
+	t.Helper()
+	clear := func() {
+		mu.Lock()
+		limiters = make(map[string]*memoryLimiter)
+		mu.Unlock()
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+// newTestServer returns a server keeping quotes, counters, page views, and sessions in memory, without a database,
+// whose hub runs until the test ends
+func newTestServer(t testing.TB) *Server {
+	t.Helper()
+	return newTestServerWithConfig(t, defaultConfig())
+}
+
+// newTestServerWithConfig is newTestServer with the given settings
+func newTestServerWithConfig(t testing.TB, config Config) *Server {
+	t.Helper()
+	s, err := newServer(config, nil, nil)
+	if err != nil {
+		t.Fatalf("creating the server: %v", err)
+	}
+	store := newMemoryStore()
+	s.quotes, s.counters, s.pageViews, s.sessions, s.messages = store, store, store, store, store
+	s.audit = NewAuditLog(store, config.MongoWriteTimeout)
+	go s.hub.Run()
+	t.Cleanup(func() { s.hub.Shutdown(context.Background()) })
+	return s
+}
+
+// newMongoTestServer returns a server using a fresh database on the MongoDB at MONGO_TEST_URI,
+// dropped when the test ends. The test is skipped if MONGO_TEST_URI is unset.
+func newMongoTestServer(t testing.TB) *Server {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("pinging MongoDB: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("personal_website_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	s, err := newServer(defaultConfig(), client, db)
+	if err != nil {
+		t.Fatalf("creating the server: %v", err)
+	}
+	go s.hub.Run()
+	t.Cleanup(func() { s.hub.Shutdown(context.Background()) })
+	return s
+}
+
+// captureLogs sends the server's and its hub's logs, at every level, to the returned function,
+// which decodes the records written so far
+func captureLogs(t *testing.T, s *Server) func() []map[string]any {
+	t.Helper()
+	var (
+		mu  sync.Mutex
+		buf bytes.Buffer
+	)
+	l := newLogger(lockedWriter{&mu, &buf}, "json", slog.LevelDebug)
+	s.logger = l
+	s.hub.logger = l.With("component", "hub")
+
+	return func() []map[string]any {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		var records []map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for decoder.More() {
+			var record map[string]any
+			if err := decoder.Decode(&record); err != nil {
+				t.Fatalf("decoding log record: %v", err)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+// lockedWriter serializes writes from loggers used on several goroutines
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// setGitHubRepos fills the GitHub repo cache for the rest of the test, so nothing is fetched
+func setGitHubRepos(t testing.TB, repos []GitHubRepo) {
+	t.Helper()
+	githubCache.mu.Lock()
+	previous, previousFetchedAt := githubCache.repos, githubCache.fetchedAt
+	githubCache.repos, githubCache.fetchedAt = repos, time.Now()
+	githubCache.mu.Unlock()
+	t.Cleanup(func() {
+		githubCache.mu.Lock()
+		githubCache.repos, githubCache.fetchedAt = previous, previousFetchedAt
+		githubCache.mu.Unlock()
+	})
+}
+
+// countOf returns the count n points to, or -1 if the count was left out
+func countOf(n *int) int {
+	if n == nil {
+		return -1
+	}
+	return *n
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// homeCacheTTL is how long a rendered home page is served before it's rebuilt, unless a quote
+// or counter changes first
+const homeCacheTTL = 3 * time.Second
+
+// signedInCookie is reserved for signed-in visitors. Their home page may differ from everyone
+// else's, so it's never served from or stored in the cache. Anonymous visitors' session_id
+// cookie doesn't change the page, so they still share it.
+const signedInCookie = "session"
+
+// homePageCache holds the most recently rendered home page in each theme for anonymous
+// visitors, so a burst of traffic is served from memory instead of querying the database and
+// executing the template for every request
+type homePageCache struct {
+	mu    sync.Mutex
+	pages map[string]cachedHomePage // by theme
+	// generation counts invalidations, so a page built from data read before one isn't stored
+	generation uint64
+	// clock tells the time, or the system clock when nil
+	clock clock
+}
+
+// cachedHomePage is a rendered home page and when it was rendered
+type cachedHomePage struct {
+	html    []byte
+	builtAt time.Time
+}
+
+func (c *homePageCache) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// get returns the cached page in theme if it was rendered within homeCacheTTL, along with the
+// generation a page rendered now should be stored under
+func (c *homePageCache) get(theme string) (html []byte, generation uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if page, ok := c.pages[theme]; ok && c.now().Sub(page.builtAt) < homeCacheTTL {
+		return page.html, c.generation, true
+	}
+	return nil, c.generation, false
+}
+
+// put stores a page rendered in theme, unless the cache was invalidated since generation was read
+func (c *homePageCache) put(theme string, html []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if c.pages == nil {
+		c.pages = make(map[string]cachedHomePage)
+	}
+	c.pages[theme] = cachedHomePage{html: html, builtAt: c.now()}
+}
+
+// invalidate drops the cached pages after a change to what the home page shows
+func (c *homePageCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.pages)
+	c.generation++
+}
+
+// homeCacheable reports whether r may be answered with the shared home page
+func homeCacheable(r *http.Request) bool {
+	_, err := r.Cookie(signedInCookie)
+	return err != nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// homeShowsQuote reports whether the home page served by s includes text
+func homeShowsQuote(t *testing.T, s *Server, text string, cookies ...*http.Cookie) bool {
+	t.Helper()
+	r := newJSONRequest(http.MethodGet, "/", "")
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := serve(s.routes(), r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	return strings.Contains(w.Body.String(), text)
+}
+
+// newHomeCacheServer returns a test server with the counters the home page shows, so its pages
+// are complete enough to cache
+func newHomeCacheServer(t *testing.T) *Server {
+	t.Helper()
+	setGitHubRepos(t, nil)
+	s := newTestServer(t)
+	if err := s.counters.InitCounters(t.Context(), "webhook", "totalClicks"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestHomeCacheExpires(t *testing.T) {
+	s := newHomeCacheServer(t)
+	clk := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	s.home.clock = clk
+
+	homeShowsQuote(t, s, "")
+	// Written straight to the store, so nothing invalidates the cached page
+	if err := s.quotes.InsertQuote(t.Context(), Quote{Name: "Ada", Quote: "Fresh off the press", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.set(clk.Now().Add(homeCacheTTL - time.Millisecond))
+	if homeShowsQuote(t, s, "Fresh off the press") {
+		t.Error("home page within the TTL shows a quote added after it was cached")
+	}
+	if !homeShowsQuote(t, s, "Fresh off the press", &http.Cookie{Name: signedInCookie, Value: "x"}) {
+		t.Error("home page with a session cookie was served from the cache")
+	}
+
+	clk.set(clk.Now().Add(time.Millisecond))
+	if !homeShowsQuote(t, s, "Fresh off the press") {
+		t.Error("home page after the TTL doesn't show the new quote")
+	}
+}
+
+func TestHomeCacheInvalidatedByNewQuote(t *testing.T) {
+	s := newHomeCacheServer(t)
+	s.home.clock = newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+
+	homeShowsQuote(t, s, "")
+	if err := s.insertQuote(t.Context(), Quote{Name: "Ada", Quote: "Straight to the top", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if !homeShowsQuote(t, s, "Straight to the top") {
+		t.Error("home page doesn't show a quote submitted after it was cached")
+	}
+}
+
+func TestHomeCacheHitsCountPageViews(t *testing.T) {
+	s := newHomeCacheServer(t)
+	s.config.Counting = CounterSettings{PageViews: true}
+	h := s.routes()
+
+	for _, addr := range []string{"192.0.2.81:1000", "192.0.2.82:1000"} {
+		if w := serveRequest(h, http.MethodGet, "/", "", addr); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+	if _, _, fresh := s.home.get(themeAuto); !fresh {
+		t.Fatal("home page wasn't cached")
+	}
+	total, err := s.pageViews.TotalPageViews(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("page views after a miss and a hit = %d, want 2", total)
+	}
+}
+
+func TestHomeCacheDropsPageBuiltBeforeInvalidation(t *testing.T) {
+	var c homePageCache
+	_, generation, _ := c.get(themeLight)
+	c.invalidate()
+	c.put(themeLight, []byte("stale"), generation)
+	if _, _, fresh := c.get(themeLight); fresh {
+		t.Error("page rendered before an invalidation was cached")
+	}
+}
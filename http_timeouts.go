@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newHTTPServer returns a server for handler listening on config's port, with its HTTP time
+// limits. Explicit timeouts keep slow or idle clients from
+// holding connections open forever, except for streamed responses, which streamingMiddleware
+// exempts from the write timeout.
+func newHTTPServer(config Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + config.Port,
+		Handler:           streamingMiddleware(handler),
+		ReadHeaderTimeout: config.HTTPReadHeaderTimeout,
+		ReadTimeout:       config.HTTPReadTimeout,
+		WriteTimeout:      config.HTTPWriteTimeout,
+		IdleTimeout:       config.HTTPIdleTimeout,
+	}
+}
+
+// streamingMiddleware lifts the write timeout for responses that stay open as long as the client
+// is connected. The write timeout is a deadline on the whole response counted from when the
+// request was read, not a limit on each write, so left in place it would cut every event stream
+// and WebSocket off after a few seconds, however actively they were being written to.
+//
+// WebSocket upgrades are passed straight through, since the upgrader clears the connection's
+// deadlines itself once it hijacks it. Any other response has its deadline cleared when the
+// handler sends a text/event-stream Content-Type, so handlers don't each need to remember to.
+func streamingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&streamingWriter{ResponseWriter: w}, r)
+	})
+}
+
+// streamingWriter clears the write deadline when the response turns out to be an event stream
+type streamingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (s *streamingWriter) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		if strings.HasPrefix(s.Header().Get("Content-Type"), "text/event-stream") {
+			// Writers that can't have deadlines, like httptest's, have none to clear
+			http.NewResponseController(s.ResponseWriter).SetWriteDeadline(time.Time{})
+		}
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *streamingWriter) Write(b []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush sends the headers first if they haven't been, so an event stream flushed before anything
+// is written still has its deadline cleared
+func (s *streamingWriter) Flush() {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (s *streamingWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
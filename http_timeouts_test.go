@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSlowClientsAreCutOff sends part of a request and then stalls, as a slowloris client
+// would, and checks that the server gives up on the connection rather than waiting forever
+func TestSlowClientsAreCutOff(t *testing.T) {
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newHTTPServer(defaultConfig(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	// Shorten the production timeouts so the test doesn't wait on them
+	server.Config.ReadHeaderTimeout = 100 * time.Millisecond
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name    string
+		partial string
+	}{
+		{"headers", "POST / HTTP/1.1\r\nHost: example.com\r\n"},
+		{"body", "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 100\r\n\r\nname=Ada"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := io.WriteString(conn, tt.partial); err != nil {
+				t.Fatal(err)
+			}
+
+			// The server may answer with an error before closing, but it mustn't hold on
+			start := time.Now()
+			conn.SetReadDeadline(start.Add(5 * time.Second))
+			if _, err := io.Copy(io.Discard, bufio.NewReader(conn)); err != nil {
+				t.Fatalf("connection still open after %s: %v", time.Since(start), err)
+			}
+		})
+	}
+}
+
+// TestEventStreamsOutliveWriteTimeout checks that a response sent as text/event-stream keeps
+// going past the write timeout, which cuts off an ordinary response that takes as long
+func TestEventStreamsOutliveWriteTimeout(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+	respond := func(contentType string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			rc := http.NewResponseController(w)
+			io.WriteString(w, "data: first\n\n")
+			rc.Flush()
+			time.Sleep(3 * writeTimeout)
+			io.WriteString(w, "data: second\n\n")
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/events", respond("text/event-stream"))
+	mux.Handle("/slow", respond("text/plain"))
+
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newHTTPServer(defaultConfig(), mux)
+	server.Config.WriteTimeout = writeTimeout
+	server.Start()
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		path     string
+		complete bool
+	}{
+		{"/events", true},
+		{"/slow", false},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if complete := strings.Contains(string(body), "second"); complete != tt.complete {
+			t.Errorf("GET %s got %q, complete = %v, want %v", tt.path, body, complete, tt.complete)
+		}
+	}
+}
+
+func TestHTTPServerUsesConfiguredTimeouts(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("HTTP_READ_HEADER_TIMEOUT_MS", "1500")
+	t.Setenv("HTTP_READ_TIMEOUT_SECONDS", "4")
+	t.Setenv("HTTP_WRITE_TIMEOUT_SECONDS", "8")
+	t.Setenv("HTTP_IDLE_TIMEOUT_SECONDS", "90")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newHTTPServer(cfg, http.NotFoundHandler())
+	if server.Addr != ":8080" {
+		t.Errorf("Addr = %q, want :8080", server.Addr)
+	}
+	got := []time.Duration{server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.IdleTimeout}
+	want := []time.Duration{1500 * time.Millisecond, 4 * time.Second, 8 * time.Second, 90 * time.Second}
+	if !slices.Equal(got, want) {
+		t.Errorf("read header, read, write, and idle timeouts = %v, want %v", got, want)
+	}
+}
+
+// TestWebSocketsOutliveWriteTimeout checks that a WebSocket connection still gets updates after
+// the write timeout has passed
+func TestWebSocketsOutliveWriteTimeout(t *testing.T) {
+	const writeTimeout = 100 * time.Millisecond
+	config := defaultConfig()
+	config.HTTPWriteTimeout = writeTimeout
+	s := newTestServerWithConfig(t, config)
+
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newHTTPServer(config, s.routes())
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client := dialHub(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws")
+	if _, err := client.NextUpdate(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * writeTimeout)
+
+	s.hub.Broadcast(CounterUpdate{Count: optionalCount(true, 42)})
+	update, err := client.NextUpdate(time.Second)
+	if err != nil || countOf(update.Count) != 42 {
+		t.Errorf("update after the write timeout = %+v, %v; want count 42", update, err)
+	}
+}
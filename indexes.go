@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoIndex is an index created at startup. Failing to create an optional index is logged
+// instead of stopping the server, for indexes that only speed things up or that some
+// deployments don't support.
+type mongoIndex struct {
+	collection string
+	keys       bson.D
+	options    *options.IndexOptions
+	optional   bool
+}
+
+// name returns the index's name, which is what MongoDB would call it by default
+func (i mongoIndex) name() string {
+	parts := make([]string, 0, len(i.keys))
+	for _, key := range i.keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// startupIndexes returns the indexes the configured features need
+func startupIndexes(config Config) []mongoIndex {
+	indexes := []mongoIndex{
+		// Quote pages and the home page sort by newest first
+		{collection: config.Collections.Quotes, keys: bson.D{{Key: "timestamp", Value: -1}}},
+		// Full-text search over quotes, which not every deployment supports
+		{
+			collection: config.Collections.Quotes,
+			keys:       bson.D{{Key: "quote", Value: "text"}, {Key: "name", Value: "text"}},
+			optional:   true,
+		},
+		// Grouping quotes by the hash of their author's IP address, which older quotes don't have
+		{
+			collection: config.Collections.Quotes,
+			keys:       bson.D{{Key: "authorIPHash", Value: 1}},
+			options:    options.Index().SetSparse(true),
+		},
+		// Listing contact messages newest first
+		{collection: config.Collections.Messages, keys: bson.D{{Key: "createdAt", Value: -1}}},
+		// Listing the counters in a namespace
+		{collection: config.Collections.Counters, keys: bson.D{{Key: "namespace", Value: 1}, {Key: "_id", Value: 1}}},
+		// Totalling a counter's recent events for its velocity
+		{collection: config.Collections.CounterEvents, keys: bson.D{{Key: "counterId", Value: 1}, {Key: "timestamp", Value: 1}}},
+		// Expiring counter events after counterEventRetention
+		{
+			collection: config.Collections.CounterEvents,
+			keys:       bson.D{{Key: "timestamp", Value: 1}},
+			options:    options.Index().SetExpireAfterSeconds(int32(counterEventRetention.Seconds())),
+		},
+		// Forgetting counted visitors once their dedup window has passed
+		{
+			collection: config.Collections.PageViewVisitors,
+			keys:       bson.D{{Key: "expiresAt", Value: 1}},
+			options:    options.Index().SetExpireAfterSeconds(0),
+		},
+		// Expiring sessions once their cookie has
+		{
+			collection: config.Collections.Sessions,
+			keys:       bson.D{{Key: "createdAt", Value: 1}},
+			options:    options.Index().SetExpireAfterSeconds(int32(sessionLifetime.Seconds())),
+		},
+		// Forgetting quote reactions once the session that made them has expired
+		{
+			collection: config.Collections.QuoteReactions,
+			keys:       bson.D{{Key: "createdAt", Value: 1}},
+			options:    options.Index().SetExpireAfterSeconds(int32(sessionLifetime.Seconds())),
+		},
+	}
+
+	if config.RateLimitBackend == "mongo" {
+		// Removing expired rate limit windows
+		indexes = append(indexes, mongoIndex{
+			collection: config.Collections.RateLimits,
+			keys:       bson.D{{Key: "expiresAt", Value: 1}},
+			options:    options.Index().SetExpireAfterSeconds(0),
+		})
+	}
+	return indexes
+}
+
+// ensureIndexes creates any of the startup indexes that don't exist yet, leaving existing ones
+// alone apart from bringing a TTL index's expiry up to date. It returns an error if a required
+// index can't be created or updated.
+func ensureIndexes(ctx context.Context, db *mongo.Database, config Config) error {
+	l := componentLogger("mongo")
+	existing := map[string]map[string]mongo.IndexSpecification{}
+
+	for _, index := range startupIndexes(config) {
+		name := index.name()
+		specs, ok := existing[index.collection]
+		if !ok {
+			var err error
+			specs, err = indexSpecs(ctx, db.Collection(index.collection))
+			if err != nil && !index.optional {
+				return fmt.Errorf("listing indexes on %s: %w", index.collection, err)
+			}
+			existing[index.collection] = specs
+		}
+		if spec, ok := specs[name]; ok {
+			if err := updateIndexExpiry(ctx, db, index, spec); err != nil {
+				return fmt.Errorf("updating the expiry of index %s on %s: %w", name, index.collection, err)
+			}
+			l.Debug("index exists", "collection", index.collection, "index", name)
+			continue
+		}
+
+		opts := index.options
+		if opts == nil {
+			opts = options.Index()
+		}
+		_, err := db.Collection(index.collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    index.keys,
+			Options: opts.SetName(name),
+		})
+		if err != nil {
+			if index.optional {
+				l.Warn("skipping optional index", "collection", index.collection, "index", name, "err", err)
+				continue
+			}
+			return fmt.Errorf("creating index %s on %s: %w", name, index.collection, err)
+		}
+		l.Info("created index", "collection", index.collection, "index", name)
+	}
+	return nil
+}
+
+// indexSpecs returns a collection's indexes by name. A collection that doesn't exist yet has
+// none.
+func indexSpecs(ctx context.Context, collection *mongo.Collection) (map[string]mongo.IndexSpecification, error) {
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]mongo.IndexSpecification, len(specs))
+	for _, spec := range specs {
+		byName[spec.Name] = *spec
+	}
+	return byName, nil
+}
+
+// updateIndexExpiry changes an existing TTL index to expire documents after the time index asks
+// for, such as when a retention period has changed since the index was created
+func updateIndexExpiry(ctx context.Context, db *mongo.Database, index mongoIndex, spec mongo.IndexSpecification) error {
+	if index.options == nil || index.options.ExpireAfterSeconds == nil || spec.ExpireAfterSeconds == nil {
+		return nil
+	}
+	want := *index.options.ExpireAfterSeconds
+	if *spec.ExpireAfterSeconds == want {
+		return nil
+	}
+
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: index.collection},
+		{Key: "index", Value: bson.D{{Key: "name", Value: spec.Name}, {Key: "expireAfterSeconds", Value: want}}},
+	}).Err()
+	if err != nil {
+		return err
+	}
+	componentLogger("mongo").Info("updated index expiry", "collection", index.collection, "index", spec.Name,
+		"from_seconds", *spec.ExpireAfterSeconds, "to_seconds", want)
+	return nil
+}
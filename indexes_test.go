@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoIndexNameMatchesMongoDefault(t *testing.T) {
+	tests := []struct {
+		keys bson.D
+		want string
+	}{
+		{bson.D{{Key: "timestamp", Value: -1}}, "timestamp_-1"},
+		{bson.D{{Key: "namespace", Value: 1}, {Key: "_id", Value: 1}}, "namespace_1__id_1"},
+		{bson.D{{Key: "quote", Value: "text"}, {Key: "name", Value: "text"}}, "quote_text_name_text"},
+	}
+	for _, tt := range tests {
+		if got := (mongoIndex{keys: tt.keys}).name(); got != tt.want {
+			t.Errorf("name of %v = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureIndexesCreatesIndexes(t *testing.T) {
+	s := newMongoTestServer(t)
+	s.config.RateLimitBackend = "mongo"
+	ctx := context.Background()
+
+	// Running it again finds every index already there
+	for range 2 {
+		if err := ensureIndexes(ctx, s.db, s.config); err != nil {
+			t.Fatalf("ensureIndexes() = %v", err)
+		}
+	}
+
+	for _, index := range startupIndexes(s.config) {
+		specs, err := indexSpecs(ctx, s.db.Collection(index.collection))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := specs[index.name()]; !ok && !index.optional {
+			t.Errorf("%s has no %s index, only %v", index.collection, index.name(), specs)
+		}
+	}
+}
+
+func TestEnsureIndexesUpdatesExpiry(t *testing.T) {
+	s := newMongoTestServer(t)
+	ctx := context.Background()
+
+	// An expiry index left over from when counter events were kept for an hour
+	events := s.db.Collection(s.config.Collections.CounterEvents)
+	_, err := events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "timestamp", Value: 1}},
+		Options: options.Index().SetName("timestamp_1").SetExpireAfterSeconds(3600),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ensureIndexes(ctx, s.db, s.config); err != nil {
+		t.Fatalf("ensureIndexes() = %v", err)
+	}
+	specs, err := indexSpecs(ctx, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiry := specs["timestamp_1"].ExpireAfterSeconds; expiry == nil || *expiry != int32(counterEventRetention.Seconds()) {
+		t.Errorf("expireAfterSeconds = %v, want %d", expiry, int32(counterEventRetention.Seconds()))
+	}
+}
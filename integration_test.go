@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seededWebhookCount is the webhook count startTestSite starts a site with
+const seededWebhookCount = 3
+
+// seededQuotes are the quotes startTestSite adds, oldest first
+var seededQuotes = []struct{ name, quote string }{
+	{"Ada Lovelace", "The Analytical Engine weaves algebraic patterns"},
+	{"Grace Hopper", "It's easier to ask forgiveness than it is to get permission"},
+	{"Edsger Dijkstra", "Simplicity is prerequisite for reliability"},
+}
+
+// testSite is a server listening on a real port, with the whole middleware chain in front of its
+// routes as in production
+type testSite struct {
+	*httptest.Server
+	server *Server
+}
+
+// siteStores are the stores the integration tests run against. The MongoDB one is skipped unless
+// MONGO_TEST_URI is set.
+var siteStores = []struct {
+	name      string
+	newServer func(t *testing.T) *Server
+}{
+	{"memory", func(t *testing.T) *Server { return newTestServer(t) }},
+	{"mongo", func(t *testing.T) *Server { return newMongoTestServer(t) }},
+}
+
+// forEachStore runs test as a subtest against a seeded site for each of siteStores
+func forEachStore(t *testing.T, test func(t *testing.T, site *testSite)) {
+	for _, store := range siteStores {
+		t.Run(store.name, func(t *testing.T) {
+			test(t, startTestSite(t, store.newServer(t)))
+		})
+	}
+}
+
+// startTestSite seeds s with the built-in counters, a webhook count of seededWebhookCount, and
+// seededQuotes, then serves it until the test ends. Rate limits and the GitHub repos are reset
+// for the rest of the test.
+func startTestSite(t *testing.T, s *Server) *testSite {
+	t.Helper()
+	resetRateLimiters(t)
+	setGitHubRepos(t, nil)
+
+	ctx := context.Background()
+	if err := s.initializeCounters(ctx); err != nil {
+		t.Fatalf("initializing counters: %v", err)
+	}
+	for range seededWebhookCount {
+		if _, err := s.counters.IncrementCounter(ctx, "webhook"); err != nil {
+			t.Fatalf("seeding the webhook counter: %v", err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	for i, seed := range seededQuotes {
+		quote, err := newQuote(seed.name, seed.quote, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		quote.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		if err := s.insertQuote(ctx, quote); err != nil {
+			t.Fatalf("seeding quotes: %v", err)
+		}
+	}
+
+	site := &testSite{Server: httptest.NewServer(s.handler()), server: s}
+	t.Cleanup(site.Close)
+	return site
+}
+
+// wsURL returns the address of the site's WebSocket
+func (site *testSite) wsURL() string {
+	return "ws" + strings.TrimPrefix(site.URL, "http") + "/ws"
+}
+
+// get fetches path from the site, failing the test unless it's a 200, and returns the body
+func (site *testSite) get(t *testing.T, path string) string {
+	t.Helper()
+	resp, err := site.Client().Get(site.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading GET %s: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d", path, resp.StatusCode, http.StatusOK)
+	}
+	return string(body)
+}
+
+// post sends form to path on the site, without following redirects, and returns the status
+func (site *testSite) post(t *testing.T, path string, form url.Values) int {
+	t.Helper()
+	client := *site.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.PostForm(site.URL+path, form)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSiteHomePageShowsSeededData(t *testing.T) {
+	forEachStore(t, func(t *testing.T, site *testSite) {
+		home := site.get(t, "/")
+		if !strings.Contains(home, `id="counter">3<`) {
+			t.Errorf("home page doesn't show the seeded webhook count of %d", seededWebhookCount)
+		}
+		for _, seed := range seededQuotes {
+			// Apostrophes in quotes are escaped
+			if !strings.Contains(home, strings.ReplaceAll(seed.quote, "'", "&#39;")) || !strings.Contains(home, seed.name) {
+				t.Errorf("home page doesn't show the quote by %s", seed.name)
+			}
+		}
+	})
+}
+
+func TestSiteIncrementBroadcasts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, site *testSite) {
+		client := dialHub(t, site.wsURL())
+		if update, err := client.NextUpdate(2 * time.Second); err != nil || countOf(update.Count) != seededWebhookCount {
+			t.Fatalf("first update = %+v, %v; want the seeded count %d", update, err, seededWebhookCount)
+		}
+
+		if status := site.post(t, "/increment", nil); status != http.StatusOK {
+			t.Fatalf("POST /increment status = %d, want %d", status, http.StatusOK)
+		}
+		update, err := client.NextUpdate(2 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update.Type != messageTypeUpdate || countOf(update.Count) != seededWebhookCount+1 {
+			t.Errorf("broadcast = %+v, want an update with count %d", update, seededWebhookCount+1)
+		}
+		counter, err := site.server.counters.GetCounter(t.Context(), "webhook")
+		if err != nil || counter.Count != seededWebhookCount+1 {
+			t.Errorf("stored webhook counter = %+v, %v; want count %d", counter, err, seededWebhookCount+1)
+		}
+	})
+}
+
+func TestSiteSubmittedQuoteIsShown(t *testing.T) {
+	forEachStore(t, func(t *testing.T, site *testSite) {
+		// Render first, so a stale cached page would be caught
+		site.get(t, "/")
+
+		form := url.Values{"name": {"Alan Turing"}, "quote": {"We can only see a short distance ahead"}}
+		if status := site.post(t, "/quote", form); status != http.StatusSeeOther {
+			t.Fatalf("POST /quote status = %d, want %d", status, http.StatusSeeOther)
+		}
+		home := site.get(t, "/")
+		if !strings.Contains(home, "We can only see a short distance ahead") || !strings.Contains(home, "Alan Turing") {
+			t.Error("home page doesn't show the submitted quote")
+		}
+	})
+}
+
+func TestSiteQuoteRateLimit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, site *testSite) {
+		for i := range site.server.config.RateLimitBurst {
+			form := url.Values{"name": {"Ada"}, "quote": {fmt.Sprintf("Quote %d", i+1)}}
+			if status := site.post(t, "/quote", form); status != http.StatusSeeOther {
+				t.Fatalf("quote %d of the burst: status = %d, want %d", i+1, status, http.StatusSeeOther)
+			}
+		}
+		if status := site.post(t, "/quote", url.Values{"name": {"Ada"}, "quote": {"One too many"}}); status != http.StatusTooManyRequests {
+			t.Errorf("quote past the burst: status = %d, want %d", status, http.StatusTooManyRequests)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger is the package-level logger, configured from Config.LogFormat and LogLevel at startup.
+// Servers and hubs take a copy when they're created, so tests can swap in their own.
+var logger = slog.Default()
+
+// newLogger returns a logger writing to w as JSON if format is "json" or as text otherwise,
+// dropping records below level
+func newLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// componentLogger returns the package-level logger tagged with a component
+func componentLogger(component string) *slog.Logger {
+	return logger.With("component", component)
+}
+
+// log returns the server's logger tagged with a component and, during a request, its ID
+func (s *Server) log(ctx context.Context, component string) *slog.Logger {
+	l := s.logger.With("component", component)
+	if id := requestIDFromContext(ctx); id != "" {
+		l = l.With("request_id", id)
+	}
+	return l
+}
+
+// fatal logs an error and exits, for failures the server can't start without
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerFormatAndLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf, "json", slog.LevelWarn)
+
+	l.Info("hidden")
+	l.Warn("shown", "component", "counter")
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("log output %q isn't a single JSON record: %v", buf.String(), err)
+	}
+	if record["msg"] != "shown" || record["level"] != "WARN" || record["component"] != "counter" {
+		t.Errorf("record = %v, want the warning with its component", record)
+	}
+}
+
+func TestNewLoggerWritesText(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf, "text", slog.LevelInfo)
+	l.Debug("hidden")
+	l.Info("shown")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") || !strings.Contains(out, "msg=shown") {
+		t.Errorf("output %q, want info records as text and no debug records", out)
+	}
+}
+
+func TestDBErrorLogsComponentAndRequestID(t *testing.T) {
+	s := newTestServer(t)
+	records := captureLogs(t, s)
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+
+	if status := s.dbError(ctx, "quotes", "get quotes", errors.New("boom")); status != 500 {
+		t.Errorf("status = %d, want 500", status)
+	}
+
+	logged := records()
+	if len(logged) != 1 {
+		t.Fatalf("got %d records, want 1", len(logged))
+	}
+	want := map[string]any{"level": "ERROR", "component": "quotes", "request_id": "req-1", "operation": "get quotes", "err": "boom"}
+	for key, value := range want {
+		if logged[0][key] != value {
+			t.Errorf("record %s = %v, want %v", key, logged[0][key], value)
+		}
+	}
+}
+
+func TestHubLogsConnectionsWithComponentAndIP(t *testing.T) {
+	s := newTestServer(t)
+	records := captureLogs(t, s)
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+
+	client := dialHub(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws")
+	waitForClients(t, s.hub, 1)
+	client.Close()
+	waitForClients(t, s.hub, 0)
+
+	var messages []string
+	for _, record := range records() {
+		if record["component"] == "hub" && record["ip"] == "127.0.0.1" {
+			messages = append(messages, record["msg"].(string))
+		}
+	}
+	if len(messages) != 2 || messages[0] != "websocket client connected" || messages[1] != "websocket client disconnected" {
+		t.Errorf("hub records = %q, want a connect and a disconnect with the client's IP", messages)
+	}
+}
@@ -1,211 +1,670 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
 	"net/http"
 	"os"
-	"time"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"golang.org/x/sync/errgroup"
 )
 
-var (
-	client    *mongo.Client
-	db        *mongo.Database
-	templates *template.Template
-	hub       *Hub
-)
-
-// GitHubRepo represents a GitHub repository
-type GitHubRepo struct {
-	Name            string `json:"name"`
-	Description     string `json:"description"`
-	HTMLURL         string `json:"html_url"`
-	Language        string `json:"language"`
-	StargazersCount int    `json:"stargazers_count"`
-}
-
 // PageData represents the data passed to the home page template
 type PageData struct {
-	Name          string
-	WebhookCount  int
-	PageViewCount int
-	TotalClicks   int
-	Quotes        []Quote
-	GitHubRepos   []GitHubRepo
+	Name          string          `json:"name"`
+	WebhookCount  int             `json:"webhookCount"`
+	PageViewCount int             `json:"pageViewCount"`
+	TotalClicks   int             `json:"totalClicks"`
+	Counting      CounterSettings `json:"counting"`
+	Quotes        []Quote         `json:"quotes"`
+	GitHubRepos   []GitHubRepo    `json:"githubRepos"`
+	// Commit is the abbreviated commit the binary was built from, or "" if it isn't known
+	Commit string `json:"commit"`
+	// Theme is the color theme the page is rendered in, "light" or "dark", or empty to follow
+	// the browser's color scheme
+	Theme string `json:"theme"`
+	// FeedURL is the quotes feed, linked from the page's head
+	FeedURL string `json:"feedUrl"`
+	// ImageUploads shows the quote form's image field, when UPLOAD_DIR is set
+	ImageUploads bool `json:"imageUploads"`
+	// ReadOnly hides the forms and buttons that change anything while read-only mode is on
+	ReadOnly bool `json:"readOnly"`
+	// RequestID is shown on the error pages so a visitor can quote it when reporting a problem
+	RequestID string `json:"requestId,omitempty"`
 }
 
+// siteName is the name at the top of every page
+const siteName = "Wyat"
+
+// commandUsage describes the subcommands, printed by help and for an unknown command
+const commandUsage = `Usage: personal-website [command] [flags]
+
+Commands:
+  serve        Run the site (the default)
+  init-db      Create the MongoDB indexes and INIT_COUNTERS counters, then exit
+  export       Write collections to a file in the /admin/import backup format
+  healthcheck  Exit 0 if the local server is ready, or 1 if it isn't
+  help         Show this message
+
+Settings are read from environment variables; see the README. Run a command with -h to
+list its flags.
+`
+
 func main() {
-	// Get MongoDB URI from environment
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017"
+	os.Exit(runCommand(os.Args[1:], os.Stderr))
+}
+
+// runCommand runs the subcommand named by args[0], or serve if args are empty or start with a
+// flag, and returns the exit code. An unknown command prints the usage and returns 2.
+func runCommand(args []string, stderr io.Writer) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		return serveCommand(args, stderr)
+	case "init-db":
+		return initDBCommand(args, stderr)
+	case "export":
+		return exportCommand(args, stderr)
+	case "healthcheck":
+		return healthcheckCommand(args, stderr)
+	case "help":
+		fmt.Fprint(stderr, commandUsage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", command, commandUsage)
+		return 2
 	}
+}
 
-	// Connect to MongoDB with connection pooling for concurrency
-	var err error
-	clientOptions := options.Client().
-		ApplyURI(mongoURI).
-		SetMaxPoolSize(100).    // Max 100 concurrent connections
-		SetMinPoolSize(10)       // Keep 10 warm connections
+// newFlagSet returns the flags for a subcommand, which report errors to stderr rather than exiting
+func newFlagSet(command string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
 
-	client, err = mongo.Connect(context.Background(), clientOptions)
+// parseFlags parses a subcommand's flags. If they're invalid or -h was given it returns false
+// with the exit code to stop with.
+func parseFlags(flags *flag.FlagSet, args []string) (code int, ok bool) {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0, false
+	}
 	if err != nil {
-		log.Fatal(err)
+		return 2, false
 	}
-	defer client.Disconnect(context.Background())
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return 2, false
+	}
+	return 0, true
+}
+
+// loadConfig reads the configuration and sets up logging, exiting if any setting is invalid.
+// Each non-empty override replaces the environment variable it's keyed by, so settings given
+// as flags are checked just like the environment.
+func loadConfig(overrides map[string]string) Config {
+	for key, value := range overrides {
+		if value != "" {
+			os.Setenv(key, value)
+		}
+	}
+	cfg, err := LoadConfig()
+
+	// Log as configured, including anything still written through the standard log package
+	logger = newLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	slog.SetDefault(logger)
+
+	if err != nil {
+		fatal("invalid configuration", "problems", strings.Split(err.Error(), "\n"))
+	}
+	return cfg
+}
 
-	// Test connection
-	err = client.Ping(context.Background(), nil)
+// connectMongo connects to MongoDB with connection pooling for concurrency, retrying for a
+// while in case it's still starting. It exits if it can't connect.
+func connectMongo(cfg Config) *mongo.Client {
+	pool := cfg.MongoPool
+	logger.Info("MongoDB pool", "max", pool.MaxPoolSize, "min", pool.MinPoolSize,
+		"max_idle", pool.MaxConnIdle, "connect_timeout", pool.ConnectTimeout)
+	clientOptions := pool.apply(options.Client().ApplyURI(cfg.MongoURI))
+	readPreference, err := readpref.New(cfg.MongoReadPreference)
 	if err != nil {
-		log.Fatal("Could not connect to MongoDB:", err)
+		fatal("invalid MongoDB read preference", "err", err)
+	}
+	clientOptions.SetReadPreference(readPreference)
+
+	// Every write inherits the client's write concern
+	logger.Info("MongoDB write concern", describeWriteConcern(cfg.MongoWriteConcern)...)
+	if cfg.MongoWriteConcern != nil {
+		clientOptions.SetWriteConcern(cfg.MongoWriteConcern)
+	}
+
+	dial := dialMongo(clientOptions, pool.ConnectTimeout)
+	client, err := connectWithRetry(context.Background(), dial, startupBackoff, cfg.MongoConnectDeadline, systemClock{})
+	if err != nil {
+		fatal("could not connect to MongoDB", "err", err)
+	}
+	return client
+}
+
+// serveCommand runs the site. -port and -mongo-uri override PORT and MONGO_URI, and -seed
+// fills the database with demo data and exits instead.
+func serveCommand(args []string, stderr io.Writer) int {
+	flags := newFlagSet("serve", stderr)
+	port := flags.String("port", "", "port to listen on, overriding PORT")
+	mongoURI := flags.String("mongo-uri", "", "MongoDB connection string, overriding MONGO_URI")
+	seed := flags.Bool("seed", false, "fill the database with demo data and exit")
+	clearData := flags.Bool("clear", false, "with -seed, delete existing quotes and counters first")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
 	}
 
-	db = client.Database("personal_website")
+	// Read every setting up front so a bad one stops the server before it serves anything
+	cfg := loadConfig(map[string]string{"PORT": *port, "MONGO_URI": *mongoURI})
+
+	if *clearData && !*seed {
+		fatal("-clear only applies with -seed")
+	}
+	if *seed {
+		if err := checkSeedAllowed(cfg); err != nil {
+			fatal("seeding demo data", "err", err)
+		}
+	}
+
+	client := connectMongo(cfg)
+	warnIfNotReplicated(context.Background(), client, cfg.MongoReadPreference)
+
+	// Create the indexes queries rely on before serving any
+	if err := ensureIndexes(context.Background(), cfg.database(client), cfg); err != nil {
+		fatal("creating MongoDB indexes", "err", err)
+	}
+
+	if *seed {
+		if err := runSeed(context.Background(), cfg.database(client), cfg.Collections, *clearData); err != nil {
+			fatal("seeding demo data", "err", err)
+		}
+		return 0
+	}
+
+	// Hash static files for cache-busting URLs
+	loadFingerprinter(staticFS(cfg.assetsFS()))
+
+	server, err := newServer(cfg, client, cfg.database(client))
+	if err != nil {
+		fatal("could not set up server", "err", err)
+	}
+
+	// Report not ready, rather than crashing, if MongoDB becomes unreachable later
+	server.startMongoHealthMonitor(server.stoppingContext())
+
+	// Start the WebSocket hub
+	go server.hub.Run()
 
 	// Initialize counters if they don't exist
-	initializeCounters()
-
-	// Parse templates
-	templates = template.Must(template.ParseGlob("templates/*.html"))
-
-	// Initialize and start WebSocket hub
-	hub = NewHub()
-	go hub.Run()
-
-	// Routes
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/increment", incrementHandler)
-	http.HandleFunc("/decrement", decrementHandler)
-	http.HandleFunc("/quote", rateLimitMiddleware(quoteHandler, 5)) // 5 requests per minute
-	http.HandleFunc("/ws", wsHandler)
-	http.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "static/robots.txt")
-	})
-	http.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "static/sitemap.xml")
-	})
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	if err := server.initializeCounters(context.Background()); err != nil {
+		componentLogger("counter").Error("initializing counters", "err", err)
+	}
+
+	// Carry the old site-wide page view count over to the home page
+	server.migrateLegacyPageViews(context.Background())
+
+	// Preload quotes for fresh deployments
+	server.seedQuotesFromConfig(context.Background())
+
+	// Have repos to show even if GitHub is down before the first fetch
+	loadGitHubFallback(cfg.GitHubFallbackFile)
+
+	// Count page views by country if there's a database to look visitors up in
+	server.countries = openCountryDatabase(cfg.MaxMindDBPath)
+
+	server.setMaintenance(cfg.Maintenance)
+	server.setReadOnly(cfg.ReadOnly)
+
+	// Save audit events, and state-changing requests when AUDIT_ENABLED is set, to the audit log
+	if err := server.ensureAuditCollection(context.Background()); err != nil {
+		componentLogger("audit").Error("creating audit log collection", "err", err)
+	}
+	if cfg.AuditEnabled {
+		server.startAuditWriter()
+	}
+	go server.audit.Run()
+
+	// Stream audit events to admins
+	go server.audit.hub.Run()
+
+	// Load banned IPs and keep the list fresh
+	server.startBanRefresher()
+
+	// Reset the configured counters every day at midnight UTC
+	server.startDailyResetScheduler(server.stoppingContext())
 
-	// Get port from environment or use default
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Deliver the contact messages a restart interrupted, before new ones can arrive
+	if err := server.redeliverContactMessages(context.Background()); err != nil {
+		componentLogger("contact").Error("listing undelivered contact messages", "err", err)
 	}
 
-	log.Printf("Server starting on port %s...", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	httpServer := newHTTPServer(cfg, server.handler())
+
+	build := currentBuildInfo()
+	logger.Info("server starting", "port", cfg.Port, "version", build.Version, "commit", build.Commit, "built", build.BuildTime)
+	return server.serve(httpServer, cfg.ShutdownGrace)
 }
 
-// homeHandler renders the home page
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+// initDBCommand creates the MongoDB indexes and built-in counters and exits, for deploy hooks
+// that prepare the database before a new version starts
+func initDBCommand(args []string, stderr io.Writer) int {
+	flags := newFlagSet("init-db", stderr)
+	mongoURI := flags.String("mongo-uri", "", "MongoDB connection string, overriding MONGO_URI")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
 	}
 
-	ctx := context.Background()
+	cfg := loadConfig(map[string]string{"MONGO_URI": *mongoURI})
+	client := connectMongo(cfg)
+	defer client.Disconnect(context.Background())
 
-	// Increment page view counter
-	countersCollection := db.Collection("counters")
-	_, err := countersCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": "pageviews"},
-		bson.M{"$inc": bson.M{"count": 1}},
-	)
+	server, err := newServer(cfg, client, cfg.database(client))
 	if err != nil {
-		log.Println("Error incrementing page views:", err)
+		fatal("could not set up server", "err", err)
+	}
+	if err := server.initDatabase(context.Background()); err != nil {
+		logger.Error("initializing database", "err", err)
+		return 1
 	}
+	logger.Info("database initialized", "database", cfg.MongoDB)
+	return 0
+}
 
-	// Get webhook counter
-	var webhookCounter Counter
-	err = countersCollection.FindOne(ctx, bson.M{"_id": "webhook"}).Decode(&webhookCounter)
+// exportCommand writes collections to a file, or stdout, in the backup format
+func exportCommand(args []string, stderr io.Writer) int {
+	flags := newFlagSet("export", stderr)
+	collection := flags.String("collection", "", "collection to export, such as quotes (default every backed up collection)")
+	out := flags.String("out", "", "file to write the backup to (default stdout)")
+	mongoURI := flags.String("mongo-uri", "", "MongoDB connection string, overriding MONGO_URI")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	cfg := loadConfig(map[string]string{"MONGO_URI": *mongoURI})
+	names, err := exportCollectionNames(cfg.Collections, *collection)
 	if err != nil {
-		log.Println("Error getting webhook counter:", err)
-		webhookCounter.Count = 0
+		fmt.Fprintln(stderr, err)
+		return 2
 	}
 
-	// Get page view counter
-	var pageViewCounter Counter
-	err = countersCollection.FindOne(ctx, bson.M{"_id": "pageviews"}).Decode(&pageViewCounter)
+	client := connectMongo(cfg)
+	defer client.Disconnect(context.Background())
+
+	db := cfg.database(client)
+	if *out == "" {
+		err = exportCollections(context.Background(), os.Stdout, db, names)
+	} else {
+		err = exportToFile(context.Background(), db, names, *out)
+	}
 	if err != nil {
-		log.Println("Error getting page view counter:", err)
-		pageViewCounter.Count = 0
+		logger.Error("exporting", "collections", names, "err", err)
+		return 1
+	}
+	if *out != "" {
+		logger.Info("exported", "collections", names, "path", *out)
+	}
+	return 0
+}
+
+// healthcheckCommand exits 0 if the server on this machine is ready to serve, and 1 if it
+// isn't or can't be reached, for use as a Docker HEALTHCHECK
+func healthcheckCommand(args []string, stderr io.Writer) int {
+	flags := newFlagSet("healthcheck", stderr)
+	port := flags.String("port", cmp.Or(os.Getenv("PORT"), "8080"), "port the server listens on")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthcheckTimeout)
+	defer cancel()
+	if err := checkReady(ctx, "http://localhost:"+*port); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// handler returns the site's routes behind the middleware every request passes through
+func (s *Server) handler() http.Handler {
+	return requestIDMiddleware(s.recoverMiddleware(s.auditMiddleware(s.banMiddleware(s.maintenanceMiddleware(s.notFoundMiddleware(s.routes()))))))
+}
+
+// routes registers every route on a new router. Patterns are method-scoped, so requests
+// with the wrong method get a 405 with an Allow header and unknown paths get a 404.
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", s.sessionMiddleware(s.homeHandler))
+	mux.HandleFunc("POST /increment", s.writableMiddleware(s.maxBytesMiddleware(s.incrementHandler, maxFormBytes)))
+	mux.HandleFunc("POST /decrement", s.writableMiddleware(s.maxBytesMiddleware(s.decrementHandler, maxFormBytes)))
+	mux.HandleFunc("POST /quote", s.requireFeature(quoteSubmissionsEnabled, s.writableMiddleware(s.rateLimitMiddleware(s.sessionMiddleware(s.maxBytesMiddleware(s.quoteHandler, maxQuoteUploadBytes)), s.config.RateLimitRPM, s.config.RateLimitBurst))))
+	mux.HandleFunc("GET /contact", s.contactPageHandler)
+	mux.HandleFunc("POST /contact", s.writableMiddleware(s.scopedRateLimitMiddleware("contact", s.maxBytesMiddleware(s.contactHandler, maxFormBytes), contactRateLimitRPM)))
+	mux.HandleFunc("POST /preferences", s.maxBytesMiddleware(s.preferencesHandler, maxFormBytes))
+	mux.HandleFunc("GET /ws", s.wsHandler)
+
+	// Health checks for the proxy and uptime monitor, never rate limited
+	mux.HandleFunc("GET /healthz", s.healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+
+	// JSON API, versioned by path prefix or by the API-Version header on unversioned paths
+	apiV1 := s.newAPIMux("v1")
+	apiV2 := s.newAPIMux("v2")
+	mux.Handle("/api/v1/", http.StripPrefix("/api/v1", apiV1))
+	mux.Handle("/api/v2/", http.StripPrefix("/api/v2", apiV2))
+	mux.Handle("/api/", http.StripPrefix("/api", s.versionMiddleware(apiV1, apiV2)))
+	mux.HandleFunc("GET /api/openapi.json", s.requireFeature(apiDocsEnabled, s.openAPIHandler))
+	mux.HandleFunc("GET /openapi.json", s.requireFeature(apiDocsEnabled, s.openAPIHandler))
+	mux.HandleFunc("GET /api/docs", s.requireFeature(apiDocsEnabled, s.apiDocsHandler))
+
+	// GraphQL, with the playground only in development
+	mux.HandleFunc("POST /graphql", s.requireFeature(graphQLEnabled, s.maxBytesMiddleware(s.graphqlHandler, maxFormBytes)))
+	if s.config.DevMode {
+		mux.HandleFunc("GET /graphiql", s.requireFeature(graphQLEnabled, s.graphiqlHandler))
+	}
+
+	// Admin routes
+	mux.HandleFunc("GET /admin", s.adminAuthMiddleware(s.adminDashboardHandler))
+	mux.HandleFunc("GET /admin.json", s.adminAuthMiddleware(s.adminDashboardJSONHandler))
+	mux.HandleFunc("GET /admin/bans", s.adminAuthMiddleware(s.listBansHandler))
+	mux.HandleFunc("POST /admin/bans", s.adminAuthMiddleware(s.maxBytesMiddleware(s.createBanHandler, maxFormBytes)))
+	mux.HandleFunc("DELETE /admin/bans/{id}", s.adminAuthMiddleware(s.deleteBanHandler))
+	mux.HandleFunc("GET /admin/ws", s.adminAuthMiddleware(s.adminWSListHandler))
+	mux.HandleFunc("POST /admin/ws/disconnect", s.adminAuthMiddleware(s.maxBytesMiddleware(s.adminWSDisconnectHandler, maxFormBytes)))
+	mux.HandleFunc("GET /admin/ws/clients", s.adminAuthMiddleware(s.adminWSClientsHandler))
+	mux.HandleFunc("GET /admin/audit", s.adminAuthMiddleware(s.listAuditHandler))
+	mux.HandleFunc("GET /admin/audit/events", s.adminAuthMiddleware(s.listAuditEventsHandler))
+	mux.HandleFunc("GET /admin/audit/stream", s.adminAuthMiddleware(s.auditStreamHandler))
+	mux.HandleFunc("GET /admin/features", s.adminAuthMiddleware(s.getFeaturesHandler))
+	mux.HandleFunc("POST /admin/features", s.adminAuthMiddleware(s.maxBytesMiddleware(s.setFeaturesHandler, maxFormBytes)))
+	mux.HandleFunc("GET /admin/ratelimit", s.adminAuthMiddleware(s.rateLimitStatusHandler))
+	mux.HandleFunc("DELETE /admin/ratelimit/{ip}", s.adminAuthMiddleware(s.resetRateLimitHandler))
+	mux.HandleFunc("POST /admin/ratelimit/tokens", s.adminAuthMiddleware(s.maxBytesMiddleware(s.createBypassTokenHandler, maxFormBytes)))
+	mux.HandleFunc("GET /admin/maintenance", s.adminAuthMiddleware(s.getMaintenanceHandler))
+	mux.HandleFunc("POST /admin/maintenance", s.adminAuthMiddleware(s.maxBytesMiddleware(s.setMaintenanceHandler, maxFormBytes)))
+	mux.HandleFunc("GET /admin/read-only", s.adminAuthMiddleware(s.getReadOnlyHandler))
+	mux.HandleFunc("POST /admin/read-only", s.adminAuthMiddleware(s.maxBytesMiddleware(s.setReadOnlyHandler, maxFormBytes)))
+	mux.HandleFunc("GET /admin/quotes/authors", s.adminAuthMiddleware(s.quoteAuthorsHandler))
+	mux.HandleFunc("GET /admin/messages", s.adminAuthMiddleware(s.listMessagesHandler))
+	mux.HandleFunc("POST /admin/messages/{id}/read", s.adminAuthMiddleware(s.markMessageReadHandler))
+	mux.HandleFunc("GET /admin/export", s.adminAuthMiddleware(s.exportHandler))
+	mux.HandleFunc("POST /admin/import", s.adminAuthMiddleware(s.maxBytesMiddleware(s.importHandler, maxImportBytes)))
+	mux.HandleFunc("GET /admin/runtime", s.adminAuthMiddleware(s.runtimeHandler))
+	s.registerDebugRoutes(mux)
+
+	// Prometheus metrics
+	mux.Handle("GET /metrics", s.adminAuthMiddleware(promhttp.Handler().ServeHTTP))
+
+	// Static files
+	static := staticFS(s.config.assetsFS())
+	mux.HandleFunc("GET /robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, static, "robots.txt")
+	})
+	mux.HandleFunc("GET /sitemap.xml", s.sitemapHandler)
+	mux.HandleFunc("GET /feed", s.feedHandler)
+	mux.HandleFunc("GET /uploads/{name}", s.uploadHandler)
+	mux.Handle("GET /static/", http.StripPrefix("/static/", fingerprintMiddleware(precompressedFileServer(static, http.FileServer(http.FS(static))))))
+
+	return mux
+}
+
+// homeHandler renders the home page. The counters, quotes, and repos are fetched concurrently,
+// so the page takes as long as the slowest of them rather than their sum. Any that can't be
+// fetched are shown empty rather than failing the page.
+func (s *Server) homeHandler(w http.ResponseWriter, r *http.Request) {
+	// Clients asking for JSON get the page's data instead, and aren't counted as visitors
+	asJSON := negotiate(r) == "json"
+	w.Header().Add("Vary", "Accept")
+
+	// Decided before fetching anything since it may set the visitor's cookie
+	countView := s.config.Counting.PageViews && !asJSON && s.shouldCountPageView(w, r)
+
+	// Anonymous visitors share one page, rebuilt every few seconds or when a quote or counter
+	// changes. Their view is still counted, though the total shown may lag by a few views.
+	cacheable := !asJSON && homeCacheable(r)
+	theme := requestTheme(r)
+	setThemeHeaders(w)
+	cached, generation, fresh := s.home.get(theme)
+	if cacheable && fresh {
+		if countView {
+			s.trackPageView(r, "/")
+		}
+		s.writeHomePage(w, r, cached)
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+
+	// A page missing a section isn't cached, so a brief outage isn't shown for longer than it lasts
+	var degraded atomic.Bool
+
+	// getCounter fetches a counter into dst, leaving it zero if that fails
+	getCounter := func(id, operation string, dst *Counter) {
+		counter, err := s.counters.GetCounter(ctx, id)
+		if err != nil {
+			s.dbError(r.Context(), "counter", operation, err)
+			degraded.Store(true)
+			return
+		}
+		*dst = counter
 	}
 
+	// Get webhook counter
+	var webhookCounter Counter
+	g.Go(func() error {
+		getCounter("webhook", "get webhook counter", &webhookCounter)
+		return nil
+	})
+
+	// Count this view of the home page, once per visitor per window, then total every page's views
+	var pageViewCount int
+	g.Go(func() error {
+		if countView {
+			s.trackPageView(r, "/")
+		}
+		total, err := s.pageViews.TotalPageViews(ctx)
+		if err != nil {
+			s.dbError(r.Context(), "pageviews", "total page views", err)
+			degraded.Store(true)
+			return nil
+		}
+		pageViewCount = total
+		return nil
+	})
+
 	// Get total clicks counter
 	var totalClicksCounter Counter
-	err = countersCollection.FindOne(ctx, bson.M{"_id": "totalClicks"}).Decode(&totalClicksCounter)
-	if err != nil {
-		log.Println("Error getting total clicks counter:", err)
-		totalClicksCounter.Count = 0
-	}
+	g.Go(func() error {
+		getCounter("totalClicks", "get total clicks counter", &totalClicksCounter)
+		return nil
+	})
 
 	// Get quotes
-	quotesCollection := db.Collection("quotes")
-	cursor, err := quotesCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}))
 	quotes := []Quote{}
-	if err == nil {
-		defer cursor.Close(ctx)
-		cursor.All(ctx, &quotes)
-	}
+	g.Go(func() error {
+		latest, err := s.quotes.LatestQuotes(ctx, 0)
+		if err != nil {
+			s.dbError(r.Context(), "quotes", "get quotes", err)
+			degraded.Store(true)
+			return nil
+		}
+		quotes = latest
+		return nil
+	})
+
+	// Get the most starred GitHub repos
+	var repos []GitHubRepo
+	g.Go(func() error {
+		repos = topReposByStars(s.getCachedGitHubRepos(s.config.GitHubUsername), s.config.GitHubMaxDisplay)
+		return nil
+	})
 
-	// Get GitHub repos
-	repos := getGitHubRepos("wsoule")
+	// Every section degrades on its own, so there's no error to check
+	g.Wait()
 
 	// Render template
 	data := PageData{
-		Name:          "Wyat",
+		Name:          siteName,
 		WebhookCount:  webhookCounter.Count,
-		PageViewCount: pageViewCounter.Count,
+		PageViewCount: pageViewCount,
 		TotalClicks:   totalClicksCounter.Count,
+		Counting:      s.config.Counting,
 		Quotes:        quotes,
 		GitHubRepos:   repos,
+		Commit:        currentBuildInfo().ShortCommit(),
+		Theme:         theme,
+		FeedURL:       s.config.SiteURL + feedPath,
+		ImageUploads:  s.config.UploadDir != "",
+		ReadOnly:      s.isReadOnly(),
+	}
+	if asJSON {
+		// Counts that aren't counted are left out, as they are from the page
+		if !s.config.Counting.Webhook {
+			data.WebhookCount = 0
+		}
+		if !s.config.Counting.PageViews {
+			data.PageViewCount = 0
+		}
+		if !s.config.Counting.TotalClicks {
+			data.TotalClicks = 0
+		}
+		respondJSON(w, http.StatusOK, data)
+		return
 	}
 
-	err = templates.ExecuteTemplate(w, "index.html", data)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var page bytes.Buffer
+	if err := s.render(&page, "home", data); err != nil {
+		s.log(r.Context(), "home").Error("rendering home page", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error rendering page")
+		return
+	}
+	if cacheable && !degraded.Load() {
+		s.home.put(theme, page.Bytes(), generation)
+	}
+	s.writeHomePage(w, r, page.Bytes())
+}
+
+// writeHomePage sends a rendered home page
+func (s *Server) writeHomePage(w http.ResponseWriter, r *http.Request, page []byte) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-WS-Connected-Clients", strconv.Itoa(s.hub.ClientCount()))
+	if _, err := w.Write(page); err != nil {
+		s.log(r.Context(), "home").Warn("sending home page", "err", err)
 	}
 }
 
-// getGitHubRepos fetches repositories for a given GitHub username
-func getGitHubRepos(username string) []GitHubRepo {
-	url := fmt.Sprintf("https://api.github.com/users/%s/repos?sort=updated&per_page=100", username)
+// queryInt parses a positive integer query parameter, returning the default when it is absent
+func queryInt(r *http.Request, key string, fallback int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback, nil
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Println("Error creating GitHub request:", err)
-		return []GitHubRepo{}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
 	}
+	return n, nil
+}
+
+// precompressedEncodings are the sidecar file extensions checked for each static file, in order of preference
+var precompressedEncodings = []struct {
+	ext      string
+	encoding string
+}{
+	{ext: ".br", encoding: "br"},
+	{ext: ".gz", encoding: "gzip"},
+}
 
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
+// precompressedFileServer serves a pre-compressed sidecar (e.g. style.css.br or style.css.gz)
+// in place of the requested file when the client accepts that encoding, avoiding compressing
+// popular files on every request. Requests without a usable sidecar fall through to next.
+func precompressedFileServer(fsys fs.FS, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		acceptEncoding := r.Header.Get("Accept-Encoding")
+
+		for _, sidecar := range precompressedEncodings {
+			if acceptsEncoding(acceptEncoding, sidecar.encoding) && servePrecompressed(w, r, fsys, name, sidecar.ext, sidecar.encoding) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+// servePrecompressed serves name's sidecar with the given extension and encoding, reporting
+// false without writing anything if there's no such sidecar. The sidecar is closed on return, so
+// trying each encoding in turn doesn't keep the ones passed over open until the request ends.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fsys fs.FS, name, ext, encoding string) bool {
+	f, err := fsys.Open(name + ext)
 	if err != nil {
-		log.Println("Error fetching GitHub repos:", err)
-		return []GitHubRepo{}
+		return false
 	}
-	defer resp.Body.Close()
+	defer f.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("GitHub API returned status %d\n", resp.StatusCode)
-		return []GitHubRepo{}
+	stat, err := f.Stat()
+	content, ok := f.(io.ReadSeeker)
+	if err != nil || stat.IsDir() || !ok {
+		return false
 	}
 
-	var repos []GitHubRepo
-	err = json.NewDecoder(resp.Body).Decode(&repos)
-	if err != nil {
-		log.Println("Error decoding GitHub response:", err)
-		return []GitHubRepo{}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// The compressed copy is a different representation, so it needs its own strong ETag
+	if etag := w.Header().Get("ETag"); etag != "" {
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`)
 	}
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept-Encoding")
+	http.ServeContent(w, r, name, stat.ModTime(), content)
+	return true
+}
 
-	return repos
+// acceptsEncoding reports whether an Accept-Encoding header allows the given encoding
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		token, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(token), encoding) {
+			continue
+		}
+
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
 }
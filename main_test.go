@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowStore delays each read the home page makes, standing in for a slow database
+type slowStore struct {
+	*memoryStore
+	delay time.Duration
+}
+
+func (s slowStore) GetCounter(ctx context.Context, id string) (Counter, error) {
+	time.Sleep(s.delay)
+	return s.memoryStore.GetCounter(ctx, id)
+}
+
+func (s slowStore) TotalPageViews(ctx context.Context) (int, error) {
+	time.Sleep(s.delay)
+	return s.memoryStore.TotalPageViews(ctx)
+}
+
+func (s slowStore) LatestQuotes(ctx context.Context, limit int64) ([]Quote, error) {
+	time.Sleep(s.delay)
+	return s.memoryStore.LatestQuotes(ctx, limit)
+}
+
+// failingQuotes fails every quote query
+type failingQuotes struct {
+	*memoryStore
+}
+
+func (failingQuotes) LatestQuotes(ctx context.Context, limit int64) ([]Quote, error) {
+	return nil, errors.New("quotes unavailable")
+}
+
+// newSlowHomeServer returns a test server whose counter, page view, and quote reads each take delay
+func newSlowHomeServer(tb testing.TB, delay time.Duration) *Server {
+	tb.Helper()
+	s := newTestServer(tb)
+	store := slowStore{memoryStore: newMemoryStore(), delay: delay}
+	if err := store.InitCounters(context.Background(), "webhook", "totalClicks"); err != nil {
+		tb.Fatal(err)
+	}
+	s.quotes, s.counters, s.pageViews = store, store, store
+	return s
+}
+
+func TestHomeHandlerFetchesConcurrently(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{{Name: "site"}})
+	const delay = 100 * time.Millisecond
+	h := newSlowHomeServer(t, delay).routes()
+
+	// Two counter reads, the page view total, and the quotes query take 400ms one after another
+	start := time.Now()
+	w := serveRequest(h, http.MethodGet, "/", "", "")
+	elapsed := time.Since(start)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if elapsed >= 3*delay {
+		t.Errorf("home page took %v with each read taking %v, want about the slowest read", elapsed, delay)
+	}
+}
+
+func TestHomeHandlerDegradesFailedSections(t *testing.T) {
+	s := newTestServer(t)
+	store := newMemoryStore()
+	s.counters, s.quotes = store, failingQuotes{store}
+	if err := store.InitCounters(t.Context(), "webhook"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.IncrementCounter(t.Context(), "webhook"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveRequest(s.routes(), http.MethodGet, "/", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status with failing quotes = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), `<strong id="counter">1</strong>`) {
+		t.Error("home page with failing quotes doesn't show the webhook count")
+	}
+}
+
+func TestHomeHandlerServesJSON(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{{Name: "site", StargazersCount: 3}})
+	s := newTestServer(t)
+	ctx := t.Context()
+	for range 2 {
+		s.counters.IncrementCounter(ctx, "webhook")
+	}
+	s.counters.IncrementCounter(ctx, "totalClicks")
+	quote, _ := newQuote("Ada", "Simplicity is prerequisite for reliability", "", nil)
+	s.insertQuote(ctx, quote)
+	h := s.routes()
+
+	// Render the HTML page first, so a cached copy would be caught
+	if w := serveRequest(h, http.MethodGet, "/", "", "198.51.100.63:1000"); !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Content-Type without an Accept header = %q, want HTML", w.Header().Get("Content-Type"))
+	}
+
+	r := newJSONRequest(http.MethodGet, "/", "")
+	r.Header.Set("Accept", "application/json")
+	r.RemoteAddr = "198.51.100.64:1000"
+	w := serve(h, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("response = %d %q, want 200 JSON", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Header().Get("Vary"), "Accept") {
+		t.Errorf("Vary = %q, want it to include Accept", w.Header().Get("Vary"))
+	}
+	var data PageData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Name != siteName || data.WebhookCount != 2 || data.TotalClicks != 1 || !data.Counting.Webhook {
+		t.Errorf("page data = %+v, want the site's name, webhook count 2, and total clicks 1", data)
+	}
+	if len(data.Quotes) != 1 || data.Quotes[0].Quote != quote.Quote || len(data.GitHubRepos) != 1 {
+		t.Errorf("page data quotes, repos = %+v, %+v; want the saved quote and repo", data.Quotes, data.GitHubRepos)
+	}
+	// Only the HTML page counted as a view
+	if data.PageViewCount != 1 {
+		t.Errorf("pageViewCount = %d, want 1", data.PageViewCount)
+	}
+}
+
+func BenchmarkHomeHandler(b *testing.B) {
+	setGitHubRepos(b, []GitHubRepo{{Name: "site", StargazersCount: 3}})
+	h := newSlowHomeServer(b, time.Millisecond).routes()
+
+	for b.Loop() {
+		if w := serveRequest(h, http.MethodGet, "/", "", ""); w.Code != http.StatusOK {
+			b.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+}
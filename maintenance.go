@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maintenanceRetryAfter is the Retry-After value (in seconds) sent while in maintenance mode
+const maintenanceRetryAfter = "300"
+
+// defaultMaintenanceMessage is shown when maintenance mode is enabled without a message
+const defaultMaintenanceMessage = "The site is down for maintenance. Please check back soon."
+
+// MaintenanceStatus represents whether the site is in maintenance mode
+type MaintenanceStatus struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// setMaintenance switches maintenance mode, closing WebSocket connections and counter streams
+// when it is enabled
+func (s *Server) setMaintenance(status MaintenanceStatus) {
+	if status.Message == "" {
+		status.Message = defaultMaintenanceMessage
+	}
+	s.maintenance.Store(&status)
+
+	if status.Enabled {
+		componentLogger("maintenance").Info("maintenance mode enabled", "message", status.Message)
+		s.hub.CloseAll(status.Message)
+	} else {
+		componentLogger("maintenance").Info("maintenance mode disabled")
+	}
+}
+
+// getMaintenance returns the current maintenance status
+func (s *Server) getMaintenance() MaintenanceStatus {
+	if status := s.maintenance.Load(); status != nil {
+		return *status
+	}
+	return MaintenanceStatus{}
+}
+
+// isMaintenanceExempt reports whether a path stays available during maintenance
+func isMaintenanceExempt(path string) bool {
+	return path == "/admin" ||
+		path == "/admin.json" ||
+		strings.HasPrefix(path, "/admin/") ||
+		path == "/healthz" ||
+		path == "/readyz" ||
+		strings.HasPrefix(path, "/static/")
+}
+
+// maintenanceMiddleware serves the maintenance page for all non-exempt routes while maintenance mode is on.
+// It never touches MongoDB so it keeps working while the database is being migrated.
+func (s *Server) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := s.getMaintenance()
+		if !status.Enabled || isMaintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := s.renderTemplate(w, "maintenance.html", status); err != nil {
+			s.log(r.Context(), "maintenance").Error("rendering maintenance page", "err", err)
+		}
+	})
+}
+
+// getMaintenanceHandler returns the maintenance status
+func (s *Server) getMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, s.getMaintenance())
+}
+
+// setMaintenanceHandler updates the maintenance status
+func (s *Server) setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var status MaintenanceStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	s.setMaintenance(status)
+	s.logAdminAction(r, "maintenance.update", fmt.Sprintf("enabled=%t", status.Enabled), true)
+
+	s.getMaintenanceHandler(w, r)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaintenanceModeIsPerServer(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	first.setMaintenance(MaintenanceStatus{Enabled: true})
+
+	w := serveRequest(first.handler(), http.MethodGet, "/api/v1/stats", "", "")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != maintenanceRetryAfter {
+		t.Errorf("stats during maintenance = %d, Retry-After %q; want %d, %s", w.Code, w.Header().Get("Retry-After"), http.StatusServiceUnavailable, maintenanceRetryAfter)
+	}
+	if status := first.getMaintenance(); status.Message != defaultMaintenanceMessage {
+		t.Errorf("maintenance message = %q, want the default", status.Message)
+	}
+	if second.getMaintenance().Enabled {
+		t.Error("maintenance mode is on for a server it wasn't switched on for")
+	}
+	if w := serveRequest(second.handler(), http.MethodGet, "/api/v1/stats", "", ""); w.Code != http.StatusOK {
+		t.Errorf("stats on the other server = %d, want %d", w.Code, http.StatusOK)
+	}
+}
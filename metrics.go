@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Rate limiter outcomes recorded in rateLimitDecisions
+const (
+	rateLimitAllowed  = "allowed"
+	rateLimitLimited  = "limited"
+	rateLimitBypassed = "bypassed"
+)
+
+// rateLimitDecisions counts requests seen by the rate limiter by outcome, so traffic
+// that skips the limiter through the allowlist or a bypass token is visible too
+var rateLimitDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limit_requests_total",
+	Help: "Requests checked by the rate limiter, by result (allowed, limited, or bypassed).",
+}, []string{"result"})
+
+// rateLimitRejections counts requests refused with 429 by route pattern, to show whether a
+// limit is too tight or a route is under attack
+var rateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limit_rejections_total",
+	Help: "Requests rejected by the rate limiter, by route pattern.",
+}, []string{"route"})
+
+// hubBroadcastsDropped counts counter updates dropped because the hub's broadcast buffer was
+// full. A few are harmless; a steady rate means WS_BROADCAST_BUFFER is too small.
+var hubBroadcastsDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "websocket_broadcasts_dropped_total",
+	Help: "Counter updates dropped because the WebSocket hub's broadcast buffer was full.",
+})
+
+// storeBreakerState is the storage circuit breaker's state: 0 closed, 1 half-open, or 2 open
+// while the site is read-only
+var storeBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "store_circuit_breaker_state",
+	Help: "Storage circuit breaker state: 0 closed, 1 half-open, 2 open.",
+})
+
+// rateLimitRoute returns the route pattern r matched, such as "POST /quote", for labelling
+// metrics. The pattern rather than the path keeps the number of labels bounded.
+func rateLimitRoute(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	return r.Pattern
+}
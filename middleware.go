@@ -1,52 +1,151 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net"
 	"net/http"
+	"net/netip"
+	"regexp"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/gorilla/websocket"
 	"golang.org/x/time/rate"
 )
 
+// maxFormBytes is the largest request body accepted by form handlers
+const maxFormBytes = 64 << 10 // 64KB
+
+// requestIDPattern matches request IDs accepted from upstream proxies
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDKey is the context key holding the request ID
+type requestIDKey struct{}
+
 var (
-	limiters = make(map[string]*rate.Limiter)
+	limiters = make(map[string]*memoryLimiter)
 	mu       sync.Mutex
 )
 
-// getIPAddress extracts the real IP address from the request
-func getIPAddress(r *http.Request) string {
-	// Check X-Forwarded-For header (used by proxies/load balancers)
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		if ip, _, err := net.SplitHostPort(forwarded); err == nil {
-			return ip
+// Limiter decides whether a single request may proceed
+type Limiter interface {
+	Allow() bool
+}
+
+// getIPAddress returns the client's IP address. The forwarding headers are only read when the
+// request comes from a trusted proxy, as anyone else can send them. Each proxy appends the
+// address it received the request from to X-Forwarded-For, so the client is the right-most
+// address that isn't a trusted proxy; addresses to the left of it are whatever the client sent.
+func (s *Server) getIPAddress(r *http.Request) string {
+	remote, err := parseHostAddr(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if !s.isTrustedProxy(remote) {
+		return remote.String()
+	}
+
+	if hops := forwardedHops(r.Header); len(hops) > 0 {
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := parseHostAddr(hops[i])
+			if err != nil {
+				// Trusted proxies only append valid addresses, so this was sent by the client
+				break
+			}
+			client = hop
+			if !s.isTrustedProxy(hop) {
+				break
+			}
 		}
-		return forwarded
+		return client.String()
 	}
 
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+	if realIP, err := parseHostAddr(r.Header.Get("X-Real-IP")); err == nil {
+		return realIP.String()
 	}
+	return remote.String()
+}
 
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+// forwardedHops returns the addresses in every X-Forwarded-For header, in order
+func forwardedHops(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseHostAddr parses an IP address, with or without a port, unmapping IPv4-mapped IPv6
+// addresses so they match IPv4 ranges
+func parseHostAddr(hostport string) (netip.Addr, error) {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
 	if err != nil {
-		return r.RemoteAddr
+		return netip.Addr{}, err
+	}
+	return addr.Unmap().WithZone(""), nil
+}
+
+// isTrustedProxy reports whether addr is in one of the trusted proxy ranges
+func (s *Server) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLimiter returns a rate limiter for the given IP, rate, and burst using the configured backend.
+// The mongo backend counts fixed windows, so it enforces the rate but not the burst.
+func (s *Server) getLimiter(ip string, requestsPerMinute, burst int) Limiter {
+	if s.config.RateLimitBackend == "mongo" {
+		return &mongoLimiter{coll: s.db.Collection(s.config.Collections.RateLimits), key: ip, requestsPerMinute: requestsPerMinute}
 	}
-	return ip
+	return getMemoryLimiter(ip, requestsPerMinute, burst)
 }
 
-// getLimiter returns a rate limiter for the given IP and limit
-func getLimiter(ip string, requestsPerMinute int) *rate.Limiter {
+// memoryLimiter is an in-memory token bucket for one IP that counts its rejected requests
+type memoryLimiter struct {
+	*rate.Limiter
+	requestsPerMinute int
+	burst             int
+	violations        atomic.Int64
+}
+
+// Allow reports whether a request may proceed, counting it as a violation if not
+func (l *memoryLimiter) Allow() bool {
+	if l.Limiter.Allow() {
+		return true
+	}
+	l.violations.Add(1)
+	return false
+}
+
+// getMemoryLimiter returns the in-memory rate limiter for the given IP, rate, and burst
+func getMemoryLimiter(ip string, requestsPerMinute, burst int) *memoryLimiter {
 	mu.Lock()
 	defer mu.Unlock()
 
 	limiter, exists := limiters[ip]
 	if !exists {
-		limiter = rate.NewLimiter(rate.Limit(requestsPerMinute)/60, requestsPerMinute)
+		limiter = &memoryLimiter{
+			Limiter:           rate.NewLimiter(rate.Limit(requestsPerMinute)/60, burst),
+			requestsPerMinute: requestsPerMinute,
+			burst:             burst,
+		}
 		limiters[ip] = limiter
 
 		// Clean up old limiters periodically (optional, prevents memory leak)
@@ -66,17 +165,172 @@ func getLimiter(ip string, requestsPerMinute int) *rate.Limiter {
 	return limiter
 }
 
-// rateLimitMiddleware wraps a handler with rate limiting
-func rateLimitMiddleware(next http.HandlerFunc, requestsPerMinute int) http.HandlerFunc {
+// rateLimitMiddleware wraps a handler with rate limiting, per API token for requests that carry
+// one and per IP otherwise
+func (s *Server) rateLimitMiddleware(next http.HandlerFunc, requestsPerMinute, burst int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ip := getIPAddress(r)
-		limiter := getLimiter(ip, requestsPerMinute)
+		if s.bypassesRateLimit(r) {
+			rateLimitDecisions.WithLabelValues(rateLimitBypassed).Inc()
+			next(w, r)
+			return
+		}
+
+		key, requestsPerMinute, burst := s.rateLimitKey(r, requestsPerMinute, burst)
+		if !s.allowRequest(r, "", key, requestsPerMinute, burst) {
+			rateLimitDecisions.WithLabelValues(rateLimitLimited).Inc()
+			rateLimitRejections.WithLabelValues(rateLimitRoute(r)).Inc()
+			s.audit.Log(r.Context(), AuditEvent{Action: "ratelimit.exceeded", Actor: key, Resource: r.URL.Path})
+			s.respondError(w, r, http.StatusTooManyRequests, "Rate limit exceeded. Please try again later.")
+			return
+		}
 
-		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
+		rateLimitDecisions.WithLabelValues(rateLimitAllowed).Inc()
+		next(w, r)
+	}
+}
+
+// scopedRateLimitMiddleware is like rateLimitMiddleware, but counts requests against a budget of
+// their own for scope, so they don't use up a client's other limits
+func (s *Server) scopedRateLimitMiddleware(scope string, next http.HandlerFunc, requestsPerMinute int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, requestsPerMinute, burst := s.rateLimitKey(r, requestsPerMinute, requestsPerMinute)
+		if !s.allowRequest(r, scope, key, requestsPerMinute, burst) {
+			rateLimitRejections.WithLabelValues(rateLimitRoute(r)).Inc()
+			if r.Context().Value(apiVersionKey{}) != nil {
+				s.apiError(w, r, http.StatusTooManyRequests, "Rate limit exceeded. Please try again later.")
+			} else {
+				s.respondError(w, r, http.StatusTooManyRequests, "Rate limit exceeded. Please try again later.")
+			}
 			return
 		}
 
 		next(w, r)
 	}
 }
+
+// maxBytesMiddleware limits the size of the request body a handler may read
+func (s *Server) maxBytesMiddleware(next http.HandlerFunc, maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			s.respondError(w, r, http.StatusRequestEntityTooLarge, "Request body too large.")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next(w, r)
+	}
+}
+
+// requestIDMiddleware tags each request with an ID, reusing a well-formed X-Request-ID from
+// upstream proxies, and echoes it in the response so errors can be matched to logs
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recoverMiddleware turns a panicking handler into a 500 response, logged with its stack and
+// request ID, so one bad request can't take down the server. http.ErrAbortHandler is passed on
+// since it's how handlers deliberately abort a response.
+func (s *Server) recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			s.log(r.Context(), "http").Error("panic serving request", "method", r.Method, "path", r.URL.Path,
+				"ip", s.getIPAddress(r), "err", err, "stack", string(debug.Stack()))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				s.apiError(w, r, http.StatusInternalServerError, "Internal server error")
+			} else {
+				s.error500Handler(w, r)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notFoundMiddleware replaces the router's plain text 404s, for paths no route matches and files
+// missing from /static/, with the 404 page, or a JSON error under /api/. A 404 a handler writes
+// itself, like a missing quote, already says what wasn't found and is passed through.
+func (s *Server) notFoundMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The hub needs the connection itself, which the recorder doesn't offer
+		if websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &notFoundRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if !rec.notFound {
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			s.apiError(w, r, http.StatusNotFound, "Not found")
+		} else {
+			s.error404Handler(w, r)
+		}
+	})
+}
+
+// notFoundRecorder holds back a plain text 404, the kind http.NotFound writes, so
+// notFoundMiddleware can send its own response instead, and passes everything else through
+type notFoundRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
+	notFound    bool // a plain text 404 was held back, and its body is being discarded
+}
+
+func (n *notFoundRecorder) WriteHeader(status int) {
+	if n.wroteHeader {
+		return
+	}
+	n.wroteHeader = true
+	if status == http.StatusNotFound && strings.HasPrefix(n.Header().Get("Content-Type"), "text/plain") {
+		n.notFound = true
+		return
+	}
+	n.ResponseWriter.WriteHeader(status)
+}
+
+func (n *notFoundRecorder) Write(b []byte) (int, error) {
+	if !n.wroteHeader {
+		n.WriteHeader(http.StatusOK)
+	}
+	if n.notFound {
+		return len(b), nil
+	}
+	return n.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for flushing event streams
+func (n *notFoundRecorder) Unwrap() http.ResponseWriter {
+	return n.ResponseWriter
+}
+
+// newRequestID returns a random 16-character hex ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDFromContext returns the request ID stored in the context, if any
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
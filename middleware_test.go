@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// trustProxies sets the ranges s trusts as proxies
+func trustProxies(t *testing.T, s *Server, cidrs ...string) {
+	t.Helper()
+	s.config.TrustedProxies = nil
+	for _, cidr := range cidrs {
+		prefix, err := parseBanPrefix(cidr)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", cidr, err)
+		}
+		s.config.TrustedProxies = append(s.config.TrustedProxies, prefix)
+	}
+}
+
+func TestGetIPAddress(t *testing.T) {
+	s := newTestServer(t)
+	trustProxies(t, s, "10.0.0.0/8", "fd00::/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "direct IPv6", remoteAddr: "[2001:db8::1]:5000", want: "2001:db8::1"},
+		{name: "IPv4-mapped", remoteAddr: "[::ffff:203.0.113.7]:5000", want: "203.0.113.7"},
+		{name: "untrusted forwarded", remoteAddr: "203.0.113.7:5000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "untrusted real IP", remoteAddr: "203.0.113.7:5000", realIP: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:5000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forged hop", remoteAddr: "10.0.0.2:5000", forwarded: []string{"192.0.2.9, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of proxies", remoteAddr: "10.0.0.2:5000", forwarded: []string{"192.0.2.9, 198.51.100.1, 10.1.1.1"}, want: "198.51.100.1"},
+		{name: "repeated headers", remoteAddr: "10.0.0.2:5000", forwarded: []string{"192.0.2.9", "198.51.100.1, 10.1.1.1"}, want: "198.51.100.1"},
+		{name: "every hop trusted", remoteAddr: "10.0.0.2:5000", forwarded: []string{"10.3.3.3, 10.1.1.1"}, want: "10.3.3.3"},
+		{name: "garbage hop", remoteAddr: "10.0.0.2:5000", forwarded: []string{"not-an-ip, 10.1.1.1"}, want: "10.1.1.1"},
+		{name: "forwarded with port", remoteAddr: "10.0.0.2:5000", forwarded: []string{"198.51.100.1:4444"}, want: "198.51.100.1"},
+		{name: "forwarded IPv6", remoteAddr: "[fd00::2]:5000", forwarded: []string{"2001:db8::5"}, want: "2001:db8::5"},
+		{name: "trusted real IP", remoteAddr: "10.0.0.2:5000", realIP: "198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted without headers", remoteAddr: "10.0.0.2:5000", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := s.getIPAddress(r); got != tt.want {
+				t.Errorf("getIPAddress = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetIPAddressWithoutTrustedProxies(t *testing.T) {
+	s := newTestServer(t)
+	trustProxies(t, s)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Real-IP", "198.51.100.1")
+	if got := s.getIPAddress(r); got != "10.0.0.2" {
+		t.Errorf("getIPAddress = %q, want the connecting address", got)
+	}
+}
+
+func TestIsTrustedProxy(t *testing.T) {
+	s := newTestServer(t)
+	trustProxies(t, s, "10.0.0.0/8", "2001:db8::/32")
+
+	for addr, want := range map[string]bool{
+		"10.20.30.40":     true,
+		"11.0.0.1":        false,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"::ffff:10.0.0.1": false, // callers unmap addresses first
+	} {
+		if got := s.isTrustedProxy(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isTrustedProxy(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// limiterRequest is a request made at some time after a test starts, and whether it should be allowed
+type limiterRequest struct {
+	at   time.Duration
+	want bool
+}
+
+func TestLimiterBurstAndRateAreIndependent(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name              string
+		requestsPerMinute int
+		burst             int
+		requests          []limiterRequest
+	}{
+		{
+			// A slow sustained rate with room for a burst
+			name: "60rpm burst 3", requestsPerMinute: 60, burst: 3,
+			requests: []limiterRequest{
+				{0, true}, {0, true}, {0, true}, {0, false},
+				{time.Second, true}, {time.Second, false},
+				// Idling refills the bucket only up to the burst
+				{time.Minute, true}, {time.Minute, true}, {time.Minute, true}, {time.Minute, false},
+			},
+		},
+		{
+			// A fast sustained rate with no burst to speak of
+			name: "600rpm burst 1", requestsPerMinute: 600, burst: 1,
+			requests: []limiterRequest{
+				{0, true}, {0, false},
+				{100 * time.Millisecond, true}, {100 * time.Millisecond, false},
+				{time.Minute, true}, {time.Minute, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		resetRateLimiters(t)
+		limiter := getMemoryLimiter("203.0.113.1", tt.requestsPerMinute, tt.burst)
+		for i, req := range tt.requests {
+			if got := limiter.AllowN(start.Add(req.at), 1); got != req.want {
+				t.Errorf("%s: request %d at +%s allowed = %v, want %v", tt.name, i, req.at, got, req.want)
+			}
+		}
+	}
+}
+
+func TestRateLimitMiddlewareUsesConfiguredBurst(t *testing.T) {
+	resetRateLimiters(t)
+	// One request a minute, but five at once
+	const burst = 5
+	h := newTestServer(t).rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {}, 1, burst)
+
+	for i := range burst + 1 {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/quote", nil)
+		r.RemoteAddr = "203.0.113.1:1000"
+		r.Header.Set("Accept", "application/json")
+		h(w, r)
+		want := http.StatusOK
+		if i == burst {
+			want = http.StatusTooManyRequests
+		}
+		if w.Code != want {
+			t.Errorf("request %d status = %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
+
+func TestRecoverMiddlewareKeepsServingAfterPanic(t *testing.T) {
+	s := newTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	mux.HandleFunc("GET /api/v1/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(requestIDMiddleware(s.recoverMiddleware(mux)))
+	t.Cleanup(server.Close)
+
+	get := func(path, accept string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	tests := []struct {
+		path, accept, contentType string
+	}{
+		{path: "/api/v1/panic", contentType: "application/json"},
+		{path: "/panic", accept: "text/html", contentType: "text/html; charset=utf-8"},
+		{path: "/panic", accept: "application/json", contentType: "application/json"},
+	}
+	for _, tt := range tests {
+		resp := get(tt.path, tt.accept)
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("GET %s status = %d, want %d", tt.path, resp.StatusCode, http.StatusInternalServerError)
+		}
+		if got := resp.Header.Get("Content-Type"); got != tt.contentType {
+			t.Errorf("GET %s with Accept %q has Content-Type %q, want %q", tt.path, tt.accept, got, tt.contentType)
+		}
+	}
+
+	if resp := get("/ok", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /ok after panics status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestRateLimitMiddlewareGivesAPITokensMoreHeadroom(t *testing.T) {
+	resetRateLimiters(t)
+	const token = "integration-token-0123456789"
+	s := newTestServer(t)
+	s.config.APITokens, s.config.APITokenRateLimitMultiplier = []string{token}, 3
+
+	// Two requests at once, or six with an API token
+	const burst = 2
+	h := s.rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {}, 1, burst)
+	allowed := func(authorization string) int {
+		t.Helper()
+		n := 0
+		for range burst*s.config.APITokenRateLimitMultiplier + 1 {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/quote", nil)
+			r.RemoteAddr = "203.0.113.1:1000"
+			r.Header.Set("Accept", "application/json")
+			if authorization != "" {
+				r.Header.Set("Authorization", authorization)
+			}
+			if h(w, r); w.Code == http.StatusOK {
+				n++
+			}
+		}
+		return n
+	}
+
+	// The token has its own budget, so it's unaffected by the IP it shares being limited
+	if got := allowed(""); got != burst {
+		t.Errorf("anonymous requests allowed = %d, want the IP's burst of %d", got, burst)
+	}
+	if got := allowed("Bearer " + token); got != burst*3 {
+		t.Errorf("requests with an API token allowed = %d, want %d", got, burst*3)
+	}
+	// An unknown token is limited by IP, whose budget is already spent
+	if got := allowed("Bearer not-a-known-token-at-all"); got != 0 {
+		t.Errorf("requests with an unknown token allowed = %d, want 0", got)
+	}
+}
+
+func TestRateLimitRejectionsAreCountedByRoute(t *testing.T) {
+	resetRateLimiters(t)
+	const burst = 2
+	s := newTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /limited/{id}", s.rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {}, 1, burst))
+	mux.HandleFunc("POST /scoped", s.scopedRateLimitMiddleware("test", func(w http.ResponseWriter, r *http.Request) {}, burst))
+
+	limited := rateLimitRejections.WithLabelValues("POST /limited/{id}")
+	scoped := rateLimitRejections.WithLabelValues("POST /scoped")
+	limitedBefore, scopedBefore := testutil.ToFloat64(limited), testutil.ToFloat64(scoped)
+
+	// The first burst requests are allowed and the rest rejected, whichever ID they're for
+	for i := range burst + 3 {
+		serveRequest(mux, http.MethodPost, fmt.Sprintf("/limited/%d", i), "", "203.0.113.1:1000")
+		serveRequest(mux, http.MethodPost, "/scoped", "", "203.0.113.1:1000")
+	}
+
+	if got := testutil.ToFloat64(limited) - limitedBefore; got != 3 {
+		t.Errorf("rejections for POST /limited/{id} = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(scoped) - scopedBefore; got != 3 {
+		t.Errorf("rejections for POST /scoped = %v, want 3", got)
+	}
+}
+
+func TestScopedRateLimitRejectionsUseErrorResponses(t *testing.T) {
+	resetRateLimiters(t)
+	h := newTestServer(t).routes()
+	const client = "203.0.113.1:1000"
+
+	// API requests get the API's JSON error, tagged with its version
+	var w *httptest.ResponseRecorder
+	for range quoteReactionsPerMinute + 1 {
+		w = serveRequest(h, http.MethodPost, "/api/v1/quotes/missing/react", `{"emoji":"👍"}`, client)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != http.StatusTooManyRequests || body.Status != http.StatusTooManyRequests || body.Version != "v1" {
+		t.Errorf("API request past the limit = %d %+v (%v), want a v1 JSON %d", w.Code, body, err, http.StatusTooManyRequests)
+	}
+
+	// Pages get the HTML error page
+	form := url.Values{"name": {"Ada"}, "email": {"ada@example.com"}, "message": {"Hello"}}.Encode()
+	for range contactRateLimitRPM + 1 {
+		r := httptest.NewRequest(http.MethodPost, "/contact", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = client
+		w = serve(h, r)
+	}
+	if w.Code != http.StatusTooManyRequests || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("form post past the limit = %d %q, want an HTML %d", w.Code, w.Header().Get("Content-Type"), http.StatusTooManyRequests)
+	}
+}
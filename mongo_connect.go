@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// mongoDialer connects to MongoDB and checks the connection, returning a client ready for use
+type mongoDialer func(ctx context.Context) (*mongo.Client, error)
+
+// connectBackoff is the schedule of waits between MongoDB connection attempts: initial after
+// the first failure, doubling after each one after that up to max
+type connectBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	// jitter returns the wait to use in place of d, or nil to wait exactly d
+	jitter func(d time.Duration) time.Duration
+}
+
+// startupBackoff is the schedule used while connecting at startup
+var startupBackoff = connectBackoff{initial: 500 * time.Millisecond, max: 10 * time.Second, jitter: halfJitter}
+
+// delay returns how long to wait after the given failed attempt, counting from 1
+func (b connectBackoff) delay(attempt int) time.Duration {
+	d := b.initial
+	for i := 1; i < attempt && d < b.max; i++ {
+		d *= 2
+	}
+	d = min(d, b.max)
+	if b.jitter != nil {
+		d = b.jitter(d)
+	}
+	return d
+}
+
+// halfJitter returns a random wait between d/2 and d, so instances started together don't
+// retry in lockstep
+func halfJitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+// dialMongo returns a dialer that connects with opts and pings the primary, which every write
+// goes to. The ping gives up after pingTimeout rather than the driver's 30 second server
+// selection timeout, so an unreachable server is retried promptly.
+func dialMongo(opts *options.ClientOptions, pingTimeout time.Duration) mongoDialer {
+	return func(ctx context.Context) (*mongo.Client, error) {
+		client, err := mongo.Connect(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+		if err := client.Ping(pingCtx, readpref.Primary()); err != nil {
+			client.Disconnect(context.Background())
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+// connectWithRetry calls dial until it succeeds, waiting longer after each failure. Once
+// deadline has passed since the first attempt it gives up with the last error. MongoDB often
+// starts after the site under docker-compose, so a few failures at startup are expected.
+func connectWithRetry(ctx context.Context, dial mongoDialer, backoff connectBackoff, deadline time.Duration, clk clock) (*mongo.Client, error) {
+	giveUp := clk.Now().Add(deadline)
+	for attempt := 1; ; attempt++ {
+		client, err := dial(ctx)
+		if err == nil {
+			if attempt > 1 {
+				componentLogger("mongo").Info("connected to MongoDB", "attempts", attempt)
+			}
+			return client, nil
+		}
+
+		remaining := giveUp.Sub(clk.Now())
+		if remaining <= 0 {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		wait := min(backoff.delay(attempt), remaining)
+		componentLogger("mongo").Warn("connecting to MongoDB failed, retrying",
+			"attempt", attempt, "retry_in", wait, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-clk.After(wait):
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestConnectBackoffDelay(t *testing.T) {
+	b := connectBackoff{initial: 500 * time.Millisecond, max: 5 * time.Second}
+	want := []time.Duration{
+		500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+	}
+	for i, w := range want {
+		if got := b.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestHalfJitterStaysInRange(t *testing.T) {
+	for range 1000 {
+		if got := halfJitter(time.Second); got < 500*time.Millisecond || got > time.Second {
+			t.Fatalf("halfJitter(1s) = %v, want between 500ms and 1s", got)
+		}
+	}
+}
+
+// fakeDialer fails its first failures calls, then succeeds
+type fakeDialer struct {
+	failures int
+	calls    int
+}
+
+func (d *fakeDialer) dial(ctx context.Context) (*mongo.Client, error) {
+	d.calls++
+	if d.calls <= d.failures {
+		return nil, errors.New("connection refused")
+	}
+	return &mongo.Client{}, nil
+}
+
+// runConnect runs connectWithRetry against dialer on a fake clock, stepping the clock through
+// each wait, and returns the waits along with the result
+func runConnect(t *testing.T, dialer *fakeDialer, deadline time.Duration) ([]time.Duration, error) {
+	t.Helper()
+	clk := newFakeClock(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	backoff := connectBackoff{initial: time.Second, max: 8 * time.Second}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := connectWithRetry(t.Context(), dialer.dial, backoff, deadline, clk)
+		done <- err
+	}()
+
+	var waits []time.Duration
+	for {
+		select {
+		case err := <-done:
+			return waits, err
+		case wait := <-clk.waits:
+			waits = append(waits, wait.d)
+			clk.set(clk.Now().Add(wait.d))
+			wait.fire <- clk.Now()
+		case <-time.After(2 * time.Second):
+			t.Fatal("connectWithRetry neither waited nor returned")
+		}
+	}
+}
+
+func TestConnectWithRetryBacksOff(t *testing.T) {
+	dialer := &fakeDialer{failures: 5}
+	waits, err := runConnect(t, dialer, time.Minute)
+	if err != nil {
+		t.Fatalf("connectWithRetry() = %v, want success once the dialer recovers", err)
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	if !slices.Equal(waits, want) {
+		t.Errorf("waits = %v, want %v", waits, want)
+	}
+	if dialer.calls != 6 {
+		t.Errorf("dial calls = %d, want 6", dialer.calls)
+	}
+}
+
+func TestConnectWithRetryGivesUpAtDeadline(t *testing.T) {
+	dialer := &fakeDialer{failures: 100}
+	waits, err := runConnect(t, dialer, 10*time.Second)
+	if err == nil {
+		t.Fatal("connectWithRetry() = nil, want an error once the deadline passes")
+	}
+
+	// The last wait is cut short so the final attempt lands on the deadline
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 3 * time.Second}
+	if !slices.Equal(waits, want) {
+		t.Errorf("waits = %v, want %v", waits, want)
+	}
+	if dialer.calls != 5 {
+		t.Errorf("dial calls = %d, want 5", dialer.calls)
+	}
+}
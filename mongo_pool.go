@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoPoolSettings controls the MongoDB driver's connection pool
+type MongoPoolSettings struct {
+	MaxPoolSize    int
+	MinPoolSize    int
+	MaxConnIdle    time.Duration
+	ConnectTimeout time.Duration
+}
+
+// loadMongoPoolSettings reads the pool settings, falling back to defaults for any that are
+// missing. Sizes that aren't positive whole numbers, and a minimum set above the maximum, are
+// problems. The default minimum is lowered to fit under a small maximum rather than clashing
+// with it, so setting only MONGO_MAX_POOL_SIZE always works.
+func loadMongoPoolSettings(env *envReader) MongoPoolSettings {
+	maxPoolSize := env.int("MONGO_MAX_POOL_SIZE", 100)
+	settings := MongoPoolSettings{
+		MaxPoolSize:    maxPoolSize,
+		MinPoolSize:    env.int("MONGO_MIN_POOL_SIZE", min(10, maxPoolSize)),
+		MaxConnIdle:    env.seconds("MONGO_MAX_CONN_IDLE_TIME_SECONDS", 300),
+		ConnectTimeout: env.seconds("MONGO_CONNECT_TIMEOUT_SECONDS", 10),
+	}
+
+	if settings.MinPoolSize > settings.MaxPoolSize {
+		env.problem("MONGO_MIN_POOL_SIZE", "%d is above MONGO_MAX_POOL_SIZE (%d)",
+			settings.MinPoolSize, settings.MaxPoolSize)
+	}
+
+	return settings
+}
+
+// apply sets the pool settings on the client options
+func (s MongoPoolSettings) apply(clientOptions *options.ClientOptions) *options.ClientOptions {
+	return clientOptions.
+		SetMaxPoolSize(uint64(s.MaxPoolSize)).
+		SetMinPoolSize(uint64(s.MinPoolSize)).
+		SetMaxConnIdleTime(s.MaxConnIdle).
+		SetConnectTimeout(s.ConnectTimeout)
+}
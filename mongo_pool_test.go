@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLoadMongoPoolSettingsBounds(t *testing.T) {
+	tests := []struct {
+		min, max string
+		wantErr  string
+	}{
+		{"10", "100", ""},
+		{"10", "10", ""},
+		{"", "5", ""},
+		{"", "", ""},
+		{"50", "10", "MONGO_MIN_POOL_SIZE"},
+		{"", "lots", "MONGO_MAX_POOL_SIZE"},
+		{"0", "", "MONGO_MIN_POOL_SIZE"},
+		{"-5", "", "MONGO_MIN_POOL_SIZE"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("MONGO_MIN_POOL_SIZE", tt.min)
+		t.Setenv("MONGO_MAX_POOL_SIZE", tt.max)
+		env := &envReader{}
+		loadMongoPoolSettings(env)
+		err := errors.Join(env.problems...)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("loadMongoPoolSettings(min %q, max %q) = %v, want no error", tt.min, tt.max, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("loadMongoPoolSettings(min %q, max %q) = %v, want a %s error", tt.min, tt.max, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadConfigReportsInvalidPoolSizes(t *testing.T) {
+	t.Setenv("MONGO_MAX_POOL_SIZE", "many")
+	t.Setenv("MONGO_MIN_POOL_SIZE", "0")
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "MONGO_MAX_POOL_SIZE") || !strings.Contains(err.Error(), "MONGO_MIN_POOL_SIZE") {
+		t.Errorf("LoadConfig() = %v, want problems with both pool sizes", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// loadWriteConcern reads MONGO_WRITE_CONCERN, which is "majority", "w1", or a number of nodes,
+// and MONGO_WRITE_CONCERN_TIMEOUT_MS. It returns nil when no write concern is set, leaving the
+// driver's default in place.
+//
+// With a replica set, majority is what keeps counters correct: an increment acknowledged by the
+// primary alone can be rolled back if that primary fails before a secondary copies it, so a
+// count the visitor saw, and that was broadcast to everyone, would quietly go backwards. Waiting
+// for a majority makes every acknowledged write survive a failover, at the cost of a round trip
+// to a secondary on every write, which adds latency to each click and quote. A single node gains
+// nothing from majority, so w1 is fine there.
+func loadWriteConcern(env *envReader) *writeconcern.WriteConcern {
+	value := env.string("MONGO_WRITE_CONCERN", "")
+	timeout := time.Duration(env.int("MONGO_WRITE_CONCERN_TIMEOUT_MS", 5000)) * time.Millisecond
+	if value == "" {
+		return nil
+	}
+
+	var w any
+	switch value {
+	case "majority":
+		w = "majority"
+	case "w1":
+		w = 1
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			env.problem("MONGO_WRITE_CONCERN", "%q must be majority, w1, or a number of nodes", value)
+			return nil
+		}
+		w = n
+	}
+	return &writeconcern.WriteConcern{W: w, WTimeout: timeout}
+}
+
+// describeWriteConcern returns the write concern's settings for logging
+func describeWriteConcern(wc *writeconcern.WriteConcern) []any {
+	if wc == nil {
+		return []any{"w", "server default"}
+	}
+	return []any{"w", wc.W, "wtimeout", wc.WTimeout}
+}
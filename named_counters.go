@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxCounterNameLength is the longest allowed counter name
+const maxCounterNameLength = 64
+
+// counterNamePattern is the allowed charset for counter names (after lowercasing)
+var counterNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// builtinCounterIDs maps normalized names to the IDs of the counters managed by the site
+// itself, which predate name normalization and so aren't all lowercase
+var builtinCounterIDs = map[string]string{
+	"webhook":     "webhook",
+	"pageviews":   "pageviews",
+	"totalclicks": "totalClicks",
+}
+
+var (
+	errCounterNameEmpty   = errors.New("counter name cannot be empty")
+	errCounterNameTooLong = errors.New("counter name is too long")
+	errCounterNameInvalid = errors.New("counter name may only contain letters, numbers, '-' and '_'")
+)
+
+// normalizeCounterName trims and lowercases a counter name and validates its length and charset,
+// so that near-duplicates like "Likes" and "likes" refer to the same counter
+func normalizeCounterName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if name == "" {
+		return "", errCounterNameEmpty
+	}
+	if len(name) > maxCounterNameLength {
+		return "", errCounterNameTooLong
+	}
+	if !counterNamePattern.MatchString(name) {
+		return "", errCounterNameInvalid
+	}
+
+	if id, ok := builtinCounterIDs[name]; ok {
+		return id, nil
+	}
+	return name, nil
+}
+
+// isBuiltinCounter reports whether the counter is managed by the site rather than the counters API
+func isBuiltinCounter(id string) bool {
+	_, ok := builtinCounterIDs[strings.ToLower(id)]
+	return ok
+}
+
+var errBuiltinCounter = errors.New("built-in counters can't be changed through the counters API")
+
+// errCounterExists is returned when creating a counter whose ID is already taken
+var errCounterExists = errors.New("counter already exists")
+
+// maxCounterDelta is the largest change a single increment request may make
+const maxCounterDelta = 1000
+
+// validateCounterDelta checks that a requested counter change is non-zero and within bounds
+func validateCounterDelta(delta int) error {
+	if delta == 0 || delta > maxCounterDelta || delta < -maxCounterDelta {
+		return fmt.Errorf("delta must be between -%d and %d and not zero", maxCounterDelta, maxCounterDelta)
+	}
+	return nil
+}
+
+// adjustCounter atomically adds delta to an existing counter, stopping at zero, and returns the
+// updated counter. It returns mongo.ErrNoDocuments if the counter doesn't exist and
+// errBuiltinCounter for built-in counters.
+func (s *Server) adjustCounter(ctx context.Context, id string, delta int) (Counter, error) {
+	if isBuiltinCounter(id) {
+		return Counter{}, errBuiltinCounter
+	}
+	return s.counters.AdjustCounter(ctx, id, delta)
+}
+
+// listCountersHandler lists all counters
+func (s *Server) listCountersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	counters, err := s.listCounters(ctx, "")
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "list counters", err), "Error listing counters")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, s.config.Counting.visibleCounters(counters))
+}
+
+// listNamespacesHandler lists the namespaces that have counters
+func (s *Server) listNamespacesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	namespaces, err := s.counters.ListNamespaces(ctx)
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "list namespaces", err), "Error listing namespaces")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, namespaces)
+}
+
+// namespaceCountersHandler lists the counters in a namespace, which is empty for unknown namespaces
+func (s *Server) namespaceCountersHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, err := normalizeCounterName(r.PathValue("ns"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid namespace: "+err.Error())
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	counters, err := s.listCounters(ctx, namespace)
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "list namespace counters", err), "Error listing counters")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, counters)
+}
+
+// createCounterHandler creates a new counter, optionally in a namespace
+func (s *Server) createCounterHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	name, err := normalizeCounterName(body.Name)
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Namespaces follow the same rules as names
+	var namespace string
+	if strings.TrimSpace(body.Namespace) != "" {
+		namespace, err = normalizeCounterName(body.Namespace)
+		if err != nil {
+			s.apiError(w, r, http.StatusBadRequest, "Invalid namespace: "+err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+
+	counter := Counter{ID: name, Count: 0, Namespace: namespace}
+	err = s.counters.CreateCounter(ctx, counter)
+	if errors.Is(err, errCounterExists) {
+		s.apiError(w, r, http.StatusConflict, "Counter already exists")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "create counter", err), "Error creating counter")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, counter)
+}
+
+// cloneCounterHandler copies a counter's current value into a new counter, so it can be
+// archived before the original is reset
+func (s *Server) cloneCounterHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := normalizeCounterName(r.PathValue("name"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var body struct {
+		NewID string `json:"newId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	newID, err := normalizeCounterName(body.NewID)
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+
+	source, err := s.getCounter(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) || s.config.Counting.hides(name) {
+		s.apiError(w, r, http.StatusNotFound, "Counter not found")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "get counter", err), "Error getting counter")
+		return
+	}
+
+	clone := Counter{ID: newID, Count: source.Count, Namespace: source.Namespace}
+	err = s.counters.CreateCounter(ctx, clone)
+	if errors.Is(err, errCounterExists) {
+		s.apiError(w, r, http.StatusConflict, "Counter already exists")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "clone counter", err), "Error cloning counter")
+		return
+	}
+
+	s.audit.Log(r.Context(), AuditEvent{
+		Action:   "counter.clone",
+		Actor:    s.getIPAddress(r),
+		Resource: name + " -> " + newID,
+		Success:  true,
+	})
+	respondJSON(w, http.StatusCreated, clone)
+}
+
+// maxMergeSources is the most counters a single merge may read
+const maxMergeSources = 20
+
+// errMergeDestMissing is returned when the destination of a merge doesn't exist
+var errMergeDestMissing = errors.New("destination counter doesn't exist")
+
+// mergeSourceMissingError is returned when one of the sources of a merge doesn't exist
+type mergeSourceMissingError struct {
+	id string
+}
+
+func (e *mergeSourceMissingError) Error() string {
+	return fmt.Sprintf("counter %q doesn't exist", e.id)
+}
+
+// mergeCountersHandler adds the values of two or more counters to an existing destination counter
+func (s *Server) mergeCountersHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Sources []string `json:"sources"`
+		Dest    string   `json:"dest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var sources []string
+	for _, name := range body.Sources {
+		id, err := normalizeCounterName(name)
+		if err != nil {
+			s.apiError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if slices.Contains(sources, id) {
+			s.apiError(w, r, http.StatusBadRequest, "Sources must be different counters")
+			return
+		}
+		sources = append(sources, id)
+	}
+	if len(sources) < 2 || len(sources) > maxMergeSources {
+		s.apiError(w, r, http.StatusBadRequest, fmt.Sprintf("Merges need between 2 and %d sources", maxMergeSources))
+		return
+	}
+
+	dest, err := normalizeCounterName(body.Dest)
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isBuiltinCounter(dest) {
+		s.apiError(w, r, http.StatusForbidden, "Built-in counters can't be changed through the counters API")
+		return
+	}
+	if slices.Contains(sources, dest) {
+		s.apiError(w, r, http.StatusBadRequest, "The destination can't be one of the sources")
+		return
+	}
+	for _, id := range sources {
+		if s.config.Counting.hides(id) {
+			s.apiError(w, r, http.StatusNotFound, "Counter not found")
+			return
+		}
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+
+	merged, err := s.counters.MergeCounters(ctx, sources, dest)
+	var sourceMissing *mergeSourceMissingError
+	if errors.As(err, &sourceMissing) {
+		s.apiError(w, r, http.StatusNotFound, "Counter not found: "+sourceMissing.id)
+		return
+	}
+	if errors.Is(err, errMergeDestMissing) {
+		s.apiError(w, r, http.StatusConflict, "Destination counter doesn't exist, create it first")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "merge counters", err), "Error merging counters")
+		return
+	}
+
+	s.audit.Log(r.Context(), AuditEvent{
+		Action:   "counter.merge",
+		Actor:    s.getIPAddress(r),
+		Resource: strings.Join(sources, " + ") + " -> " + dest,
+		Success:  true,
+	})
+	respondJSON(w, http.StatusOK, merged)
+}
+
+// namedCounterHandler returns a single counter by name
+func (s *Server) namedCounterHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := normalizeCounterName(r.PathValue("name"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	counter, err := s.getCounter(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) || s.config.Counting.hides(name) {
+		s.apiError(w, r, http.StatusNotFound, "Counter not found")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "get counter", err), "Error getting counter")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, counter)
+}
+
+// HighWaterMark is the highest value a counter has reached
+type HighWaterMark struct {
+	MaxSeen   int       `json:"maxSeen"`
+	MaxSeenAt time.Time `json:"maxSeenAt,omitzero"`
+}
+
+// highWaterHandler returns a counter's high-water mark. Counters last incremented before marks
+// were tracked report their current count, without a time.
+func (s *Server) highWaterHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := normalizeCounterName(r.PathValue("name"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	counter, err := s.getCounter(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) || s.config.Counting.hides(name) {
+		s.apiError(w, r, http.StatusNotFound, "Counter not found")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "get counter", err), "Error getting counter")
+		return
+	}
+
+	mark := HighWaterMark{MaxSeen: counter.MaxSeen, MaxSeenAt: counter.MaxSeenAt}
+	if counter.Count > mark.MaxSeen {
+		mark = HighWaterMark{MaxSeen: counter.Count}
+	}
+	respondJSON(w, http.StatusOK, mark)
+}
+
+// namedCounterIncrementHandler increments a counter by name
+func (s *Server) namedCounterIncrementHandler(w http.ResponseWriter, r *http.Request) {
+	s.adjustNamedCounter(w, r, 1)
+}
+
+// namedCounterDecrementHandler decrements a counter by name, stopping at zero
+func (s *Server) namedCounterDecrementHandler(w http.ResponseWriter, r *http.Request) {
+	s.adjustNamedCounter(w, r, -1)
+}
+
+// counterRateLimit limits changes to named counters, which share one budget per client whatever
+// the counter
+func (s *Server) counterRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return s.scopedRateLimitMiddleware("counters", next, s.config.CounterRateLimitRPM)
+}
+
+// namedCounterDeltaIncrementHandler adds the delta from a {"delta": n} body to a counter by name,
+// incrementing by one when no body is sent
+func (s *Server) namedCounterDeltaIncrementHandler(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Delta *int `json:"delta"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	delta := 1
+	if body.Delta != nil {
+		delta = *body.Delta
+	}
+	if err := validateCounterDelta(delta); err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.adjustNamedCounter(w, r, delta)
+}
+
+// adjustNamedCounter adds delta to the counter named in the path, without taking it below zero,
+// and returns the updated counter
+func (s *Server) adjustNamedCounter(w http.ResponseWriter, r *http.Request, delta int) {
+	name, err := normalizeCounterName(r.PathValue("name"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+
+	counter, err := s.adjustCounter(ctx, name, delta)
+	if errors.Is(err, errBuiltinCounter) {
+		s.apiError(w, r, http.StatusForbidden, "Built-in counters can't be changed through the counters API")
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		s.apiError(w, r, http.StatusNotFound, "Counter not found")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "adjust counter", err), "Error updating counter")
+		return
+	}
+	s.recordCounterEvent(ctx, name, delta)
+
+	respondJSON(w, http.StatusOK, counter)
+}
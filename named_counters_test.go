@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeCounterName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "likes", want: "likes"},
+		{name: "Likes", want: "likes"},
+		{name: "LIKES", want: "likes"},
+		{name: "  likes\t", want: "likes"},
+		{name: "blog-post_42", want: "blog-post_42"},
+		{name: "TotalClicks", want: "totalClicks"},
+		{name: "totalclicks", want: "totalClicks"},
+		{name: "WEBHOOK", want: "webhook"},
+		{name: strings.Repeat("a", maxCounterNameLength), want: strings.Repeat("a", maxCounterNameLength)},
+		{name: " " + strings.Repeat("A", maxCounterNameLength) + " ", want: strings.Repeat("a", maxCounterNameLength)},
+	}
+	for _, tt := range tests {
+		got, err := normalizeCounterName(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeCounterName(%q) = %q, %v; want %q, nil", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeCounterNameRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		want error
+	}{
+		{name: "", want: errCounterNameEmpty},
+		{name: "   ", want: errCounterNameEmpty},
+		{name: strings.Repeat("a", maxCounterNameLength+1), want: errCounterNameTooLong},
+		{name: strings.Repeat("é", maxCounterNameLength), want: errCounterNameTooLong},
+		{name: "two words", want: errCounterNameInvalid},
+		{name: "dots.are.out", want: errCounterNameInvalid},
+		{name: "path/../escape", want: errCounterNameInvalid},
+		{name: "$where", want: errCounterNameInvalid},
+		{name: "naïve", want: errCounterNameInvalid},
+		{name: "emoji🙂", want: errCounterNameInvalid},
+		{name: "null\x00byte", want: errCounterNameInvalid},
+	}
+	for _, tt := range tests {
+		if got, err := normalizeCounterName(tt.name); !errors.Is(err, tt.want) {
+			t.Errorf("normalizeCounterName(%q) = %q, %v; want error %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestNamedCounterWritesAreRateLimited(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	s.config.CounterRateLimitRPM = 3
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	increment := s.counterRateLimit(ok)
+	decrement := s.counterRateLimit(ok)
+	create := s.scopedRateLimitMiddleware("counters.create", ok, 1)
+	const client, other = "203.0.113.1:1000", "203.0.113.2:1000"
+
+	if w := serveRequest(create, http.MethodPost, "/api/counters", `{"name":"first"}`, client); w.Code != http.StatusOK {
+		t.Fatalf("first create status = %d: %s", w.Code, w.Body)
+	}
+	if w := serveRequest(create, http.MethodPost, "/api/counters", `{"name":"second"}`, client); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second create status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// Increments and decrements share a budget, separate from creating
+	for _, h := range []http.HandlerFunc{increment, increment, decrement} {
+		if w := serveRequest(h, http.MethodPost, "/api/counters/likes/increment", "", client); w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+	if w := serveRequest(increment, http.MethodPost, "/api/counters/likes/increment", "", client); w.Code != http.StatusTooManyRequests {
+		t.Errorf("increment past the burst: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := serveRequest(increment, http.MethodPost, "/api/counters/likes/increment", "", other); w.Code != http.StatusOK {
+		t.Errorf("increment from another client: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestCloneCounterRequiresAdmin(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+
+	if w := serveRequest(h, http.MethodPost, "/api/v1/counters/webhook/clone", `{"newId":"webhook-2024"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("clone without a token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	r := newJSONRequest(http.MethodPost, "/api/v1/counters/webhook/clone", `{"newId":"webhook-2024"}`)
+	r.Header.Set("Authorization", "Bearer wrong")
+	if w := serve(h, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("clone with the wrong token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestMergeCountersValidation(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "one source", body: `{"sources":["webhook"],"dest":"total"}`, want: http.StatusBadRequest},
+		{name: "repeated source", body: `{"sources":["webhook","Webhook"],"dest":"total"}`, want: http.StatusBadRequest},
+		{name: "invalid source", body: `{"sources":["webhook","a b"],"dest":"total"}`, want: http.StatusBadRequest},
+		{name: "destination is a source", body: `{"sources":["likes","total"],"dest":"total"}`, want: http.StatusBadRequest},
+		{name: "built-in destination", body: `{"sources":["likes","hearts"],"dest":"webhook"}`, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := newJSONRequest(http.MethodPost, "/api/v1/counters/merge", tt.body)
+		r.Header.Set("Authorization", "Bearer secret")
+		if w := serve(h, r); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	body := `{"sources":["likes","hearts"],"dest":"total"}`
+	if w := serveRequest(h, http.MethodPost, "/api/v1/counters/merge", body, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("merge without a token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestMergeCounters(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+	ctx := context.Background()
+	for id, count := range map[string]int{"likes": 3, "hearts": 2, "total": 1} {
+		if err := s.counters.CreateCounter(ctx, Counter{ID: id, Count: count}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	merge := func(body string) *httptest.ResponseRecorder {
+		r := newJSONRequest(http.MethodPost, "/api/v1/counters/merge", body)
+		r.Header.Set("Authorization", "Bearer secret")
+		return serve(h, r)
+	}
+
+	w := merge(`{"sources":["likes","Hearts"],"dest":"total"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var merged Counter
+	if err := json.NewDecoder(w.Body).Decode(&merged); err != nil {
+		t.Fatal(err)
+	}
+	if merged.ID != "total" || merged.Count != 6 {
+		t.Errorf("merged counter = %+v, want total at 6", merged)
+	}
+	// The sources are left as they were
+	for id, want := range map[string]int{"likes": 3, "hearts": 2} {
+		if counter, err := s.counters.GetCounter(ctx, id); err != nil || counter.Count != want {
+			t.Errorf("source %s after merging = %+v, %v; want count %d", id, counter, err, want)
+		}
+	}
+
+	// A failed merge changes nothing
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "missing source", body: `{"sources":["likes","missing"],"dest":"total"}`, want: http.StatusNotFound},
+		{name: "missing destination", body: `{"sources":["likes","hearts"],"dest":"missing"}`, want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if w := merge(tt.body); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+	if counter, err := s.counters.GetCounter(ctx, "total"); err != nil || counter.Count != 6 {
+		t.Errorf("total after the failed merges = %+v, %v; want count 6", counter, err)
+	}
+	if _, err := s.counters.GetCounter(ctx, "missing"); err == nil {
+		t.Error("a failed merge created its missing destination")
+	}
+}
+
+func TestInvalidNamespacesAreRejected(t *testing.T) {
+	resetRateLimiters(t)
+	h := newTestServer(t).routes()
+
+	if w := serveRequest(h, http.MethodPost, "/api/v1/counters", `{"name":"likes","namespace":"my blog"}`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("create with an invalid namespace: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := serveRequest(h, http.MethodGet, "/api/v1/namespaces/my%20blog/counters", "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("list an invalid namespace: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// testCountersAreListedByNamespace checks listing counters and namespaces against s's store
+func testCountersAreListedByNamespace(t *testing.T, s *Server) {
+	resetRateLimiters(t)
+	h := s.routes()
+
+	for _, body := range []string{
+		`{"name":"likes","namespace":"Blog"}`,
+		`{"name":"shares","namespace":"blog"}`,
+		`{"name":"signups","namespace":"shop"}`,
+		`{"name":"visits"}`,
+	} {
+		if w := serveRequest(h, http.MethodPost, "/api/v1/counters", body, ""); w.Code != http.StatusCreated {
+			t.Fatalf("create %s: status = %d, want %d", body, w.Code, http.StatusCreated)
+		}
+	}
+
+	if w := serveRequest(h, http.MethodGet, "/api/v1/namespaces", "", ""); strings.TrimSpace(w.Body.String()) != `["blog","shop"]` {
+		t.Errorf("GET /api/v1/namespaces = %s, want [\"blog\",\"shop\"]", w.Body)
+	}
+	want := `[{"id":"likes","count":0,"maxSeen":0,"namespace":"blog"},{"id":"shares","count":0,"maxSeen":0,"namespace":"blog"}]`
+	if w := serveRequest(h, http.MethodGet, "/api/v1/namespaces/blog/counters", "", ""); strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("GET /api/v1/namespaces/blog/counters = %s, want %s", w.Body, want)
+	}
+}
+
+func TestCountersAreListedByNamespace(t *testing.T) {
+	testCountersAreListedByNamespace(t, newTestServer(t))
+}
+
+func TestCountersAreListedByNamespaceMongo(t *testing.T) {
+	testCountersAreListedByNamespace(t, newMongoTestServer(t))
+}
+
+func TestHighWaterMarkSurvivesDecrements(t *testing.T) {
+	s := newTestServer(t)
+	h := s.routes()
+	for _, target := range []string{"/increment", "/increment", "/increment", "/decrement"} {
+		if w := serveRequest(h, http.MethodPost, target, "", ""); w.Code != http.StatusOK {
+			t.Fatalf("POST %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+	}
+
+	w := serveRequest(h, http.MethodGet, "/api/v1/counters/webhook/highwater", "", "")
+	var mark HighWaterMark
+	if err := json.Unmarshal(w.Body.Bytes(), &mark); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if mark.MaxSeen != 3 || mark.MaxSeenAt.IsZero() {
+		t.Errorf("high-water mark = %+v, want 3 with a time", mark)
+	}
+
+	if w := serveRequest(h, http.MethodGet, "/api/v1/counters/missing/highwater", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("high-water mark of a missing counter: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCounterVelocity(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.counters.InitCounters(ctx, "webhook")
+	now := time.Now()
+	for _, event := range []CounterEvent{
+		{CounterID: "webhook", Delta: 1, Timestamp: now.Add(-4 * time.Minute)},
+		{CounterID: "webhook", Delta: 5, Timestamp: now.Add(-2 * time.Minute)},
+		{CounterID: "webhook", Delta: -1, Timestamp: now.Add(-time.Minute)},
+		{CounterID: "webhook", Delta: 100, Timestamp: now.Add(-10 * time.Minute)}, // outside the window
+		{CounterID: "other", Delta: 100, Timestamp: now},
+	} {
+		s.counters.RecordCounterEvent(ctx, event)
+	}
+
+	w := serveRequest(s.routes(), http.MethodGet, "/api/v1/counters/webhook/velocity", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var velocity VelocityResponse
+	if err := json.NewDecoder(w.Body).Decode(&velocity); err != nil {
+		t.Fatal(err)
+	}
+	if velocity.CounterID != "webhook" || velocity.EventsPerMinute != 0.6 || velocity.DeltaPerMinute != 1 || velocity.Partial {
+		t.Errorf("velocity = %+v, want 0.6 events and a delta of 1 per minute over the whole window", velocity)
+	}
+
+	// The result is cached, so a new event doesn't show up straight away
+	s.counters.RecordCounterEvent(ctx, CounterEvent{CounterID: "webhook", Delta: 5, Timestamp: now})
+	if cached, _ := s.counterVelocity(ctx, "webhook"); cached.DeltaPerMinute != 1 || !cached.ComputedAt.Equal(velocity.ComputedAt) {
+		t.Errorf("velocity within the cache TTL = %+v, want the cached %+v", cached, velocity)
+	}
+
+	if w := serveRequest(s.routes(), http.MethodGet, "/api/v1/counters/missing/velocity", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing counter status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCounterVelocityIsPartialForUnderAMinuteOfEvents(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.counters.InitCounters(ctx, "likes")
+	for range 3 {
+		s.counters.RecordCounterEvent(ctx, CounterEvent{CounterID: "likes", Delta: 2, Timestamp: time.Now().Add(-10 * time.Second)})
+	}
+
+	velocity, err := s.counterVelocity(ctx, "likes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rates cover the last minute rather than being spread over five
+	if !velocity.Partial || velocity.EventsPerMinute != 3 || velocity.DeltaPerMinute != 6 {
+		t.Errorf("velocity = %+v, want a partial 3 events and a delta of 6 per minute", velocity)
+	}
+}
+
+func TestWebhookChangesAreRecordedForVelocity(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Counting = CounterSettings{PageViews: true, Webhook: true, TotalClicks: false}
+	h := s.routes()
+	for _, path := range []string{"/increment", "/increment", "/decrement"} {
+		if w := serveRequest(h, http.MethodPost, path, "", ""); w.Code != http.StatusOK {
+			t.Fatalf("POST %s status = %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+
+	summary, err := s.counters.SummarizeCounterEvents(context.Background(), "webhook", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Events != 3 || summary.Delta != 1 {
+		t.Errorf("recorded %d events with delta %d, want 3 with delta 1", summary.Events, summary.Delta)
+	}
+}
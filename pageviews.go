@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pageViewCookie holds the random ID a visitor's page views are deduplicated by
+const pageViewCookie = "pv"
+
+// pageViewCookieLifetime is how long a visitor keeps their page view ID
+const pageViewCookieLifetime = 365 * 24 * time.Hour
+
+// PageView is how many times one page has been viewed
+type PageView struct {
+	Path  string `bson:"_id" json:"path"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// CountryViews is how many page views came from one country
+type CountryViews struct {
+	// Country is the ISO 3166-1 alpha-2 country code, such as "US"
+	Country string `bson:"_id" json:"country"`
+	Views   int    `bson:"views" json:"views"`
+}
+
+// shouldCountPageView reports whether this request should increment page views. A visitor is
+// counted once per window, remembered in the database so restarts don't reset it. Visitors are
+// told apart by an ID in their pv cookie, so people sharing an IP are counted separately. One
+// without the cookie, on a first visit or with cookies disabled, is given one and deduplicated by
+// a hash of their IP salted with s.visitorSalt instead.
+func (s *Server) shouldCountPageView(w http.ResponseWriter, r *http.Request) bool {
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+	now := time.Now()
+
+	if cookie, err := r.Cookie(pageViewCookie); err == nil && validSessionID(cookie.Value) {
+		return s.recordVisitor(ctx, "visitor:"+cookie.Value, now)
+	}
+
+	id := newSessionID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     pageViewCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(pageViewCookieLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// The visitor is counted now, so their next visit with the cookie isn't counted again
+	s.recordVisitor(ctx, "visitor:"+id, now)
+	return s.recordVisitor(ctx, "ip:"+hashIPWithSalt(s.visitorSalt, s.getIPAddress(r)), now)
+}
+
+// recordVisitor records the visitor with the given key for the page view window, reporting
+// whether they're new. A visitor that can't be recorded isn't counted.
+func (s *Server) recordVisitor(ctx context.Context, key string, now time.Time) bool {
+	counted, err := s.pageViews.RecordVisitor(ctx, key, now, s.config.PageViewWindow)
+	if err != nil {
+		s.dbError(ctx, "pageviews", "record visitor", err)
+		return false
+	}
+	return counted
+}
+
+// trackPageView counts r's view of the page at path, and the visitor's country if it can be
+// found. Page handlers call it once they've decided the view counts, with shouldCountPageView. A
+// detached context is used so the count isn't lost when the visitor navigates away mid-request.
+func (s *Server) trackPageView(r *http.Request, path string) {
+	ctx := r.Context()
+	writeCtx, cancel := s.detachedWriteContext(ctx)
+	defer cancel()
+
+	if err := s.pageViews.TrackPageView(writeCtx, path); err != nil {
+		s.dbError(ctx, "pageviews", "track page view", err)
+		return
+	}
+	// Keep the velocity of the pageviews counter covering every page
+	s.recordCounterEvent(writeCtx, "pageviews", 1)
+
+	// Only the country is stored, never the visitor's IP
+	if country := s.visitorCountry(r); country != "" {
+		if err := s.pageViews.TrackCountryView(writeCtx, country); err != nil {
+			s.dbError(ctx, "pageviews", "track country view", err)
+		}
+	}
+}
+
+// migrateLegacyPageViews copies the site-wide pageviews counter, from before views were counted
+// per page, to the home page's count. Only a home page without a count is set, so it's safe to
+// run at every startup.
+func (s *Server) migrateLegacyPageViews(ctx context.Context) {
+	legacy, err := s.counters.GetCounter(ctx, "pageviews")
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && legacy.Count == 0) {
+		return
+	}
+	if err != nil {
+		s.log(ctx, "pageviews").Error("reading legacy page views", "err", err)
+		return
+	}
+	if err := s.pageViews.SeedPageViews(ctx, "/", legacy.Count); err != nil {
+		s.log(ctx, "pageviews").Error("migrating legacy page views", "err", err)
+	}
+}
+
+// pageViewsHandler returns the views of every page, most viewed first
+func (s *Server) pageViewsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.config.Counting.PageViews {
+		s.apiError(w, r, http.StatusNotFound, "Page views aren't counted")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	views, err := s.pageViews.ListPageViews(ctx)
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "pageviews", "list page views", err), "Error listing page views")
+		return
+	}
+	respondJSON(w, http.StatusOK, views)
+}
+
+// countryViewsHandler returns the page views from each country, most views first. Views are only
+// counted by country with a MaxMind database, set by MAXMIND_DB_PATH.
+func (s *Server) countryViewsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.config.Counting.PageViews {
+		s.apiError(w, r, http.StatusNotFound, "Page views aren't counted")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	views, err := s.pageViews.ListCountryViews(ctx)
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "pageviews", "list country views", err), "Error listing country views")
+		return
+	}
+	respondJSON(w, http.StatusOK, views)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRecordVisitor checks deduplicating visitors against s's store
+func testRecordVisitor(t *testing.T, s *Server) {
+	ctx := t.Context()
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if counted, err := s.pageViews.RecordVisitor(ctx, "visitor:a", start, 30*time.Minute); err != nil || !counted {
+		t.Fatalf("first visit = %v, %v; want counted", counted, err)
+	}
+
+	tests := []struct {
+		after time.Duration
+		key   string
+		want  bool
+	}{
+		{29 * time.Minute, "visitor:a", false},
+		{29 * time.Minute, "visitor:b", true},
+		{30 * time.Minute, "visitor:a", true},
+		{45 * time.Minute, "visitor:a", false},
+		{61 * time.Minute, "visitor:a", true},
+	}
+	for _, tt := range tests {
+		counted, err := s.pageViews.RecordVisitor(ctx, tt.key, start.Add(tt.after), 30*time.Minute)
+		if err != nil || counted != tt.want {
+			t.Errorf("visit from %s after %s counted = %v, %v; want %v", tt.key, tt.after, counted, err, tt.want)
+		}
+	}
+}
+
+func TestRecordVisitor(t *testing.T) {
+	testRecordVisitor(t, newTestServer(t))
+}
+
+func TestRecordVisitorMongo(t *testing.T) {
+	testRecordVisitor(t, newMongoTestServer(t))
+}
+
+func TestPageViewCookie(t *testing.T) {
+	s := newTestServer(t)
+	visit := func(remoteAddr string, cookie *http.Cookie) (bool, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		return s.shouldCountPageView(w, r), w
+	}
+
+	counted, w := visit("192.0.2.44:1000", nil)
+	if !counted {
+		t.Error("first visit wasn't counted")
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != pageViewCookie || !validSessionID(cookies[0].Value) || cookies[0].MaxAge != int(pageViewCookieLifetime.Seconds()) {
+		t.Fatalf("first visit set cookies %v, want a %s visitor ID lasting a year", cookies, pageViewCookie)
+	}
+	first := cookies[0]
+
+	// A visitor with the cookie isn't counted again, even from a new address
+	if counted, _ := visit("192.0.2.45:1000", first); counted {
+		t.Error("visit with the page view cookie was counted")
+	}
+	// Someone else behind the same IP is counted once they have a cookie of their own
+	if counted, _ := visit("192.0.2.44:1000", nil); counted {
+		t.Error("a second cookieless visit from the same IP was counted")
+	}
+	if counted, _ := visit("192.0.2.44:1000", &http.Cookie{Name: pageViewCookie, Value: newSessionID()}); !counted {
+		t.Error("another visitor from the same IP wasn't counted")
+	}
+
+	// The visitors are remembered in the store, so a restarted server doesn't count them again
+	restarted := newTestServer(t)
+	restarted.pageViews = s.pageViews
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(first)
+	if restarted.shouldCountPageView(httptest.NewRecorder(), r) {
+		t.Error("the visitor was counted again after a restart")
+	}
+}
+
+func TestPageViewIPKeyIsSalted(t *testing.T) {
+	// ipKeys returns the keys s stored for cookieless visitors after one visit from ip
+	ipKeys := func(s *Server, ip string) []string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":1000"
+		s.shouldCountPageView(httptest.NewRecorder(), r)
+		store := s.pageViews.(*memoryStore)
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		var keys []string
+		for key := range store.visitors {
+			if strings.HasPrefix(key, "ip:") {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+
+	// Without IP_HASH_SALT the IP is still hashed with a salt, a different one for each server
+	first := ipKeys(newTestServer(t), "192.0.2.44")
+	if len(first) != 1 || first[0] == "ip:"+hashIPWithSalt("", "192.0.2.44") {
+		t.Fatalf("stored IP keys %v, want one that isn't an unsalted hash of the IP", first)
+	}
+	if second := ipKeys(newTestServer(t), "192.0.2.44"); len(second) != 1 || second[0] == first[0] {
+		t.Errorf("another server stored IP keys %v, want one other than %s", second, first[0])
+	}
+
+	config := defaultConfig()
+	config.IPHashSalt = "pepper"
+	s := newTestServerWithConfig(t, config)
+	if keys := ipKeys(s, "192.0.2.44"); !slices.Equal(keys, []string{"ip:" + hashIPWithSalt("pepper", "192.0.2.44")}) {
+		t.Errorf("with IP_HASH_SALT set, stored IP keys %v, want the IP hashed with it", keys)
+	}
+}
+
+func TestPageViewsAreCountedPerPath(t *testing.T) {
+	s := newTestServer(t)
+	ctx := t.Context()
+	for _, path := range []string{"/", "/quotes/1", "/", "/about", "/"} {
+		s.trackPageView(httptest.NewRequest(http.MethodGet, path, nil), path)
+	}
+
+	w := serveRequest(s.routes(), http.MethodGet, "/api/v1/analytics/pages", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var views []PageView
+	if err := json.NewDecoder(w.Body).Decode(&views); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := []PageView{{Path: "/", Count: 3}, {Path: "/about", Count: 1}, {Path: "/quotes/1", Count: 1}}
+	if !slices.Equal(views, want) {
+		t.Errorf("page views = %+v, want %+v", views, want)
+	}
+
+	if total, err := s.pageViews.TotalPageViews(ctx); err != nil || total != 5 {
+		t.Errorf("TotalPageViews() = %d, %v; want 5", total, err)
+	}
+}
+
+func TestHomePageShowsTotalPageViews(t *testing.T) {
+	s := newTestServer(t)
+	setGitHubRepos(t, nil)
+	s.trackPageView(httptest.NewRequest(http.MethodGet, "/about", nil), "/about")
+
+	// A new visitor's view of the home page is added to the other pages' views
+	serveRequest(s.routes(), http.MethodGet, "/", "", "192.0.2.77:1000")
+
+	views, err := s.pageViews.ListPageViews(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PageView{{Path: "/", Count: 1}, {Path: "/about", Count: 1}}
+	if !slices.Equal(views, want) {
+		t.Errorf("page views after visiting the home page = %+v, want %+v", views, want)
+	}
+
+	w := serveRequest(s.routes(), http.MethodGet, "/api/v1/stats", "", "")
+	var stats Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+	if stats.PageViewCount == nil || *stats.PageViewCount != 2 {
+		t.Errorf("stats pageViewCount = %v, want 2", stats.PageViewCount)
+	}
+}
+
+func TestMigrateLegacyPageViews(t *testing.T) {
+	s := newTestServer(t)
+	ctx := t.Context()
+	if err := s.counters.InitCounters(ctx, "pageviews"); err != nil {
+		t.Fatal(err)
+	}
+	for range 7 {
+		if _, err := s.counters.IncrementCounter(ctx, "pageviews"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Running it again after more views doesn't reset the home page's count
+	s.migrateLegacyPageViews(ctx)
+	s.trackPageView(httptest.NewRequest(http.MethodGet, "/", nil), "/")
+	s.migrateLegacyPageViews(ctx)
+
+	views, err := s.pageViews.ListPageViews(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PageView{{Path: "/", Count: 8}}; !slices.Equal(views, want) {
+		t.Errorf("page views after migrating = %+v, want %+v", views, want)
+	}
+}
+
+func TestPageViewsCounterReadsTheTotal(t *testing.T) {
+	s := newTestServer(t)
+	ctx := t.Context()
+	if err := s.counters.InitCounters(ctx, "pageviews"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.counters.IncrementCounter(ctx, "pageviews"); err != nil {
+		t.Fatal(err)
+	}
+	s.migrateLegacyPageViews(ctx)
+	s.trackPageView(httptest.NewRequest(http.MethodGet, "/", nil), "/")
+	s.trackPageView(httptest.NewRequest(http.MethodGet, "/quotes", nil), "/quotes")
+	h := s.routes()
+
+	// The legacy count of 1 moved to the home page, and two more views were counted since
+	w := serveRequest(h, http.MethodGet, "/api/v1/counters/pageviews", "", "")
+	var counter Counter
+	if err := json.NewDecoder(w.Body).Decode(&counter); err != nil {
+		t.Fatal(err)
+	}
+	if counter.Count != 3 {
+		t.Errorf("GET /api/v1/counters/pageviews count = %d, want the total of 3", counter.Count)
+	}
+
+	w = serveRequest(h, http.MethodGet, "/api/v1/counters", "", "")
+	var counters []Counter
+	if err := json.NewDecoder(w.Body).Decode(&counters); err != nil {
+		t.Fatal(err)
+	}
+	if i := slices.IndexFunc(counters, func(c Counter) bool { return c.ID == "pageviews" }); i < 0 || counters[i].Count != 3 {
+		t.Errorf("counters list = %+v, want pageviews at the total of 3", counters)
+	}
+
+	w = serveRequest(h, http.MethodGet, "/api/v1/counters/pageviews/highwater", "", "")
+	var mark HighWaterMark
+	if err := json.NewDecoder(w.Body).Decode(&mark); err != nil {
+		t.Fatal(err)
+	}
+	if mark.MaxSeen != 3 {
+		t.Errorf("pageviews high-water mark = %d, want 3", mark.MaxSeen)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// QuoteAuthor is the quotes submitted from one IP address, which is only known by its hash
+type QuoteAuthor struct {
+	IPHash string `bson:"_id" json:"ipHash"`
+	Quotes int    `bson:"quotes" json:"quotes"`
+	// Names are the distinct names the quotes were submitted under, alphabetically
+	Names       []string  `bson:"names" json:"names"`
+	LastQuoteAt time.Time `bson:"lastQuoteAt" json:"lastQuoteAt"`
+}
+
+// authorIPHashKey is the context key holding the hash of the IP address a GraphQL request came
+// from, from quoteAuthorIPHash
+type authorIPHashKey struct{}
+
+// quoteAuthorIPHash returns the hash of the IP address a quote was submitted from, stored with the
+// quote so admins can tell when one person posts under many names. It's the same hash the audit
+// log uses. Without IP_HASH_SALT nothing is stored, as an unsalted hash of an IPv4 address can be
+// reversed by hashing every address.
+func (s *Server) quoteAuthorIPHash(ip string) string {
+	if s.config.IPHashSalt == "" {
+		return ""
+	}
+	return s.hashIP(ip)
+}
+
+// sortQuoteAuthors puts the authors who used the most names first, then those with the most
+// quotes, then the most recent
+func sortQuoteAuthors(authors []QuoteAuthor) {
+	for _, author := range authors {
+		slices.Sort(author.Names)
+	}
+	slices.SortFunc(authors, func(a, b QuoteAuthor) int {
+		return cmp.Or(
+			cmp.Compare(len(b.Names), len(a.Names)),
+			cmp.Compare(b.Quotes, a.Quotes),
+			b.LastQuoteAt.Compare(a.LastQuoteAt),
+			cmp.Compare(a.IPHash, b.IPHash),
+		)
+	})
+}
+
+// quoteAuthorsHandler lists quotes grouped by the hash of the IP address they were submitted
+// from, those posted under the most names first. Quotes submitted without IP_HASH_SALT set
+// aren't included.
+func (s *Server) quoteAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	authors, err := s.quotes.GroupQuotesByAuthor(ctx)
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "quotes", "group quotes by author", err), "Error listing quote authors")
+		return
+	}
+	respondJSON(w, http.StatusOK, authors)
+}
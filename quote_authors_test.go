@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// submitQuote posts the quote form from remoteAddr and returns the response
+func submitQuote(h http.Handler, name, text, remoteAddr string) *httptest.ResponseRecorder {
+	form := url.Values{"quote": {text}, "name": {name}}.Encode()
+	r := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	r.RemoteAddr = remoteAddr
+	return serve(h, r)
+}
+
+func TestQuoteStoresAuthorIPHash(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	s.config.IPHashSalt = "pepper"
+	h := s.routes()
+
+	w := submitQuote(h, "Ada", "The first quote", "203.0.113.90:1000")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	submitQuote(h, "Grace", "The same person under another name", "203.0.113.90:2000")
+	serveRequest(h, http.MethodPost, "/graphql", `{"query": "mutation { submitQuote(name: \"Alan\", quote: \"From GraphQL\") { name } }"}`, "203.0.113.90:3000")
+	submitQuote(h, "Ada", "Someone else", "198.51.100.90:1000")
+
+	quotes, err := s.quotes.LatestQuotes(t.Context(), 0)
+	if err != nil || len(quotes) != 4 {
+		t.Fatalf("LatestQuotes() = %d quotes, %v; want 4", len(quotes), err)
+	}
+	hash := quotes[3].AuthorIPHash
+	if hash == "" || strings.Contains(hash, "203.0.113.90") {
+		t.Fatalf("AuthorIPHash = %q, want a hash of the IP", hash)
+	}
+	// The same IP and salt always give the same hash, however the quote was submitted
+	if quotes[2].AuthorIPHash != hash || quotes[1].AuthorIPHash != hash {
+		t.Errorf("hashes from the same IP = %q, %q, %q; want them equal", hash, quotes[2].AuthorIPHash, quotes[1].AuthorIPHash)
+	}
+	if quotes[0].AuthorIPHash == hash {
+		t.Error("quotes from different IPs have the same hash")
+	}
+	// A different salt gives a different hash
+	s.config.IPHashSalt = "salt"
+	if s.quoteAuthorIPHash("203.0.113.90") == hash {
+		t.Error("hash didn't change with the salt")
+	}
+
+	// The hash is never in public responses
+	for _, target := range []string{"/api/v1/quotes", "/api/v2/quotes", "/api/v1/quotes/since?ts=2000-01-01T00:00:00Z", "/feed"} {
+		w := serveRequest(h, http.MethodGet, target, "", "")
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+		if body := w.Body.String(); strings.Contains(body, hash) || strings.Contains(strings.ToLower(body), "iphash") {
+			t.Errorf("GET %s includes the author's IP hash: %s", target, body)
+		}
+	}
+	if body := w.Body.String(); strings.Contains(body, hash) || strings.Contains(strings.ToLower(body), "iphash") {
+		t.Errorf("POST /quote response includes the author's IP hash: %s", body)
+	}
+}
+
+func TestQuoteAuthorIPHashNeedsSalt(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+
+	submitQuote(s.routes(), "Ada", "Unsalted", "203.0.113.91:1000")
+	quotes, err := s.quotes.LatestQuotes(t.Context(), 0)
+	if err != nil || len(quotes) != 1 || quotes[0].AuthorIPHash != "" {
+		t.Errorf("LatestQuotes() = %+v, %v; want one quote without an IP hash", quotes, err)
+	}
+}
+
+// testQuoteAuthors checks grouping quotes by their author's IP hash against s's store
+func testQuoteAuthors(t *testing.T, s *Server) {
+	s.config.AdminToken = "secret"
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, q := range []struct{ name, hash string }{
+		{"Ada", "aaaa"},
+		{"Grace", "bbbb"},
+		{"Grace", "bbbb"},
+		{"Ada", "cccc"},
+		{"Alan", "cccc"},
+		{"Edsger", "cccc"},
+		{"Ada", "cccc"},
+		{"Unknown", ""},
+	} {
+		quote, _ := newQuote(q.name, "Quote", "", nil)
+		quote.AuthorIPHash, quote.Timestamp = q.hash, start.Add(time.Duration(i)*time.Minute)
+		if err := s.quotes.InsertQuote(t.Context(), quote); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := s.routes()
+	if w := serveRequest(h, http.MethodGet, "/admin/quotes/authors", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("status without the admin token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	r := newJSONRequest(http.MethodGet, "/admin/quotes/authors", "")
+	r.Header.Set("Authorization", "Bearer secret")
+	w := serve(h, r)
+	var authors []QuoteAuthor
+	if err := json.NewDecoder(w.Body).Decode(&authors); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%v), want %d", w.Code, err, http.StatusOK)
+	}
+
+	// The most names first, then the most quotes
+	want := []QuoteAuthor{
+		{IPHash: "cccc", Quotes: 4, Names: []string{"Ada", "Alan", "Edsger"}, LastQuoteAt: start.Add(6 * time.Minute)},
+		{IPHash: "bbbb", Quotes: 2, Names: []string{"Grace"}, LastQuoteAt: start.Add(2 * time.Minute)},
+		{IPHash: "aaaa", Quotes: 1, Names: []string{"Ada"}, LastQuoteAt: start},
+	}
+	if !slices.EqualFunc(authors, want, func(a, b QuoteAuthor) bool {
+		return a.IPHash == b.IPHash && a.Quotes == b.Quotes && slices.Equal(a.Names, b.Names) && a.LastQuoteAt.Equal(b.LastQuoteAt)
+	}) {
+		t.Errorf("authors = %+v, want %+v", authors, want)
+	}
+}
+
+func TestQuoteAuthors(t *testing.T) {
+	testQuoteAuthors(t, newTestServer(t))
+}
+
+func TestQuoteAuthorsMongo(t *testing.T) {
+	testQuoteAuthors(t, newMongoTestServer(t))
+}
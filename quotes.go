@@ -2,55 +2,353 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// maxQuoteTags is the most tags a quote may have
+const maxQuoteTags = 5
+
+// maxQuoteSourceLength is the longest source URL a quote may have
+const maxQuoteSourceLength = 2048
+
 // Quote represents a quote document in MongoDB
 type Quote struct {
-	Name      string    `bson:"name" json:"name"`
-	Quote     string    `bson:"quote" json:"quote"`
-	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	ID    primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name  string             `bson:"name" json:"name"`
+	Quote string             `bson:"quote" json:"quote"`
+	Tags  []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	// Source optionally links to where the quote came from
+	Source string `bson:"source,omitempty" json:"source,omitempty"`
+	// ImagePath is where the image attached to the quote is served from, if it has one
+	ImagePath string `bson:"imagePath,omitempty" json:"imagePath,omitempty"`
+	// AuthorIPHash is the hash of the IP address the quote was submitted from, from
+	// quoteAuthorIPHash. It's only shown to admins.
+	AuthorIPHash string    `bson:"authorIPHash,omitempty" json:"-"`
+	Timestamp    time.Time `bson:"timestamp" json:"timestamp"`
+	// Reactions counts the reactions to the quote by emoji
+	Reactions map[string]int `bson:"reactions" json:"reactions"`
 }
 
-// quoteHandler handles quote submission requests
-func quoteHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// quotePreviewRunes is the longest preview of a quote's text the quotes API returns
+const quotePreviewRunes = 100
+
+// QuoteView is a quote as returned by the quotes API, with counts and a preview derived from its text
+type QuoteView struct {
+	Quote
+	CharCount int    `json:"charCount"`
+	WordCount int    `json:"wordCount"`
+	Preview   string `json:"preview"`
+}
+
+// newQuoteView returns the view of a quote, counting characters as runes so multibyte text
+// isn't overcounted
+func newQuoteView(quote Quote) QuoteView {
+	return QuoteView{
+		Quote:     quote,
+		CharCount: utf8.RuneCountInString(quote.Quote),
+		WordCount: len(strings.Fields(quote.Quote)),
+		Preview:   previewQuote(quote.Quote, quotePreviewRunes),
 	}
+}
 
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
-		return
+// QuotePage represents a single page of quotes
+type QuotePage struct {
+	Quotes []Quote `json:"quotes"`
+	Page   int     `json:"page"`
+	Limit  int     `json:"limit"`
+	Total  int64   `json:"total"`
+}
+
+var (
+	errEmptyQuote         = errors.New("quote cannot be empty")
+	errInvalidQuoteSource = errors.New("source must be an http or https URL")
+)
+
+// parseQuoteSource checks a quote's optional source URL, returning "" if none was given. Only
+// absolute http and https URLs are accepted, so a source can't run script when it's clicked.
+func parseQuoteSource(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	if len(raw) > maxQuoteSourceLength {
+		return "", errInvalidQuoteSource
+	}
+
+	u, err := url.ParseRequestURI(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errInvalidQuoteSource
 	}
+	return u.String(), nil
+}
 
-	quoteText := r.FormValue("quote")
-	name := r.FormValue("name")
+// newQuote builds a quote from user input, defaulting the name, checking the source, and
+// normalizing tags
+func newQuote(name, text, source string, tags []string) (Quote, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Quote{}, errEmptyQuote
+	}
 
-	if quoteText == "" {
-		http.Error(w, "Quote cannot be empty", http.StatusBadRequest)
-		return
+	source, err := parseQuoteSource(source)
+	if err != nil {
+		return Quote{}, err
 	}
 
+	name = strings.TrimSpace(name)
 	if name == "" {
 		name = "Unknown"
 	}
 
-	quote := Quote{
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || slices.Contains(normalized, tag) {
+			continue
+		}
+		if len(normalized) == maxQuoteTags {
+			break
+		}
+		normalized = append(normalized, tag)
+	}
+
+	return Quote{
+		ID:        primitive.NewObjectID(),
 		Name:      name,
-		Quote:     quoteText,
+		Quote:     text,
+		Tags:      normalized,
+		Source:    source,
 		Timestamp: time.Now(),
+		Reactions: map[string]int{},
+	}, nil
+}
+
+// Quote page cache limits. Entries are dropped once they're older than quotePageCacheTTL, so
+// quotes added by other instances still show up, and evicted when the cache outgrows
+// quotePageCacheMaxEntries.
+const (
+	quotePageCacheTTL        = 60 * time.Second
+	quotePageCacheMaxEntries = 200
+)
+
+// QuotePageCache is a QuoteStore that caches pages of quotes. Inserting a quote bumps a
+// generation number that's part of every cache key, so pages cached before it are never served.
+type QuotePageCache struct {
+	QuoteStore
+	pages      sync.Map // cache key -> quotePageEntry
+	entries    atomic.Int64
+	generation atomic.Uint64
+	hits       atomic.Int64
+	misses     atomic.Int64
+}
+
+// quotePageEntry is a cached page and when it was read from the store
+type quotePageEntry struct {
+	page      QuotePage
+	fetchedAt time.Time
+}
+
+// newQuotePageCache returns a cache in front of store
+func newQuotePageCache(store QuoteStore) *QuotePageCache {
+	return &QuotePageCache{QuoteStore: store}
+}
+
+func (c *QuotePageCache) cacheKey(page, limit int, tag string) string {
+	return fmt.Sprintf("%d:%d:%d:%s", c.generation.Load(), page, limit, tag)
+}
+
+func (c *QuotePageCache) ListQuotes(ctx context.Context, page, limit int, tag string) (QuotePage, error) {
+	key := c.cacheKey(page, limit, tag)
+	if cached, ok := c.pages.Load(key); ok {
+		entry := cached.(quotePageEntry)
+		if time.Since(entry.fetchedAt) < quotePageCacheTTL {
+			c.hits.Add(1)
+			return entry.page, nil
+		}
 	}
+	c.misses.Add(1)
 
-	ctx := context.Background()
-	quotesCollection := db.Collection("quotes")
-	_, err = quotesCollection.InsertOne(ctx, quote)
+	result, err := c.QuoteStore.ListQuotes(ctx, page, limit, tag)
 	if err != nil {
-		http.Error(w, "Error saving quote", http.StatusInternalServerError)
+		return QuotePage{}, err
+	}
+	if _, loaded := c.pages.Swap(key, quotePageEntry{page: result, fetchedAt: time.Now()}); !loaded {
+		if c.entries.Add(1) > quotePageCacheMaxEntries {
+			c.cacheEvict()
+		}
+	}
+	return result, nil
+}
+
+// InsertQuote saves a quote and invalidates every cached page
+func (c *QuotePageCache) InsertQuote(ctx context.Context, quote Quote) error {
+	err := c.QuoteStore.InsertQuote(ctx, quote)
+	c.generation.Add(1)
+	return err
+}
+
+// InsertQuoteIfNew saves a quote unless its text is taken, invalidating every cached page if
+// it was saved
+func (c *QuotePageCache) InsertQuoteIfNew(ctx context.Context, quote Quote) (bool, error) {
+	added, err := c.QuoteStore.InsertQuoteIfNew(ctx, quote)
+	if added {
+		c.generation.Add(1)
+	}
+	return added, err
+}
+
+// ReactToQuote records a reaction and invalidates every cached page, as they show its count
+func (c *QuotePageCache) ReactToQuote(ctx context.Context, reaction QuoteReaction) (map[string]int, error) {
+	reactions, err := c.QuoteStore.ReactToQuote(ctx, reaction)
+	c.generation.Add(1)
+	return reactions, err
+}
+
+// Invalidate drops every cached page, for when quotes change behind the cache's back
+func (c *QuotePageCache) Invalidate() {
+	c.generation.Add(1)
+}
+
+// cacheEvict removes the entries older than quotePageCacheTTL
+func (c *QuotePageCache) cacheEvict() {
+	c.pages.Range(func(key, value any) bool {
+		if time.Since(value.(quotePageEntry).fetchedAt) >= quotePageCacheTTL {
+			if _, loaded := c.pages.LoadAndDelete(key); loaded {
+				c.entries.Add(-1)
+			}
+		}
+		return true
+	})
+}
+
+// HitRate returns the fraction of ListQuotes calls served from the cache
+func (c *QuotePageCache) HitRate() float64 {
+	hits, misses := c.hits.Load(), c.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// listQuotes returns one page of quotes, newest first, optionally only those with the given tag
+func (s *Server) listQuotes(ctx context.Context, page, limit int, tag string) (QuotePage, error) {
+	return s.quotes.ListQuotes(ctx, page, limit, strings.ToLower(tag))
+}
+
+// getDailyQuote returns the quote of the day, which changes at midnight UTC and cycles through every quote.
+// It returns mongo.ErrNoDocuments when there are no quotes.
+func (s *Server) getDailyQuote(ctx context.Context) (Quote, error) {
+	total, err := s.quotes.CountQuotes(ctx)
+	if err != nil {
+		return Quote{}, err
+	}
+	if total == 0 {
+		return Quote{}, mongo.ErrNoDocuments
+	}
+
+	day := time.Now().UTC().Unix() / int64(24*time.Hour/time.Second)
+	return s.quotes.NthOldestQuote(ctx, day%total)
+}
+
+// quotesAPIHandler returns the latest quotes, up to the limit query parameter (default 20, max 100)
+func (s *Server) quotesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 20)
+	if err != nil || limit > 100 {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	quotes, err := s.quotes.LatestQuotes(ctx, int64(limit))
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "quotes", "get latest quotes", err), "Error getting quotes")
+		return
+	}
+
+	respondQuoteViews(w, quotes)
+}
+
+// respondQuoteViews writes the views of quotes as a JSON array
+func respondQuoteViews(w http.ResponseWriter, quotes []Quote) {
+	views := make([]QuoteView, len(quotes))
+	for i, quote := range quotes {
+		views[i] = newQuoteView(quote)
+	}
+	respondJSON(w, http.StatusOK, views)
+}
+
+// quoteHandler handles quote submission requests
+func (s *Server) quoteHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(maxQuoteUploadBytes)
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
+	} else {
+		// Only a quote with an image may be large
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+		err = r.ParseForm()
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.respondError(w, r, http.StatusRequestEntityTooLarge, "Quote is too large.")
+			return
+		}
+		s.respondError(w, r, http.StatusBadRequest, "Error parsing form")
 		return
 	}
 
+	quote, err := newQuote(r.FormValue("name"), r.FormValue("quote"), r.FormValue("source"), nil)
+	if errors.Is(err, errInvalidQuoteSource) {
+		s.respondError(w, r, http.StatusBadRequest, "Source must be an http or https URL")
+		return
+	}
+	if err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Quote cannot be empty")
+		return
+	}
+	quote.AuthorIPHash = s.quoteAuthorIPHash(s.getIPAddress(r))
+
+	file, header, err := r.FormFile("image")
+	if err == nil {
+		quote.ImagePath, err = s.saveQuoteImage(file, header)
+		file.Close()
+		if err != nil {
+			s.respondImageError(w, r, err)
+			return
+		}
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+
+	err = s.insertQuote(ctx, quote)
+	if err != nil {
+		s.removeQuoteImage(quote.ImagePath)
+		status, message := s.writeError(r.Context(), "quotes", "insert quote", err, "Error saving quote")
+		s.respondError(w, r, status, message)
+		return
+	}
+
+	if wantsJSON(r) {
+		respondJSON(w, http.StatusCreated, quote)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
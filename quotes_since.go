@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// quotesSinceLimit is the most quotes returned by one quotes since request
+const quotesSinceLimit = 100
+
+// quotesLongPollTimeout is how long a quotes since request waits for a new quote before
+// responding with none. The request's write deadline is pushed back by as long, so the wait
+// doesn't eat into the time the server allows for writing the response.
+var quotesLongPollTimeout = 10 * time.Second
+
+// quoteNotifier wakes long-polling requests when a quote is added on this instance
+type quoteNotifier struct {
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+// wait returns a channel that's closed the next time a quote is added
+func (n *quoteNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.changed == nil {
+		n.changed = make(chan struct{})
+	}
+	return n.changed
+}
+
+// notify wakes every request waiting for a new quote
+func (n *quoteNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.changed != nil {
+		close(n.changed)
+		n.changed = nil
+	}
+}
+
+// insertQuote saves a quote, wakes the requests waiting for one, and drops the cached home page
+func (s *Server) insertQuote(ctx context.Context, quote Quote) error {
+	if err := s.quotes.InsertQuote(ctx, quote); err != nil {
+		return err
+	}
+	s.newQuotes.notify()
+	s.home.invalidate()
+	return nil
+}
+
+// quotesSinceHandler returns the quotes added after the ts query parameter, oldest first, for
+// clients that can't use the WebSocket. If there are none it waits up to quotesLongPollTimeout
+// for one before responding with an empty list.
+func (s *Server) quotesSinceHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("ts"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid ts, expected an RFC 3339 timestamp")
+		return
+	}
+
+	// Writers that can't have deadlines, like httptest's, have none to extend
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(quotesLongPollTimeout + s.config.HTTPWriteTimeout))
+	timeout := time.NewTimer(quotesLongPollTimeout)
+	defer timeout.Stop()
+
+	for {
+		// Start listening before checking, so a quote added in between still wakes us
+		added := s.newQuotes.wait()
+
+		ctx, cancel := s.readContext(r)
+		quotes, err := s.quotes.QuotesSince(ctx, since, quotesSinceLimit)
+		cancel()
+		if err != nil {
+			s.apiError(w, r, s.dbError(r.Context(), "quotes", "get quotes since", err), "Error getting quotes")
+			return
+		}
+		if len(quotes) > 0 {
+			respondQuoteViews(w, quotes)
+			return
+		}
+
+		select {
+		case <-added:
+		case <-timeout.C:
+			respondQuoteViews(w, quotes)
+			return
+		case <-s.stopping:
+			respondQuoteViews(w, quotes)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
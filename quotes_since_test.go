@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setQuotesLongPollTimeout changes how long quotes since requests wait until the test ends
+func setQuotesLongPollTimeout(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	previous := quotesLongPollTimeout
+	quotesLongPollTimeout = timeout
+	t.Cleanup(func() { quotesLongPollTimeout = previous })
+}
+
+// getQuotesSince requests the quotes since ts and decodes the response
+func getQuotesSince(t *testing.T, s *Server, ts time.Time) []QuoteView {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/quotes/since?ts="+ts.Format(time.RFC3339Nano), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var quotes []QuoteView
+	if err := json.Unmarshal(w.Body.Bytes(), &quotes); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body, err)
+	}
+	return quotes
+}
+
+func TestQuotesSinceReturnsNewerQuotesImmediately(t *testing.T) {
+	setQuotesLongPollTimeout(t, time.Minute)
+	s := newTestServer(t)
+	start := time.Now()
+	for i, text := range []string{"old", "newer", "newest"} {
+		quote := Quote{Name: "n", Quote: text, Timestamp: start.Add(time.Duration(i-1) * time.Second)}
+		if err := s.quotes.InsertQuote(context.Background(), quote); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quotes := getQuotesSince(t, s, start.Add(-time.Second))
+	if len(quotes) != 2 || quotes[0].Quote.Quote != "newer" || quotes[1].Quote.Quote != "newest" {
+		t.Errorf("quotes = %+v, want newer and newest, oldest first", quotes)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v with quotes already there, want an immediate response", elapsed)
+	}
+}
+
+func TestQuotesSinceWaitsForNewQuote(t *testing.T) {
+	setQuotesLongPollTimeout(t, 10*time.Second)
+	s := newTestServer(t)
+	since := time.Now()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		quote, _ := newQuote("Ada", "Worth the wait", "", nil)
+		if err := s.insertQuote(context.Background(), quote); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	start := time.Now()
+	quotes := getQuotesSince(t, s, since)
+	if len(quotes) != 1 || quotes[0].Quote.Quote != "Worth the wait" {
+		t.Errorf("quotes = %+v, want the quote added while waiting", quotes)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v, want the new quote to end the wait", elapsed)
+	}
+}
+
+func TestQuotesSinceTimesOutWithNoQuotes(t *testing.T) {
+	setQuotesLongPollTimeout(t, 50*time.Millisecond)
+	s := newTestServer(t)
+
+	start := time.Now()
+	quotes := getQuotesSince(t, s, time.Now())
+	if quotes == nil || len(quotes) != 0 {
+		t.Errorf("quotes = %#v, want an empty list", quotes)
+	}
+	if elapsed := time.Since(start); elapsed < quotesLongPollTimeout {
+		t.Errorf("responded after %v, want it to wait %v", elapsed, quotesLongPollTimeout)
+	}
+}
+
+// TestQuotesSinceOutlivesWriteTimeout waits out an empty long poll through the whole site with
+// the production timeouts, which would cut the response off if the wait counted against the
+// write timeout
+func TestQuotesSinceOutlivesWriteTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out the full long poll timeout")
+	}
+	s := newTestServer(t)
+	server := httptest.NewUnstartedServer(nil)
+	server.Config = newHTTPServer(s.config, s.handler())
+	server.Start()
+	t.Cleanup(server.Close)
+	if quotesLongPollTimeout < server.Config.WriteTimeout {
+		t.Fatalf("long poll timeout %v is under the write timeout %v, so this test proves nothing", quotesLongPollTimeout, server.Config.WriteTimeout)
+	}
+
+	resp, err := server.Client().Get(server.URL + "/api/v1/quotes/since?ts=" + time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("long poll cut off: %v", err)
+	}
+	defer resp.Body.Close()
+	var quotes []QuoteView
+	if err := json.NewDecoder(resp.Body).Decode(&quotes); err != nil || resp.StatusCode != http.StatusOK || len(quotes) != 0 {
+		t.Errorf("long poll = %d %v (%v), want 200 with no quotes", resp.StatusCode, quotes, err)
+	}
+}
+
+func TestQuotesSinceRejectsInvalidTimestamp(t *testing.T) {
+	s := newTestServer(t)
+	for _, target := range []string{"/api/v1/quotes/since", "/api/v1/quotes/since?ts=yesterday"} {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, w.Code)
+		}
+	}
+}
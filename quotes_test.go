@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOversizedQuoteIsRejected checks that quote submissions over maxFormBytes get a 413 before
+// the handler reaches the database, whether the size is declared up front or only discovered
+// while reading a chunked body
+func TestOversizedQuoteIsRejected(t *testing.T) {
+	// The rejection is rendered with the error page. Only a quote with an image may be larger.
+	s := newTestServer(t)
+	handler := s.maxBytesMiddleware(s.quoteHandler, maxQuoteUploadBytes)
+	form := url.Values{"quote": {strings.Repeat("a", maxFormBytes)}, "name": {"Ada"}}.Encode()
+
+	tests := []struct {
+		name string
+		body io.Reader
+	}{
+		{"declared length", strings.NewReader(form)},
+		// Hiding the reader's type leaves the length unknown, so the body is sent chunked
+		{"chunked", io.MultiReader(strings.NewReader(form))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/quote", tt.body)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+			}
+		})
+	}
+}
+
+func TestEmptyQuoteIsRejected(t *testing.T) {
+	resetRateLimiters(t)
+	h := newTestServer(t).routes()
+	for _, quote := range []string{"", "   "} {
+		form := url.Values{"quote": {quote}, "name": {"Ada"}}.Encode()
+		r := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Accept", "application/json")
+		r.RemoteAddr = "203.0.113.1:1000"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("quote %q status = %d, want %d", quote, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSubmittedQuoteAppearsOnHomePage(t *testing.T) {
+	resetRateLimiters(t)
+	setGitHubRepos(t, []GitHubRepo{})
+	h := newTestServer(t).routes()
+
+	form := url.Values{"quote": {"Simplicity is prerequisite for reliability"}, "name": {"Dijkstra"}}.Encode()
+	r := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(h, r); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /quote status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	w := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET / status = %d, want %d", w.Code, http.StatusOK)
+	}
+	for _, want := range []string{"Simplicity is prerequisite for reliability", "Dijkstra"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("home page doesn't contain %q", want)
+		}
+	}
+}
+
+func TestQuoteSource(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	h := s.routes()
+
+	tests := []struct {
+		name   string
+		source string
+		status int
+		want   string
+	}{
+		{name: "valid URL", source: "https://example.com/talks/simplicity", status: http.StatusCreated, want: "https://example.com/talks/simplicity"},
+		{name: "absent", source: "", status: http.StatusCreated, want: ""},
+		{name: "javascript scheme", source: "javascript:alert(1)", status: http.StatusBadRequest},
+		{name: "ftp scheme", source: "ftp://example.com/quote.txt", status: http.StatusBadRequest},
+		{name: "relative", source: "/quotes", status: http.StatusBadRequest},
+		{name: "malformed", source: "https://exa mple.com", status: http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		form := url.Values{"quote": {fmt.Sprintf("Quote %d", i)}, "name": {"Ada"}, "source": {tt.source}}.Encode()
+		r := httptest.NewRequest(http.MethodPost, "/quote", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Accept", "application/json")
+		r.RemoteAddr = fmt.Sprintf("203.0.113.%d:1000", 10+i)
+		w := serve(h, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusCreated {
+			continue
+		}
+
+		quotes, err := s.quotes.LatestQuotes(t.Context(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(quotes) != 1 || quotes[0].Source != tt.want {
+			t.Errorf("%s: stored quotes %+v, want source %q", tt.name, quotes, tt.want)
+		}
+	}
+
+	setGitHubRepos(t, nil)
+	page := serve(h, httptest.NewRequest(http.MethodGet, "/", nil)).Body.String()
+	if !strings.Contains(page, `<a href="https://example.com/talks/simplicity"`) {
+		t.Error("home page doesn't link to the quote's source")
+	}
+}
+
+func TestQuoteViewCounts(t *testing.T) {
+	tests := []struct {
+		text                 string
+		wantChars, wantWords int
+	}{
+		{text: "Simplicity is prerequisite for reliability", wantChars: 42, wantWords: 5},
+		{text: "  spaced\tout\nwords  ", wantChars: 20, wantWords: 3},
+		{text: "café crème", wantChars: 10, wantWords: 2},
+		{text: "日本語のテキスト", wantChars: 8, wantWords: 1},
+		{text: "👋 hi", wantChars: 4, wantWords: 2},
+	}
+	for _, tt := range tests {
+		view := newQuoteView(Quote{Quote: tt.text})
+		if view.CharCount != tt.wantChars || view.WordCount != tt.wantWords {
+			t.Errorf("newQuoteView(%q) counts = %d chars, %d words; want %d, %d",
+				tt.text, view.CharCount, view.WordCount, tt.wantChars, tt.wantWords)
+		}
+	}
+}
+
+func TestQuotesAPIIncludesCounts(t *testing.T) {
+	s := newTestServer(t)
+	s.quotes.InsertQuote(context.Background(), Quote{Name: "Ada", Quote: "naïve idea", Timestamp: time.Now()})
+
+	w := serveRequest(s.routes(), http.MethodGet, "/api/v1/quotes", "", "")
+	want := `"charCount":10,"wordCount":2,"preview":"naïve idea"`
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) {
+		t.Errorf("GET /api/v1/quotes = %d %s, want counts %s", w.Code, w.Body, want)
+	}
+}
+
+// countingQuoteStore counts the pages read from the store it wraps
+type countingQuoteStore struct {
+	QuoteStore
+	lists int
+}
+
+func (c *countingQuoteStore) ListQuotes(ctx context.Context, page, limit int, tag string) (QuotePage, error) {
+	c.lists++
+	return c.QuoteStore.ListQuotes(ctx, page, limit, tag)
+}
+
+func TestQuotePageCacheInvalidatesOnInsert(t *testing.T) {
+	ctx := context.Background()
+	store := &countingQuoteStore{QuoteStore: newMemoryStore()}
+	cache := newQuotePageCache(store)
+	first, _ := newQuote("Ada", "First", "", nil)
+	cache.InsertQuote(ctx, first)
+
+	for range 3 {
+		page, err := cache.ListQuotes(ctx, 1, 10, "")
+		if err != nil || page.Total != 1 {
+			t.Fatalf("ListQuotes() = %+v, %v; want one quote", page, err)
+		}
+	}
+	if store.lists != 1 {
+		t.Errorf("store listed %d times for three identical requests, want 1", store.lists)
+	}
+
+	second, _ := newQuote("Grace", "Second", "", nil)
+	cache.InsertQuote(ctx, second)
+	if page, _ := cache.ListQuotes(ctx, 1, 10, ""); page.Total != 2 {
+		t.Errorf("after an insert the page has %d quotes, want 2", page.Total)
+	}
+	if store.lists != 2 {
+		t.Errorf("store listed %d times, want 2 after the insert invalidated the cache", store.lists)
+	}
+
+	// Quotes changed behind the cache, as by an import, are listed once it's invalidated
+	third, _ := newQuote("Linus", "Third", "", nil)
+	store.QuoteStore.InsertQuote(ctx, third)
+	if page, _ := cache.ListQuotes(ctx, 1, 10, ""); page.Total != 2 {
+		t.Fatalf("before invalidating the page has %d quotes, want the cached 2", page.Total)
+	}
+	cache.Invalidate()
+	if page, _ := cache.ListQuotes(ctx, 1, 10, ""); page.Total != 3 {
+		t.Errorf("after invalidating the page has %d quotes, want 3", page.Total)
+	}
+}
+
+func TestQuotePageCacheEvictsOldEntries(t *testing.T) {
+	ctx := context.Background()
+	cache := newQuotePageCache(newMemoryStore())
+	stale := time.Now().Add(-2 * quotePageCacheTTL)
+	for page := range quotePageCacheMaxEntries {
+		cache.pages.Store(cache.cacheKey(page+2, 10, ""), quotePageEntry{fetchedAt: stale})
+	}
+	cache.entries.Store(quotePageCacheMaxEntries)
+
+	// One more entry takes the cache over its limit, so the stale ones are evicted
+	cache.ListQuotes(ctx, 1, 10, "")
+	if n := cache.entries.Load(); n != 1 {
+		t.Errorf("cache holds %d entries after eviction, want 1", n)
+	}
+	if _, ok := cache.pages.Load(cache.cacheKey(1, 10, "")); !ok {
+		t.Error("the fresh entry was evicted")
+	}
+}
+
+func BenchmarkQuotePageCache(b *testing.B) {
+	ctx := context.Background()
+	cache := newQuotePageCache(newMemoryStore())
+	for i := range 50 {
+		quote, _ := newQuote("Ada", fmt.Sprintf("Quote %d", i), "", []string{"go"})
+		cache.InsertQuote(ctx, quote)
+	}
+
+	i := 0
+	for b.Loop() {
+		// Mostly the first few pages, with a new quote now and then
+		if i%500 == 499 {
+			quote, _ := newQuote("Grace", "Another", "", nil)
+			cache.InsertQuote(ctx, quote)
+		}
+		tag := ""
+		if i%2 == 0 {
+			tag = "go"
+		}
+		if _, err := cache.ListQuotes(ctx, i%3+1, 10, tag); err != nil {
+			b.Fatal(err)
+		}
+		i++
+	}
+
+	hitRate := cache.HitRate()
+	b.ReportMetric(hitRate*100, "%hits")
+	if b.N >= 1000 && hitRate <= 0.9 {
+		b.Fatalf("hit rate %.2f, want above 0.9", hitRate)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// LimiterStatus describes the in-memory rate limiter for one IP
+type LimiterStatus struct {
+	IP         string  `json:"ip"`
+	Tokens     float64 `json:"tokens"`
+	Limit      int     `json:"limit"`
+	Burst      int     `json:"burst"`
+	Violations int64   `json:"violations"`
+}
+
+// Limited reports whether the IP has no tokens left and so is currently being rejected
+func (s LimiterStatus) Limited() bool {
+	return s.Tokens < 1
+}
+
+// rateLimitedIPs reports how many IPs currently have no tokens left
+var rateLimitedIPs = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "rate_limited_ips",
+	Help: "IPs whose in-memory rate limiter currently has no tokens left.",
+}, func() float64 {
+	limited := 0
+	for _, status := range limiterStatuses() {
+		if status.Limited() {
+			limited++
+		}
+	}
+	return float64(limited)
+})
+
+// limiterStatuses returns the state of every in-memory rate limiter, sorted by IP
+func limiterStatuses() []LimiterStatus {
+	mu.Lock()
+	statuses := make([]LimiterStatus, 0, len(limiters))
+	for ip, limiter := range limiters {
+		statuses = append(statuses, LimiterStatus{
+			IP:         ip,
+			Tokens:     limiter.Tokens(),
+			Limit:      limiter.requestsPerMinute,
+			Burst:      limiter.burst,
+			Violations: limiter.violations.Load(),
+		})
+	}
+	mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].IP < statuses[j].IP
+	})
+	return statuses
+}
+
+// resetLimiter forgets the in-memory rate limiter for an IP, reporting whether it had one
+func resetLimiter(ip string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := limiters[ip]; !ok {
+		return false
+	}
+	delete(limiters, ip)
+	return true
+}
+
+// rateLimitStatusHandler lists the in-memory rate limiters as JSON, or as a page for browsers
+func (s *Server) rateLimitStatusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := limiterStatuses()
+	if !wantsHTML(r) {
+		respondJSON(w, http.StatusOK, statuses)
+		return
+	}
+
+	err := s.renderTemplate(w, "admin_ratelimit.html", statuses)
+	if err != nil {
+		s.log(r.Context(), "ratelimit").Error("rendering rate limit page", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error rendering page")
+	}
+}
+
+// resetRateLimitHandler resets the rate limiter for a single IP
+func (s *Server) resetRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	if !resetLimiter(ip) {
+		s.respondError(w, r, http.StatusNotFound, "Not found")
+		return
+	}
+
+	s.logAdminAction(r, "ratelimit.reset", ip, true)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimitAdmin(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+
+	// One IP uses up its only token and is rejected once, the other hasn't been limited
+	limited := getMemoryLimiter("198.51.100.1", 60, 1)
+	limited.Allow()
+	limited.Allow()
+	getMemoryLimiter("198.51.100.2", 60, 5).Allow()
+	if got := testutil.ToFloat64(rateLimitedIPs); got != 1 {
+		t.Errorf("rate_limited_ips = %v, want 1", got)
+	}
+
+	admin := func(method, target, accept string) *http.Request {
+		r := newJSONRequest(method, target, "")
+		r.Header.Set("Authorization", "Bearer secret")
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		return r
+	}
+	if w := serveRequest(h, http.MethodGet, "/admin/ratelimit", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin/ratelimit without a token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w := serve(h, admin(http.MethodGet, "/admin/ratelimit", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/ratelimit = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var statuses []LimiterStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].IP != "198.51.100.1" || statuses[1].IP != "198.51.100.2" {
+		t.Fatalf("limiters = %+v, want both IPs in order", statuses)
+	}
+	if statuses[0].Violations != 1 || !statuses[0].Limited() || statuses[0].Burst != 1 {
+		t.Errorf("limited IP = %+v, want it out of tokens with one violation", statuses[0])
+	}
+	if statuses[1].Violations != 0 || statuses[1].Limited() || statuses[1].Limit != 60 {
+		t.Errorf("other IP = %+v, want it allowed with no violations", statuses[1])
+	}
+
+	// Browsers get a page highlighting only the limited IP
+	w = serve(h, admin(http.MethodGet, "/admin/ratelimit", "text/html"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/ratelimit as HTML = %d, want %d", w.Code, http.StatusOK)
+	}
+	page := w.Body.String()
+	if n := strings.Count(page, `class="limited"`); n != 1 {
+		t.Fatalf("page highlights %d rows, want 1:\n%s", n, page)
+	}
+	if row := page[strings.Index(page, `<tr class="limited">`):]; !strings.Contains(row[:strings.Index(row, "</tr>")], "198.51.100.1") {
+		t.Errorf("highlighted row = %q, want the limited IP", row)
+	}
+
+	// Resetting forgets the limiter, so the IP is no longer counted as limited
+	if w := serve(h, admin(http.MethodDelete, "/admin/ratelimit/198.51.100.1", "")); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /admin/ratelimit/198.51.100.1 = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := testutil.ToFloat64(rateLimitedIPs); got != 0 {
+		t.Errorf("rate_limited_ips after the reset = %v, want 0", got)
+	}
+	if w := serve(h, admin(http.MethodDelete, "/admin/ratelimit/198.51.100.1", "")); w.Code != http.StatusNotFound {
+		t.Errorf("resetting an untracked IP = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if statuses := limiterStatuses(); len(statuses) != 1 || statuses[0].IP != "198.51.100.2" {
+		t.Errorf("limiters after the reset = %+v, want only the other IP", statuses)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitBypassHeader carries a signed token that lets a client skip rate limiting
+const rateLimitBypassHeader = "X-RateLimit-Bypass"
+
+var (
+	errBypassTokenMalformed = errors.New("malformed bypass token")
+	errBypassTokenExpired   = errors.New("bypass token has expired")
+	errBypassTokenSignature = errors.New("invalid bypass token signature")
+)
+
+// isAllowlisted reports whether the IP falls in one of the allowlisted ranges
+func isAllowlisted(ip string, allowlist []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range allowlist {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// signBypassToken returns a bypass token for the subject that expires at the given time.
+// Tokens look like "<subject>.<unix expiry>.<hex HMAC-SHA256 of the first two parts>".
+func signBypassToken(subject string, expiresAt time.Time, secret []byte) string {
+	payload := subject + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + hex.EncodeToString(bypassSignature(payload, secret))
+}
+
+// bypassSignature returns the HMAC-SHA256 of a token payload
+func bypassSignature(payload string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifyBypassToken checks a bypass token's signature and expiry and returns its subject
+func verifyBypassToken(token string, secret []byte, now time.Time) (string, error) {
+	if len(secret) == 0 {
+		return "", errBypassTokenSignature
+	}
+
+	payload, signatureHex, ok := cutLast(token, ".")
+	if !ok {
+		return "", errBypassTokenMalformed
+	}
+	subject, expiry, ok := cutLast(payload, ".")
+	if !ok || subject == "" {
+		return "", errBypassTokenMalformed
+	}
+
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return "", errBypassTokenMalformed
+	}
+	if !hmac.Equal(signature, bypassSignature(payload, secret)) {
+		return "", errBypassTokenSignature
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", errBypassTokenMalformed
+	}
+	if !now.Before(time.Unix(expiresAt, 0)) {
+		return "", errBypassTokenExpired
+	}
+
+	return subject, nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// bypassesRateLimit reports whether the request comes from an allowlisted IP or carries a valid
+// bypass token. The IP is the one getIPAddress finds through TRUSTED_PROXIES, so sending an
+// allowlisted address in X-Forwarded-For doesn't skip the limiter.
+func (s *Server) bypassesRateLimit(r *http.Request) bool {
+	if isAllowlisted(s.getIPAddress(r), s.config.RateLimitAllowlist) {
+		return true
+	}
+
+	token := r.Header.Get(rateLimitBypassHeader)
+	if token == "" {
+		return false
+	}
+
+	if _, err := verifyBypassToken(token, s.config.RateLimitBypassSecret, time.Now()); err != nil {
+		componentLogger("ratelimit").Warn("rejected rate limit bypass token", "request_id", requestIDFromContext(r.Context()), "ip", s.getIPAddress(r), "err", err)
+		return false
+	}
+	return true
+}
+
+// createBypassTokenHandler issues a bypass token for a subject such as "ci" that is valid for ttl (a Go duration)
+func (s *Server) createBypassTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if len(s.config.RateLimitBypassSecret) == 0 {
+		s.respondError(w, r, http.StatusConflict, "RATE_LIMIT_BYPASS_SECRET is not set")
+		return
+	}
+
+	var body struct {
+		Subject string `json:"subject"`
+		TTL     string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ttl, err := time.ParseDuration(body.TTL)
+	if err != nil || ttl <= 0 {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid ttl")
+		return
+	}
+	if body.Subject == "" || strings.Contains(body.Subject, ".") {
+		s.respondError(w, r, http.StatusBadRequest, "Subject must be non-empty and may not contain '.'")
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	s.logAdminAction(r, "ratelimit.token.create", body.Subject, true)
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"token":     signBypassToken(body.Subject, expiresAt, s.config.RateLimitBypassSecret),
+		"expiresAt": expiresAt.UTC().Truncate(time.Second),
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsAllowlisted(t *testing.T) {
+	allowlist := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("203.0.113.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("fe80::1/128"),
+	}
+
+	for ip, want := range map[string]bool{
+		"10.0.0.1":          true,
+		"10.255.255.255":    true,
+		"11.0.0.1":          false,
+		"203.0.113.7":       true,
+		"203.0.113.8":       false,
+		"::ffff:10.1.2.3":   true,
+		"2001:db8::1":       true,
+		"2001:db8:abcd::42": true,
+		"2001:db9::1":       false,
+		"fe80::1":           true,
+		"fe80::2":           false,
+		"":                  false,
+		"garbage":           false,
+	} {
+		if got := isAllowlisted(ip, allowlist); got != want {
+			t.Errorf("isAllowlisted(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestVerifyBypassToken(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	valid := signBypassToken("ci", now.Add(time.Hour), secret)
+
+	subject, err := verifyBypassToken(valid, secret, now)
+	if err != nil || subject != "ci" {
+		t.Fatalf("verifyBypassToken(valid) = %q, %v; want \"ci\", nil", subject, err)
+	}
+
+	payload, signature, _ := cutLast(valid, ".")
+	// Change one hex digit of the signature
+	flipped := []byte(signature)
+	if flipped[0] == '0' {
+		flipped[0] = '1'
+	} else {
+		flipped[0] = '0'
+	}
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		want  error
+	}{
+		{name: "expired", token: signBypassToken("ci", now.Add(-time.Second), secret), now: now, want: errBypassTokenExpired},
+		{name: "expires now", token: signBypassToken("ci", now, secret), now: now.Add(time.Second), want: errBypassTokenExpired},
+		{name: "tampered signature", token: payload + "." + string(flipped), now: now, want: errBypassTokenSignature},
+		{name: "extended expiry", token: strings.Replace(valid, "ci.", "ci.9", 1), now: now, want: errBypassTokenSignature},
+		{name: "changed subject", token: "admin" + strings.TrimPrefix(valid, "ci"), now: now, want: errBypassTokenSignature},
+		{name: "other secret", token: signBypassToken("ci", now.Add(time.Hour), []byte("another secret of enough length!")), now: now, want: errBypassTokenSignature},
+		{name: "signature not hex", token: payload + ".zz", now: now, want: errBypassTokenMalformed},
+		{name: "no signature", token: "ci", now: now, want: errBypassTokenMalformed},
+		{name: "no subject", token: signBypassToken("", now.Add(time.Hour), secret), now: now, want: errBypassTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifyBypassToken(tt.token, secret, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("verifyBypassToken = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := verifyBypassToken(valid, nil, now); !errors.Is(err, errBypassTokenSignature) {
+		t.Errorf("verifyBypassToken without a secret = %v, want %v", err, errBypassTokenSignature)
+	}
+}
+
+func TestBypassesRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	trustProxies(t, s, "10.0.0.0/8")
+	s.config.RateLimitAllowlist = []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("2001:db8::/32")}
+	s.config.RateLimitBypassSecret = []byte("0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		token      string
+		want       bool
+	}{
+		{name: "allowlisted", remoteAddr: "203.0.113.9:1000", want: true},
+		{name: "allowlisted IPv6", remoteAddr: "[2001:db8::9]:1000", want: true},
+		{name: "not allowlisted", remoteAddr: "198.51.100.9:1000", want: false},
+		{name: "forged header", remoteAddr: "198.51.100.9:1000", forwarded: "203.0.113.9", want: false},
+		{name: "forged hop through a proxy", remoteAddr: "10.0.0.2:1000", forwarded: "203.0.113.9, 198.51.100.9", want: false},
+		{name: "allowlisted through a proxy", remoteAddr: "10.0.0.2:1000", forwarded: "203.0.113.9", want: true},
+		{name: "valid token", remoteAddr: "198.51.100.9:1000", token: signBypassToken("ci", time.Now().Add(time.Hour), s.config.RateLimitBypassSecret), want: true},
+		{name: "expired token", remoteAddr: "198.51.100.9:1000", token: signBypassToken("ci", time.Now().Add(-time.Hour), s.config.RateLimitBypassSecret), want: false},
+		{name: "token from another secret", remoteAddr: "198.51.100.9:1000", token: signBypassToken("ci", time.Now().Add(time.Hour), []byte("another secret of enough length!")), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/increment", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.token != "" {
+				r.Header.Set(rateLimitBypassHeader, tt.token)
+			}
+			if got := s.bypassesRateLimit(r); got != tt.want {
+				t.Errorf("bypassesRateLimit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoLimiterTimeout bounds how long a rate limit check may wait on MongoDB
+const mongoLimiterTimeout = 500 * time.Millisecond
+
+// rateLimitWindow is the length of each fixed rate limiting window
+const rateLimitWindow = time.Minute
+
+// rateLimitWindowDoc is a request count for one key in one window
+type rateLimitWindowDoc struct {
+	ID        string    `bson:"_id"`
+	Count     int       `bson:"count"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// mongoLimiter is a fixed-window rate limiter shared by every instance through MongoDB.
+// Each check costs one findAndModify round trip, so it is slower than the in-memory limiter.
+type mongoLimiter struct {
+	coll              *mongo.Collection
+	key               string
+	requestsPerMinute int
+}
+
+// Allow counts the request in the current window and reports whether it is within the limit.
+// If MongoDB can't be reached the request is allowed, so an outage doesn't take the site down with it.
+func (l *mongoLimiter) Allow() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoLimiterTimeout)
+	defer cancel()
+
+	windowStart := time.Now().Truncate(rateLimitWindow)
+
+	var window rateLimitWindowDoc
+	err := l.coll.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": fmt.Sprintf("%s:%d", l.key, windowStart.Unix())},
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"expiresAt": windowStart.Add(2 * rateLimitWindow)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&window)
+	if err != nil {
+		componentLogger("ratelimit").Error("checking rate limit in MongoDB, allowing request", "err", err)
+		return true
+	}
+
+	return window.Count <= l.requestsPerMinute
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoLimiterSharesWindows(t *testing.T) {
+	s := newMongoTestServer(t)
+	s.config.RateLimitBackend = "mongo"
+
+	for i := range 3 {
+		if !s.getLimiter("203.0.113.1", 3, 3).Allow() {
+			t.Fatalf("request %d was limited, want 3 allowed a minute", i+1)
+		}
+	}
+	// Each request gets a new limiter, as it would on another instance, and sees the same count
+	if s.getLimiter("203.0.113.1", 3, 3).Allow() {
+		t.Error("fourth request in the window was allowed")
+	}
+	if !s.getLimiter("203.0.113.2", 3, 3).Allow() {
+		t.Error("another IP was limited by the first one's requests")
+	}
+}
+
+func TestMongoLimiterFailsOpen(t *testing.T) {
+	// Nothing listens on port 1, so every check fails once server selection gives up
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	limiter := &mongoLimiter{coll: client.Database("test").Collection("rate_limits"), key: "203.0.113.1", requestsPerMinute: 1}
+
+	for i := range 3 {
+		if !limiter.Allow() {
+			t.Fatalf("request %d was limited while MongoDB was unreachable, want it allowed", i+1)
+		}
+	}
+}
+
+// BenchmarkRateLimitBackends compares the cost of one rate limit check with each backend. The
+// mongo backend is skipped unless MONGO_TEST_URI is set.
+func BenchmarkRateLimitBackends(b *testing.B) {
+	backends := []struct {
+		name      string
+		newServer func(b *testing.B) *Server
+	}{
+		{"memory", func(b *testing.B) *Server { return newTestServer(b) }},
+		{"mongo", func(b *testing.B) *Server { return newMongoTestServer(b) }},
+	}
+	for _, backend := range backends {
+		b.Run(backend.name, func(b *testing.B) {
+			resetRateLimiters(b)
+			s := backend.newServer(b)
+			s.config.RateLimitBackend = backend.name
+			for b.Loop() {
+				// A budget large enough that every check is allowed, as most requests are
+				s.getLimiter("203.0.113.1", 1<<30, 1<<30).Allow()
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// minAPITokenLength is the shortest API token accepted, so tokens can't be guessed
+const minAPITokenLength = 16
+
+// loadAPITokens reads API_TOKENS, a comma-separated list of API tokens
+func loadAPITokens(env *envReader) []string {
+	var tokens []string
+	for token := range strings.SplitSeq(env.string("API_TOKENS", ""), ",") {
+		if token = strings.TrimSpace(token); token == "" {
+			continue
+		}
+		if len(token) < minAPITokenLength {
+			env.problem("API_TOKENS", "tokens must be at least %d characters", minAPITokenLength)
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// apiTokenKey returns the rate limiter key for the request's API token, and false if it doesn't
+// carry a known one. The key is a hash of the token so it never shows up in rate limit state.
+func (s *Server) apiTokenKey(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+
+	for _, known := range s.config.APITokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			sum := sha256.Sum256([]byte(token))
+			return "token:" + hex.EncodeToString(sum[:8]), true
+		}
+	}
+	return "", false
+}
+
+// rateLimitKey returns what a request is rate limited by, with the rate and burst that apply:
+// its API token with the limits raised by APITokenRateLimitMultiplier, or otherwise its client
+func (s *Server) rateLimitKey(r *http.Request, requestsPerMinute, burst int) (string, int, int) {
+	if key, ok := s.apiTokenKey(r); ok {
+		return key, requestsPerMinute * s.config.APITokenRateLimitMultiplier, burst * s.config.APITokenRateLimitMultiplier
+	}
+	return s.clientKey(r), requestsPerMinute, burst
+}
+
+// clientKey returns what an anonymous client is rate limited by: the signed session it sent
+// back, so visitors sharing an IP don't share a budget, or otherwise its IP. A session issued to
+// this request doesn't count, so dropping the cookie can't earn a fresh budget every request.
+func (s *Server) clientKey(r *http.Request) string {
+	if id := s.sessionCookieValue(r); id != "" {
+		return sessionRateLimitKey(id)
+	}
+	return s.getIPAddress(r)
+}
+
+// sessionIPRateLimitMultiplier is how many times a client's budget the sessions from one IP get
+// between them, so visitors behind a shared IP don't starve each other but collecting session
+// cookies can't multiply a client's budget without end
+const sessionIPRateLimitMultiplier = 10
+
+// allowRequest reports whether a request rate limited by key, from rateLimitKey, may go ahead,
+// counting it against the key's budget for scope ("" for the shared one). A session's requests
+// also count against a budget sessionIPRateLimitMultiplier times as large for its IP.
+func (s *Server) allowRequest(r *http.Request, scope, key string, requestsPerMinute, burst int) bool {
+	if scope != "" {
+		scope += ":"
+	}
+	if !s.getLimiter(scope+key, requestsPerMinute, burst).Allow() {
+		return false
+	}
+	if !strings.HasPrefix(key, sessionRateLimitPrefix) {
+		return true
+	}
+	ipKey := scope + "sessions:" + s.getIPAddress(r)
+	return s.getLimiter(ipKey, requestsPerMinute*sessionIPRateLimitMultiplier, burst*sessionIPRateLimitMultiplier).Allow()
+}
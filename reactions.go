@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// reactionEmoji are the emoji quotes can be reacted to with, in the order they're shown
+var reactionEmoji = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// quoteReactionsPerMinute is how many reactions a client may send per minute
+const quoteReactionsPerMinute = 30
+
+var errAlreadyReacted = errors.New("already reacted to this quote with that emoji")
+
+// QuoteReaction records that a session reacted to a quote with an emoji, so it can't again
+type QuoteReaction struct {
+	// ID is made from the quote, emoji, and a hash of the session, so a repeat reaction has
+	// the same ID as the first
+	ID        string             `bson:"_id"`
+	QuoteID   primitive.ObjectID `bson:"quoteId"`
+	Emoji     string             `bson:"emoji"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// newQuoteReaction returns the record of a session reacting to a quote. The session is hashed so
+// its ID isn't stored alongside what it reacted to.
+func newQuoteReaction(quoteID primitive.ObjectID, sessionID, emoji string) QuoteReaction {
+	sum := sha256.Sum256([]byte(sessionID))
+	return QuoteReaction{
+		ID:        quoteID.Hex() + ":" + emoji + ":" + hex.EncodeToString(sum[:]),
+		QuoteID:   quoteID,
+		Emoji:     emoji,
+		CreatedAt: time.Now(),
+	}
+}
+
+// ReactionRequest is the body of a reaction request
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// ReactionUpdate is a quote's reaction counts after someone reacts to it, returned to them and
+// broadcast to WebSocket clients with the type "reactions"
+type ReactionUpdate struct {
+	Type      string             `json:"type,omitempty"`
+	QuoteID   primitive.ObjectID `json:"quoteId"`
+	Reactions map[string]int     `json:"reactions"`
+}
+
+// reactHandler adds a reaction to a quote, once per session, quote, and emoji. The session is
+// the one the server signed into the visitor's cookie, so clients that don't keep cookies, or
+// make up session IDs, can't react.
+func (s *Server) reactHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		s.apiError(w, r, http.StatusNotFound, "Quote not found")
+		return
+	}
+	sessionID := s.sessionCookieValue(r)
+	if sessionID == "" {
+		s.apiError(w, r, http.StatusForbidden, "Reacting needs a session cookie, which visiting the site gives you")
+		return
+	}
+
+	var body ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.apiError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !slices.Contains(reactionEmoji, body.Emoji) {
+		s.apiError(w, r, http.StatusBadRequest, "Unsupported emoji")
+		return
+	}
+
+	ctx, cancel := s.writeContext(r)
+	defer cancel()
+
+	reactions, err := s.quotes.ReactToQuote(ctx, newQuoteReaction(id, sessionID, body.Emoji))
+	switch {
+	case errors.Is(err, errAlreadyReacted):
+		s.apiError(w, r, http.StatusConflict, "You've already reacted to this quote with that emoji")
+		return
+	case errors.Is(err, mongo.ErrNoDocuments):
+		s.apiError(w, r, http.StatusNotFound, "Quote not found")
+		return
+	case err != nil:
+		status, message := s.writeError(r.Context(), "quotes", "react to quote", err, "Error saving reaction")
+		s.apiError(w, r, status, message)
+		return
+	}
+	s.home.invalidate()
+
+	update := ReactionUpdate{QuoteID: id, Reactions: reactions}
+	s.hub.BroadcastReactions(update)
+	respondJSON(w, http.StatusOK, update)
+}
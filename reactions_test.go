@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// react posts a reaction to a quote with the given session cookie value, if any
+func react(h http.Handler, quoteID, session, emoji string) *httptest.ResponseRecorder {
+	r := newJSONRequest(http.MethodPost, "/api/v1/quotes/"+quoteID+"/react", `{"emoji":"`+emoji+`"}`)
+	if session != "" {
+		r.AddCookie(&http.Cookie{Name: sessionIDCookie, Value: session})
+	}
+	return serve(h, r)
+}
+
+// testReactToQuote checks reacting to a quote through the API against s's store
+func testReactToQuote(t *testing.T, s *Server) {
+	resetRateLimiters(t)
+	h := s.routes()
+	quote, _ := newQuote("Ada", "Simplicity is prerequisite for reliability", "", nil)
+	if err := s.quotes.InsertQuote(context.Background(), quote); err != nil {
+		t.Fatal(err)
+	}
+	id := quote.ID.Hex()
+	first, second := s.signSession(newSessionID()), s.signSession(newSessionID())
+	// A session the server didn't sign counts as none, so forging IDs can't add reactions
+	forged := newSessionID() + "." + strings.Repeat("0", 64)
+
+	tests := []struct {
+		name    string
+		session string
+		emoji   string
+		status  int
+		want    map[string]int
+	}{
+		{name: "first", session: first, emoji: "👍", status: http.StatusOK, want: map[string]int{"👍": 1}},
+		{name: "repeat", session: first, emoji: "👍", status: http.StatusConflict},
+		{name: "another emoji", session: first, emoji: "❤️", status: http.StatusOK, want: map[string]int{"👍": 1, "❤️": 1}},
+		{name: "another session", session: second, emoji: "👍", status: http.StatusOK, want: map[string]int{"👍": 2, "❤️": 1}},
+		{name: "unlisted emoji", session: second, emoji: "💩", status: http.StatusBadRequest},
+		{name: "no session", emoji: "🎉", status: http.StatusForbidden},
+		{name: "unsigned session", session: newSessionID(), emoji: "🎉", status: http.StatusForbidden},
+		{name: "forged session", session: forged, emoji: "🎉", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := react(h, id, tt.session, tt.emoji)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.want == nil {
+			continue
+		}
+		var update ReactionUpdate
+		if err := json.NewDecoder(w.Body).Decode(&update); err != nil {
+			t.Fatal(err)
+		}
+		if update.QuoteID != quote.ID || !maps.Equal(update.Reactions, tt.want) {
+			t.Errorf("%s: response = %+v, want reactions %v", tt.name, update, tt.want)
+		}
+	}
+
+	if w := react(h, primitive.NewObjectID().Hex(), first, "👍"); w.Code != http.StatusNotFound {
+		t.Errorf("missing quote: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := react(h, "nope", first, "👍"); w.Code != http.StatusNotFound {
+		t.Errorf("malformed quote ID: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestReactToQuote(t *testing.T) {
+	testReactToQuote(t, newTestServer(t))
+}
+
+func TestReactToQuoteMongo(t *testing.T) {
+	testReactToQuote(t, newMongoTestServer(t))
+}
+
+func TestReactionsAreBroadcast(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	quote, _ := newQuote("Ada", "Simplicity is prerequisite for reliability", "", nil)
+	s.quotes.InsertQuote(context.Background(), quote)
+
+	h := s.routes()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	client := dialHub(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws")
+	// The first message is the current counter value
+	if _, err := client.NextUpdate(2 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := react(h, quote.ID.Hex(), s.signSession(newSessionID()), "🎉"); w.Code != http.StatusOK {
+		t.Fatalf("reaction status = %d, want %d", w.Code, http.StatusOK)
+	}
+	message, err := client.NextUpdate(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != messageTypeReactions || message.QuoteID != quote.ID.Hex() || message.Reactions["🎉"] != 1 {
+		t.Errorf("broadcast = %+v, want the quote's reactions with one 🎉", message)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// readOnlyModeMessage is returned for writes refused while read-only mode is on
+const readOnlyModeMessage = "The site is in read-only mode, so changes aren't being accepted right now."
+
+// errReadOnlyMode is returned by GraphQL mutations while read-only mode is on
+var errReadOnlyMode = errors.New(readOnlyModeMessage)
+
+// ReadOnlyStatus represents whether the site is refusing writes
+type ReadOnlyStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// setReadOnly switches read-only mode. Unlike the circuit breaker this is deliberate, such as to
+// stop quote spam while the site is getting a lot of traffic, and stays on until it's switched off.
+func (s *Server) setReadOnly(enabled bool) {
+	if s.readOnly.Swap(enabled) == enabled {
+		return
+	}
+	// The cached home page has the forms shown or hidden for the old mode
+	s.home.invalidate()
+
+	if enabled {
+		componentLogger("readonly").Info("read-only mode enabled")
+	} else {
+		componentLogger("readonly").Info("read-only mode disabled")
+	}
+}
+
+// isReadOnly reports whether read-only mode is on
+func (s *Server) isReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// writableMiddleware refuses a request with 403 while read-only mode is on. It wraps the public
+// routes that change counters or quotes; reads, the WebSocket stream, page view counting, and
+// the admin routes keep working.
+func (s *Server) writableMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.isReadOnly() {
+			if r.Context().Value(apiVersionKey{}) != nil {
+				s.apiError(w, r, http.StatusForbidden, readOnlyModeMessage)
+			} else {
+				s.respondError(w, r, http.StatusForbidden, readOnlyModeMessage)
+			}
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// getReadOnlyHandler returns whether read-only mode is on
+func (s *Server) getReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, ReadOnlyStatus{Enabled: s.isReadOnly()})
+}
+
+// setReadOnlyHandler switches read-only mode on or off
+func (s *Server) setReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	var status ReadOnlyStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	s.setReadOnly(status.Enabled)
+	s.logAdminAction(r, "readonly.update", fmt.Sprintf("enabled=%t", status.Enabled), true)
+
+	s.getReadOnlyHandler(w, r)
+}
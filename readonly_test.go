@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyModeRefusesWrites(t *testing.T) {
+	resetRateLimiters(t)
+	setGitHubRepos(t, nil)
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	s.config.Counting = CounterSettings{PageViews: true, Webhook: true}
+	if err := s.counters.InitCounters(t.Context(), "webhook", "totalClicks"); err != nil {
+		t.Fatal(err)
+	}
+	h := s.routes()
+
+	setReadOnlyMode := func(enabled bool) {
+		t.Helper()
+		body := `{"enabled":false}`
+		if enabled {
+			body = `{"enabled":true}`
+		}
+		r := newJSONRequest(http.MethodPost, "/admin/read-only", body)
+		r.Header.Set("Authorization", "Bearer secret")
+		var status ReadOnlyStatus
+		if w := serve(h, r); w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&status) != nil || status.Enabled != enabled {
+			t.Fatalf("POST /admin/read-only %s = %d %+v", body, w.Code, status)
+		}
+	}
+
+	// Render the home page before switching, so a cached copy with the forms could be served
+	w := serveRequest(h, http.MethodGet, "/", "", "198.51.100.61:1000")
+	for _, shown := range []string{`action="/quote"`, `id="increment-btn"`} {
+		if !strings.Contains(w.Body.String(), shown) {
+			t.Fatalf("home page before read-only mode doesn't contain %s", shown)
+		}
+	}
+	setReadOnlyMode(true)
+
+	writes := []struct{ method, path, body string }{
+		{http.MethodPost, "/increment", ""},
+		{http.MethodPost, "/decrement", ""},
+		{http.MethodPost, "/quote", ""},
+		{http.MethodPost, "/api/v1/counters", `{"name":"visits"}`},
+		{http.MethodPost, "/api/v1/counters/webhook/increment", ""},
+		{http.MethodPost, "/api/v2/counters/webhook/increment", `{"delta":2}`},
+		{http.MethodPost, "/api/v1/counters/webhook/decrement", ""},
+		{http.MethodPost, "/api/v1/quotes/0123456789abcdef01234567/react", `{"emoji":"👍"}`},
+	}
+	for _, write := range writes {
+		r := newJSONRequest(write.method, write.path, write.body)
+		r.Header.Set("Accept", "application/json")
+		w := serve(h, r)
+		var body ErrorResponse
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusForbidden || body.Error != readOnlyModeMessage {
+			t.Errorf("%s %s = %d %q, want %d with the read-only message", write.method, write.path, w.Code, body.Error, http.StatusForbidden)
+		}
+	}
+
+	w = serve(h, newJSONRequest(http.MethodPost, "/graphql", `{"query":"mutation { incrementCounter(id: \"webhook\") { count } }"}`))
+	if !strings.Contains(w.Body.String(), readOnlyModeMessage) {
+		t.Errorf("GraphQL mutation = %s, want the read-only message", w.Body)
+	}
+
+	// Reads carry on, and the home page hides what would be refused
+	w = serveRequest(h, http.MethodGet, "/", "", "198.51.100.62:1000")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET / status = %d, want %d", w.Code, http.StatusOK)
+	}
+	for _, hidden := range []string{`action="/quote"`, `id="increment-btn"`} {
+		if strings.Contains(w.Body.String(), hidden) {
+			t.Errorf("home page in read-only mode contains %s", hidden)
+		}
+	}
+	if w := serveRequest(h, http.MethodGet, "/api/v1/counters/webhook", "", ""); w.Code != http.StatusOK {
+		t.Errorf("GET /api/v1/counters/webhook status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if total, err := s.pageViews.TotalPageViews(t.Context()); err != nil || total != 2 {
+		t.Errorf("TotalPageViews() = %d, %v; want both home page views counted", total, err)
+	}
+
+	var health HealthStatus
+	json.NewDecoder(serveRequest(h, http.MethodGet, "/healthz", "", "").Body).Decode(&health)
+	if !health.ReadOnly {
+		t.Error("/healthz doesn't report read-only mode")
+	}
+
+	setReadOnlyMode(false)
+	if w := serve(h, newJSONRequest(http.MethodPost, "/increment", "")); w.Code != http.StatusOK {
+		t.Errorf("increment after read-only mode status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestReadOnlyModeIsPerServer(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	first.setReadOnly(true)
+	if !first.isReadOnly() || second.isReadOnly() {
+		t.Errorf("read-only = %t and %t, want only the server switched on", first.isReadOnly(), second.isReadOnly())
+	}
+	if w := serveRequest(second.routes(), http.MethodPost, "/increment", "", ""); w.Code != http.StatusOK {
+		t.Errorf("increment on the other server = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorResponse represents a JSON error response
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Status    int    `json:"status"`
+	RequestID string `json:"requestId,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// ErrorPageData represents the data passed to the error page template
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	Message    string
+	RequestID  string
+}
+
+// respondJSON writes v as a JSON response with the given status
+func respondJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("encoding JSON response", "err", err)
+	}
+}
+
+// newErrorResponse builds the JSON error body for a request
+func newErrorResponse(r *http.Request, status int, message string) ErrorResponse {
+	version, _ := r.Context().Value(apiVersionKey{}).(string)
+	return ErrorResponse{
+		Error:     message,
+		Status:    status,
+		RequestID: requestIDFromContext(r.Context()),
+		Version:   version,
+	}
+}
+
+// respondError writes an error as JSON if the client prefers it, or as the error page otherwise
+func (s *Server) respondError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if wantsJSON(r) {
+		respondJSON(w, status, newErrorResponse(r, status, message))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	err := s.renderTemplate(w, "error.html", ErrorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+		RequestID:  requestIDFromContext(r.Context()),
+	})
+	if err != nil {
+		s.log(r.Context(), "http").Error("rendering error page", "err", err)
+
+		// The error page can't be shown while reloaded templates are broken, so show why instead
+		var parseErr *templateParseError
+		if errors.As(err, &parseErr) {
+			templateErrorPage.Execute(w, parseErr)
+		}
+	}
+}
+
+// templateErrorPage replaces the error page when reloaded templates fail to parse
+var templateErrorPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Template error</title></head>
+<body>
+<h1>Template error</h1>
+<p>The templates failed to parse, so the page can't be rendered. Fix the template and reload.</p>
+<pre>{{.}}</pre>
+</body>
+</html>
+`))
+
+// error404Handler responds with the 404 page, or a JSON error if the client prefers it
+func (s *Server) error404Handler(w http.ResponseWriter, r *http.Request) {
+	s.respondErrorPage(w, r, http.StatusNotFound, "404", "Not found")
+}
+
+// error500Handler responds with the 500 page, showing the request ID so the failure can be found
+// in the logs, or a JSON error if the client prefers it
+func (s *Server) error500Handler(w http.ResponseWriter, r *http.Request) {
+	s.respondErrorPage(w, r, http.StatusInternalServerError, "500", "Internal server error")
+}
+
+// respondErrorPage writes status with page rendered inside the base layout. It needs nothing from
+// the database, so it works while MongoDB is down. If page can't be rendered the plain error page
+// is sent with message instead.
+func (s *Server) respondErrorPage(w http.ResponseWriter, r *http.Request, status int, page, message string) {
+	if wantsJSON(r) {
+		respondJSON(w, status, newErrorResponse(r, status, message))
+		return
+	}
+
+	var body bytes.Buffer
+	err := s.render(&body, page, PageData{
+		Name:      siteName,
+		Commit:    currentBuildInfo().ShortCommit(),
+		Theme:     requestTheme(r),
+		RequestID: requestIDFromContext(r.Context()),
+	})
+	if err != nil {
+		s.log(r.Context(), "http").Error("rendering error page", "page", page, "err", err)
+		s.respondError(w, r, status, message)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// negotiate returns the format to respond to r in, "json" or "html", whichever its Accept
+// header prefers. A missing header, a bare */*, or a tie counts as no preference, which gets
+// "html".
+func negotiate(r *http.Request) string {
+	jsonQ, htmlQ := acceptQualities(r)
+	if jsonQ > 0 && jsonQ > htmlQ {
+		return "json"
+	}
+	return "html"
+}
+
+// wantsJSON reports whether the Accept header prefers JSON over HTML
+func wantsJSON(r *http.Request) bool {
+	return negotiate(r) == "json"
+}
+
+// wantsHTML reports whether the Accept header explicitly prefers HTML over JSON, as browsers do
+func wantsHTML(r *http.Request) bool {
+	jsonQ, htmlQ := acceptQualities(r)
+	return htmlQ > 0 && htmlQ > jsonQ
+}
+
+// acceptQualities returns the quality values the Accept header gives JSON and HTML, which are
+// zero when nothing matches them. Each comes from the most specific media range matching it, so
+// "text/html;q=0.5, */*" gives HTML 0.5, and if ranges are equally specific the highest wins.
+func acceptQualities(r *http.Request) (jsonQ, htmlQ float64) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return 0, 0
+	}
+
+	// How specific the range each quality came from is: 1 for */*, 2 for type/*, 3 for a full type
+	var jsonSpecificity, htmlSpecificity int
+	match := func(q *float64, specificity *int, rangeSpecificity int, rangeQ float64) {
+		switch {
+		case rangeSpecificity > *specificity:
+			*q, *specificity = rangeQ, rangeSpecificity
+		case rangeSpecificity == *specificity:
+			*q = max(*q, rangeQ)
+		}
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch {
+		case mediaType == "*/*":
+			match(&jsonQ, &jsonSpecificity, 1, q)
+			match(&htmlQ, &htmlSpecificity, 1, q)
+		case mediaType == "application/*":
+			match(&jsonQ, &jsonSpecificity, 2, q)
+		case mediaType == "text/*":
+			match(&htmlQ, &htmlSpecificity, 2, q)
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			match(&jsonQ, &jsonSpecificity, 3, q)
+		case mediaType == "text/html":
+			match(&htmlQ, &htmlSpecificity, 3, q)
+		}
+	}
+
+	return jsonQ, htmlQ
+}
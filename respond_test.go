@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptNegotiation(t *testing.T) {
+	tests := []struct {
+		accept    string
+		wantsJSON bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"application/problem+json", true},
+		{"text/html", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"application/json, text/html;q=0.9", true},
+		{"text/html, application/json;q=0.5", false},
+		{"*/*;q=0.1, application/json", true},
+		{"application/json;q=0, */*", false},
+		{"text/*;q=0.5, text/html", false},
+		{"application/json;q=0.5, text/html;q=0.5", false},
+		{"not a media type", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsJSON(r); got != tt.wantsJSON {
+			t.Errorf("wantsJSON(%q) = %v, want %v", tt.accept, got, tt.wantsJSON)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "html"},
+		{"*/*", "html"},
+		{"application/json", "json"},
+		{"text/html", "html"},
+		{"application/*", "json"},
+		{"text/*, application/json;q=0.9", "html"},
+		// The most specific range decides, whatever the quality of broader ones
+		{"application/json;q=0.4, application/*;q=1, text/html;q=0.5", "html"},
+		{"text/html;q=0.3, */*", "json"},
+		{"application/json;q=0, */*", "html"},
+	}
+	for _, tt := range tests {
+		r := newJSONRequest(http.MethodGet, "/", "")
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := negotiate(r); got != tt.want {
+			t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestRespondErrorNegotiatesFormat(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "text/html"},
+		{"*/*", "text/html"},
+		{"text/html", "text/html"},
+		{"application/json", "application/json"},
+		{"text/html;q=0.5, application/json", "application/json"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		s.respondError(w, r, http.StatusBadRequest, "Bad input")
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Accept %q: status = %d, want %d", tt.accept, w.Code, http.StatusBadRequest)
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+			t.Errorf("Accept %q: Content-Type = %q, want %s", tt.accept, got, tt.contentType)
+			continue
+		}
+		if tt.contentType != "application/json" {
+			if !strings.Contains(w.Body.String(), "Bad input") {
+				t.Errorf("Accept %q: error page doesn't contain the message", tt.accept)
+			}
+			continue
+		}
+
+		var body ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Accept %q: decoding: %v", tt.accept, err)
+		}
+		if body.Error != "Bad input" || body.Status != http.StatusBadRequest {
+			t.Errorf("Accept %q: body = %+v, want error %q and status %d", tt.accept, body, "Bad input", http.StatusBadRequest)
+		}
+	}
+}
+
+func TestErrorPagesRenderWithoutDatabase(t *testing.T) {
+	s := newTestServer(t)
+	flaky := &flakyStore{memoryStore: newMemoryStore()}
+	flaky.down.Store(true)
+	s.quotes, s.counters, s.pageViews, s.sessions = flaky, flaky, flaky, flaky
+
+	tests := []struct {
+		handler http.HandlerFunc
+		status  int
+		heading string
+	}{
+		{s.error404Handler, http.StatusNotFound, "404 Not Found"},
+		{s.error500Handler, http.StatusInternalServerError, "500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, "0123456789abcdef"))
+		w := httptest.NewRecorder()
+		tt.handler(w, r)
+
+		body := w.Body.String()
+		if w.Code != tt.status || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: response = %d %q, want %d HTML", tt.heading, w.Code, w.Header().Get("Content-Type"), tt.status)
+		}
+		for _, want := range []string{"<h1>" + siteName + "</h1>", tt.heading, "Request ID: 0123456789abcdef", "<footer>"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s page doesn't contain %q", tt.heading, want)
+			}
+		}
+	}
+}
+
+func TestNotFoundMiddlewareReplacesPlainTextNotFound(t *testing.T) {
+	h := newTestServer(t).notFoundMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/quote/missing":
+			respondJSON(w, http.StatusNotFound, ErrorResponse{Error: "Quote not found", Status: http.StatusNotFound})
+		case "/ok":
+			w.Write([]byte("ok"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	w := serveRequest(h, http.MethodGet, "/nope", "", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "404 Not Found") || strings.Contains(w.Body.String(), "404 page not found") {
+		t.Errorf("GET /nope = %d %q, want the 404 page alone", w.Code, w.Body.String())
+	}
+
+	w = serveRequest(h, http.MethodGet, "/api/v1/nope", "", "")
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != http.StatusNotFound || body.Status != http.StatusNotFound {
+		t.Errorf("GET /api/v1/nope = %d %+v (%v), want a JSON 404", w.Code, body, err)
+	}
+
+	// A 404 the handler wrote itself says what wasn't found, so it's kept
+	w = serveRequest(h, http.MethodGet, "/quote/missing", "", "")
+	if !strings.Contains(w.Body.String(), "Quote not found") {
+		t.Errorf("GET /quote/missing = %d %q, want the handler's own 404", w.Code, w.Body.String())
+	}
+
+	if w := serveRequest(h, http.MethodGet, "/ok", "", ""); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("GET /ok = %d %q, want it passed through", w.Code, w.Body.String())
+	}
+}
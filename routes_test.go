@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// registeredRoutes lists every route registered unconditionally, with a path that matches it
+// and the methods it's registered for
+var registeredRoutes = []struct {
+	path    string
+	methods []string
+}{
+	{"/", []string{"GET"}},
+	{"/increment", []string{"POST"}},
+	{"/decrement", []string{"POST"}},
+	{"/quote", []string{"POST"}},
+	{"/preferences", []string{"POST"}},
+	{"/contact", []string{"GET", "POST"}},
+	{"/ws", []string{"GET"}},
+	{"/admin/bans", []string{"GET", "POST"}},
+	{"/admin/bans/1", []string{"DELETE"}},
+	{"/admin/ws", []string{"GET"}},
+	{"/admin/ws/disconnect", []string{"POST"}},
+	{"/admin/ws/clients", []string{"GET"}},
+	{"/admin/maintenance", []string{"GET", "POST"}},
+	{"/admin/read-only", []string{"GET", "POST"}},
+	{"/admin/quotes/authors", []string{"GET"}},
+	{"/admin/messages", []string{"GET"}},
+	{"/admin/messages/1/read", []string{"POST"}},
+	{"/admin/export", []string{"GET"}},
+	{"/admin/import", []string{"POST"}},
+	{"/admin/runtime", []string{"GET"}},
+	{"/debug/pprof/", []string{"GET"}},
+	{"/debug/pprof/symbol", []string{"GET", "POST"}},
+	{"/robots.txt", []string{"GET"}},
+	{"/sitemap.xml", []string{"GET"}},
+	{"/feed", []string{"GET"}},
+	{"/openapi.json", []string{"GET"}},
+	{"/uploads/0123456789abcdef-photo.jpg", []string{"GET"}},
+	{"/static/robots.txt", []string{"GET"}},
+	{"/api/v1/counters", []string{"GET", "POST"}},
+	{"/api/v1/counters/webhook", []string{"GET"}},
+	{"/api/v1/counters/stream", []string{"GET"}},
+	{"/api/v1/counters/webhook/forecast", []string{"GET"}},
+	{"/api/v1/counters/webhook/increment", []string{"POST"}},
+	{"/api/v2/counters/webhook/increment", []string{"POST"}},
+	{"/api/v1/counters/webhook/decrement", []string{"POST"}},
+	{"/api/v1/quotes", []string{"GET"}},
+	{"/api/v1/stats", []string{"GET"}},
+	{"/api/v1/search", []string{"GET"}},
+	{"/api/v1/repos", []string{"GET"}},
+	{"/api/v1/repos/languages", []string{"GET"}},
+	{"/api/stats", []string{"GET"}},
+}
+
+func TestRoutesRejectOtherMethods(t *testing.T) {
+	h := newTestServer(t).routes()
+
+	for _, route := range registeredRoutes {
+		// No route is registered for PUT, so it's the wrong method everywhere
+		w := serveRequest(h, http.MethodPut, route.path, "", "")
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("PUT %s status = %d, want %d", route.path, w.Code, http.StatusMethodNotAllowed)
+			continue
+		}
+
+		allow := strings.Split(w.Header().Get("Allow"), ", ")
+		for _, method := range route.methods {
+			if !slices.Contains(allow, method) {
+				t.Errorf("PUT %s Allow = %q, want it to include %s", route.path, w.Header().Get("Allow"), method)
+			}
+		}
+		if slices.Contains(allow, http.MethodPut) {
+			t.Errorf("PUT %s Allow = %q, which includes PUT", route.path, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestUnknownPathsAreNotFound(t *testing.T) {
+	h := newTestServer(t).routes()
+
+	paths := []string{
+		"/nope",
+		"/increment/extra",
+		"/quote/",
+		"/admin/nope",
+		"/api/v1/nope",
+		"/api/v2/nope",
+		"/api/nope",
+		"/api/v1/counters/webhook/nope",
+	}
+	for _, path := range paths {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			if w := serveRequest(h, method, path, "", ""); w.Code != http.StatusNotFound {
+				t.Errorf("%s %s status = %d, want %d", method, path, w.Code, http.StatusNotFound)
+			}
+		}
+	}
+
+	// Everything under /static/ is a GET route, so only a GET finds a file missing
+	if w := serveRequest(h, http.MethodGet, "/static/nope.txt", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET /static/nope.txt status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// searchQuoteLimit is the maximum number of quotes returned by a search
+	searchQuoteLimit = 20
+	// searchRepoLimit is the maximum number of repos returned by a search
+	searchRepoLimit = 20
+)
+
+// SearchResults represents the combined results of a site search
+type SearchResults struct {
+	Quotes []Quote      `json:"quotes"`
+	Repos  []GitHubRepo `json:"repos"`
+}
+
+// searchRepos finds repos whose name, description, or language contains the term
+func searchRepos(repos []GitHubRepo, term string, limit int) []GitHubRepo {
+	term = strings.ToLower(term)
+
+	matches := []GitHubRepo{}
+	for _, repo := range repos {
+		if len(matches) == limit {
+			break
+		}
+		if strings.Contains(strings.ToLower(repo.Name), term) ||
+			strings.Contains(strings.ToLower(repo.Description), term) ||
+			strings.Contains(strings.ToLower(repo.Language), term) {
+			matches = append(matches, repo)
+		}
+	}
+	return matches
+}
+
+// searchHandler searches quotes and repos for the q query parameter
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	term := strings.TrimSpace(r.URL.Query().Get("q"))
+	if term == "" {
+		s.apiError(w, r, http.StatusBadRequest, "Search query cannot be empty")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	quotes, err := s.quotes.SearchQuotes(ctx, term, searchQuoteLimit)
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "search", "search quotes", err), "Error searching quotes")
+		return
+	}
+
+	results := SearchResults{
+		Quotes: quotes,
+		Repos:  searchRepos(s.getCachedGitHubRepos(s.config.GitHubUsername), term, searchRepoLimit),
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
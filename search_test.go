@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSearchRepos(t *testing.T) {
+	repos := []GitHubRepo{
+		{Name: "search-engine", Language: "Go"},
+		{Name: "dotfiles", Description: "Shell config"},
+		{Name: "site", Description: "A small static site ENGINE"},
+		{Name: "engine-room", Language: "Rust"},
+	}
+
+	var names []string
+	for _, repo := range searchRepos(repos, "Engine", 2) {
+		names = append(names, repo.Name)
+	}
+	if want := []string{"search-engine", "site"}; !slices.Equal(names, want) {
+		t.Errorf("searchRepos = %q, want %q", names, want)
+	}
+
+	// Nothing matching is an empty result, not nil
+	if matches := searchRepos(repos, "zebra", 2); matches == nil || len(matches) != 0 {
+		t.Errorf("searchRepos for no matches = %#v, want an empty slice", matches)
+	}
+}
+
+func TestSearchRejectsEmptyQuery(t *testing.T) {
+	s := newTestServer(t)
+	for _, target := range []string{"/api/search", "/api/search?q=", "/api/search?q=%20%20"} {
+		if w := serveRequest(http.HandlerFunc(s.searchHandler), http.MethodGet, target, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// testSearchQuotesAndRepos checks searching across quotes and repos against s's store
+func testSearchQuotesAndRepos(t *testing.T, s *Server) {
+	setGitHubRepos(t, []GitHubRepo{
+		{Name: "ada-engine", Language: "Go"},
+		{Name: "dotfiles", Description: "Shell config"},
+	})
+	start := time.Now().Add(-time.Hour)
+	for i, seed := range []struct{ name, text string }{
+		{"Ada", "The engine can do whatever we know how to order it to perform"},
+		{"Grace", "A ship in port is safe"},
+		{"Alan", "We can only see a short distance ahead"},
+	} {
+		quote, err := newQuote(seed.name, seed.text, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		quote.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		if err := s.quotes.InsertQuote(context.Background(), quote); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q          string
+		wantQuotes []string
+		wantRepos  []string
+	}{
+		// Matches the text of Ada's quote and the name of Ada's repo, ignoring case
+		{q: "ENGINE", wantQuotes: []string{"Ada"}, wantRepos: []string{"ada-engine"}},
+		// Matches an author, and Alan's text, newest first
+		{q: "a", wantQuotes: []string{"Alan", "Grace", "Ada"}, wantRepos: []string{"ada-engine"}},
+		{q: "shell", wantQuotes: nil, wantRepos: []string{"dotfiles"}},
+		// Regular expression syntax is matched literally
+		{q: ".*", wantQuotes: nil, wantRepos: nil},
+	}
+	for _, tt := range tests {
+		w := serveRequest(http.HandlerFunc(s.searchHandler), http.MethodGet, "/api/search?q="+url.QueryEscape(tt.q), "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("search for %q status = %d, want %d: %s", tt.q, w.Code, http.StatusOK, w.Body)
+		}
+		var results SearchResults
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		var quotes, repos []string
+		for _, quote := range results.Quotes {
+			quotes = append(quotes, quote.Name)
+		}
+		for _, repo := range results.Repos {
+			repos = append(repos, repo.Name)
+		}
+		if !slices.Equal(quotes, tt.wantQuotes) || !slices.Equal(repos, tt.wantRepos) {
+			t.Errorf("search for %q = quotes %q, repos %q; want %q, %q", tt.q, quotes, repos, tt.wantQuotes, tt.wantRepos)
+		}
+	}
+}
+
+func TestSearchQuotesAndRepos(t *testing.T) {
+	testSearchQuotesAndRepos(t, newTestServer(t))
+}
+
+func TestSearchQuotesAndReposMongo(t *testing.T) {
+	testSearchQuotesAndRepos(t, newMongoTestServer(t))
+}
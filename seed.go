@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// SeedQuote represents a quote entry in a seed file
+type SeedQuote struct {
+	Name   string `json:"name"`
+	Quote  string `json:"quote"`
+	Source string `json:"source,omitempty"`
+}
+
+// readSeedQuotes reads a JSON array of quotes from a seed file
+func readSeedQuotes(path string) ([]SeedQuote, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var seeds []SeedQuote
+	if err := json.Unmarshal(data, &seeds); err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
+
+// seedQuotes inserts the quotes from a seed file that aren't already in the collection,
+// matching on quote text so that running it again doesn't add duplicates.
+// It returns the number of quotes inserted.
+func (s *Server) seedQuotes(ctx context.Context, path string) (int, error) {
+	seeds, err := readSeedQuotes(path)
+	if err != nil {
+		return 0, err
+	}
+
+	inserted := 0
+	for _, seed := range seeds {
+		quote, err := newQuote(seed.Name, seed.Quote, seed.Source, nil)
+		if err != nil {
+			s.log(ctx, "quotes").Warn("skipping seed quote", "quote", seed.Quote, "err", err)
+			continue
+		}
+
+		added, err := s.quotes.InsertQuoteIfNew(ctx, quote)
+		if err != nil {
+			return inserted, err
+		}
+		if added {
+			inserted++
+		}
+	}
+	return inserted, nil
+}
+
+// seedQuotesFromConfig seeds quotes from the configured SEED_QUOTES_FILE, if set
+func (s *Server) seedQuotesFromConfig(ctx context.Context) {
+	path := s.config.SeedQuotesFile
+	if path == "" {
+		return
+	}
+
+	inserted, err := s.seedQuotes(ctx, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.log(ctx, "quotes").Info("seed quotes file not found, skipping", "path", path)
+		return
+	}
+	if err != nil {
+		s.log(ctx, "quotes").Error("seeding quotes", "err", err)
+		return
+	}
+	s.log(ctx, "quotes").Info("seeded quotes", "count", inserted, "path", path)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// demoQuoteCount is how many quotes the demo seeder inserts
+const demoQuoteCount = 50
+
+// demoQuotePeriod is how far back the demo quotes' timestamps reach
+const demoQuotePeriod = 30 * 24 * time.Hour
+
+// demoCounters are the counter values the demo seeder sets
+var demoCounters = []Counter{
+	{ID: "webhook", Count: 500},
+}
+
+// demoPageViews are the page view counts the demo seeder sets
+var demoPageViews = []PageView{
+	{Path: "/", Count: 10000},
+}
+
+// demoNames and demoTexts are combined to build the demo quotes
+var (
+	demoNames = []string{
+		"Ada", "Grace", "Linus", "Margaret", "Ken", "Barbara", "Dennis", "Frances",
+		"Edsger", "Radia", "Alan", "Katherine", "Unknown",
+	}
+	demoTexts = []string{
+		"Simplicity is prerequisite for reliability.",
+		"Make it work, make it right, make it fast.",
+		"The best way to predict the future is to invent it.",
+		"Premature optimization is the root of all evil.",
+		"Talk is cheap. Show me the code.",
+		"Programs must be written for people to read.",
+		"It always takes longer than you expect.",
+		"Deleted code is debugged code.",
+		"Clear is better than clever.",
+		"The most dangerous phrase is: we've always done it this way.",
+		"Weeks of coding can save you hours of planning.",
+		"If it hurts, do it more often.",
+		"First, solve the problem. Then, write the code.",
+		"There is no cloud, just someone else's computer.",
+		"A little copying is better than a little dependency.",
+		"Errors are values.",
+		"Nice website!",
+	}
+)
+
+// errSeedProduction is returned when the demo seeder is run against production
+var errSeedProduction = errors.New("refusing to seed demo data with GO_ENV=production")
+
+// checkSeedAllowed returns errSeedProduction if cfg is for production, where demo data would
+// mix with (or, with -clear, replace) real data
+func checkSeedAllowed(cfg Config) error {
+	if cfg.Environment == "production" {
+		return errSeedProduction
+	}
+	return nil
+}
+
+// demoQuotes returns n demo quotes with varied names and text, spread evenly over the
+// demoQuotePeriod before now, oldest first
+func demoQuotes(now time.Time, n int) []Quote {
+	quotes := make([]Quote, n)
+	step := demoQuotePeriod / time.Duration(n)
+	for i := range quotes {
+		quotes[i] = Quote{
+			Name:      demoNames[i%len(demoNames)],
+			Quote:     demoTexts[(i*7)%len(demoTexts)],
+			Timestamp: now.Add(-demoQuotePeriod + time.Duration(i)*step).UTC().Truncate(time.Second),
+		}
+	}
+	return quotes
+}
+
+// clearDemoCollections deletes every quote, counter, counter event and page view, so seeding
+// starts from an empty database
+func clearDemoCollections(ctx context.Context, db *mongo.Database, collections CollectionNames) error {
+	for _, name := range []string{collections.Quotes, collections.Counters, collections.CounterEvents, collections.PageViews} {
+		result, err := db.Collection(name).DeleteMany(ctx, bson.M{})
+		if err != nil {
+			return fmt.Errorf("clearing %s: %w", name, err)
+		}
+		fmt.Printf("Deleted %d documents from %s\n", result.DeletedCount, name)
+	}
+	return nil
+}
+
+// seedDatabase fills db with demo data: demoQuoteCount quotes from the last 30 days and
+// realistic counter and page view values. Existing data is kept, so quotes are added alongside any already
+// there; use clearDemoCollections first for a clean slate.
+func seedDatabase(ctx context.Context, db *mongo.Database, collections CollectionNames) error {
+	quotes := demoQuotes(time.Now(), demoQuoteCount)
+	quoteWrites := make([]mongo.WriteModel, 0, len(quotes))
+	for _, quote := range quotes {
+		quoteWrites = append(quoteWrites, mongo.NewInsertOneModel().SetDocument(quote))
+	}
+	result, err := db.Collection(collections.Quotes).BulkWrite(ctx, quoteWrites, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("inserting demo quotes: %w", err)
+	}
+	fmt.Printf("Inserted %d quotes\n", result.InsertedCount)
+
+	now := time.Now().UTC()
+	counterWrites := make([]mongo.WriteModel, 0, len(demoCounters))
+	for _, counter := range demoCounters {
+		counterWrites = append(counterWrites, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": counter.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"count": counter.Count, "maxSeen": counter.Count, "maxSeenAt": now}}).
+			SetUpsert(true))
+	}
+	if _, err := db.Collection(collections.Counters).BulkWrite(ctx, counterWrites); err != nil {
+		return fmt.Errorf("setting demo counters: %w", err)
+	}
+	for _, counter := range demoCounters {
+		fmt.Printf("Set %s counter to %d\n", counter.ID, counter.Count)
+	}
+
+	pageViewWrites := make([]mongo.WriteModel, 0, len(demoPageViews))
+	for _, view := range demoPageViews {
+		pageViewWrites = append(pageViewWrites, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": view.Path}).
+			SetUpdate(bson.M{"$set": bson.M{"count": view.Count}}).
+			SetUpsert(true))
+	}
+	if _, err := db.Collection(collections.PageViews).BulkWrite(ctx, pageViewWrites); err != nil {
+		return fmt.Errorf("setting demo page views: %w", err)
+	}
+	for _, view := range demoPageViews {
+		fmt.Printf("Set page views of %s to %d\n", view.Path, view.Count)
+	}
+	return nil
+}
+
+// runSeed seeds db with demo data, first clearing it if clear is set
+func runSeed(ctx context.Context, db *mongo.Database, collections CollectionNames, clear bool) error {
+	fmt.Printf("Seeding database %s\n", db.Name())
+	if clear {
+		if err := clearDemoCollections(ctx, db, collections); err != nil {
+			return err
+		}
+	}
+	if err := seedDatabase(ctx, db, collections); err != nil {
+		return err
+	}
+	fmt.Println("Done")
+	return nil
+}
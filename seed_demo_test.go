@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDemoQuotesSpreadOverPeriod(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	quotes := demoQuotes(now, demoQuoteCount)
+	if len(quotes) != demoQuoteCount {
+		t.Fatalf("demoQuotes() returned %d quotes, want %d", len(quotes), demoQuoteCount)
+	}
+
+	names := map[string]bool{}
+	for i, quote := range quotes {
+		names[quote.Name] = true
+		if quote.Timestamp.Before(now.Add(-demoQuotePeriod)) || !quote.Timestamp.Before(now) {
+			t.Errorf("quote %d timestamp %v is outside the 30 days before %v", i, quote.Timestamp, now)
+		}
+		if i > 0 && !quote.Timestamp.After(quotes[i-1].Timestamp) {
+			t.Errorf("quote %d timestamp %v isn't after the previous %v", i, quote.Timestamp, quotes[i-1].Timestamp)
+		}
+		if i > 0 && quote.Quote == quotes[i-1].Quote {
+			t.Errorf("quotes %d and %d have the same text %q", i-1, i, quote.Quote)
+		}
+	}
+	if len(names) != len(demoNames) {
+		t.Errorf("demoQuotes() used %d names, want all %d", len(names), len(demoNames))
+	}
+}
+
+func TestCheckSeedAllowed(t *testing.T) {
+	if err := checkSeedAllowed(Config{Environment: "production"}); !errors.Is(err, errSeedProduction) {
+		t.Errorf("checkSeedAllowed(production) = %v, want errSeedProduction", err)
+	}
+	for _, env := range []string{"", "development", "staging"} {
+		if err := checkSeedAllowed(Config{Environment: env}); err != nil {
+			t.Errorf("checkSeedAllowed(%q) = %v, want no error", env, err)
+		}
+	}
+}
+
+func TestSeedDatabase(t *testing.T) {
+	s := newMongoTestServer(t)
+	ctx := t.Context()
+
+	// Seeding twice with clear leaves exactly one set of demo data
+	for range 2 {
+		if err := runSeed(ctx, s.db, s.config.Collections, true); err != nil {
+			t.Fatalf("runSeed() = %v", err)
+		}
+	}
+
+	count, err := s.quotes.CountQuotes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != demoQuoteCount {
+		t.Errorf("CountQuotes() after seeding = %d, want %d", count, demoQuoteCount)
+	}
+	for _, want := range demoCounters {
+		got, err := s.counters.GetCounter(ctx, want.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Count != want.Count {
+			t.Errorf("%s counter after seeding = %d, want %d", want.ID, got.Count, want.Count)
+		}
+	}
+	views, err := s.pageViews.ListPageViews(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(views, demoPageViews) {
+		t.Errorf("page views after seeding = %+v, want %+v", views, demoPageViews)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadSeedQuotes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotes.json")
+	seeds := `[
+		{"name": "Ada", "quote": "The engine weaves algebraic patterns"},
+		{"name": "", "quote": "Anonymous wisdom"}
+	]`
+	if err := os.WriteFile(path, []byte(seeds), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readSeedQuotes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SeedQuote{
+		{Name: "Ada", Quote: "The engine weaves algebraic patterns"},
+		{Name: "", Quote: "Anonymous wisdom"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("readSeedQuotes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSeedQuotesMissingFile(t *testing.T) {
+	// A missing file is reported before anything is written
+	_, err := (&Server{}).seedQuotes(t.Context(), filepath.Join(t.TempDir(), "missing.json"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("seedQuotes on a missing file = %v, want fs.ErrNotExist", err)
+	}
+}
+
+// testSeedQuotesIsIdempotent checks seeding quotes twice against s's store
+func testSeedQuotesIsIdempotent(t *testing.T, s *Server) {
+	ctx := context.Background()
+	existing, _ := newQuote("Grace", "A ship in port is safe", "", nil)
+	if err := s.quotes.InsertQuote(ctx, existing); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "quotes.json")
+	seeds := `[
+		{"name": "Ada", "quote": "The engine weaves algebraic patterns"},
+		{"name": "Someone else", "quote": "A ship in port is safe"},
+		{"name": "Nobody", "quote": ""},
+		{"name": "", "quote": "Anonymous wisdom"}
+	]`
+	if err := os.WriteFile(path, []byte(seeds), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Quotes already saved are matched by their text, and invalid ones are skipped
+	if inserted, err := s.seedQuotes(ctx, path); err != nil || inserted != 2 {
+		t.Fatalf("first seedQuotes() = %d, %v; want 2 inserted", inserted, err)
+	}
+	if inserted, err := s.seedQuotes(ctx, path); err != nil || inserted != 0 {
+		t.Errorf("second seedQuotes() = %d, %v; want nothing inserted", inserted, err)
+	}
+
+	quotes, err := s.quotes.LatestQuotes(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, quote := range quotes {
+		texts = append(texts, quote.Name+": "+quote.Quote)
+	}
+	slices.Sort(texts)
+	want := []string{"Ada: The engine weaves algebraic patterns", "Grace: A ship in port is safe", "Unknown: Anonymous wisdom"}
+	if !slices.Equal(texts, want) {
+		t.Errorf("quotes after seeding twice = %q, want %q", texts, want)
+	}
+}
+
+func TestSeedQuotesIsIdempotent(t *testing.T) {
+	testSeedQuotesIsIdempotent(t, newTestServer(t))
+}
+
+func TestSeedQuotesIsIdempotentMongo(t *testing.T) {
+	testSeedQuotesIsIdempotent(t, newMongoTestServer(t))
+}
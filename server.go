@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/rand"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Server holds the dependencies shared by the site's handlers
+type Server struct {
+	client    *mongo.Client
+	db        *mongo.Database
+	templates templateSet
+	quotes    QuoteStore
+	counters  CounterStore
+	pageViews PageViewStore
+	sessions  SessionStore
+	messages  MessageStore
+	// breaker is the circuit breaker in front of the stores, open while the site is read-only
+	breaker *circuitBreaker
+	// countries looks up visitors' countries for page views, nil without a country database
+	countries     countryLookup
+	hub           *Hub
+	audit         *AuditLog
+	upgrader      *websocket.Upgrader
+	graphqlSchema graphql.Schema
+	velocities    velocityCache
+	sitemap       sitemapCache
+	feed          feedCache
+	home          homePageCache
+	newQuotes     quoteNotifier
+	mongoHealth   mongoHealth
+	// readOnly is set while read-only mode refuses writes
+	readOnly atomic.Bool
+	// maintenance is the maintenance status, nil until it is first set
+	maintenance atomic.Pointer[MaintenanceStatus]
+	// features are the feature flags, starting from FEATURES
+	features   featureState
+	milestones slackMilestones
+	// bans are the unexpired bans, refreshed from MongoDB
+	bans banList
+	// visitorSalt salts the IP hashes that deduplicate cookieless page views: IP_HASH_SALT, or
+	// without it a random salt, since an unsalted hash of an IPv4 address is easily reversed
+	visitorSalt string
+	// stopping is closed by stop when the server starts shutting down
+	stopping chan struct{}
+	stopOnce sync.Once
+	logger   *slog.Logger
+	config   Config
+}
+
+// newServer creates a server storing quotes, counters, page views, and sessions in the given MongoDB database, parsing
+// the templates, checking the OpenAPI document, and building the GraphQL schema. The WebSocket and audit hubs are created but not started.
+// Only the stores' read-only queries follow db's read preference; everything else uses the primary.
+func newServer(config Config, client *mongo.Client, db *mongo.Database) (*Server, error) {
+	templates, err := loadTemplates(config)
+	if err != nil {
+		return nil, err
+	}
+	// Like the templates, the spec is read from disk on every request while reloading
+	if !config.ReloadTemplates {
+		if err := checkOpenAPI(config.assetsFS()); err != nil {
+			return nil, err
+		}
+	}
+
+	store := newBreakerStore(newMongoStore(db, config.Collections), config.StoreBreakerThreshold, config.StoreBreakerCooldown)
+	s := &Server{
+		client:    client,
+		db:        primaryDatabase(db),
+		templates: templates,
+		quotes:    newQuotePageCache(store),
+		counters:  store,
+		pageViews: store,
+		sessions:  store,
+		messages:  store,
+		breaker:   store.breaker,
+		hub:       NewHub(config.WSBroadcastBuffer),
+		audit:     NewAuditLog(store, config.MongoWriteTimeout),
+		upgrader:  newUpgrader(config),
+		logger:    logger,
+		config:    config,
+		stopping:  make(chan struct{}),
+	}
+
+	s.features.flags = config.Features
+	if s.visitorSalt = config.IPHashSalt; s.visitorSalt == "" {
+		s.visitorSalt = rand.Text()
+	}
+
+	s.graphqlSchema, err = s.newGraphQLSchema()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sessionIDCookie holds an anonymous visitor's session ID, identifying them across visits
+// without an account
+const sessionIDCookie = "session_id"
+
+// sessionLifetime is how long a session cookie lasts, and how long its record is kept
+const sessionLifetime = 365 * 24 * time.Hour
+
+// sessionIDBytes is how many random bytes make up a session ID, which is sent hex encoded
+const sessionIDBytes = 32
+
+// minSessionSecretLength is the shortest SESSION_SECRET accepted, so signatures can't be forged
+// by guessing it
+const minSessionSecretLength = 32
+
+// Session is an anonymous visitor's identity, recorded when their session cookie is issued
+type Session struct {
+	ID        string    `bson:"_id"`
+	CreatedAt time.Time `bson:"createdAt"`
+	// IP is the address the session was started from
+	IP string `bson:"ip"`
+}
+
+// sessionKey is the context key holding the session ID issued to a request without one
+type sessionKey struct{}
+
+// newSessionID returns a random session ID
+func newSessionID() string {
+	b := make([]byte, sessionIDBytes)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validSessionID reports whether id looks like one newSessionID made
+func validSessionID(id string) bool {
+	if len(id) != 2*sessionIDBytes {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// loadSessionSecret reads SESSION_SECRET, which signs session cookies. Without it a random
+// secret is used, so sessions only last until the server restarts.
+func loadSessionSecret(env *envReader) []byte {
+	if secret := env.string("SESSION_SECRET", ""); secret != "" {
+		if len(secret) < minSessionSecretLength {
+			env.problem("SESSION_SECRET", "must be at least %d characters", minSessionSecretLength)
+		}
+		return []byte(secret)
+	}
+	secret := make([]byte, sessionIDBytes)
+	rand.Read(secret)
+	return secret
+}
+
+// sessionSignature returns the HMAC-SHA256 of a session ID
+func sessionSignature(id string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+// signSession returns the cookie value for a session: its ID and the ID's signature, so only
+// sessions the server issued are accepted back
+func (s *Server) signSession(id string) string {
+	return id + "." + hex.EncodeToString(sessionSignature(id, s.config.SessionSecret))
+}
+
+// sessionCookieValue returns the session ID the client sent back in its cookie, or "" if it
+// didn't send one the server signed
+func (s *Server) sessionCookieValue(r *http.Request) string {
+	cookie, err := r.Cookie(sessionIDCookie)
+	if err != nil {
+		return ""
+	}
+	id, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !validSessionID(id) {
+		return ""
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, sessionSignature(id, s.config.SessionSecret)) {
+		return ""
+	}
+	return id
+}
+
+// sessionFromRequest returns the request's session ID: the one issued to it by
+// sessionMiddleware, or otherwise the one in its cookie. It's "" for a request outside
+// sessionMiddleware that didn't send one.
+func (s *Server) sessionFromRequest(r *http.Request) string {
+	if id, ok := r.Context().Value(sessionKey{}).(string); ok {
+		return id
+	}
+	return s.sessionCookieValue(r)
+}
+
+// sessionRateLimitPrefix starts the rate limiter keys of sessions
+const sessionRateLimitPrefix = "session:"
+
+// sessionRateLimitKey returns the rate limiter key for a session. It's a hash of the ID so
+// sessions never show up in rate limit state or the audit log.
+func sessionRateLimitKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return sessionRateLimitPrefix + hex.EncodeToString(sum[:8])
+}
+
+// sessionMiddleware gives a visitor without a valid session cookie a new session. The cookie is
+// used straight away; the session is recorded in the background, so the page doesn't wait on
+// MongoDB and still works if it's down.
+func (s *Server) sessionMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.sessionCookieValue(r) != "" {
+			next(w, r)
+			return
+		}
+
+		session := Session{ID: newSessionID(), CreatedAt: time.Now(), IP: s.getIPAddress(r)}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionIDCookie,
+			Value:    s.signSession(session.ID),
+			Path:     "/",
+			MaxAge:   int(sessionLifetime.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		go s.saveSession(r.Context(), session)
+
+		next(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session.ID)))
+	}
+}
+
+// saveSession records a new session, using a detached context so it isn't cancelled when the
+// request that started it finishes. Failures are only logged, since the cookie works without it.
+func (s *Server) saveSession(ctx context.Context, session Session) {
+	writeCtx, cancel := s.detachedWriteContext(ctx)
+	defer cancel()
+
+	if err := s.sessions.CreateSession(writeCtx, session); err != nil {
+		s.dbError(ctx, "sessions", "create session", err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingSessions fails to record every session, standing in for MongoDB being down
+type failingSessions struct{}
+
+func (failingSessions) CreateSession(ctx context.Context, session Session) error {
+	return errors.New("sessions unavailable")
+}
+
+// issuedSession returns the session cookie set by w and the ID it carries, failing if there
+// isn't one
+func issuedSession(t *testing.T, w *httptest.ResponseRecorder) (*http.Cookie, string) {
+	t.Helper()
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionIDCookie {
+			id, _, _ := strings.Cut(cookie.Value, ".")
+			return cookie, id
+		}
+	}
+	t.Fatalf("no %s cookie set", sessionIDCookie)
+	return nil, ""
+}
+
+func TestSessionMiddlewareIssuesAndRecordsSessions(t *testing.T) {
+	setGitHubRepos(t, nil)
+	s := newTestServer(t)
+	store := newMemoryStore()
+	s.sessions = store
+	h := s.routes()
+
+	w := serveRequest(h, http.MethodGet, "/", "", "192.0.2.91:1000")
+	cookie, id := issuedSession(t, w)
+	if !validSessionID(id) || cookie.Value != s.signSession(id) || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge != int(sessionLifetime.Seconds()) {
+		t.Errorf("session cookie = %+v, want a signed 64 character hex ID, HttpOnly, SameSite=Lax, lasting a year", cookie)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		store.mu.Lock()
+		session, ok := store.sessions[id]
+		store.mu.Unlock()
+		if ok {
+			if session.IP != "192.0.2.91" || session.CreatedAt.IsZero() {
+				t.Errorf("recorded session = %+v, want IP 192.0.2.91 and a creation time", session)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session wasn't recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A returning visitor keeps their session
+	r := newJSONRequest(http.MethodGet, "/", "")
+	r.AddCookie(cookie)
+	for _, c := range serve(h, r).Result().Cookies() {
+		if c.Name == sessionIDCookie {
+			t.Errorf("returning visitor was given a new session %q", c.Value)
+		}
+	}
+}
+
+func TestSessionWorksWithoutDatabase(t *testing.T) {
+	s := newTestServer(t)
+	s.sessions = failingSessions{}
+
+	var seen string
+	h := s.sessionMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		seen = s.sessionFromRequest(r)
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	_, id := issuedSession(t, w)
+	if seen != id {
+		t.Errorf("sessionFromRequest() = %q with the database down, want the issued %q", seen, id)
+	}
+}
+
+func TestClientKeyUsesReturnedSession(t *testing.T) {
+	s := newTestServer(t)
+	request := func(cookie string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/quote", nil)
+		r.RemoteAddr = "192.0.2.92:1000"
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: sessionIDCookie, Value: cookie})
+		}
+		return r
+	}
+
+	first, second := newSessionID(), newSessionID()
+	if s.clientKey(request(s.signSession(first))) == s.clientKey(request(s.signSession(second))) {
+		t.Error("two sessions from the same IP share a rate limit key")
+	}
+	if got, want := s.clientKey(request(s.signSession(first))), sessionRateLimitKey(first); got != want {
+		t.Errorf("clientKey() with a session = %q, want %q", got, want)
+	}
+	other := newTestServerWithConfig(t, defaultConfig())
+	cookies := []string{"", "not-a-session", first, first[:10], first + "." + strings.Repeat("0", 64), other.signSession(first)}
+	for _, cookie := range cookies {
+		if got := s.clientKey(request(cookie)); got != "192.0.2.92" {
+			t.Errorf("clientKey() with session cookie %q = %q, want the IP", cookie, got)
+		}
+	}
+}
+
+func TestSessionsFromOneIPShareACap(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	h := s.rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {}, 1, 1)
+
+	// Each new session gets a budget of its own, until the IP's shared budget runs out
+	var allowed int
+	for range 2 * sessionIPRateLimitMultiplier {
+		r := httptest.NewRequest(http.MethodPost, "/quote", nil)
+		r.RemoteAddr = "192.0.2.93:1000"
+		r.AddCookie(&http.Cookie{Name: sessionIDCookie, Value: s.signSession(newSessionID())})
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code == http.StatusOK {
+			allowed++
+		}
+	}
+	if allowed != sessionIPRateLimitMultiplier {
+		t.Errorf("%d fresh sessions from one IP were allowed, want %d", allowed, sessionIPRateLimitMultiplier)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Process exit codes
+const (
+	exitOK            = 0
+	exitListenError   = 1
+	exitShutdownError = 2
+)
+
+// stop marks the server as shutting down, closing s.stopping so long-lived responses such as
+// event streams can end and let the shutdown finish. Calling it again does nothing.
+func (s *Server) stop() {
+	s.stopOnce.Do(func() { close(s.stopping) })
+}
+
+// stoppingContext returns a context that is cancelled when the server starts shutting down,
+// for background work that should stop with it
+func (s *Server) stoppingContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.stopping
+		cancel()
+	}()
+	return ctx
+}
+
+// serve runs the server until it fails or the process receives SIGINT or SIGTERM, then
+// shuts everything down in order: in-flight requests finish (for up to grace), WebSocket
+// clients are sent the updates those requests broadcast and closed, the audit log is flushed,
+// and MongoDB is disconnected.
+// It returns the process exit code.
+func (s *Server) serve(server *http.Server, grace time.Duration) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server.RegisterOnShutdown(s.stop)
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-listenErr:
+		s.logger.Error("server error", "err", err)
+		s.disconnectMongo(context.Background())
+		return exitListenError
+	case <-ctx.Done():
+	}
+
+	// Restore default signal handling so a second signal exits immediately
+	stop()
+	s.logger.Info("shutting down, waiting for requests to finish", "grace", grace)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	code := exitOK
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error("shutting down server", "err", err)
+		code = exitShutdownError
+	}
+	if err := <-listenErr; !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error("server error", "err", err)
+	}
+
+	if err := s.hub.Shutdown(shutdownCtx); err != nil {
+		s.log(shutdownCtx, "hub").Error("closing websocket clients", "err", err)
+	}
+	s.stopAuditWriter(shutdownCtx)
+	s.audit.Stop(shutdownCtx)
+	s.disconnectMongo(shutdownCtx)
+
+	s.logger.Info("shutdown complete")
+	return code
+}
+
+// disconnectMongo closes the MongoDB connection pool, if there is one
+func (s *Server) disconnectMongo(ctx context.Context) {
+	if s.client == nil {
+		return
+	}
+	if err := s.client.Disconnect(ctx); err != nil {
+		s.logger.Error("disconnecting from MongoDB", "err", err)
+	}
+}
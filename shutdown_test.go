@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestShutdownLetsInFlightRequestsFinish(t *testing.T) {
+	s := newTestServer(t)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+	server := newHTTPServer(s.config, handler)
+	server.Addr = freeAddr(t)
+
+	exited := make(chan int, 1)
+	go func() { exited <- s.serve(server, 5*time.Second) }()
+
+	// serve handles signals before it starts listening, so once a request gets through it's
+	// safe to send one
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		for deadline := time.Now().Add(2 * time.Second); ; {
+			resp, err := http.Get("http://" + server.Addr + "/slow")
+			if err != nil && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			if err != nil {
+				response <- result{err: err}
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			response <- result{string(body), err}
+			return
+		}
+	}()
+
+	select {
+	case <-started:
+	case r := <-response:
+		t.Fatalf("request finished before it reached the handler: %v", r.err)
+	}
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.stopping:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server didn't start shutting down after SIGTERM")
+	}
+
+	// The server is shutting down but waits for the request in flight
+	select {
+	case code := <-exited:
+		t.Fatalf("serve returned %d with a request in flight", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if r := <-response; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request = %q, %v; want it to finish with %q", r.body, r.err, "done")
+	}
+	select {
+	case code := <-exited:
+		if code != exitOK {
+			t.Errorf("serve returned %d, want %d", code, exitOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve didn't return after the request finished")
+	}
+
+	if _, err := http.Get("http://" + server.Addr + "/slow"); err == nil {
+		t.Error("server still accepting requests after shutdown")
+	}
+}
+
+func TestStopIsPerServerAndIdempotent(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	ctx := first.stoppingContext()
+	first.stop()
+	first.stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("stopping context wasn't cancelled when the server stopped")
+	}
+	select {
+	case <-second.stopping:
+		t.Error("stopping one server stopped another")
+	default:
+	}
+}
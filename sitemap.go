@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sitemapCacheTTL is how long a generated sitemap is served before it's rebuilt
+const sitemapCacheTTL = 10 * time.Minute
+
+// sitemapMaxURLs is the most URLs the sitemap protocol allows in one file. Longer sitemaps are
+// split into pages listed by a sitemap index.
+const sitemapMaxURLs = 50000
+
+// sitemapNamespace is the XML namespace of sitemaps and sitemap indexes
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// sitemapRoute is a page listed in the sitemap. lastMod, if set, reports when the page's
+// content last changed.
+type sitemapRoute struct {
+	path       string
+	changeFreq string
+	priority   float64
+	lastMod    func(s *Server, ctx context.Context) (time.Time, error)
+}
+
+// sitemapRoutes are the pages listed in the sitemap
+var sitemapRoutes = []sitemapRoute{
+	{path: "/", changeFreq: "weekly", priority: 1.0, lastMod: (*Server).latestQuoteTime},
+	{path: "/static/wyat_resume.pdf", changeFreq: "monthly", priority: 0.8},
+	{path: "/contact", changeFreq: "yearly", priority: 0.5},
+}
+
+// sitemapURLSet is a sitemap file
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapURL is one page in a sitemap
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+// sitemapIndex lists the pages of a sitemap too long for one file
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+
+// sitemapRef is one page of a sitemap in a sitemap index
+type sitemapRef struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapCache holds the most recently generated sitemap files
+type sitemapCache struct {
+	mu      sync.Mutex
+	files   [][]byte
+	builtAt time.Time
+}
+
+// latestQuoteTime returns when the newest quote was added, which is when the home page's
+// content last changed, or the zero time if there are no quotes
+func (s *Server) latestQuoteTime(ctx context.Context) (time.Time, error) {
+	quotes, err := s.quotes.LatestQuotes(ctx, 1)
+	if err != nil || len(quotes) == 0 {
+		return time.Time{}, err
+	}
+	return quotes[0].Timestamp, nil
+}
+
+// sitemapURLs returns every URL in the sitemap, made absolute with siteURL
+func (s *Server) sitemapURLs(ctx context.Context, siteURL string) ([]sitemapURL, error) {
+	urls := make([]sitemapURL, 0, len(sitemapRoutes))
+	for _, route := range sitemapRoutes {
+		u := sitemapURL{
+			Loc:        siteURL + route.path,
+			ChangeFreq: route.changeFreq,
+			Priority:   strconv.FormatFloat(route.priority, 'f', 1, 64),
+		}
+		if route.lastMod != nil {
+			modified, err := route.lastMod(s, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if !modified.IsZero() {
+				u.LastMod = modified.UTC().Format(time.RFC3339)
+			}
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// renderSitemaps renders the sitemap for urls. The first file is served at /sitemap.xml: the
+// whole sitemap if it fits in one file, or otherwise an index of the pages that follow it,
+// served at /sitemap.xml?page=N.
+func renderSitemaps(siteURL string, urls []sitemapURL, now time.Time) ([][]byte, error) {
+	if len(urls) <= sitemapMaxURLs {
+		file, err := marshalSitemap(sitemapURLSet{Xmlns: sitemapNamespace, URLs: urls})
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{file}, nil
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	files := [][]byte{nil}
+	for start := 0; start < len(urls); start += sitemapMaxURLs {
+		end := min(start+sitemapMaxURLs, len(urls))
+		file, err := marshalSitemap(sitemapURLSet{Xmlns: sitemapNamespace, URLs: urls[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		index.Sitemaps = append(index.Sitemaps, sitemapRef{
+			Loc:     fmt.Sprintf("%s/sitemap.xml?page=%d", siteURL, len(files)-1),
+			LastMod: now.UTC().Format(time.RFC3339),
+		})
+	}
+
+	file, err := marshalSitemap(index)
+	if err != nil {
+		return nil, err
+	}
+	files[0] = file
+	return files, nil
+}
+
+// marshalSitemap renders a sitemap or sitemap index as an XML document
+func marshalSitemap(v any) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// sitemapFiles returns the rendered sitemap files, rebuilding them once they're older than
+// sitemapCacheTTL
+func (s *Server) sitemapFiles(ctx context.Context) ([][]byte, error) {
+	// Requests arriving during a rebuild wait for it rather than repeating it
+	s.sitemap.mu.Lock()
+	defer s.sitemap.mu.Unlock()
+
+	if s.sitemap.files != nil && time.Since(s.sitemap.builtAt) < sitemapCacheTTL {
+		return s.sitemap.files, nil
+	}
+
+	urls, err := s.sitemapURLs(ctx, s.config.SiteURL)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	files, err := renderSitemaps(s.config.SiteURL, urls, now)
+	if err != nil {
+		return nil, err
+	}
+	s.sitemap.files, s.sitemap.builtAt = files, now
+	return files, nil
+}
+
+// sitemapHandler serves the sitemap generated from the site's routes and content, or the static
+// sitemap if SITE_URL isn't set since absolute URLs can't be built without it
+func (s *Server) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	if s.config.SiteURL == "" {
+		http.ServeFileFS(w, r, staticFS(s.config.assetsFS()), "sitemap.xml")
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	files, err := s.sitemapFiles(ctx)
+	if err != nil {
+		s.respondError(w, r, s.dbError(r.Context(), "sitemap", "build sitemap", err), "Error building sitemap")
+		return
+	}
+
+	page := 0
+	if raw := r.URL.Query().Get("page"); raw != "" {
+		page, err = strconv.Atoi(raw)
+		if err != nil || page < 1 || page >= len(files) {
+			s.respondError(w, r, http.StatusNotFound, "Sitemap page not found")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(files[page])
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getSitemap requests the sitemap and decodes it into v
+func getSitemap(t *testing.T, s *Server, target string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.sitemapHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, want 200: %s", target, w.Code, w.Body)
+	}
+	if v != nil {
+		if err := xml.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding %s: %v", target, err)
+		}
+	}
+	return w
+}
+
+func TestSitemapServesStaticFileWithoutSiteURL(t *testing.T) {
+	w := getSitemap(t, newTestServer(t), "/sitemap.xml", nil)
+	if !strings.Contains(w.Body.String(), "<loc>https://wyat.me</loc>") {
+		t.Errorf("sitemap = %s, want the static file", w.Body)
+	}
+}
+
+func TestSitemapListsRoutesWithSiteURL(t *testing.T) {
+	s := newTestServer(t)
+	s.config.SiteURL = "https://example.com"
+	newest := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, ts := range []time.Time{newest.Add(-time.Hour), newest} {
+		if err := s.quotes.InsertQuote(context.Background(), Quote{Quote: "hi", Timestamp: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sitemap sitemapURLSet
+	w := getSitemap(t, s, "/sitemap.xml", &sitemap)
+	if got := w.Header().Get("Content-Type"); got != "application/xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want XML", got)
+	}
+	if !strings.HasPrefix(w.Body.String(), `<?xml version="1.0" encoding="UTF-8"?>`) {
+		t.Errorf("sitemap doesn't start with an XML declaration: %s", w.Body)
+	}
+	if sitemap.XMLName.Space != sitemapNamespace || sitemap.XMLName.Local != "urlset" {
+		t.Errorf("root element = %v, want a urlset in the sitemap namespace", sitemap.XMLName)
+	}
+	if len(sitemap.URLs) != len(sitemapRoutes) {
+		t.Fatalf("sitemap lists %d URLs, want %d", len(sitemap.URLs), len(sitemapRoutes))
+	}
+
+	home := sitemap.URLs[0]
+	if home.Loc != "https://example.com/" || home.LastMod != "2024-05-06T07:08:09Z" || home.Priority != "1.0" {
+		t.Errorf("home page entry = %+v, want it dated by the newest quote", home)
+	}
+	for _, u := range sitemap.URLs {
+		if !strings.HasPrefix(u.Loc, "https://example.com/") {
+			t.Errorf("loc %q isn't an absolute URL on SITE_URL", u.Loc)
+		}
+	}
+}
+
+func TestSitemapIsCached(t *testing.T) {
+	s := newTestServer(t)
+	s.config.SiteURL = "https://example.com"
+	first := getSitemap(t, s, "/sitemap.xml", nil).Body.String()
+
+	if err := s.quotes.InsertQuote(context.Background(), Quote{Quote: "new", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if second := getSitemap(t, s, "/sitemap.xml", nil).Body.String(); second != first {
+		t.Errorf("sitemap changed within %v:\n%s\nthen\n%s", sitemapCacheTTL, first, second)
+	}
+
+	s.sitemap.builtAt = time.Now().Add(-sitemapCacheTTL)
+	if third := getSitemap(t, s, "/sitemap.xml", nil).Body.String(); third == first {
+		t.Error("sitemap wasn't rebuilt once the cache expired")
+	}
+}
+
+func TestRenderSitemapsSplitsLongSitemaps(t *testing.T) {
+	urls := make([]sitemapURL, sitemapMaxURLs+1)
+	for i := range urls {
+		urls[i] = sitemapURL{Loc: fmt.Sprintf("https://example.com/%d", i)}
+	}
+
+	files, err := renderSitemaps("https://example.com", urls, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("rendered %d files, want an index and 2 pages", len(files))
+	}
+
+	var index sitemapIndex
+	if err := xml.Unmarshal(files[0], &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.XMLName.Space != sitemapNamespace || len(index.Sitemaps) != 2 ||
+		index.Sitemaps[1].Loc != "https://example.com/sitemap.xml?page=2" {
+		t.Errorf("index = %+v, want 2 pages in the sitemap namespace", index)
+	}
+
+	for page, want := range []int{sitemapMaxURLs, 1} {
+		var sitemap sitemapURLSet
+		if err := xml.Unmarshal(files[page+1], &sitemap); err != nil {
+			t.Fatal(err)
+		}
+		if len(sitemap.URLs) != want {
+			t.Errorf("page %d lists %d URLs, want %d", page+1, len(sitemap.URLs), want)
+		}
+	}
+}
+
+func TestSitemapRejectsUnknownPages(t *testing.T) {
+	s := newTestServer(t)
+	s.config.SiteURL = "https://example.com"
+	for _, target := range []string{"/sitemap.xml?page=1", "/sitemap.xml?page=0", "/sitemap.xml?page=x"} {
+		w := httptest.NewRecorder()
+		s.sitemapHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, w.Code)
+		}
+	}
+}
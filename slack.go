@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// slackMaxAttempts is how many times a Slack notification is tried before giving up
+const slackMaxAttempts = 5
+
+// slackRetryDelay is how long the first retry of a failed notification waits, doubling after each
+var slackRetryDelay = time.Second
+
+// SlackConfig holds the settings for counter milestone notifications
+type SlackConfig struct {
+	WebhookURL        string
+	MilestoneInterval int
+}
+
+// loadSlackConfig reads the Slack settings
+func loadSlackConfig(env *envReader) SlackConfig {
+	return SlackConfig{
+		WebhookURL:        env.string("SLACK_WEBHOOK_URL", ""),
+		MilestoneInterval: env.int("SLACK_MILESTONE_INTERVAL", 100),
+	}
+}
+
+// isMilestone reports whether the count should trigger a Slack notification
+func (c SlackConfig) isMilestone(count int) bool {
+	return c.WebhookURL != "" && count > 0 && count%c.MilestoneInterval == 0
+}
+
+// slackMilestones records the highest milestone announced for each counter, so a counter
+// moving back and forth across a milestone only announces it once
+type slackMilestones struct {
+	mu       sync.Mutex
+	notified map[string]int
+}
+
+// claim reports whether counter reaching count is a milestone to announce, recording it if so.
+// Only a new high counts: a milestone at or below one already announced, or below maxSeen, the
+// counter's highest count, was reached before, even if that was before a restart.
+func (m *slackMilestones) claim(config SlackConfig, counter string, count, maxSeen int) bool {
+	if !config.isMilestone(count) || count < maxSeen {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if count <= m.notified[counter] {
+		return false
+	}
+	if m.notified == nil {
+		m.notified = make(map[string]int)
+	}
+	m.notified[counter] = count
+	return true
+}
+
+// slackMessage is a Slack incoming webhook payload using Block Kit
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// notifySlackMilestone posts a counter milestone to Slack, retrying with exponential backoff
+// until it succeeds, runs out of attempts, or ctx is done
+func (s *Server) notifySlackMilestone(ctx context.Context, count, totalClicks int) {
+	summary := fmt.Sprintf("The webhook counter just reached %d!", count)
+	message := slackMessage{
+		Text: summary,
+		Blocks: []slackBlock{
+			{
+				Type: "header",
+				Text: &slackText{Type: "plain_text", Text: "Webhook counter milestone"},
+			},
+			{
+				Type: "section",
+				Text: &slackText{Type: "mrkdwn", Text: summary},
+				Fields: []slackText{
+					{Type: "mrkdwn", Text: fmt.Sprintf("*Count:*\n%d", count)},
+					{Type: "mrkdwn", Text: fmt.Sprintf("*Total clicks:*\n%d", totalClicks)},
+				},
+			},
+		},
+	}
+	if s.config.SiteURL != "" {
+		message.Blocks = append(message.Blocks, slackBlock{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("<%s|Visit the website>", s.config.SiteURL)},
+		})
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		componentLogger("slack").Error("encoding Slack message", "err", err)
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	backoff := slackRetryDelay
+	for attempt := 1; attempt <= slackMaxAttempts; attempt++ {
+		err = s.postSlackMessage(ctx, client, body)
+		if err == nil {
+			return
+		}
+
+		componentLogger("slack").Warn("Slack notification failed", "attempt", attempt, "max_attempts", slackMaxAttempts, "err", err)
+		if attempt == slackMaxAttempts {
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			componentLogger("slack").Info("giving up on Slack notification", "err", ctx.Err())
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// postSlackMessage sends a single request to the Slack webhook
+func (s *Server) postSlackMessage(ctx context.Context, client *http.Client, body []byte) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.Slack.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slackRecorder is a fake Slack webhook that records each message posted to it, failing the
+// first failures requests
+type slackRecorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages []slackMessage
+	failures int
+	posted   chan struct{}
+}
+
+func newSlackRecorder(t *testing.T, failures int) *slackRecorder {
+	t.Helper()
+	rec := &slackRecorder{failures: failures, posted: make(chan struct{}, 16)}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message slackMessage
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("decoding Slack message: %v", err)
+		}
+		rec.mu.Lock()
+		rec.messages = append(rec.messages, message)
+		failed := len(rec.messages) <= rec.failures
+		rec.mu.Unlock()
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		rec.posted <- struct{}{}
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// wait waits for n more messages to be posted
+func (rec *slackRecorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-rec.posted:
+		case <-time.After(2 * time.Second):
+			t.Fatal("no Slack message within 2s")
+		}
+	}
+}
+
+// summaries returns the text of each message posted
+func (rec *slackRecorder) summaries() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var summaries []string
+	for _, message := range rec.messages {
+		summaries = append(summaries, message.Text)
+	}
+	return summaries
+}
+
+// setSlackRetryDelay sets the delay before the first Slack retry for the rest of the test
+func setSlackRetryDelay(t *testing.T, d time.Duration) {
+	t.Helper()
+	previous := slackRetryDelay
+	slackRetryDelay = d
+	t.Cleanup(func() { slackRetryDelay = previous })
+}
+
+func TestSlackMilestonesOnlyClaimNewHighs(t *testing.T) {
+	config := SlackConfig{WebhookURL: "https://hooks.slack.example/1", MilestoneInterval: 10}
+	var m slackMilestones
+	steps := []struct {
+		counter        string
+		count, maxSeen int
+		want           bool
+	}{
+		{"webhook", 9, 9, false},
+		{"webhook", 10, 10, true},
+		// Going back below the milestone and up to it again
+		{"webhook", 10, 10, false},
+		// A milestone the counter had already passed before a restart
+		{"webhook", 20, 25, false},
+		{"webhook", 30, 30, true},
+		{"webhook", 20, 30, false},
+		// Each counter has its own milestones
+		{"likes", 10, 10, true},
+		{"webhook", 0, 30, false},
+	}
+	for _, step := range steps {
+		if got := m.claim(config, step.counter, step.count, step.maxSeen); got != step.want {
+			t.Errorf("claim(%s, %d, max %d) = %t, want %t", step.counter, step.count, step.maxSeen, got, step.want)
+		}
+	}
+
+	if (&slackMilestones{}).claim(SlackConfig{MilestoneInterval: 10}, "webhook", 10, 10) {
+		t.Error("claimed a milestone without a webhook URL")
+	}
+}
+
+func TestNotifySlackMilestonePayload(t *testing.T) {
+	rec := newSlackRecorder(t, 0)
+	s := newTestServer(t)
+	s.config.Slack.WebhookURL = rec.URL
+	s.config.SiteURL = "https://example.com"
+
+	s.notifySlackMilestone(context.Background(), 200, 350)
+	rec.wait(t, 1)
+	message := rec.messages[0]
+	if message.Text != "The webhook counter just reached 200!" || len(message.Blocks) != 3 {
+		t.Fatalf("message = %+v, want the summary in a header, section, and link", message)
+	}
+	fields := message.Blocks[1].Fields
+	if len(fields) != 2 || fields[0].Text != "*Count:*\n200" || fields[1].Text != "*Total clicks:*\n350" {
+		t.Errorf("fields = %+v, want the count and total clicks", fields)
+	}
+	if link := message.Blocks[2].Text; link == nil || link.Text != "<https://example.com|Visit the website>" {
+		t.Errorf("link block = %+v, want SITE_URL", link)
+	}
+
+	// Without SITE_URL there's nothing to link to
+	s.config.SiteURL = ""
+	s.notifySlackMilestone(context.Background(), 300, 400)
+	rec.wait(t, 1)
+	if blocks := rec.messages[1].Blocks; len(blocks) != 2 {
+		t.Errorf("message without SITE_URL has %d blocks, want 2", len(blocks))
+	}
+}
+
+func TestNotifySlackMilestoneRetries(t *testing.T) {
+	setSlackRetryDelay(t, time.Millisecond)
+	rec := newSlackRecorder(t, 2)
+	s := newTestServer(t)
+	s.config.Slack.WebhookURL = rec.URL
+
+	s.notifySlackMilestone(context.Background(), 100, 100)
+	if got := len(rec.summaries()); got != 3 {
+		t.Errorf("posted %d times, want 2 failures and a success", got)
+	}
+
+	// A webhook that never succeeds is tried slackMaxAttempts times
+	rec = newSlackRecorder(t, slackMaxAttempts+1)
+	s.config.Slack.WebhookURL = rec.URL
+	s.notifySlackMilestone(context.Background(), 100, 100)
+	if got := len(rec.summaries()); got != slackMaxAttempts {
+		t.Errorf("posted %d times to a failing webhook, want %d", got, slackMaxAttempts)
+	}
+}
+
+func TestNotifySlackMilestoneStopsWithContext(t *testing.T) {
+	setSlackRetryDelay(t, time.Hour)
+	rec := newSlackRecorder(t, slackMaxAttempts)
+	s := newTestServer(t)
+	s.config.Slack.WebhookURL = rec.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.notifySlackMilestone(ctx, 100, 100)
+	}()
+	rec.wait(t, 1)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("notifySlackMilestone kept waiting to retry after its context was done")
+	}
+}
+
+func TestIncrementAnnouncesEachMilestoneOnce(t *testing.T) {
+	rec := newSlackRecorder(t, 0)
+	s := newTestServer(t)
+	s.config.Slack = SlackConfig{WebhookURL: rec.URL, MilestoneInterval: 2}
+	h := s.routes()
+
+	// Reaching 2, dropping back to 1 and reaching 2 again, then going on to 4
+	for _, target := range []string{"/increment", "/increment", "/decrement", "/increment", "/increment", "/increment"} {
+		if w := serveRequest(h, http.MethodPost, target, "", ""); w.Code != http.StatusOK {
+			t.Fatalf("POST %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+	}
+	rec.wait(t, 2)
+	// Give a repeated announcement, were one sent, time to arrive
+	time.Sleep(50 * time.Millisecond)
+	summaries := rec.summaries()
+	want := map[string]bool{"The webhook counter just reached 2!": true, "The webhook counter just reached 4!": true}
+	if len(summaries) != 2 || !want[summaries[0]] || !want[summaries[1]] || summaries[0] == summaries[1] {
+		t.Errorf("Slack messages = %q, want one each for 2 and 4", summaries)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// honeypotField is a form field hidden from people, so only bots fill it in
+	honeypotField = "website"
+	// formTokenField holds the token recording when a form was rendered
+	formTokenField = "form_token"
+	// minFormFillTime is the least time a person takes to fill in a form; anything quicker is a bot
+	minFormFillTime = 3 * time.Second
+	// maxFormAge is how long after it's rendered a form can be submitted
+	maxFormAge = 24 * time.Hour
+)
+
+var (
+	errFormTooFast = errors.New("form submitted too quickly after it was rendered")
+	errFormExpired = errors.New("form token is missing, invalid, or expired")
+)
+
+// spamRejections counts form submissions dropped as spam, by form and the defense that caught them
+var spamRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spam_rejections_total",
+	Help: "Form submissions dropped as spam, by form and reason (honeypot or too_fast).",
+}, []string{"form", "reason"})
+
+// formTokenKey signs form tokens. It's made up at startup, so forms rendered before a restart
+// have to be submitted again.
+var formTokenKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// newFormToken returns a token for a form rendered at now, like "<unix ms>.<hex HMAC>", so its
+// submission can show how long it took to fill in without the time being forged
+func newFormToken(now time.Time) string {
+	payload := strconv.FormatInt(now.UnixMilli(), 10)
+	return payload + "." + hex.EncodeToString(formTokenSignature(payload))
+}
+
+// formTokenSignature returns the HMAC-SHA256 of a form token's payload
+func formTokenSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, formTokenKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// checkFormToken checks a token from newFormToken, returning errFormTooFast if the form was
+// submitted less than minFormFillTime after it was rendered, or errFormExpired if the token
+// isn't valid or is older than maxFormAge
+func checkFormToken(token string, now time.Time) error {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return errFormExpired
+	}
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, formTokenSignature(payload)) {
+		return errFormExpired
+	}
+	millis, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return errFormExpired
+	}
+
+	elapsed := now.Sub(time.UnixMilli(millis))
+	switch {
+	case elapsed > maxFormAge:
+		return errFormExpired
+	case elapsed < minFormFillTime:
+		return errFormTooFast
+	}
+	return nil
+}
+
+// filledHoneypot reports whether the hidden honeypot field of a parsed form was filled in
+func filledHoneypot(r *http.Request) bool {
+	return r.PostFormValue(honeypotField) != ""
+}
@@ -0,0 +1,847 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// QuoteStore saves and lists quotes
+type QuoteStore interface {
+	InsertQuote(ctx context.Context, quote Quote) error
+	// InsertQuoteIfNew inserts a quote unless one with the same text exists, and reports whether
+	// it was inserted
+	InsertQuoteIfNew(ctx context.Context, quote Quote) (bool, error)
+	// DeleteQuote removes a quote, or returns mongo.ErrNoDocuments if it doesn't exist
+	DeleteQuote(ctx context.Context, id primitive.ObjectID) error
+	// ListQuotes returns one page of quotes, newest first, optionally only those with the given tag
+	ListQuotes(ctx context.Context, page, limit int, tag string) (QuotePage, error)
+	// LatestQuotes returns up to limit quotes, newest first, or every quote if limit is zero
+	LatestQuotes(ctx context.Context, limit int64) ([]Quote, error)
+	// NthOldestQuote returns the quote with n older quotes before it, or mongo.ErrNoDocuments
+	// if there are n quotes or fewer
+	NthOldestQuote(ctx context.Context, n int64) (Quote, error)
+	CountQuotes(ctx context.Context) (int64, error)
+	// QuotesSince returns up to limit quotes added after since, oldest first
+	QuotesSince(ctx context.Context, since time.Time, limit int64) ([]Quote, error)
+	// ReactToQuote adds one to a quote's count for the reaction's emoji and returns the quote's
+	// updated reactions. It returns errAlreadyReacted if the session already reacted to the quote
+	// with that emoji, or mongo.ErrNoDocuments if the quote doesn't exist.
+	ReactToQuote(ctx context.Context, reaction QuoteReaction) (map[string]int, error)
+	// GroupQuotesByAuthor groups the quotes that have an AuthorIPHash by it, sorted with
+	// sortQuoteAuthors
+	GroupQuotesByAuthor(ctx context.Context) ([]QuoteAuthor, error)
+	// SearchQuotes returns up to limit quotes whose text or author contains term, ignoring
+	// case, newest first
+	SearchQuotes(ctx context.Context, term string, limit int64) ([]Quote, error)
+}
+
+// CounterStore reads and updates counters
+type CounterStore interface {
+	// InitCounters creates the given counters at zero, leaving any that already exist alone
+	InitCounters(ctx context.Context, ids ...string) error
+	// GetCounter returns a counter by ID, or mongo.ErrNoDocuments if it doesn't exist
+	GetCounter(ctx context.Context, id string) (Counter, error)
+	// GetLatestCounter is GetCounter reading from the primary whatever the read preference, for
+	// reads that must see the latest writes
+	GetLatestCounter(ctx context.Context, id string) (Counter, error)
+	// IncrementCounter adds one to a counter, creating it if it's missing, and returns the updated
+	// counter with its high-water mark raised to match
+	IncrementCounter(ctx context.Context, id string) (Counter, error)
+	// DecrementCounter subtracts one from a counter, stopping at zero and creating it if it's
+	// missing, and returns the updated counter
+	DecrementCounter(ctx context.Context, id string) (Counter, error)
+	// ResetCounters sets the given counters to zero and returns them as they were before,
+	// leaving out any that don't exist
+	ResetCounters(ctx context.Context, ids []string) ([]Counter, error)
+	// ListCounters returns the counters in a namespace, or every counter if it's "", sorted by ID
+	ListCounters(ctx context.Context, namespace string) ([]Counter, error)
+	// ListNamespaces returns the distinct namespaces in use, sorted
+	ListNamespaces(ctx context.Context) ([]string, error)
+	// CreateCounter adds a new counter, or returns errCounterExists if its ID is taken
+	CreateCounter(ctx context.Context, counter Counter) error
+	// AdjustCounter adds delta to an existing counter, stopping at zero, and returns the updated
+	// counter with its high-water mark raised to match. It returns mongo.ErrNoDocuments if the
+	// counter doesn't exist.
+	AdjustCounter(ctx context.Context, id string, delta int) (Counter, error)
+	// MergeCounters adds the sum of the source counters to dest all at once and returns the
+	// updated dest, leaving the sources unchanged. It returns a *mergeSourceMissingError or
+	// errMergeDestMissing if a counter doesn't exist.
+	MergeCounters(ctx context.Context, sources []string, dest string) (Counter, error)
+	// RecordCounterEvent notes that a counter was changed by delta
+	RecordCounterEvent(ctx context.Context, event CounterEvent) error
+	// SummarizeCounterEvents totals a counter's events at or after since
+	SummarizeCounterEvents(ctx context.Context, id string, since time.Time) (CounterEventSummary, error)
+}
+
+// PageViewStore counts views of each page
+type PageViewStore interface {
+	// TrackPageView adds one to the views of path, creating its count if it's missing
+	TrackPageView(ctx context.Context, path string) error
+	// ListPageViews returns the views of every page, most viewed first
+	ListPageViews(ctx context.Context) ([]PageView, error)
+	// TotalPageViews returns the views of every page added together
+	TotalPageViews(ctx context.Context) (int, error)
+	// SeedPageViews sets the views of path to count unless it already has a count
+	SeedPageViews(ctx context.Context, path string, count int) error
+	// TrackCountryView adds one to the views from the country with the given code
+	TrackCountryView(ctx context.Context, country string) error
+	// ListCountryViews returns the views from every country, most views first
+	ListCountryViews(ctx context.Context) ([]CountryViews, error)
+	// RecordVisitor notes that the visitor with the given key was counted at now, unless they
+	// already were within window of it, and reports whether they were new
+	RecordVisitor(ctx context.Context, key string, now time.Time, window time.Duration) (bool, error)
+}
+
+// MessageStore keeps the messages sent through the contact form
+type MessageStore interface {
+	InsertMessage(ctx context.Context, message ContactMessage) error
+	// ListMessages returns the messages newest first, only those not yet read if unreadOnly is set
+	ListMessages(ctx context.Context, unreadOnly bool) ([]ContactMessage, error)
+	// ListUndeliveredMessages returns the messages not yet marked delivered, oldest first
+	ListUndeliveredMessages(ctx context.Context) ([]ContactMessage, error)
+	// MarkMessageRead and MarkMessageDelivered set a message's Read and Delivered flags,
+	// returning mongo.ErrNoDocuments if it doesn't exist
+	MarkMessageRead(ctx context.Context, id primitive.ObjectID) error
+	MarkMessageDelivered(ctx context.Context, id primitive.ObjectID) error
+}
+
+// SessionStore records anonymous sessions
+type SessionStore interface {
+	// CreateSession records a new session, doing nothing if its ID is already recorded
+	CreateSession(ctx context.Context, session Session) error
+}
+
+// AuditStore keeps the audit log: the state-changing requests recorded by auditMiddleware, and
+// the audit events logged by admin and security checks
+type AuditStore interface {
+	InsertAuditEntry(ctx context.Context, entry AuditEntry) error
+	// ListAuditEntries returns up to limit request entries newest first, only those for the
+	// given route unless it's empty
+	ListAuditEntries(ctx context.Context, route string, limit int) ([]AuditEntry, error)
+	InsertAuditEvent(ctx context.Context, event AuditEvent) error
+	// ListAuditEvents returns up to limit events newest first, only those with the given action
+	// unless it's empty
+	ListAuditEvents(ctx context.Context, action string, limit int) ([]AuditEvent, error)
+}
+
+// loadReadPreference reads MONGO_READ_PREFERENCE, treating MONGO_READ_SECONDARY as shorthand
+// for secondaryPreferred
+func loadReadPreference(env *envReader) readpref.Mode {
+	readSecondary := env.bool("MONGO_READ_SECONDARY", false)
+	value := env.string("MONGO_READ_PREFERENCE", "")
+	if value == "" {
+		if readSecondary {
+			return readpref.SecondaryPreferredMode
+		}
+		return readpref.PrimaryMode
+	}
+
+	if readSecondary {
+		env.problem("MONGO_READ_SECONDARY", "can't be combined with MONGO_READ_PREFERENCE")
+	}
+	mode, err := readpref.ModeFromString(value)
+	if err != nil {
+		env.problem("MONGO_READ_PREFERENCE", "%q must be primary, primaryPreferred, secondary, secondaryPreferred, or nearest", value)
+		return readpref.PrimaryMode
+	}
+	return mode
+}
+
+// helloResponse is the part of the hello command's reply that describes the topology
+type helloResponse struct {
+	SetName string `bson:"setName"`
+	Msg     string `bson:"msg"`
+}
+
+// replicated reports whether the server is a replica set member or a mongos router, either of
+// which can send reads to secondaries
+func (h helloResponse) replicated() bool {
+	return h.SetName != "" || h.Msg == "isdbgrid"
+}
+
+// warnIfNotReplicated logs a warning when reads prefer secondaries but MongoDB isn't a replica
+// set, so every read goes to the one server anyway
+func warnIfNotReplicated(ctx context.Context, client *mongo.Client, mode readpref.Mode) {
+	if mode == readpref.PrimaryMode {
+		return
+	}
+
+	l := componentLogger("mongo")
+	var hello helloResponse
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		l.Warn("checking the MongoDB topology", "err", err)
+		return
+	}
+	if !hello.replicated() {
+		l.Warn("MONGO_READ_PREFERENCE has no effect without a replica set", "read_preference", mode.String())
+	}
+}
+
+// primaryDatabase returns db with reads sent to the primary whatever the client's read
+// preference, for reads that must see the latest writes
+func primaryDatabase(db *mongo.Database) *mongo.Database {
+	if db == nil {
+		return nil
+	}
+	return db.Client().Database(db.Name(), options.Database().SetReadPreference(readpref.Primary()))
+}
+
+// mongoStore keeps quotes, counters, and page views in MongoDB
+type mongoStore struct {
+	// db always reads from the primary, for writes and the reads that follow them
+	db *mongo.Database
+	// reads uses the client's read preference, for read-only queries that can lag behind writes
+	reads       *mongo.Database
+	collections CollectionNames
+}
+
+// newMongoStore returns a store using the named collections in db, whose read preference
+// applies to read-only queries
+func newMongoStore(db *mongo.Database, collections CollectionNames) *mongoStore {
+	return &mongoStore{db: primaryDatabase(db), reads: db, collections: collections}
+}
+
+func (m *mongoStore) InsertQuote(ctx context.Context, quote Quote) error {
+	// A null reactions field couldn't be incremented, unlike a missing or empty one
+	if quote.Reactions == nil {
+		quote.Reactions = map[string]int{}
+	}
+	_, err := m.db.Collection(m.collections.Quotes).InsertOne(ctx, quote)
+	return err
+}
+
+func (m *mongoStore) DeleteQuote(ctx context.Context, id primitive.ObjectID) error {
+	result, err := m.db.Collection(m.collections.Quotes).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (m *mongoStore) InsertQuoteIfNew(ctx context.Context, quote Quote) (bool, error) {
+	result, err := m.db.Collection(m.collections.Quotes).UpdateOne(
+		ctx,
+		bson.M{"quote": quote.Quote},
+		bson.M{"$setOnInsert": quote},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+func (m *mongoStore) SearchQuotes(ctx context.Context, term string, limit int64) ([]Quote, error) {
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(term), Options: "i"}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"quote": pattern},
+		bson.M{"name": pattern},
+	}}
+
+	cursor, err := m.reads.Collection(m.collections.Quotes).Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	quotes := []Quote{}
+	err = cursor.All(ctx, &quotes)
+	return quotes, err
+}
+
+func (m *mongoStore) GroupQuotesByAuthor(ctx context.Context) ([]QuoteAuthor, error) {
+	cursor, err := m.reads.Collection(m.collections.Quotes).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"authorIPHash": bson.M{"$exists": true, "$ne": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$authorIPHash",
+			"quotes":      bson.M{"$sum": 1},
+			"names":       bson.M{"$addToSet": "$name"},
+			"lastQuoteAt": bson.M{"$max": "$timestamp"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	authors := []QuoteAuthor{}
+	if err := cursor.All(ctx, &authors); err != nil {
+		return nil, err
+	}
+	sortQuoteAuthors(authors)
+	return authors, nil
+}
+
+func (m *mongoStore) ListQuotes(ctx context.Context, page, limit int, tag string) (QuotePage, error) {
+	filter := bson.M{}
+	if tag != "" {
+		filter["tags"] = tag
+	}
+
+	quotesCollection := m.reads.Collection(m.collections.Quotes)
+	total, err := quotesCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return QuotePage{}, err
+	}
+
+	cursor, err := quotesCollection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetSkip(int64((page-1)*limit)).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return QuotePage{}, err
+	}
+	defer cursor.Close(ctx)
+
+	quotes := []Quote{}
+	if err := cursor.All(ctx, &quotes); err != nil {
+		return QuotePage{}, err
+	}
+
+	return QuotePage{Quotes: quotes, Page: page, Limit: limit, Total: total}, nil
+}
+
+func (m *mongoStore) LatestQuotes(ctx context.Context, limit int64) ([]Quote, error) {
+	cursor, err := m.reads.Collection(m.collections.Quotes).Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	quotes := []Quote{}
+	if err := cursor.All(ctx, &quotes); err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+func (m *mongoStore) NthOldestQuote(ctx context.Context, n int64) (Quote, error) {
+	var quote Quote
+	err := m.reads.Collection(m.collections.Quotes).FindOne(ctx, bson.M{}, options.FindOne().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetSkip(n)).Decode(&quote)
+	return quote, err
+}
+
+func (m *mongoStore) CountQuotes(ctx context.Context) (int64, error) {
+	return m.reads.Collection(m.collections.Quotes).CountDocuments(ctx, bson.M{})
+}
+
+// QuotesSince reads from the primary, since it's called right after this instance inserts a quote
+func (m *mongoStore) QuotesSince(ctx context.Context, since time.Time, limit int64) ([]Quote, error) {
+	cursor, err := m.db.Collection(m.collections.Quotes).Find(ctx, bson.M{"timestamp": bson.M{"$gt": since}}, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	quotes := []Quote{}
+	if err := cursor.All(ctx, &quotes); err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// ReactToQuote records the reaction before counting it, so a second identical reaction fails on
+// the duplicate _id. If counting it fails the record is removed again so it can be retried.
+func (m *mongoStore) ReactToQuote(ctx context.Context, reaction QuoteReaction) (map[string]int, error) {
+	_, err := m.db.Collection(m.collections.QuoteReactions).InsertOne(ctx, reaction)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errAlreadyReacted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var quote Quote
+	err = m.db.Collection(m.collections.Quotes).FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": reaction.QuoteID},
+		bson.M{"$inc": bson.M{"reactions." + reaction.Emoji: 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"reactions": 1}),
+	).Decode(&quote)
+	if err != nil {
+		if _, deleteErr := m.db.Collection(m.collections.QuoteReactions).DeleteOne(ctx, bson.M{"_id": reaction.ID}); deleteErr != nil {
+			err = errors.Join(err, deleteErr)
+		}
+		return nil, err
+	}
+	return quote.Reactions, nil
+}
+
+func (m *mongoStore) InitCounters(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		_, err := m.db.Collection(m.collections.Counters).UpdateOne(
+			ctx,
+			bson.M{"_id": id},
+			bson.M{"$setOnInsert": bson.M{"count": 0}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mongoStore) GetCounter(ctx context.Context, id string) (Counter, error) {
+	var counter Counter
+	err := m.reads.Collection(m.collections.Counters).FindOne(ctx, bson.M{"_id": id}).Decode(&counter)
+	return counter, err
+}
+
+func (m *mongoStore) GetLatestCounter(ctx context.Context, id string) (Counter, error) {
+	var counter Counter
+	err := m.db.Collection(m.collections.Counters).FindOne(ctx, bson.M{"_id": id}).Decode(&counter)
+	return counter, err
+}
+
+func (m *mongoStore) IncrementCounter(ctx context.Context, id string) (Counter, error) {
+	var counter Counter
+	err := m.db.Collection(m.collections.Counters).FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id},
+		incrementUpdate(1),
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter, err
+}
+
+// incrementUpdate adds delta to a counter and raises its high-water mark to the new count in the
+// same update, noting the time when the mark moves. It's a pipeline so the mark can be compared
+// with the updated count, and a missing count, as on upsert, is treated as zero.
+func incrementUpdate(delta int) bson.A {
+	return bson.A{
+		bson.M{"$set": bson.M{"count": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$count", 0}}, delta}}}},
+		// Both fields are computed from the document before this stage, so maxSeen is still the old mark
+		bson.M{"$set": bson.M{
+			"maxSeen": bson.M{"$max": bson.A{"$count", bson.M{"$ifNull": bson.A{"$maxSeen", 0}}}},
+			"maxSeenAt": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$count", bson.M{"$ifNull": bson.A{"$maxSeen", 0}}}},
+				"$$NOW",
+				"$maxSeenAt",
+			}},
+		}},
+	}
+}
+
+// decrementUpdate subtracts one from a counter, stopping at zero. It's a pipeline so a missing
+// count, as on upsert, is treated as zero rather than going negative.
+var decrementUpdate = bson.A{
+	bson.M{"$set": bson.M{"count": bson.M{"$max": bson.A{
+		0,
+		bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$count", 0}}, -1}},
+	}}}},
+}
+
+func (m *mongoStore) DecrementCounter(ctx context.Context, id string) (Counter, error) {
+	var counter Counter
+	err := m.db.Collection(m.collections.Counters).FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id},
+		decrementUpdate,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter, err
+}
+
+// ResetCounters resets each counter with its own update, so the count it had is read atomically
+func (m *mongoStore) ResetCounters(ctx context.Context, ids []string) ([]Counter, error) {
+	var previous []Counter
+	for _, id := range ids {
+		var counter Counter
+		err := m.db.Collection(m.collections.Counters).FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": id},
+			bson.M{"$set": bson.M{"count": 0}},
+			options.FindOneAndUpdate().SetReturnDocument(options.Before),
+		).Decode(&counter)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return previous, err
+		}
+		previous = append(previous, counter)
+	}
+	return previous, nil
+}
+
+func (m *mongoStore) ListCounters(ctx context.Context, namespace string) ([]Counter, error) {
+	filter := bson.M{}
+	if namespace != "" {
+		filter["namespace"] = namespace
+	}
+	cursor, err := m.reads.Collection(m.collections.Counters).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counters := []Counter{}
+	if err := cursor.All(ctx, &counters); err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
+
+func (m *mongoStore) ListNamespaces(ctx context.Context) ([]string, error) {
+	values, err := m.reads.Collection(m.collections.Counters).Distinct(ctx, "namespace", bson.M{"namespace": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make([]string, 0, len(values))
+	for _, value := range values {
+		if namespace, ok := value.(string); ok {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces, nil
+}
+
+func (m *mongoStore) CreateCounter(ctx context.Context, counter Counter) error {
+	_, err := m.db.Collection(m.collections.Counters).InsertOne(ctx, counter)
+	if mongo.IsDuplicateKeyError(err) {
+		return errCounterExists
+	}
+	return err
+}
+
+func (m *mongoStore) AdjustCounter(ctx context.Context, id string, delta int) (Counter, error) {
+	countersCollection := m.db.Collection(m.collections.Counters)
+	for {
+		// A change that leaves the count at zero or more is made as asked
+		filter := bson.M{"_id": id}
+		if delta < 0 {
+			filter["count"] = bson.M{"$gte": -delta}
+		}
+		var counter Counter
+		err := countersCollection.FindOneAndUpdate(
+			ctx,
+			filter,
+			incrementUpdate(delta),
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&counter)
+		if delta >= 0 || !errors.Is(err, mongo.ErrNoDocuments) {
+			return counter, err
+		}
+
+		// Otherwise the counter is set to zero
+		err = countersCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": id, "count": bson.M{"$not": bson.M{"$gte": -delta}}},
+			bson.M{"$set": bson.M{"count": 0}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&counter)
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return counter, err
+		}
+
+		// Neither matched, so the counter is missing or was raised in between and can be retried
+		exists, err := countersCollection.CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return Counter{}, err
+		}
+		if exists == 0 {
+			return Counter{}, mongo.ErrNoDocuments
+		}
+	}
+}
+
+// MergeCounters reads the sources and updates dest in one transaction
+func (m *mongoStore) MergeCounters(ctx context.Context, sources []string, dest string) (Counter, error) {
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return Counter{}, err
+	}
+	defer session.EndSession(ctx)
+
+	countersCollection := m.db.Collection(m.collections.Counters)
+	result, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		sum := 0
+		for _, id := range sources {
+			var source Counter
+			err := countersCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&source)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, &mergeSourceMissingError{id: id}
+			}
+			if err != nil {
+				return nil, err
+			}
+			sum += source.Count
+		}
+
+		var merged Counter
+		err := countersCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": dest},
+			bson.M{"$inc": bson.M{"count": sum}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&merged)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errMergeDestMissing
+		}
+		return merged, err
+	})
+	if err != nil {
+		return Counter{}, err
+	}
+	return result.(Counter), nil
+}
+
+func (m *mongoStore) RecordCounterEvent(ctx context.Context, event CounterEvent) error {
+	_, err := m.db.Collection(m.collections.CounterEvents).InsertOne(ctx, event)
+	return err
+}
+
+func (m *mongoStore) SummarizeCounterEvents(ctx context.Context, id string, since time.Time) (CounterEventSummary, error) {
+	cursor, err := m.reads.Collection(m.collections.CounterEvents).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"counterId": id, "timestamp": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    nil,
+			"events": bson.M{"$sum": 1},
+			"delta":  bson.M{"$sum": "$delta"},
+			"oldest": bson.M{"$min": "$timestamp"},
+		}}},
+	})
+	if err != nil {
+		return CounterEventSummary{}, err
+	}
+	defer cursor.Close(ctx)
+
+	// No events leaves the summary empty
+	var summary CounterEventSummary
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&summary); err != nil {
+			return CounterEventSummary{}, err
+		}
+	}
+	return summary, cursor.Err()
+}
+
+func (m *mongoStore) TrackPageView(ctx context.Context, path string) error {
+	_, err := m.db.Collection(m.collections.PageViews).UpdateOne(
+		ctx,
+		bson.M{"_id": path},
+		bson.M{"$inc": bson.M{"count": 1}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *mongoStore) ListPageViews(ctx context.Context) ([]PageView, error) {
+	cursor, err := m.reads.Collection(m.collections.PageViews).Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	views := []PageView{}
+	err = cursor.All(ctx, &views)
+	return views, err
+}
+
+func (m *mongoStore) TotalPageViews(ctx context.Context) (int, error) {
+	cursor, err := m.reads.Collection(m.collections.PageViews).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$count"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	// No pages leaves the total at zero
+	var result struct {
+		Total int `bson:"total"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Total, cursor.Err()
+}
+
+func (m *mongoStore) SeedPageViews(ctx context.Context, path string, count int) error {
+	_, err := m.db.Collection(m.collections.PageViews).UpdateOne(
+		ctx,
+		bson.M{"_id": path},
+		bson.M{"$setOnInsert": bson.M{"count": count}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *mongoStore) TrackCountryView(ctx context.Context, country string) error {
+	_, err := m.db.Collection(m.collections.PageViewCountries).UpdateOne(
+		ctx,
+		bson.M{"_id": country},
+		bson.M{"$inc": bson.M{"views": 1}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *mongoStore) ListCountryViews(ctx context.Context) ([]CountryViews, error) {
+	cursor, err := m.reads.Collection(m.collections.PageViewCountries).Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.D{{Key: "views", Value: -1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	views := []CountryViews{}
+	err = cursor.All(ctx, &views)
+	return views, err
+}
+
+// RecordVisitor keeps one document per visitor, replaced once it has expired. An unexpired one
+// doesn't match the filter, so the upsert fails on its _id instead.
+func (m *mongoStore) RecordVisitor(ctx context.Context, key string, now time.Time, window time.Duration) (bool, error) {
+	_, err := m.db.Collection(m.collections.PageViewVisitors).UpdateOne(
+		ctx,
+		bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"countedAt": now, "expiresAt": now.Add(window)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *mongoStore) CreateSession(ctx context.Context, session Session) error {
+	_, err := m.db.Collection(m.collections.Sessions).UpdateOne(
+		ctx,
+		bson.M{"_id": session.ID},
+		bson.M{"$setOnInsert": session},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *mongoStore) InsertMessage(ctx context.Context, message ContactMessage) error {
+	_, err := m.db.Collection(m.collections.Messages).InsertOne(ctx, message)
+	return err
+}
+
+func (m *mongoStore) ListMessages(ctx context.Context, unreadOnly bool) ([]ContactMessage, error) {
+	filter := bson.M{}
+	if unreadOnly {
+		filter["read"] = false
+	}
+	cursor, err := m.reads.Collection(m.collections.Messages).Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	messages := []ContactMessage{}
+	err = cursor.All(ctx, &messages)
+	return messages, err
+}
+
+func (m *mongoStore) ListUndeliveredMessages(ctx context.Context) ([]ContactMessage, error) {
+	// Read from the primary, so a message delivered just before a restart isn't sent again
+	cursor, err := m.db.Collection(m.collections.Messages).Find(ctx, bson.M{"delivered": bson.M{"$ne": true}}, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	messages := []ContactMessage{}
+	err = cursor.All(ctx, &messages)
+	return messages, err
+}
+
+func (m *mongoStore) MarkMessageRead(ctx context.Context, id primitive.ObjectID) error {
+	return m.setMessageFlag(ctx, id, "read")
+}
+
+func (m *mongoStore) MarkMessageDelivered(ctx context.Context, id primitive.ObjectID) error {
+	return m.setMessageFlag(ctx, id, "delivered")
+}
+
+// setMessageFlag sets a contact message's boolean field to true
+func (m *mongoStore) setMessageFlag(ctx context.Context, id primitive.ObjectID, field string) error {
+	result, err := m.db.Collection(m.collections.Messages).UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{field: true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (m *mongoStore) InsertAuditEntry(ctx context.Context, entry AuditEntry) error {
+	_, err := m.db.Collection(m.collections.AuditLog).InsertOne(ctx, entry)
+	return err
+}
+
+func (m *mongoStore) ListAuditEntries(ctx context.Context, route string, limit int) ([]AuditEntry, error) {
+	filter := bson.M{"method": bson.M{"$exists": true}}
+	if route != "" {
+		filter["route"] = route
+	}
+	cursor, err := m.reads.Collection(m.collections.AuditLog).Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "$natural", Value: -1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
+
+// InsertAuditEvent saves an event to the capped audit_log collection, alongside the request
+// entries, which have no action
+func (m *mongoStore) InsertAuditEvent(ctx context.Context, event AuditEvent) error {
+	_, err := m.db.Collection(m.collections.AuditLog).InsertOne(ctx, event)
+	return err
+}
+
+func (m *mongoStore) ListAuditEvents(ctx context.Context, action string, limit int) ([]AuditEvent, error) {
+	filter := bson.M{"action": bson.M{"$exists": true}}
+	if action != "" {
+		filter["action"] = action
+	}
+	cursor, err := m.reads.Collection(m.collections.AuditLog).Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "$natural", Value: -1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []AuditEvent{}
+	err = cursor.All(ctx, &events)
+	return events, err
+}
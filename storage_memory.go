@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryStore keeps quotes, counters, and page views in memory, for tests that don't need MongoDB.
+// Like the MongoDB store it returns mongo.ErrNoDocuments for missing documents.
+type memoryStore struct {
+	mu        sync.Mutex
+	quotes    []Quote // oldest first
+	counters  map[string]Counter
+	events    []CounterEvent
+	pageViews map[string]int
+	countries map[string]int
+	visitors  map[string]time.Time // when each recorded visitor expires
+	sessions  map[string]Session
+	reactions map[string]bool // IDs of the quote reactions recorded
+	messages  []ContactMessage
+	entries   []AuditEntry // oldest first
+	audit     []AuditEvent // oldest first
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		counters:  make(map[string]Counter),
+		pageViews: make(map[string]int),
+		countries: make(map[string]int),
+		visitors:  make(map[string]time.Time),
+		sessions:  make(map[string]Session),
+		reactions: make(map[string]bool),
+	}
+}
+
+func (m *memoryStore) InsertQuote(ctx context.Context, quote Quote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insertQuote(quote)
+	return nil
+}
+
+// insertQuote adds a quote, which the caller must hold m.mu for
+func (m *memoryStore) insertQuote(quote Quote) {
+	// MongoDB gives a quote without an ID one when it's inserted
+	if quote.ID.IsZero() {
+		quote.ID = primitive.NewObjectID()
+	}
+	// Keep the quotes sorted even if one arrives with an earlier timestamp
+	i := len(m.quotes)
+	for i > 0 && m.quotes[i-1].Timestamp.After(quote.Timestamp) {
+		i--
+	}
+	m.quotes = slices.Insert(m.quotes, i, quote)
+}
+
+func (m *memoryStore) DeleteQuote(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.quotes, func(quote Quote) bool { return quote.ID == id })
+	if i < 0 {
+		return mongo.ErrNoDocuments
+	}
+	m.quotes = slices.Delete(m.quotes, i, i+1)
+	return nil
+}
+
+// newestFirst returns the quotes, optionally only those with the given tag, newest first.
+// The caller must hold m.mu.
+func (m *memoryStore) newestFirst(tag string) []Quote {
+	quotes := []Quote{}
+	for i := len(m.quotes) - 1; i >= 0; i-- {
+		if tag == "" || slices.Contains(m.quotes[i].Tags, tag) {
+			quotes = append(quotes, m.quotes[i])
+		}
+	}
+	return quotes
+}
+
+func (m *memoryStore) ListQuotes(ctx context.Context, page, limit int, tag string) (QuotePage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matching := m.newestFirst(tag)
+	start := min((page-1)*limit, len(matching))
+	end := min(start+limit, len(matching))
+	return QuotePage{Quotes: matching[start:end], Page: page, Limit: limit, Total: int64(len(matching))}, nil
+}
+
+func (m *memoryStore) LatestQuotes(ctx context.Context, limit int64) ([]Quote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	quotes := m.newestFirst("")
+	if limit > 0 && int64(len(quotes)) > limit {
+		quotes = quotes[:limit]
+	}
+	return quotes, nil
+}
+
+func (m *memoryStore) InsertQuoteIfNew(ctx context.Context, quote Quote) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if slices.ContainsFunc(m.quotes, func(q Quote) bool { return q.Quote == quote.Quote }) {
+		return false, nil
+	}
+	m.insertQuote(quote)
+	return true, nil
+}
+
+func (m *memoryStore) SearchQuotes(ctx context.Context, term string, limit int64) ([]Quote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	term = strings.ToLower(term)
+	quotes := []Quote{}
+	for _, quote := range m.newestFirst("") {
+		if int64(len(quotes)) == limit {
+			break
+		}
+		if strings.Contains(strings.ToLower(quote.Quote), term) || strings.Contains(strings.ToLower(quote.Name), term) {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes, nil
+}
+
+func (m *memoryStore) GroupQuotesByAuthor(ctx context.Context) ([]QuoteAuthor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byHash := make(map[string]*QuoteAuthor)
+	authors := []QuoteAuthor{}
+	for _, quote := range m.quotes {
+		if quote.AuthorIPHash == "" {
+			continue
+		}
+		author, ok := byHash[quote.AuthorIPHash]
+		if !ok {
+			author = &QuoteAuthor{IPHash: quote.AuthorIPHash}
+			byHash[quote.AuthorIPHash] = author
+		}
+		author.Quotes++
+		if !slices.Contains(author.Names, quote.Name) {
+			author.Names = append(author.Names, quote.Name)
+		}
+		if quote.Timestamp.After(author.LastQuoteAt) {
+			author.LastQuoteAt = quote.Timestamp
+		}
+	}
+	for _, author := range byHash {
+		authors = append(authors, *author)
+	}
+	sortQuoteAuthors(authors)
+	return authors, nil
+}
+
+func (m *memoryStore) NthOldestQuote(ctx context.Context, n int64) (Quote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n < 0 || n >= int64(len(m.quotes)) {
+		return Quote{}, mongo.ErrNoDocuments
+	}
+	return m.quotes[n], nil
+}
+
+func (m *memoryStore) CountQuotes(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.quotes)), nil
+}
+
+func (m *memoryStore) QuotesSince(ctx context.Context, since time.Time, limit int64) ([]Quote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	quotes := []Quote{}
+	for _, quote := range m.quotes {
+		if quote.Timestamp.After(since) && int64(len(quotes)) < limit {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes, nil
+}
+
+func (m *memoryStore) InitCounters(ctx context.Context, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := m.counters[id]; !ok {
+			m.counters[id] = Counter{ID: id}
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) GetCounter(ctx context.Context, id string) (Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter, ok := m.counters[id]
+	if !ok {
+		return Counter{}, mongo.ErrNoDocuments
+	}
+	return counter, nil
+}
+
+// GetLatestCounter is GetCounter, since the memory store has no replicas to lag behind
+func (m *memoryStore) GetLatestCounter(ctx context.Context, id string) (Counter, error) {
+	return m.GetCounter(ctx, id)
+}
+
+func (m *memoryStore) IncrementCounter(ctx context.Context, id string) (Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter := m.counters[id]
+	counter.ID = id
+	counter.Count++
+	if counter.Count > counter.MaxSeen {
+		counter.MaxSeen, counter.MaxSeenAt = counter.Count, time.Now()
+	}
+	m.counters[id] = counter
+	return counter, nil
+}
+
+func (m *memoryStore) DecrementCounter(ctx context.Context, id string) (Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter := m.counters[id]
+	counter.ID = id
+	counter.Count = max(counter.Count-1, 0)
+	m.counters[id] = counter
+	return counter, nil
+}
+
+func (m *memoryStore) ResetCounters(ctx context.Context, ids []string) ([]Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var previous []Counter
+	for _, id := range ids {
+		if counter, ok := m.counters[id]; ok {
+			previous = append(previous, counter)
+			counter.Count = 0
+			m.counters[id] = counter
+		}
+	}
+	return previous, nil
+}
+
+func (m *memoryStore) ListCounters(ctx context.Context, namespace string) ([]Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := []Counter{}
+	for _, counter := range m.counters {
+		if namespace == "" || counter.Namespace == namespace {
+			counters = append(counters, counter)
+		}
+	}
+	slices.SortFunc(counters, func(a, b Counter) int { return strings.Compare(a.ID, b.ID) })
+	return counters, nil
+}
+
+func (m *memoryStore) ListNamespaces(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	namespaces := []string{}
+	for _, counter := range m.counters {
+		if counter.Namespace != "" && !slices.Contains(namespaces, counter.Namespace) {
+			namespaces = append(namespaces, counter.Namespace)
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces, nil
+}
+
+func (m *memoryStore) CreateCounter(ctx context.Context, counter Counter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.counters[counter.ID]; ok {
+		return errCounterExists
+	}
+	m.counters[counter.ID] = counter
+	return nil
+}
+
+func (m *memoryStore) AdjustCounter(ctx context.Context, id string, delta int) (Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counter, ok := m.counters[id]
+	if !ok {
+		return Counter{}, mongo.ErrNoDocuments
+	}
+	counter.Count = max(counter.Count+delta, 0)
+	if counter.Count > counter.MaxSeen {
+		counter.MaxSeen, counter.MaxSeenAt = counter.Count, time.Now()
+	}
+	m.counters[id] = counter
+	return counter, nil
+}
+
+func (m *memoryStore) MergeCounters(ctx context.Context, sources []string, dest string) (Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sum := 0
+	for _, id := range sources {
+		source, ok := m.counters[id]
+		if !ok {
+			return Counter{}, &mergeSourceMissingError{id: id}
+		}
+		sum += source.Count
+	}
+	merged, ok := m.counters[dest]
+	if !ok {
+		return Counter{}, errMergeDestMissing
+	}
+	merged.Count += sum
+	m.counters[dest] = merged
+	return merged, nil
+}
+
+func (m *memoryStore) RecordCounterEvent(ctx context.Context, event CounterEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *memoryStore) SummarizeCounterEvents(ctx context.Context, id string, since time.Time) (CounterEventSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var summary CounterEventSummary
+	for _, event := range m.events {
+		if event.CounterID != id || event.Timestamp.Before(since) {
+			continue
+		}
+		summary.Events++
+		summary.Delta += event.Delta
+		if summary.Oldest.IsZero() || event.Timestamp.Before(summary.Oldest) {
+			summary.Oldest = event.Timestamp
+		}
+	}
+	return summary, nil
+}
+
+func (m *memoryStore) TrackPageView(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pageViews[path]++
+	return nil
+}
+
+func (m *memoryStore) ListPageViews(ctx context.Context) ([]PageView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	views := []PageView{}
+	for path, count := range m.pageViews {
+		views = append(views, PageView{Path: path, Count: count})
+	}
+	slices.SortFunc(views, func(a, b PageView) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return views, nil
+}
+
+func (m *memoryStore) TotalPageViews(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	total := 0
+	for _, count := range m.pageViews {
+		total += count
+	}
+	return total, nil
+}
+
+func (m *memoryStore) SeedPageViews(ctx context.Context, path string, count int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pageViews[path]; !ok {
+		m.pageViews[path] = count
+	}
+	return nil
+}
+
+func (m *memoryStore) TrackCountryView(ctx context.Context, country string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.countries[country]++
+	return nil
+}
+
+func (m *memoryStore) ListCountryViews(ctx context.Context) ([]CountryViews, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	views := []CountryViews{}
+	for country, count := range m.countries {
+		views = append(views, CountryViews{Country: country, Views: count})
+	}
+	slices.SortFunc(views, func(a, b CountryViews) int {
+		if a.Views != b.Views {
+			return b.Views - a.Views
+		}
+		return strings.Compare(a.Country, b.Country)
+	})
+	return views, nil
+}
+
+func (m *memoryStore) ReactToQuote(ctx context.Context, reaction QuoteReaction) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.quotes, func(quote Quote) bool { return quote.ID == reaction.QuoteID })
+	if i < 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	if m.reactions[reaction.ID] {
+		return nil, errAlreadyReacted
+	}
+	m.reactions[reaction.ID] = true
+
+	// Quotes already handed out share the old map, so it's replaced rather than changed
+	reactions := maps.Clone(m.quotes[i].Reactions)
+	if reactions == nil {
+		reactions = map[string]int{}
+	}
+	reactions[reaction.Emoji]++
+	m.quotes[i].Reactions = reactions
+	return maps.Clone(reactions), nil
+}
+
+func (m *memoryStore) RecordVisitor(ctx context.Context, key string, now time.Time, window time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if expires, ok := m.visitors[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+	m.visitors[key] = now.Add(window)
+	return true, nil
+}
+
+func (m *memoryStore) CreateSession(ctx context.Context, session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[session.ID]; !ok {
+		m.sessions[session.ID] = session
+	}
+	return nil
+}
+
+func (m *memoryStore) InsertMessage(ctx context.Context, message ContactMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *memoryStore) ListMessages(ctx context.Context, unreadOnly bool) ([]ContactMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := []ContactMessage{}
+	for _, message := range slices.Backward(m.messages) {
+		if !unreadOnly || !message.Read {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (m *memoryStore) ListUndeliveredMessages(ctx context.Context) ([]ContactMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := []ContactMessage{}
+	for _, message := range m.messages {
+		if !message.Delivered {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (m *memoryStore) MarkMessageRead(ctx context.Context, id primitive.ObjectID) error {
+	return m.updateMessage(id, func(message *ContactMessage) { message.Read = true })
+}
+
+func (m *memoryStore) MarkMessageDelivered(ctx context.Context, id primitive.ObjectID) error {
+	return m.updateMessage(id, func(message *ContactMessage) { message.Delivered = true })
+}
+
+// updateMessage applies update to the contact message with the given ID
+func (m *memoryStore) updateMessage(id primitive.ObjectID, update func(*ContactMessage)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.messages {
+		if m.messages[i].ID == id {
+			update(&m.messages[i])
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (m *memoryStore) InsertAuditEntry(ctx context.Context, entry AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *memoryStore) ListAuditEntries(ctx context.Context, route string, limit int) ([]AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []AuditEntry{}
+	for _, entry := range slices.Backward(m.entries) {
+		if len(entries) == limit {
+			break
+		}
+		if route == "" || entry.Route == route {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *memoryStore) InsertAuditEvent(ctx context.Context, event AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = append(m.audit, event)
+	return nil
+}
+
+func (m *memoryStore) ListAuditEvents(ctx context.Context, action string, limit int) ([]AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []AuditEvent{}
+	for _, event := range slices.Backward(m.audit) {
+		if len(events) == limit {
+			break
+		}
+		if action == "" || event.Action == action {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
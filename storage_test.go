@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestMongoStoreReadPreference(t *testing.T) {
+	for _, mode := range []readpref.Mode{readpref.PrimaryMode, readpref.SecondaryPreferredMode, readpref.NearestMode} {
+		pref, err := readpref.New(mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Connecting doesn't wait for a server, so no MongoDB is needed
+		client, err := mongo.Connect(context.Background(), options.Client().
+			ApplyURI("mongodb://localhost:27017").SetReadPreference(pref))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Disconnect(context.Background()) })
+		db := client.Database("personal_website_test")
+
+		store := newMongoStore(db, defaultCollectionNames())
+		if got := store.reads.ReadPreference().Mode(); got != mode {
+			t.Errorf("with read preference %v, reads use %v", mode, got)
+		}
+		if got := store.db.ReadPreference().Mode(); got != readpref.PrimaryMode {
+			t.Errorf("with read preference %v, writes use %v, want primary", mode, got)
+		}
+		if store.db.Name() != db.Name() {
+			t.Errorf("writes use database %q, want %q", store.db.Name(), db.Name())
+		}
+	}
+}
+
+func TestGetLatestCounterReadsThePrimaryMongo(t *testing.T) {
+	s := newMongoTestServer(t)
+	ctx := t.Context()
+	store := newMongoStore(s.db, s.config.Collections)
+	if _, err := store.IncrementCounter(ctx, "totalClicks"); err != nil {
+		t.Fatal(err)
+	}
+	// Point the read preference's reads at an empty database, so reading through them finds nothing
+	store.reads = s.db.Client().Database(s.db.Name() + "_reads")
+
+	if counter, err := store.GetLatestCounter(ctx, "totalClicks"); err != nil || counter.Count != 1 {
+		t.Errorf("GetLatestCounter() = %+v, %v; want count 1 from the primary", counter, err)
+	}
+	if _, err := store.GetCounter(ctx, "totalClicks"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("GetCounter() error = %v, want mongo.ErrNoDocuments through the reads database", err)
+	}
+}
+
+func TestHelloResponseReplicated(t *testing.T) {
+	tests := []struct {
+		hello helloResponse
+		want  bool
+	}{
+		{helloResponse{}, false},
+		{helloResponse{SetName: "rs0"}, true},
+		{helloResponse{Msg: "isdbgrid"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.hello.replicated(); got != tt.want {
+			t.Errorf("%+v.replicated() = %v, want %v", tt.hello, got, tt.want)
+		}
+	}
+}
+
+// testDeleteQuote checks deleting quotes against s's store
+func testDeleteQuote(t *testing.T, s *Server) {
+	ctx := context.Background()
+	keep, _ := newQuote("Ada", "Keep me", "", nil)
+	remove, _ := newQuote("Alan", "Delete me", "", nil)
+	id := primitive.NewObjectID()
+	remove.ID = id
+	for _, quote := range []Quote{keep, remove} {
+		if err := s.quotes.InsertQuote(ctx, quote); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.quotes.DeleteQuote(ctx, id); err != nil {
+		t.Fatalf("DeleteQuote() = %v", err)
+	}
+	latest, err := s.quotes.LatestQuotes(ctx, 0)
+	if err != nil || len(latest) != 1 || latest[0].Quote != keep.Quote {
+		t.Errorf("quotes after deleting = %+v, %v; want only %q", latest, err, keep.Quote)
+	}
+	if err := s.quotes.DeleteQuote(ctx, id); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("deleting it again = %v, want mongo.ErrNoDocuments", err)
+	}
+}
+
+func TestDeleteQuote(t *testing.T) {
+	testDeleteQuote(t, newTestServer(t))
+}
+
+func TestDeleteQuoteMongo(t *testing.T) {
+	testDeleteQuote(t, newMongoTestServer(t))
+}
+
+// testNamedCounterStore checks creating, listing, and adjusting named counters against s's store
+func testNamedCounterStore(t *testing.T, s *Server) {
+	ctx := context.Background()
+	for _, counter := range []Counter{{ID: "likes", Namespace: "blog"}, {ID: "claps", Namespace: "blog"}, {ID: "visits"}} {
+		if err := s.counters.CreateCounter(ctx, counter); err != nil {
+			t.Fatalf("CreateCounter(%q) = %v", counter.ID, err)
+		}
+	}
+	if err := s.counters.CreateCounter(ctx, Counter{ID: "likes"}); !errors.Is(err, errCounterExists) {
+		t.Errorf("creating likes again = %v, want errCounterExists", err)
+	}
+
+	ids := func(counters []Counter) []string {
+		var ids []string
+		for _, counter := range counters {
+			ids = append(ids, counter.ID)
+		}
+		return ids
+	}
+	if all, err := s.counters.ListCounters(ctx, ""); err != nil || !slices.Equal(ids(all), []string{"claps", "likes", "visits"}) {
+		t.Errorf("ListCounters(\"\") = %v, %v; want every counter by ID", ids(all), err)
+	}
+	if blog, err := s.counters.ListCounters(ctx, "blog"); err != nil || !slices.Equal(ids(blog), []string{"claps", "likes"}) {
+		t.Errorf("ListCounters(blog) = %v, %v; want claps and likes", ids(blog), err)
+	}
+	if namespaces, err := s.counters.ListNamespaces(ctx); err != nil || !slices.Equal(namespaces, []string{"blog"}) {
+		t.Errorf("ListNamespaces() = %v, %v; want [blog]", namespaces, err)
+	}
+
+	if counter, err := s.counters.AdjustCounter(ctx, "likes", 5); err != nil || counter.Count != 5 || counter.MaxSeen != 5 {
+		t.Errorf("adding 5 = %+v, %v; want a count and high-water mark of 5", counter, err)
+	}
+	if counter, err := s.counters.AdjustCounter(ctx, "likes", -8); err != nil || counter.Count != 0 || counter.MaxSeen != 5 {
+		t.Errorf("taking 8 from 5 = %+v, %v; want 0 with the mark left at 5", counter, err)
+	}
+	if _, err := s.counters.AdjustCounter(ctx, "missing", 1); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("adjusting a missing counter = %v, want mongo.ErrNoDocuments", err)
+	}
+}
+
+func TestNamedCounterStore(t *testing.T) {
+	testNamedCounterStore(t, newTestServer(t))
+}
+
+func TestNamedCounterStoreMongo(t *testing.T) {
+	testNamedCounterStore(t, newMongoTestServer(t))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// relTime describes how long ago t was, or how long until it is, such as "5 minutes ago"
+func relTime(t time.Time) string {
+	return relTimeFrom(t, time.Now())
+}
+
+// relTimeFrom describes t relative to now
+func relTimeFrom(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	if d < time.Minute {
+		return "just now"
+	}
+
+	var n int64
+	var unit string
+	switch {
+	case d < time.Hour:
+		n, unit = int64(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int64(d/time.Hour), "hour"
+	case d < 30*24*time.Hour:
+		n, unit = int64(d/(24*time.Hour)), "day"
+	case d < 365*24*time.Hour:
+		n, unit = int64(d/(30*24*time.Hour)), "month"
+	default:
+		n, unit = int64(d/(365*24*time.Hour)), "year"
+	}
+
+	amount := fmt.Sprintf("%d %s", n, unit)
+	if n != 1 {
+		amount += "s"
+	}
+	if future {
+		return "in " + amount
+	}
+	return amount + " ago"
+}
+
+// truncate shortens s to at most n characters, ending it with an ellipsis if anything was cut.
+// It counts runes rather than bytes so multibyte text is never split mid-character.
+func truncate(n int, s string) string {
+	if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return strings.TrimRightFunc(string(runes[:n-1]), unicode.IsSpace) + "…"
+}
+
+// previewQuote shortens text to a preview of at most maxRunes characters for places with little
+// room. Whitespace is collapsed to single spaces, and a preview that's cut short ends at the last
+// whole word that fits, followed by an ellipsis. It never cuts inside a character or, as far as
+// clusterEnd can tell, an emoji or accented letter made of several code points.
+func previewQuote(text string, maxRunes int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if maxRunes <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= maxRunes {
+		return text
+	}
+
+	// Take whole clusters up to one short of the limit, leaving room for the ellipsis
+	cut, runes := 0, 0
+	for cut < len(text) {
+		end := clusterEnd(text, cut)
+		n := utf8.RuneCountInString(text[cut:end])
+		if runes+n > maxRunes-1 {
+			break
+		}
+		cut, runes = end, runes+n
+	}
+
+	// Back up to the end of the last whole word, unless the first word alone is too long
+	preview := text[:cut]
+	if cut < len(text) && text[cut] != ' ' {
+		if space := strings.LastIndexByte(preview, ' '); space > 0 {
+			preview = preview[:space]
+		}
+	}
+	if trimmed := strings.TrimRightFunc(preview, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}); trimmed != "" {
+		preview = trimmed
+	}
+	return preview + "…"
+}
+
+// clusterEnd returns the index just past the user-perceived character starting at index i of s.
+// It approximates Unicode's grapheme clusters closely enough for previews, keeping combining
+// marks, variation selectors, skin tones, and emoji tags with what they modify, emoji joined
+// with zero width joiners together, and flags' pairs of regional indicators together.
+func clusterEnd(s string, i int) int {
+	r, size := utf8.DecodeRuneInString(s[i:])
+	i += size
+	if isRegionalIndicator(r) {
+		if next, size := utf8.DecodeRuneInString(s[i:]); isRegionalIndicator(next) {
+			i += size
+		}
+		return i
+	}
+
+	for i < len(s) {
+		next, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case next == zeroWidthJoiner:
+			// The joiner and the emoji after it are part of this character
+			i += size
+			if i < len(s) {
+				_, size = utf8.DecodeRuneInString(s[i:])
+				i += size
+			}
+		case unicode.In(next, unicode.Mn, unicode.Me, unicode.Mc),
+			next >= 0x1F3FB && next <= 0x1F3FF, // skin tone modifiers
+			next >= 0xE0020 && next <= 0xE007F: // emoji tags, as in subdivision flags
+			i += size
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// zeroWidthJoiner joins emoji into one, such as 👩 and 💻 into 👩‍💻
+const zeroWidthJoiner = '\u200d'
+
+// isRegionalIndicator reports whether r is one of the letters a flag emoji is written with
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// pluralize returns singular if count is one and plural otherwise
+func pluralize(count any, singular, plural string) (string, error) {
+	n, err := templateInt(count)
+	if err != nil {
+		return "", err
+	}
+	if n == 1 {
+		return singular, nil
+	}
+	return plural, nil
+}
+
+// commaNumber formats an integer with commas between each group of thousands
+func commaNumber(number any) (string, error) {
+	n, err := templateInt(number)
+	if err != nil {
+		return "", err
+	}
+
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String(), nil
+}
+
+// templateInt converts any signed integer a template passes in, which may be an int or an
+// int64 depending on the field, to an int64
+func templateInt(v any) (int64, error) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), nil
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", v)
+}
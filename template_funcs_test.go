@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRelTimeFrom(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{-30 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{45 * time.Minute, "45 minutes ago"},
+		{time.Hour, "1 hour ago"},
+		{23 * time.Hour, "23 hours ago"},
+		{24 * time.Hour, "1 day ago"},
+		{29 * 24 * time.Hour, "29 days ago"},
+		{60 * 24 * time.Hour, "2 months ago"},
+		{365 * 24 * time.Hour, "1 year ago"},
+		{100 * 365 * 24 * time.Hour, "100 years ago"},
+		{-2 * time.Hour, "in 2 hours"},
+		{-24 * time.Hour, "in 1 day"},
+	}
+	for _, tt := range tests {
+		if got := relTimeFrom(now.Add(-tt.ago), now); got != tt.want {
+			t.Errorf("relTimeFrom(now - %v) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n    int
+		s    string
+		want string
+	}{
+		{10, "short", "short"},
+		{5, "exact", "exact"},
+		{5, "too long", "too…"},
+		{0, "anything", ""},
+		{-1, "anything", ""},
+		{3, "", ""},
+		{4, "héllo wörld", "hél…"},
+		{3, "日本語テキスト", "日本…"},
+		{2, "👍👍👍", "👍…"},
+		{1, "abc", "…"},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.n, tt.s)
+		if got != tt.want {
+			t.Errorf("truncate(%d, %q) = %q, want %q", tt.n, tt.s, got, tt.want)
+		}
+		if tt.n > 0 && len([]rune(got)) > tt.n {
+			t.Errorf("truncate(%d, %q) = %q, longer than %d characters", tt.n, tt.s, got, tt.n)
+		}
+	}
+}
+
+func TestPreviewQuote(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxRunes int
+		text     string
+		want     string
+	}{
+		{"fits", 20, "Short and sweet", "Short and sweet"},
+		{"cut at a word", 15, "The quick brown fox jumps", "The quick…"},
+		{"collapses whitespace", 12, "  Spread\n\tout   words here ", "Spread out…"},
+		{"drops trailing punctuation", 10, "Wait, what is this", "Wait…"},
+		{"one long word", 6, "Supercalifragilistic", "Super…"},
+		{"zero", 0, "anything", ""},
+		{"empty", 3, "", ""},
+		{"only room for the ellipsis", 1, "abc", "…"},
+		{"accented", 8, "Café crème brûlée", "Café…"},
+		{"combining accent", 5, "Cafe\u0301 ok", "Caf…"},
+		{"CJK", 4, "日本語のテキスト", "日本語…"},
+		{"emoji", 3, "👍👍👍👍", "👍👍…"},
+		{"emoji between words", 12, "Great talk 🎉🎉 indeed", "Great talk…"},
+		{"joined emoji", 7, "👩\u200d💻👩\u200d💻👩\u200d💻", "👩\u200d💻👩\u200d💻…"},
+		{"joined emoji without room", 3, "👩\u200d💻👩\u200d💻", "…"},
+		{"flags", 4, "🇺🇸🇩🇪🇯🇵", "🇺🇸…"},
+		{"skin tones", 4, "👍🏽👍🏽👍🏽", "👍🏽…"},
+		{"keycaps", 5, "1\ufe0f\u20e32\ufe0f\u20e3", "1\ufe0f\u20e3…"},
+	}
+	for _, tt := range tests {
+		got := previewQuote(tt.text, tt.maxRunes)
+		if got != tt.want {
+			t.Errorf("%s: previewQuote(%q, %d) = %q, want %q", tt.name, tt.text, tt.maxRunes, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: previewQuote(%q, %d) = %q, which isn't valid UTF-8", tt.name, tt.text, tt.maxRunes, got)
+		}
+		if n := utf8.RuneCountInString(got); n > max(tt.maxRunes, 0) {
+			t.Errorf("%s: previewQuote(%q, %d) = %q, %d characters long", tt.name, tt.text, tt.maxRunes, got, n)
+		}
+		// Only a cut-short preview ends with an ellipsis, and what comes before it is whole
+		// characters from the start of the text
+		if body, cut := strings.CutSuffix(got, "…"); cut {
+			collapsed := strings.Join(strings.Fields(tt.text), " ")
+			end := 0
+			for end < len(body) {
+				end = clusterEnd(collapsed, end)
+			}
+			if !strings.HasPrefix(collapsed, body) || end != len(body) || collapsed == body {
+				t.Errorf("%s: previewQuote(%q, %d) = %q, which isn't a shortened start of the text", tt.name, tt.text, tt.maxRunes, got)
+			}
+		}
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		count any
+		want  string
+	}{
+		{0, "stars"},
+		{1, "star"},
+		{int64(1), "star"},
+		{2, "stars"},
+		{-1, "stars"},
+		{int64(math.MaxInt64), "stars"},
+	}
+	for _, tt := range tests {
+		got, err := pluralize(tt.count, "star", "stars")
+		if err != nil || got != tt.want {
+			t.Errorf("pluralize(%v) = %q, %v; want %q", tt.count, got, err, tt.want)
+		}
+	}
+
+	if _, err := pluralize("1", "star", "stars"); err == nil {
+		t.Error(`pluralize("1") = nil error, want one for a non-integer`)
+	}
+}
+
+func TestCommaNumber(t *testing.T) {
+	tests := []struct {
+		n    any
+		want string
+	}{
+		{0, "0"},
+		{7, "7"},
+		{999, "999"},
+		{1000, "1,000"},
+		{123456, "123,456"},
+		{1234567, "1,234,567"},
+		{-1, "-1"},
+		{-1000, "-1,000"},
+		{-123456, "-123,456"},
+		{int64(math.MaxInt64), "9,223,372,036,854,775,807"},
+		{int64(math.MinInt64), "-9,223,372,036,854,775,808"},
+		{int32(2048), "2,048"},
+	}
+	for _, tt := range tests {
+		got, err := commaNumber(tt.n)
+		if err != nil || got != tt.want {
+			t.Errorf("commaNumber(%v) = %q, %v; want %q", tt.n, got, err, tt.want)
+		}
+	}
+
+	if _, err := commaNumber(1.5); err == nil {
+		t.Error("commaNumber(1.5) = nil error, want one for a non-integer")
+	}
+}
+
+func TestTemplateFuncsInTemplates(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(templateFuncs).Parse(
+		`{{commaNumber .Count}} {{pluralize .Count "click" "clicks"}}, {{truncate 6 .Text}}, {{previewQuote .Text 12}}`))
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, struct {
+		Count int64
+		Text  string
+	}{1500, "long description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "1,500 clicks, long…, long…"; got != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+}
+
+func TestHomePageFormatsCounts(t *testing.T) {
+	setGitHubRepos(t, []GitHubRepo{{Name: "site", StargazersCount: 1}, {Name: "notes", StargazersCount: 2500}})
+	s := newTestServer(t)
+	for range 1234 {
+		if _, err := s.counters.IncrementCounter(context.Background(), "webhook"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.homeHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := w.Body.String()
+	for _, want := range []string{`<strong id="counter">1,234</strong>`, "1 star", "2,500 stars"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("home page doesn't contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// themeCookie holds the color theme a visitor picked
+const themeCookie = "theme"
+
+// themeCookieLifetime is how long a picked theme is remembered
+const themeCookieLifetime = 365 * 24 * time.Hour
+
+// colorSchemeHint is the client hint browsers send with the visitor's preferred color scheme
+const colorSchemeHint = "Sec-CH-Prefers-Color-Scheme"
+
+const (
+	themeLight = "light"
+	themeDark  = "dark"
+	// themeAuto leaves the theme to the stylesheet's prefers-color-scheme media query
+	themeAuto = ""
+)
+
+// validTheme reports whether theme is one the site renders
+func validTheme(theme string) bool {
+	return theme == themeLight || theme == themeDark
+}
+
+// requestTheme returns the theme to render a page in: the one the visitor picked, or otherwise
+// the color scheme their browser says it prefers. Browsers that don't send the hint get
+// themeAuto, so their own preference still applies through the stylesheet.
+func requestTheme(r *http.Request) string {
+	if cookie, err := r.Cookie(themeCookie); err == nil && validTheme(cookie.Value) {
+		return cookie.Value
+	}
+	// Structured header strings are quoted, e.g. "dark"
+	if hint := strings.Trim(r.Header.Get(colorSchemeHint), `"`); validTheme(hint) {
+		return hint
+	}
+	return themeAuto
+}
+
+// setThemeHeaders asks the browser to send its color scheme preference with later requests,
+// and tells caches that pages rendered from it differ by that preference and by the theme cookie
+func setThemeHeaders(w http.ResponseWriter) {
+	w.Header().Set("Accept-CH", colorSchemeHint)
+	w.Header().Add("Vary", colorSchemeHint)
+	w.Header().Add("Vary", "Cookie")
+}
+
+// preferencesHandler saves the theme picked on a page and sends the visitor back to it
+func (s *Server) preferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Error parsing form")
+		return
+	}
+	theme := r.FormValue("theme")
+	if !validTheme(theme) {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid theme, expected light or dark")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    theme,
+		Path:     "/",
+		MaxAge:   int(themeCookieLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, refererPath(r), http.StatusSeeOther)
+}
+
+// refererPath returns the path of the page on this site that sent r, or "/" if the referrer
+// is missing or another site, so the redirect can't be used to send visitors elsewhere
+func refererPath(r *http.Request) string {
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host != r.Host || !strings.HasPrefix(referer.Path, "/") || strings.HasPrefix(referer.Path, "//") {
+		return "/"
+	}
+	target := &url.URL{Path: referer.Path, RawQuery: referer.RawQuery, Fragment: referer.Fragment}
+	return target.String()
+}
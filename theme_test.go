@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestTheme(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		hint   string
+		want   string
+	}{
+		{name: "nothing", want: themeAuto},
+		{name: "hint", hint: `"dark"`, want: themeDark},
+		{name: "unquoted hint", hint: "dark", want: themeDark},
+		{name: "cookie over hint", cookie: themeLight, hint: `"dark"`, want: themeLight},
+		{name: "dark cookie", cookie: themeDark, want: themeDark},
+		{name: "unknown cookie", cookie: "purple", hint: `"dark"`, want: themeDark},
+		{name: "unknown hint", hint: `"sepia"`, want: themeAuto},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: themeCookie, Value: tt.cookie})
+		}
+		if tt.hint != "" {
+			r.Header.Set(colorSchemeHint, tt.hint)
+		}
+		if got := requestTheme(r); got != tt.want {
+			t.Errorf("%s: requestTheme() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHomePageRendersTheme(t *testing.T) {
+	setGitHubRepos(t, nil)
+	h := newTestServer(t).routes()
+
+	// The light page is cached first, so the others must be cached separately
+	tests := []struct {
+		name, hint, body string
+	}{
+		{"light", `"light"`, `<body class="light">`},
+		{"dark", `"dark"`, `<body class="dark">`},
+		{"no hint", "", "<body>"},
+	}
+	for _, tt := range tests {
+		r := newJSONRequest(http.MethodGet, "/", "")
+		if tt.hint != "" {
+			r.Header.Set(colorSchemeHint, tt.hint)
+		}
+		w := serve(h, r)
+		if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("home page with %s doesn't render %s", tt.name, tt.body)
+		}
+		if got := w.Header().Get("Accept-CH"); got != colorSchemeHint {
+			t.Errorf("Accept-CH = %q, want %q", got, colorSchemeHint)
+		}
+		vary := strings.Join(w.Header().Values("Vary"), ",")
+		if !strings.Contains(vary, colorSchemeHint) || !strings.Contains(vary, "Cookie") {
+			t.Errorf("Vary = %q, want it to include %s and Cookie", vary, colorSchemeHint)
+		}
+	}
+
+	// Without a theme, the browser's own preference picks the dark styles
+	w := serve(h, newJSONRequest(http.MethodGet, "/", ""))
+	if !strings.Contains(w.Body.String(), "@media (prefers-color-scheme: dark)") {
+		t.Error("home page has no prefers-color-scheme fallback for browsers without a theme")
+	}
+}
+
+func TestPreferencesHandler(t *testing.T) {
+	h := newTestServer(t).routes()
+
+	tests := []struct {
+		name     string
+		referer  string
+		location string
+	}{
+		{name: "same site", referer: "http://example.com/quotes?page=2#top", location: "/quotes?page=2#top"},
+		{name: "no referrer", location: "/"},
+		{name: "other site", referer: "https://evil.example/phish", location: "/"},
+		{name: "protocol relative", referer: "http://example.com//evil.example/", location: "/"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/preferences", strings.NewReader("theme=dark"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.referer != "" {
+			r.Header.Set("Referer", tt.referer)
+		}
+		w := serve(h, r)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("%s: status = %d, want %d", tt.name, w.Code, http.StatusSeeOther)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: redirected to %q, want %q", tt.name, got, tt.location)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != themeCookie || cookies[0].Value != themeDark {
+			t.Errorf("%s: set cookies %v, want theme=dark", tt.name, cookies)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/preferences", strings.NewReader("theme=purple"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(h, r); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown theme: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxQuoteUploadBytes is the largest quote submission accepted with an image attached
+	maxQuoteUploadBytes = 10 << 20 // 10MB
+	// maxImageBytes is the largest image that can be attached to a quote
+	maxImageBytes = 5 << 20 // 5MB
+	// maxImageWidth and maxImageHeight are the size attached images are scaled down to fit
+	maxImageWidth  = 800
+	maxImageHeight = 600
+	// maxImagePixels is the most pixels an attached image may have before scaling, so a small
+	// file that decodes to a huge image can't exhaust memory
+	maxImagePixels = 25_000_000
+	// maxUploadNameLength is the longest part of an uploaded file's name kept in its saved name
+	maxUploadNameLength = 40
+	// jpegQuality is the quality attached JPEGs are saved at
+	jpegQuality = 85
+)
+
+var (
+	errUploadsDisabled      = errors.New("image uploads aren't enabled")
+	errImageTooLarge        = errors.New("image is larger than 5MB")
+	errUnsupportedImageType = errors.New("image must be a JPEG or PNG")
+	errInvalidImage         = errors.New("image can't be read")
+)
+
+// imageFormats are the attached image types accepted, by sniffed MIME type, with the extension
+// they're saved with
+var imageFormats = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// saveQuoteImage checks the image attached to a quote, scales it down to fit within
+// maxImageWidth×maxImageHeight, and saves it to the upload directory, returning the path it's served from.
+// The type is sniffed from the file's content rather than trusted from the upload. Re-encoding
+// also drops metadata such as where a photo was taken.
+func (s *Server) saveQuoteImage(file multipart.File, header *multipart.FileHeader) (string, error) {
+	if s.config.UploadDir == "" {
+		return "", errUploadsDisabled
+	}
+	if header.Size > maxImageBytes {
+		return "", errImageTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxImageBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxImageBytes {
+		return "", errImageTooLarge
+	}
+	ext, ok := imageFormats[http.DetectContentType(data)]
+	if !ok {
+		return "", errUnsupportedImageType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > maxImagePixels {
+		return "", errInvalidImage
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", errInvalidImage
+	}
+	img = resizeToFit(img, maxImageWidth, maxImageHeight)
+
+	if err := os.MkdirAll(s.config.UploadDir, 0o755); err != nil {
+		return "", err
+	}
+	name := newUploadName(header.Filename, ext)
+	out, err := os.OpenFile(filepath.Join(s.config.UploadDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if ext == ".png" {
+		err = png.Encode(out, img)
+	} else {
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return "/uploads/" + name, nil
+}
+
+// removeQuoteImage deletes an image saved by saveQuoteImage, for when its quote couldn't be saved
+func (s *Server) removeQuoteImage(imagePath string) {
+	if imagePath == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(s.config.UploadDir, path.Base(imagePath))); err != nil {
+		componentLogger("uploads").Warn("removing unused image", "path", imagePath, "err", err)
+	}
+}
+
+// newUploadName returns a unique name to save an upload as, made of a random ID and what's left
+// of the uploaded file's name after sanitizeUploadName, with ext
+func newUploadName(filename, ext string) string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	name := hex.EncodeToString(id)
+	if base := sanitizeUploadName(filename); base != "" {
+		name += "-" + base
+	}
+	return name + ext
+}
+
+// sanitizeUploadName reduces an uploaded file's name to lowercase letters, digits, hyphens, and
+// underscores, without its directory or extension, so it can't point outside the upload
+// directory or be mistaken for another type of file
+func sanitizeUploadName(filename string) string {
+	// Browsers on Windows may send the full path with backslashes
+	filename = path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	filename = strings.TrimSuffix(filename, path.Ext(filename))
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(filename) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '.':
+			b.WriteRune('-')
+		}
+		if b.Len() == maxUploadNameLength {
+			break
+		}
+	}
+	return strings.Trim(b.String(), "-_")
+}
+
+// resizeToFit scales img down to fit within maxWidth×maxHeight, keeping its aspect ratio, by
+// averaging the pixels each pixel of the result covers. An image that already fits is returned
+// unchanged.
+func resizeToFit(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return img
+	}
+
+	scale := min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	newWidth, newHeight := max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+	resized := image.NewRGBA64(image.Rect(0, 0, newWidth, newHeight))
+	for y := range newHeight {
+		y0, y1 := bounds.Min.Y+y*height/newHeight, bounds.Min.Y+max((y+1)*height/newHeight, y*height/newHeight+1)
+		for x := range newWidth {
+			x0, x1 := bounds.Min.X+x*width/newWidth, bounds.Min.X+max((x+1)*width/newWidth, x*width/newWidth+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			resized.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return resized
+}
+
+// uploadHandler serves an image attached to a quote. Saved names are random, so a file's content
+// never changes and it can be cached indefinitely.
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if s.config.UploadDir == "" || !validUploadName(name) {
+		s.respondError(w, r, http.StatusNotFound, "Image not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFileFS(w, r, os.DirFS(s.config.UploadDir), name)
+}
+
+// validUploadName reports whether name could be a file saved by saveQuoteImage
+func validUploadName(name string) bool {
+	ext := path.Ext(name)
+	if ext != ".jpg" && ext != ".png" {
+		return false
+	}
+	base := strings.TrimSuffix(name, ext)
+	return base != "" && strings.IndexFunc(base, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) == -1
+}
+
+// respondImageError responds to a quote whose attached image couldn't be saved
+func (s *Server) respondImageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUploadsDisabled):
+		s.respondError(w, r, http.StatusBadRequest, "Images can't be attached to quotes")
+	case errors.Is(err, errImageTooLarge):
+		s.respondError(w, r, http.StatusRequestEntityTooLarge, "Image must be 5MB or smaller")
+	case errors.Is(err, errUnsupportedImageType):
+		s.respondError(w, r, http.StatusUnsupportedMediaType, "Image must be a JPEG or PNG")
+	case errors.Is(err, errInvalidImage):
+		s.respondError(w, r, http.StatusBadRequest, "Image can't be read")
+	default:
+		s.log(r.Context(), "uploads").Error("saving quote image", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error saving image")
+	}
+}
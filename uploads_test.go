@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setUploadDir has s save attached images to a temporary directory, which it returns
+func setUploadDir(t *testing.T, s *Server) string {
+	t.Helper()
+	s.config.UploadDir = t.TempDir()
+	return s.config.UploadDir
+}
+
+// encodeTestImage returns a width×height image in format, "jpeg", "png", or "gif"
+func encodeTestImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newQuoteUpload builds a multipart quote submission with image attached as filename
+func newQuoteUpload(t *testing.T, text, filename string, image []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "Ada")
+	mw.WriteField("quote", text)
+	if image != nil {
+		part, err := mw.CreateFormFile("image", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(image)
+	}
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/quote", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Accept", "application/json")
+	return r
+}
+
+func TestQuoteWithImage(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	dir := setUploadDir(t, s)
+	h := s.routes()
+
+	tests := []struct {
+		format                   string
+		width, height            int
+		wantWidth, wantHeight    int
+		filename, wantNameSuffix string
+	}{
+		{"png", 1600, 1200, 800, 600, "../../etc/Photo 1.png", "-photo-1.png"},
+		{"jpeg", 1000, 2000, 300, 600, `C:\Users\ada\talk.JPG`, "-talk.jpg"},
+		{"jpeg", 640, 480, 640, 480, "small.jpeg", "-small.jpg"},
+	}
+	for i, tt := range tests {
+		r := newQuoteUpload(t, fmt.Sprintf("Quote with a %s", tt.filename), tt.filename, encodeTestImage(t, tt.format, tt.width, tt.height))
+		r.RemoteAddr = fmt.Sprintf("203.0.113.%d:1000", 60+i)
+		w := serve(h, r)
+		var quote Quote
+		if err := json.NewDecoder(w.Body).Decode(&quote); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("%s: status = %d (%v), want %d", tt.filename, w.Code, err, http.StatusCreated)
+		}
+
+		// The image is saved under a generated name inside the upload directory
+		name := path.Base(quote.ImagePath)
+		if quote.ImagePath != "/uploads/"+name || !strings.HasSuffix(name, tt.wantNameSuffix) {
+			t.Errorf("%s: ImagePath = %q, want /uploads/<id>%s", tt.filename, quote.ImagePath, tt.wantNameSuffix)
+		}
+		saved, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: reading saved image: %v", tt.filename, err)
+		}
+		config, format, err := image.DecodeConfig(bytes.NewReader(saved))
+		if err != nil || format != tt.format || config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+			t.Errorf("%s: saved a %s %d×%d (%v), want a %s %d×%d", tt.filename, format, config.Width, config.Height, err, tt.format, tt.wantWidth, tt.wantHeight)
+		}
+
+		// And served from ImagePath
+		w = serveRequest(h, http.MethodGet, quote.ImagePath, "", "")
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), saved) {
+			t.Errorf("%s: GET %s = %d, want the saved image", tt.filename, quote.ImagePath, w.Code)
+		}
+	}
+}
+
+func TestQuoteImageIsValidated(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	dir := setUploadDir(t, s)
+	h := s.routes()
+
+	oversized := append(encodeTestImage(t, "png", 10, 10), make([]byte, maxImageBytes)...)
+	tests := []struct {
+		name     string
+		filename string
+		image    []byte
+		status   int
+	}{
+		{"GIF", "cat.gif", encodeTestImage(t, "gif", 10, 10), http.StatusUnsupportedMediaType},
+		{"script named like an image", "cat.png", []byte("<script>alert(1)</script>"), http.StatusUnsupportedMediaType},
+		{"truncated PNG", "cat.png", encodeTestImage(t, "png", 10, 10)[:40], http.StatusBadRequest},
+		{"over 5MB", "cat.png", oversized, http.StatusRequestEntityTooLarge},
+	}
+	for i, tt := range tests {
+		r := newQuoteUpload(t, "Quote "+tt.name, tt.filename, tt.image)
+		r.RemoteAddr = fmt.Sprintf("203.0.113.%d:1000", 70+i)
+		if w := serve(h, r); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+
+	// Nothing was saved, neither the images nor their quotes
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("upload directory has %d files, want none", len(entries))
+	}
+	if count, _ := s.quotes.CountQuotes(t.Context()); count != 0 {
+		t.Errorf("%d quotes saved, want none", count)
+	}
+}
+
+func TestQuoteImageNeedsUploadDir(t *testing.T) {
+	resetRateLimiters(t)
+	s := newTestServer(t)
+	h := s.routes()
+
+	w := serve(h, newQuoteUpload(t, "Quote with an image", "photo.png", encodeTestImage(t, "png", 10, 10)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// A multipart quote without an image is still accepted
+	r := newQuoteUpload(t, "Quote without an image", "", nil)
+	r.RemoteAddr = "203.0.113.80:1000"
+	if w := serve(h, r); w.Code != http.StatusCreated {
+		t.Errorf("multipart quote without an image status = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestSanitizeUploadName(t *testing.T) {
+	tests := []struct {
+		filename, want string
+	}{
+		{"photo.jpg", "photo"},
+		{"My Photo.PNG", "my-photo"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\windows\system.ini`, "system"},
+		{"archive.tar.gz", "archive-tar"},
+		{"café crème.jpg", "caf-crme"},
+		{"...", ""},
+		{"", ""},
+		{"/", ""},
+		{strings.Repeat("a", 100) + ".jpg", strings.Repeat("a", maxUploadNameLength)},
+	}
+	for _, tt := range tests {
+		got := sanitizeUploadName(tt.filename)
+		if got != tt.want {
+			t.Errorf("sanitizeUploadName(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+		if !validUploadName("0123456789abcdef-" + got + ".jpg") {
+			t.Errorf("sanitizeUploadName(%q) = %q, which isn't valid in a saved name", tt.filename, got)
+		}
+	}
+}
+
+func TestUploadsOutsideTheDirectoryAreNotServed(t *testing.T) {
+	s := newTestServer(t)
+	dir := setUploadDir(t, s)
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "secret.png"), []byte("secret"), 0o644)
+	h := s.routes()
+
+	for _, target := range []string{"/uploads/..%2Fsecret.png", "/uploads/secret.txt", "/uploads/missing.png"} {
+		if w := serveRequest(h, http.MethodGet, target, "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// velocityWindow is how far back counter events are counted when computing a counter's velocity
+const velocityWindow = 5 * time.Minute
+
+// velocityCacheTTL is how long a computed velocity is reused before aggregating the events again
+const velocityCacheTTL = 5 * time.Second
+
+// counterEventRetention is how long counter events are kept: the forecastHistory forecasts
+// average over, with an hour's room for slow clocks. Velocities only use the last velocityWindow.
+const counterEventRetention = forecastHistory + time.Hour
+
+// CounterEvent records one change to a counter
+type CounterEvent struct {
+	CounterID string    `bson:"counterId"`
+	Delta     int       `bson:"delta"`
+	Timestamp time.Time `bson:"timestamp"`
+}
+
+// CounterEventSummary totals a counter's events over a period
+type CounterEventSummary struct {
+	Events int `bson:"events"`
+	Delta  int `bson:"delta"`
+	// Oldest is the time of the earliest event, or zero if there were none
+	Oldest time.Time `bson:"oldest"`
+}
+
+// recordCounterEvent notes a change to a counter for its velocity. Failures are only logged,
+// since the change itself has already been made.
+func (s *Server) recordCounterEvent(ctx context.Context, id string, delta int) {
+	event := CounterEvent{CounterID: id, Delta: delta, Timestamp: time.Now()}
+	if err := s.counters.RecordCounterEvent(ctx, event); err != nil {
+		s.dbError(ctx, "counter", "record counter event", err)
+	}
+}
+
+// VelocityResponse is how fast a counter has been changing recently
+type VelocityResponse struct {
+	CounterID       string    `json:"counterId"`
+	EventsPerMinute float64   `json:"eventsPerMinute"`
+	DeltaPerMinute  float64   `json:"deltaPerMinute"`
+	ComputedAt      time.Time `json:"computedAt"`
+	// Partial is set when the counter's events all fall within the last minute, so the rates
+	// are what that minute has seen rather than an average over the whole window
+	Partial bool `json:"partial,omitempty"`
+}
+
+// velocityCache holds recently computed velocities by counter ID
+type velocityCache struct {
+	mu         sync.Mutex
+	velocities map[string]VelocityResponse
+}
+
+// counterVelocity returns the per-minute rate of a counter's changes over the last
+// velocityWindow, reusing a velocity computed within velocityCacheTTL
+func (s *Server) counterVelocity(ctx context.Context, id string) (VelocityResponse, error) {
+	// Requests arriving while the events are totalled wait for that result rather than repeating it
+	s.velocities.mu.Lock()
+	defer s.velocities.mu.Unlock()
+
+	if velocity, ok := s.velocities.velocities[id]; ok && time.Since(velocity.ComputedAt) < velocityCacheTTL {
+		return velocity, nil
+	}
+
+	now := time.Now()
+	summary, err := s.counters.SummarizeCounterEvents(ctx, id, now.Add(-velocityWindow))
+	if err != nil {
+		return VelocityResponse{}, err
+	}
+
+	minutes := velocityWindow.Minutes()
+	partial := summary.Events > 0 && now.Sub(summary.Oldest) < time.Minute
+	if partial {
+		minutes = 1
+	}
+	velocity := VelocityResponse{
+		CounterID:       id,
+		EventsPerMinute: float64(summary.Events) / minutes,
+		DeltaPerMinute:  float64(summary.Delta) / minutes,
+		ComputedAt:      now,
+		Partial:         partial,
+	}
+
+	if s.velocities.velocities == nil {
+		s.velocities.velocities = make(map[string]VelocityResponse)
+	}
+	s.velocities.velocities[id] = velocity
+	return velocity, nil
+}
+
+// broadcastVelocity returns the webhook counter's change per minute for a broadcast, or nil if
+// it can't be computed
+func (s *Server) broadcastVelocity(ctx context.Context) *float64 {
+	velocity, err := s.counterVelocity(ctx, "webhook")
+	if err != nil {
+		s.dbError(ctx, "counter", "get webhook velocity", err)
+		return nil
+	}
+	return &velocity.DeltaPerMinute
+}
+
+// counterVelocityHandler returns how fast a counter has been changing over the last few minutes
+func (s *Server) counterVelocityHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := normalizeCounterName(r.PathValue("name"))
+	if err != nil {
+		s.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.readContext(r)
+	defer cancel()
+
+	_, err = s.counters.GetCounter(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) || s.config.Counting.hides(name) {
+		s.apiError(w, r, http.StatusNotFound, "Counter not found")
+		return
+	}
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "get counter", err), "Error getting counter")
+		return
+	}
+
+	velocity, err := s.counterVelocity(ctx, name)
+	if err != nil {
+		s.apiError(w, r, s.dbError(r.Context(), "counter", "get counter velocity", err), "Error getting counter velocity")
+		return
+	}
+	respondJSON(w, http.StatusOK, velocity)
+}
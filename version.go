@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Any left unset are filled in from what the Go toolchain recorded in the binary.
+var (
+	version   string
+	commit    string
+	buildTime string
+)
+
+// shortCommitLength is how many characters of the commit are shown in the footer
+const shortCommitLength = 7
+
+// SetVersion sets the version reported for this build
+func SetVersion(v string) { version = v }
+
+// SetCommit sets the commit reported for this build
+func SetCommit(c string) { commit = c }
+
+// SetBuildTime sets when this build was made, as reported
+func SetBuildTime(t string) { buildTime = t }
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// ShortCommit returns the abbreviated commit, or "" if it isn't known
+func (b BuildInfo) ShortCommit() string {
+	if len(b.Commit) > shortCommitLength {
+		return b.Commit[:shortCommitLength]
+	}
+	return b.Commit
+}
+
+// recordedBuildInfo reads the build information the Go toolchain embedded in the binary once
+var recordedBuildInfo = sync.OnceValue(func() BuildInfo {
+	info := BuildInfo{GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		}
+	}
+	return info
+})
+
+// currentBuildInfo returns the build information set with -ldflags, falling back to what the
+// toolchain recorded for anything left unset. A build with no version at all is "dev".
+func currentBuildInfo() BuildInfo {
+	info := recordedBuildInfo()
+	if version != "" {
+		info.Version = version
+	}
+	if commit != "" {
+		info.Commit = commit
+	}
+	if buildTime != "" {
+		info.BuildTime = buildTime
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// versionHandler returns the running build's version, commit, build time, and Go version
+func (s *Server) versionHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, currentBuildInfo())
+}
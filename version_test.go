@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// setBuildInfo sets the build information as -ldflags would for the rest of the test
+func setBuildInfo(t *testing.T, v, c, built string) {
+	t.Helper()
+	previousVersion, previousCommit, previousBuildTime := version, commit, buildTime
+	SetVersion(v)
+	SetCommit(c)
+	SetBuildTime(built)
+	t.Cleanup(func() {
+		SetVersion(previousVersion)
+		SetCommit(previousCommit)
+		SetBuildTime(previousBuildTime)
+	})
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	setBuildInfo(t, "v1.4.0", "0123456789abcdef0123456789abcdef01234567", "2026-10-17T12:00:00Z")
+	h := newTestServer(t).routes()
+
+	for _, target := range []string{"/api/version", "/api/v1/version"} {
+		w := serveRequest(h, http.MethodGet, target, "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+		var got BuildInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		want := BuildInfo{
+			Version:   "v1.4.0",
+			Commit:    "0123456789abcdef0123456789abcdef01234567",
+			BuildTime: "2026-10-17T12:00:00Z",
+			GoVersion: runtime.Version(),
+		}
+		if got != want {
+			t.Errorf("GET %s = %+v, want %+v", target, got, want)
+		}
+	}
+}
+
+func TestBuildInfoWithoutLDFlags(t *testing.T) {
+	setBuildInfo(t, "", "", "")
+	info := currentBuildInfo()
+	if info.Version == "" {
+		t.Error("Version is empty without -ldflags, want the recorded version or dev")
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("GoVersion = %q, want %q", info.GoVersion, runtime.Version())
+	}
+}
+
+func TestBuildInfoInFooterAndHealth(t *testing.T) {
+	setGitHubRepos(t, nil)
+	setBuildInfo(t, "v1.4.0", "0123456789abcdef", "")
+	h := newTestServer(t).routes()
+
+	w := serveRequest(h, http.MethodGet, "/", "", "")
+	if !strings.Contains(w.Body.String(), "<code>0123456</code>") {
+		t.Error("home page footer doesn't show the short commit")
+	}
+
+	var health HealthStatus
+	if err := json.Unmarshal(serveRequest(h, http.MethodGet, "/healthz", "", "").Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Version != "v1.4.0" || health.Commit != "0123456789abcdef" {
+		t.Errorf("healthz version = %q, commit = %q; want v1.4.0 and the full commit", health.Version, health.Commit)
+	}
+}
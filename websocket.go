@@ -2,115 +2,719 @@ package main
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
-	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/time/rate"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
+// newUpgrader returns the WebSocket upgrader with the configured buffer sizes and optional
+// permessage-deflate compression. Counter updates are small, so modest buffers are enough, and
+// compression shrinks the JSON frames for clients that support it.
+func newUpgrader(config Config) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:    config.WSReadBufferSize,
+		WriteBufferSize:   config.WSWriteBufferSize,
+		EnableCompression: config.WSEnableCompression,
+		CheckOrigin: func(r *http.Request) bool {
+			return true // Allow all origins for development
+		},
+	}
 }
 
-// Hub maintains active WebSocket connections and broadcasts messages
+// Hub maintains active WebSocket connections and broadcasts messages. It only queues messages
+// for each client while holding mu; every client's own goroutine writes them to its socket.
+// Counter updates also go to the event stream subscribers, which are channels read by
+// counterStreamHandler.
 type Hub struct {
-	clients    map[*websocket.Conn]bool
-	broadcast  chan CounterUpdate
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
-	mu         sync.Mutex
+	clients map[*websocket.Conn]*wsClient
+	streams map[chan CounterUpdate]struct{} // event stream subscribers, guarded by mu
+	// clientCount mirrors len(clients) so it can be read without waiting on mu
+	clientCount atomic.Int64
+	broadcast   chan CounterUpdate
+	done        chan struct{} // closed when the hub starts shutting down
+	stopped     chan struct{} // closed when Run returns
+	stopOnce    sync.Once
+	writers     sync.WaitGroup // running client writers
+	mu          sync.Mutex
+	seq         uint64 // sequence number of the last broadcast, guarded by mu
+	logger      *slog.Logger
+}
+
+// ClientMeta describes a connected WebSocket client
+type ClientMeta struct {
+	IP            string    `json:"ip"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	MessagesSent  int       `json:"messagesSent"`
+	LastMessageAt time.Time `json:"lastMessageAt"`
+}
+
+// ClientMessage is a command sent by a WebSocket client
+type ClientMessage struct {
+	Type string `json:"type"`
+}
+
+// Snapshot is the full current state sent to a client in response to a sync command
+type Snapshot struct {
+	Type        string  `json:"type"`
+	Count       *int    `json:"count,omitempty"`
+	TotalClicks *int    `json:"totalClicks,omitempty"`
+	Quotes      []Quote `json:"quotes"`
+	Clients     int     `json:"clients"`
+	Seq         uint64  `json:"seq"`
 }
 
-// CounterUpdate represents a counter value update
+// snapshotQuoteLimit is the number of recent quotes included in a snapshot
+const snapshotQuoteLimit = 20
+
+// maxClientMessageBytes is the largest message a client may send. Commands like {"type":"sync"}
+// are tiny, so anything bigger closes the connection rather than being buffered.
+const maxClientMessageBytes = 512
+
+// syncInterval is the shortest time between snapshots sent to one client, as each costs a
+// counter read and a quotes query
+const syncInterval = time.Second
+
+// wsWriteTimeout is how long a write to one WebSocket client may take before it's disconnected.
+// Each client is written to from its own goroutine, so a slow one only holds up its own messages.
+const wsWriteTimeout = 5 * time.Second
+
+// wsClientQueueSize is how many messages can wait to be written to one client. When a slow
+// client's queue is full the oldest message is dropped.
+const wsClientQueueSize = 16
+
+// Message types sent by the hub
+const (
+	messageTypeUpdate    = "update"
+	messageTypeSnapshot  = "snapshot"
+	messageTypeReactions = "reactions"
+)
+
+// CounterUpdate represents a counter value update.
+// Seq increases by one with every broadcast, so a gap means the client missed an update.
+// TotalClicks is nil, and left out, only when total clicks aren't counted.
 type CounterUpdate struct {
-	Count       int `json:"count"`
-	TotalClicks int `json:"totalClicks"`
+	Type string `json:"type"`
+	// Count is the webhook counter, left out when it isn't counted
+	Count       *int `json:"count,omitempty"`
+	MaxSeen     int  `json:"maxSeen,omitempty"`
+	TotalClicks *int `json:"totalClicks,omitempty"`
+	// Velocity is the webhook counter's change per minute over the last few minutes
+	Velocity *float64 `json:"velocity,omitempty"`
+	Seq      uint64   `json:"seq,omitempty"`
 }
 
-// NewHub creates a new WebSocket hub
-func NewHub() *Hub {
+// NewHub creates a new WebSocket hub. Up to broadcastBuffer broadcasts (WS_BROADCAST_BUFFER)
+// can wait for the hub, so a burst of increments doesn't hold up the handlers sending them.
+func NewHub(broadcastBuffer int) *Hub {
 	return &Hub{
-		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan CounterUpdate),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
+		clients:   make(map[*websocket.Conn]*wsClient),
+		streams:   make(map[chan CounterUpdate]struct{}),
+		broadcast: make(chan CounterUpdate, broadcastBuffer),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		logger:    componentLogger("hub"),
 	}
 }
 
-// Run starts the hub's main loop
+// Run starts the hub's main loop. Once the hub starts shutting down it sends the broadcasts
+// still waiting, asks every client to close after its queued messages, and returns.
 func (h *Hub) Run() {
+	defer close(h.stopped)
 	for {
 		select {
-		case conn := <-h.register:
-			h.mu.Lock()
-			h.clients[conn] = true
-			h.mu.Unlock()
-			log.Printf("WebSocket client connected. Total clients: %d", len(h.clients))
-
-		case conn := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[conn]; ok {
-				delete(h.clients, conn)
-				conn.Close()
-			}
-			h.mu.Unlock()
-			log.Printf("WebSocket client disconnected. Total clients: %d", len(h.clients))
+		case update := <-h.broadcast:
+			h.send(update)
+		case <-h.done:
+			h.drain()
+			h.closeAll(websocket.CloseGoingAway, "Server shutting down")
+			h.closeStreams()
+			return
+		}
+	}
+}
 
+// drain sends the broadcasts waiting in the channel
+func (h *Hub) drain() {
+	for {
+		select {
 		case update := <-h.broadcast:
-			h.mu.Lock()
-			for conn := range h.clients {
-				err := conn.WriteJSON(update)
-				if err != nil {
-					log.Printf("WebSocket write error: %v", err)
-					conn.Close()
-					delete(h.clients, conn)
+			h.send(update)
+		default:
+			return
+		}
+	}
+}
+
+// send numbers an update and queues it for every client
+func (h *Hub) send(update CounterUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	update.Type = messageTypeUpdate
+	update.Seq = h.seq
+	for _, c := range h.clients {
+		c.enqueue(update)
+	}
+	for sub := range h.streams {
+		sendLatest(sub, update)
+	}
+}
+
+// sendLatest sends update to sub without waiting, dropping the oldest waiting update if sub's
+// buffer is full, like a WebSocket client's queue. Callers must hold h.mu, so the subscriber is
+// the only other reader.
+func sendLatest(sub chan CounterUpdate, update CounterUpdate) {
+	select {
+	case sub <- update:
+		return
+	default:
+	}
+	select {
+	case <-sub:
+	default:
+	}
+	select {
+	case sub <- update:
+	default:
+	}
+}
+
+// Subscribe returns a channel receiving every counter update from now on, for a client that
+// reads updates as an event stream rather than over a WebSocket. The channel is closed when the
+// hub shuts down, or right away if it already has. Pass it to Unsubscribe when done with it.
+func (h *Hub) Subscribe() chan CounterUpdate {
+	sub := make(chan CounterUpdate, wsClientQueueSize)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+		close(sub)
+	default:
+		h.streams[sub] = struct{}{}
+	}
+	return sub
+}
+
+// Unsubscribe stops sending counter updates to a channel from Subscribe
+func (h *Hub) Unsubscribe(sub chan CounterUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.streams[sub]; ok {
+		delete(h.streams, sub)
+		close(sub)
+	}
+}
+
+// StreamCount returns the number of event stream subscribers
+func (h *Hub) StreamCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.streams)
+}
+
+// remove forgets a client and asks its writer to close the connection, after sending closeFrame
+// if it isn't nil. Callers must hold h.mu.
+func (h *Hub) remove(c *wsClient, closeFrame []byte) {
+	if h.clients[c.conn] == c {
+		delete(h.clients, c.conn)
+		h.clientCount.Add(-1)
+	}
+	c.close(closeFrame)
+}
+
+// wsClient is a connection registered with the hub. Messages for it wait in a small queue that
+// its own goroutine writes out, so no socket I/O happens while the hub's mutex is held.
+type wsClient struct {
+	hub  *Hub
+	conn *websocket.Conn
+
+	mu    sync.Mutex
+	meta  ClientMeta
+	queue []any
+	// closing is set once the client is removed, with the close frame to send, if any, after
+	// the queue is written
+	closing    bool
+	closeFrame []byte
+
+	wake chan struct{} // signalled when a message is queued or the client is closed
+	done chan struct{} // closed once the writer has closed the connection
+}
+
+// newWSClient returns a client for conn, whose writer isn't started yet
+func newWSClient(h *Hub, conn *websocket.Conn, ip string) *wsClient {
+	return &wsClient{
+		hub:  h,
+		conn: conn,
+		meta: ClientMeta{IP: ip, ConnectedAt: time.Now()},
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+// Meta returns a copy of the client's metadata
+func (c *wsClient) Meta() ClientMeta {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.meta
+}
+
+// enqueue queues a message for the writer without waiting, dropping the oldest waiting one if
+// the queue is full. Messages for a closing client are ignored.
+func (c *wsClient) enqueue(message any) {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return
+	}
+	if len(c.queue) == wsClientQueueSize {
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+	}
+	c.queue = append(c.queue, message)
+	c.mu.Unlock()
+	c.signal()
+}
+
+// close asks the writer to send closeFrame, if it isn't nil, once the queued messages are
+// written, and then close the connection
+func (c *wsClient) close(closeFrame []byte) {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return
+	}
+	c.closing, c.closeFrame = true, closeFrame
+	c.mu.Unlock()
+	c.signal()
+}
+
+// signal wakes the writer, unless it's already due to wake
+func (c *wsClient) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes queued messages to the connection until the client is closed or a write
+// fails, when it closes the connection and removes the client from the hub
+func (c *wsClient) writeLoop() {
+	defer c.hub.writers.Done()
+	defer close(c.done)
+	defer c.conn.Close()
+
+	for range c.wake {
+		for {
+			c.mu.Lock()
+			if len(c.queue) == 0 {
+				closing, closeFrame := c.closing, c.closeFrame
+				c.mu.Unlock()
+				if !closing {
+					break
+				}
+				if closeFrame != nil {
+					c.conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(time.Second))
 				}
+				return
+			}
+			message := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+
+			if err := c.write(message); err != nil {
+				c.hub.logger.Warn("websocket write failed", "ip", c.meta.IP, "err", err)
+				c.hub.Unregister(c.conn)
+				return
 			}
-			h.mu.Unlock()
 		}
 	}
 }
 
-// wsHandler handles WebSocket connections
-func wsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// write sends a message to the connection, giving up after wsWriteTimeout, and records it in
+// the client's metadata
+func (c *wsClient) write(message any) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return err
+	}
+	if err := c.conn.WriteJSON(message); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.meta.MessagesSent++
+	c.meta.LastMessageAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// Register adds a connection to the hub and starts writing queued messages to it. Once the hub
+// has shut down the connection is closed instead.
+func (h *Hub) Register(conn *websocket.Conn, ip string) {
+	c := newWSClient(h, conn, ip)
+	h.mu.Lock()
+	select {
+	case <-h.done:
+		h.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	h.clients[conn] = c
+	h.clientCount.Add(1)
+	h.writers.Add(1)
+	h.mu.Unlock()
+
+	go c.writeLoop()
+	h.logger.Info("websocket client connected", "ip", ip, "clients", h.ClientCount())
+}
+
+// Unregister removes and closes a connection
+func (h *Hub) Unregister(conn *websocket.Conn) {
+	h.mu.Lock()
+	c, ok := h.clients[conn]
+	if ok {
+		h.remove(c, nil)
+	}
+	h.mu.Unlock()
+	if ok {
+		h.logger.Info("websocket client disconnected", "ip", c.meta.IP, "clients", h.ClientCount())
+	}
+}
+
+// Broadcast queues a counter update for every client without waiting, so a click is never
+// held up by broadcasting. If the broadcast buffer is already full, the oldest waiting update
+// is dropped to make room. That's harmless, since each update carries the counters' latest values
+// and only the newest one matters; numbering happens as updates leave the queue, so clients
+// don't see a gap either. Updates sent after shutdown starts are dropped.
+func (h *Hub) Broadcast(update CounterUpdate) {
+	for {
+		select {
+		case <-h.done:
+			return
+		default:
+		}
+
+		select {
+		case h.broadcast <- update:
+			return
+		default:
+		}
+
+		// Run is behind, so make room. Another sender may take the space first, in which case
+		// this goes round again and drops another.
+		select {
+		case <-h.broadcast:
+			hubBroadcastsDropped.Inc()
+		default:
+		}
+	}
+}
+
+// BroadcastReactions sends a quote's updated reactions to every client. Unlike counter updates
+// they aren't numbered, since each carries the quote's full counts and a missed one needs no
+// sync. Reactions sent after shutdown starts are dropped.
+func (h *Hub) BroadcastReactions(update ReactionUpdate) {
+	update.Type = messageTypeReactions
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.done:
+		return
+	default:
+	}
+	for _, c := range h.clients {
+		c.enqueue(update)
+	}
+}
+
+// Shutdown stops the hub accepting clients and broadcasts, then waits for Run to send the
+// broadcasts already waiting and for every client to be sent them, followed by a going-away
+// close frame. It returns ctx's error if ctx ends first. Run must be running.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.stopOnce.Do(func() {
+		h.mu.Lock()
+		close(h.done)
+		h.mu.Unlock()
+	})
+
+	select {
+	case <-h.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	written := make(chan struct{})
+	go func() {
+		h.writers.Wait()
+		close(written)
+	}()
+	select {
+	case <-written:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// errClientGone is returned by SendTo for a connection the hub no longer has
+var errClientGone = errors.New("WebSocket client disconnected")
+
+// SendTo queues a message for a single connection
+func (h *Hub) SendTo(conn *websocket.Conn, v any) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.clients[conn]
+	if !ok {
+		return errClientGone
+	}
+	c.enqueue(v)
+	return nil
+}
+
+// GetClients returns a snapshot of all connected clients, oldest connection first
+func (h *Hub) GetClients() []ClientMeta {
+	h.mu.Lock()
+	clients := make([]ClientMeta, 0, len(h.clients))
+	for _, c := range h.clients {
+		clients = append(clients, c.Meta())
+	}
+	h.mu.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+	return clients
+}
+
+// CloseAll sends a close frame with the given reason to every client, once their queued
+// messages are written, and disconnects them, ending the event streams too
+func (h *Hub) CloseAll(reason string) {
+	h.closeAll(websocket.CloseTryAgainLater, reason)
+	h.closeStreams()
+}
+
+// closeAll sends a close frame with the given code and reason to every client, once their
+// queued messages are written, and disconnects them
+func (h *Hub) closeAll(code int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	message := websocket.FormatCloseMessage(code, reason)
+	for _, c := range h.clients {
+		h.remove(c, message)
+	}
+}
+
+// closeStreams closes every event stream subscriber's channel, ending their streams
+func (h *Hub) closeStreams() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.streams {
+		delete(h.streams, sub)
+		close(sub)
+	}
+}
+
+// DisconnectIP sends a policy violation close frame with the given reason to every client
+// connected from ip, once their queued messages are written, and disconnects them. It returns
+// how many were disconnected.
+func (h *Hub) DisconnectIP(ip, reason string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	disconnected := 0
+	for _, c := range h.clients {
+		if c.Meta().IP == ip {
+			h.remove(c, message)
+			disconnected++
+		}
+	}
+	return disconnected
+}
+
+// ClientCount returns the number of connected clients without waiting for a broadcast to finish
+func (h *Hub) ClientCount() int {
+	return int(h.clientCount.Load())
+}
+
+// CurrentSeq returns the sequence number of the most recent broadcast
+func (h *Hub) CurrentSeq() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.seq
+}
+
+// adminWSClientsHandler lists all connected WebSocket clients, oldest connection first, as a
+// page or as JSON when the Accept header asks for it
+func (s *Server) adminWSClientsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	clients := s.hub.GetClients()
+	if wantsJSON(r) {
+		respondJSON(w, http.StatusOK, clients)
+		return
+	}
+
+	err := s.renderTemplate(w, "admin_ws.html", clients)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		s.log(r.Context(), "admin").Error("rendering websocket clients page", "err", err)
+		s.respondError(w, r, http.StatusInternalServerError, "Error rendering page")
+	}
+}
+
+// adminWSListHandler lists all connected WebSocket clients as JSON, oldest connection first
+func (s *Server) adminWSListHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, s.hub.GetClients())
+}
+
+// DisconnectRequest names the IP whose WebSocket connections an admin is closing
+type DisconnectRequest struct {
+	IP string `json:"ip"`
+}
+
+// DisconnectResult reports how many WebSocket connections were closed
+type DisconnectResult struct {
+	Disconnected int `json:"disconnected"`
+}
+
+// adminWSDisconnectHandler closes every WebSocket connection from an IP
+func (s *Server) adminWSDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	var req DisconnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(req.IP))
+	if err != nil {
+		s.respondError(w, r, http.StatusBadRequest, "Invalid IP")
+		return
+	}
+	// Client IPs are recorded the way getIPAddress formats them
+	ip := addr.Unmap().WithZone("").String()
 
-	// Register the new client
-	hub.register <- conn
+	disconnected := s.hub.DisconnectIP(ip, "disconnected by an administrator")
+	if disconnected == 0 {
+		s.respondError(w, r, http.StatusNotFound, "Not found")
+		return
+	}
 
-	// Send current counter values to new client
-	ctx := context.Background()
-	countersCollection := db.Collection("counters")
+	s.log(r.Context(), "hub").Info("disconnected websocket clients", "ip", ip, "count", disconnected)
+	s.logAdminAction(r, "ws.disconnect", ip, true)
+	respondJSON(w, http.StatusOK, DisconnectResult{Disconnected: disconnected})
+}
 
-	var webhookCounter Counter
-	var totalClicksCounter Counter
+// wsHandler handles WebSocket connections
+func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log(r.Context(), "hub").Warn("websocket upgrade failed", "ip", s.getIPAddress(r), "err", err)
+		return
+	}
 
-	countersCollection.FindOne(ctx, bson.M{"_id": "webhook"}).Decode(&webhookCounter)
-	countersCollection.FindOne(ctx, bson.M{"_id": "totalClicks"}).Decode(&totalClicksCounter)
+	// Register the new client
+	s.hub.Register(conn, s.getIPAddress(r))
 
-	conn.WriteJSON(CounterUpdate{
-		Count:       webhookCounter.Count,
-		TotalClicks: totalClicksCounter.Count,
+	// Send current counter values to new client
+	ctx, cancel := s.readContext(r)
+	webhookCount, totalClicks := s.getCounterValues(ctx)
+	cancel()
+	s.hub.SendTo(conn, CounterUpdate{
+		Type:        messageTypeUpdate,
+		Count:       optionalCount(s.config.Counting.Webhook, webhookCount),
+		TotalClicks: optionalCount(s.config.Counting.TotalClicks, totalClicks),
+		Seq:         s.hub.CurrentSeq(),
 	})
 
 	// Keep connection alive and handle cleanup
+	defer s.hub.Unregister(conn)
+
+	// Answer syncs from their own goroutine so a slow snapshot doesn't hold up reading. A sync
+	// arriving while another is waiting is merged into it.
+	ctx, stop := context.WithCancel(r.Context())
+	syncs := make(chan struct{}, 1)
+	syncsDone := make(chan struct{})
+	go func() {
+		defer close(syncsDone)
+		s.serveSyncs(r.WithContext(ctx), conn, syncs)
+	}()
 	defer func() {
-		hub.unregister <- conn
+		stop()
+		close(syncs)
+		<-syncsDone
 	}()
 
-	// Read messages (client shouldn't send any, but this keeps connection alive)
+	// Read client commands until the connection closes
+	conn.SetReadLimit(maxClientMessageBytes)
 	for {
-		_, _, err := conn.ReadMessage()
+		_, data, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
+
+		var msg ClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case "sync":
+			select {
+			case syncs <- struct{}{}:
+			default: // a snapshot is already on its way
+			}
+		}
+	}
+}
+
+// serveSyncs sends conn a snapshot for each request on syncs, at most one per syncInterval,
+// until syncs is closed or ctx is done
+func (s *Server) serveSyncs(r *http.Request, conn *websocket.Conn, syncs <-chan struct{}) {
+	limiter := rate.NewLimiter(rate.Every(syncInterval), 1)
+	for range syncs {
+		if err := limiter.Wait(r.Context()); err != nil {
+			return
+		}
+
+		ctx, cancel := s.readContext(r)
+		snapshot := s.buildSnapshot(ctx)
+		cancel()
+		if err := s.hub.SendTo(conn, snapshot); err != nil {
+			s.log(r.Context(), "hub").Warn("sending websocket snapshot", "err", err)
+		}
+	}
+}
+
+// buildSnapshot gathers the current counters, latest quotes, and presence
+func (s *Server) buildSnapshot(ctx context.Context) Snapshot {
+	// Read the sequence first so a broadcast racing with the reads is never skipped
+	seq := s.hub.CurrentSeq()
+	webhookCount, totalClicks := s.getCounterValues(ctx)
+
+	quotes, err := s.quotes.LatestQuotes(ctx, snapshotQuoteLimit)
+	if err != nil {
+		s.log(ctx, "quotes").Error("getting quotes for snapshot", "err", err)
+		quotes = []Quote{}
+	}
+
+	return Snapshot{
+		Type:        messageTypeSnapshot,
+		Count:       optionalCount(s.config.Counting.Webhook, webhookCount),
+		TotalClicks: optionalCount(s.config.Counting.TotalClicks, totalClicks),
+		Quotes:      quotes,
+		Clients:     s.hub.ClientCount(),
+		Seq:         seq,
 	}
 }
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startHub runs a hub set up from config behind a test server that registers every connection
+// with it, as wsHandler does
+func startHub(t *testing.T, config Config) (*Hub, string) {
+	t.Helper()
+	h := NewHub(config.WSBroadcastBuffer)
+	go h.Run()
+	t.Cleanup(func() { h.Shutdown(context.Background()) })
+
+	s := &Server{config: config}
+	upgrader := newUpgrader(config)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		h.Register(conn, s.getIPAddress(r))
+		defer h.Unregister(conn)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return h, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// dial connects a WebSocket client to url
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitForClients waits for the hub to count want connected clients
+func waitForClients(t *testing.T, h *Hub, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for h.ClientCount() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("ClientCount() = %d, want %d", h.ClientCount(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubCountsClients(t *testing.T) {
+	h, url := startHub(t, defaultConfig())
+	first := dialHub(t, url)
+	dialHub(t, url)
+	waitForClients(t, h, 2)
+
+	// Counting doesn't wait for the hub's lock
+	h.mu.Lock()
+	counted := make(chan int)
+	go func() { counted <- h.ClientCount() }()
+	select {
+	case n := <-counted:
+		if n != 2 {
+			t.Errorf("ClientCount() = %d while the hub is busy, want 2", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("ClientCount() waited for the hub's lock")
+	}
+	h.mu.Unlock()
+
+	first.Close()
+	waitForClients(t, h, 1)
+}
+
+func TestClientQueueDropsOldestMessages(t *testing.T) {
+	// The writer isn't started, so nothing takes messages off the queue
+	c := newWSClient(NewHub(1), nil, "192.0.2.1")
+	const extra = 3
+	for i := range wsClientQueueSize + extra {
+		c.enqueue(CounterUpdate{Count: optionalCount(true, i+1)})
+	}
+
+	if len(c.queue) != wsClientQueueSize {
+		t.Fatalf("queue holds %d messages, want %d", len(c.queue), wsClientQueueSize)
+	}
+	for i, message := range c.queue {
+		if want := extra + i + 1; countOf(message.(CounterUpdate).Count) != want {
+			t.Fatalf("queue[%d] = %+v, want count %d", i, message, want)
+		}
+	}
+}
+
+func TestBroadcastDropsOldestWhenBufferIsFull(t *testing.T) {
+	// Run isn't started, so nothing takes updates out of the buffer
+	const buffer, extra = 4, 3
+	h := NewHub(buffer)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range buffer + extra {
+			h.Broadcast(CounterUpdate{Count: optionalCount(true, i+1)})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Broadcast blocked on a full buffer")
+	}
+
+	if len(h.broadcast) != buffer {
+		t.Fatalf("buffer holds %d updates, want %d", len(h.broadcast), buffer)
+	}
+	for i := range buffer {
+		if update, want := <-h.broadcast, extra+i+1; countOf(update.Count) != want {
+			t.Errorf("buffered update %d has count %d, want %d", i, countOf(update.Count), want)
+		}
+	}
+}
+
+func TestIncrementDoesNotWaitForBroadcasts(t *testing.T) {
+	s := newTestServer(t)
+	// A hub that isn't running never takes updates, like one that's fallen far behind. The
+	// running one is put back for newTestServer to shut down.
+	if err := s.counters.InitCounters(context.Background(), builtInCounters...); err != nil {
+		t.Fatal(err)
+	}
+	running := s.hub
+	s.hub = NewHub(2)
+	t.Cleanup(func() { s.hub = running })
+	h := s.routes()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 10 {
+			serveRequest(h, http.MethodPost, "/increment", "", "")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("increments blocked on the full broadcast buffer")
+	}
+	if counter, err := s.counters.GetCounter(context.Background(), "webhook"); err != nil || counter.Count != 10 {
+		t.Errorf("webhook counter = %+v, %v; want 10", counter, err)
+	}
+}
+
+func TestStuckClientDoesNotHoldUpBroadcasts(t *testing.T) {
+	h, url := startHub(t, defaultConfig())
+	client := dial(t, url)
+	waitForClients(t, h, 1)
+
+	// A client whose writer never gets through its queue, as when its socket stops draining
+	stuck := newWSClient(h, &websocket.Conn{}, "192.0.2.1")
+	h.mu.Lock()
+	h.clients[stuck.conn] = stuck
+	h.mu.Unlock()
+	t.Cleanup(func() {
+		h.mu.Lock()
+		delete(h.clients, stuck.conn)
+		h.mu.Unlock()
+	})
+
+	for i := range wsClientQueueSize {
+		h.broadcast <- CounterUpdate{Count: optionalCount(true, i+1)}
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for want := 1; want <= wsClientQueueSize; want++ {
+		var update CounterUpdate
+		if err := client.ReadJSON(&update); err != nil {
+			t.Fatalf("reading broadcast %d: %v", want, err)
+		}
+		if countOf(update.Count) != want {
+			t.Fatalf("broadcast %d has count %d", want, countOf(update.Count))
+		}
+	}
+
+	// The hub isn't blocked on the stuck client either
+	listed := make(chan int)
+	go func() { listed <- len(h.GetClients()) }()
+	select {
+	case n := <-listed:
+		if n != 2 {
+			t.Errorf("GetClients() lists %d clients, want 2", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("GetClients() blocked while a client is stuck")
+	}
+	stuck.mu.Lock()
+	queued := len(stuck.queue)
+	stuck.mu.Unlock()
+	if queued != wsClientQueueSize {
+		t.Errorf("stuck client's queue holds %d messages, want %d", queued, wsClientQueueSize)
+	}
+}
+
+func TestUpgraderUsesConfiguredSettings(t *testing.T) {
+	config := defaultConfig()
+	config.WSReadBufferSize, config.WSWriteBufferSize, config.WSEnableCompression = 2048, 4096, true
+	upgrader := newUpgrader(config)
+
+	if upgrader.ReadBufferSize != 2048 || upgrader.WriteBufferSize != 4096 || !upgrader.EnableCompression {
+		t.Errorf("upgrader buffers %d/%d, compression %v, want 2048/4096 and compression",
+			upgrader.ReadBufferSize, upgrader.WriteBufferSize, upgrader.EnableCompression)
+	}
+}
+
+func TestWebSocketCompressionNegotiation(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		config := defaultConfig()
+		config.WSReadBufferSize, config.WSWriteBufferSize, config.WSEnableCompression = 1024, 1024, enabled
+		h, url := startHub(t, config)
+
+		dialer := websocket.Dialer{EnableCompression: true}
+		conn, resp, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dialing with compression enabled %v: %v", enabled, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		negotiated := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+		if negotiated != enabled {
+			t.Errorf("with compression enabled %v, negotiated permessage-deflate = %v", enabled, negotiated)
+		}
+
+		// Messages arrive intact either way
+		waitForClients(t, h, 1)
+		h.broadcast <- CounterUpdate{Count: optionalCount(true, 42)}
+		var update CounterUpdate
+		for countOf(update.Count) != 42 {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if err := conn.ReadJSON(&update); err != nil {
+				t.Fatalf("with compression enabled %v, reading: %v", enabled, err)
+			}
+		}
+	}
+}
+
+func TestBroadcastsAreNumberedInOrder(t *testing.T) {
+	h, url := startHub(t, defaultConfig())
+	client := dialHub(t, url)
+	waitForClients(t, h, 1)
+
+	h.Broadcast(CounterUpdate{Count: optionalCount(true, 1)})
+	h.Broadcast(CounterUpdate{Count: optionalCount(true, 2)})
+	first, err := client.NextUpdate(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.NextUpdate(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countOf(first.Count) != 1 || countOf(second.Count) != 2 {
+		t.Fatalf("got counts %d and %d, want the broadcasts in order", countOf(first.Count), countOf(second.Count))
+	}
+	if first.Seq == 0 || second.Seq != first.Seq+1 {
+		t.Errorf("got seqs %d and %d, want consecutive numbers", first.Seq, second.Seq)
+	}
+	if h.CurrentSeq() != second.Seq {
+		t.Errorf("CurrentSeq() = %d, want the last broadcast's %d", h.CurrentSeq(), second.Seq)
+	}
+}
+
+func TestSyncCommandSendsSnapshot(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	for range 3 {
+		s.counters.IncrementCounter(ctx, "webhook")
+	}
+	s.counters.IncrementCounter(ctx, "totalClicks")
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	conn := dial(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws")
+	var first Envelope
+	if err := conn.ReadJSON(&first); err != nil {
+		t.Fatal(err)
+	}
+	s.hub.Broadcast(CounterUpdate{Type: messageTypeUpdate, Count: optionalCount(true, 3)})
+	var update Envelope
+	if err := conn.ReadJSON(&update); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages that aren't commands are ignored rather than closing the connection
+	for _, message := range []string{`not json`, `{"type":"unknown"}`, `{"type":"sync"}`} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var snapshot Envelope
+	if err := conn.ReadJSON(&snapshot); err != nil {
+		t.Fatalf("reading the snapshot: %v", err)
+	}
+	if snapshot.Type != messageTypeSnapshot || countOf(snapshot.Count) != 3 || snapshot.TotalClicks == nil || *snapshot.TotalClicks != 1 || snapshot.Clients != 1 {
+		t.Errorf("snapshot = %+v, want the counters and one client", snapshot)
+	}
+	// The snapshot carries the last broadcast's number, so the client knows where it's up to
+	if snapshot.Seq != update.Seq {
+		t.Errorf("snapshot seq = %d, want the last broadcast's %d", snapshot.Seq, update.Seq)
+	}
+}
+
+func TestShutdownDeliversPendingBroadcasts(t *testing.T) {
+	h, url := startHub(t, defaultConfig())
+	client := dialHub(t, url)
+	waitForClients(t, h, 1)
+
+	h.Broadcast(CounterUpdate{Count: optionalCount(true, 7)})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	update, err := client.NextUpdate(2 * time.Second)
+	if err != nil {
+		t.Fatalf("reading the pending broadcast: %v", err)
+	}
+	if countOf(update.Count) != 7 {
+		t.Errorf("got count %d, want 7", countOf(update.Count))
+	}
+	_, err = client.NextUpdate(2 * time.Second)
+	if closeErr := (*websocket.CloseError)(nil); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("after the broadcast got %v, want a going-away close", err)
+	}
+
+	// Broadcasts after shutdown are dropped rather than waiting for the stopped hub
+	h.Broadcast(CounterUpdate{Count: optionalCount(true, 8)})
+}
+
+func TestAdminListsAndDisconnectsWebSocketClients(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	clients := []*HubClient{dialHub(t, url), dialHub(t, url)}
+	waitForClients(t, s.hub, 2)
+
+	// The clients page lists the same clients when asked for JSON
+	for _, target := range []string{"/admin/ws", "/admin/ws/clients"} {
+		r := newJSONRequest(http.MethodGet, target, "")
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("Authorization", "Bearer secret")
+		w := serve(h, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+		var listed []ClientMeta
+		if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+			t.Fatalf("GET %s: decoding %s: %v", target, w.Body, err)
+		}
+		if len(listed) != 2 {
+			t.Fatalf("GET %s listed %d clients, want 2", target, len(listed))
+		}
+		for _, client := range listed {
+			if client.IP != "127.0.0.1" || client.ConnectedAt.IsZero() {
+				t.Errorf("GET %s listed client %+v, want IP 127.0.0.1 and a connected-at time", target, client)
+			}
+		}
+	}
+
+	disconnect := func(body string) *httptest.ResponseRecorder {
+		r := newJSONRequest(http.MethodPost, "/admin/ws/disconnect", body)
+		r.Header.Set("Authorization", "Bearer secret")
+		return serve(h, r)
+	}
+	if w := disconnect(`{"ip":"not an ip"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("disconnecting an invalid IP: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := disconnect(`{"ip":"192.0.2.1"}`); w.Code != http.StatusNotFound {
+		t.Errorf("disconnecting an IP with no connections: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w := disconnect(`{"ip":"127.0.0.1"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("disconnect status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var result DisconnectResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Disconnected != 2 {
+		t.Errorf("disconnected %d clients, want 2", result.Disconnected)
+	}
+	waitForClients(t, s.hub, 0)
+
+	for i, client := range clients {
+		var err error
+		for err == nil {
+			_, err = client.NextUpdate(2 * time.Second)
+		}
+		if closeErr := (*websocket.CloseError)(nil); !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Errorf("client %d got %v, want a policy violation close", i, err)
+		}
+	}
+
+	for _, target := range []string{"/admin/ws", "/admin/ws/clients", "/admin/ws/disconnect"} {
+		method := http.MethodGet
+		if target == "/admin/ws/disconnect" {
+			method = http.MethodPost
+		}
+		if w := serveRequest(h, method, target, `{"ip":"127.0.0.1"}`, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token: status = %d, want %d", method, target, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestAdminWebSocketClientsPage(t *testing.T) {
+	s := newTestServer(t)
+	s.config.AdminToken = "secret"
+	h := s.routes()
+	get := func() *httptest.ResponseRecorder {
+		r := newJSONRequest(http.MethodGet, "/admin/ws/clients", "")
+		r.Header.Set("Authorization", "Bearer secret")
+		return serve(h, r)
+	}
+
+	w := get()
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("page = %d %q, want a 200 HTML page", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{`<meta http-equiv="refresh" content="5">`, "<strong>0</strong>", "No clients connected."} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("page with no clients doesn't contain %s", want)
+		}
+	}
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	client := dialHub(t, server.URL)
+	client.NextUpdate(2 * time.Second)
+	waitForClients(t, s.hub, 1)
+	body := get().Body.String()
+	for _, want := range []string{"<strong>1</strong>", "<td>127.0.0.1</td>", "<td>1</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page with a client doesn't contain %s", want)
+		}
+	}
+	if strings.Contains(body, "No clients connected.") {
+		t.Error("page with a client says none are connected")
+	}
+}
+
+func TestHomePageReportsConnectedClients(t *testing.T) {
+	setGitHubRepos(t, nil)
+	s := newTestServer(t)
+	h := s.routes()
+	if w := serveRequest(h, http.MethodGet, "/", "", ""); w.Header().Get("X-WS-Connected-Clients") != "0" {
+		t.Errorf("X-WS-Connected-Clients with no clients = %q, want 0", w.Header().Get("X-WS-Connected-Clients"))
+	}
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	dialHub(t, server.URL)
+	dialHub(t, server.URL)
+	waitForClients(t, s.hub, 2)
+	// The count is current even when the page itself comes from the cache
+	for range 2 {
+		if w := serveRequest(h, http.MethodGet, "/", "", ""); w.Header().Get("X-WS-Connected-Clients") != "2" {
+			t.Errorf("X-WS-Connected-Clients with two clients = %q, want 2", w.Header().Get("X-WS-Connected-Clients"))
+		}
+	}
+}
+
+func TestWebSocketLeavesOutUncountedWebhook(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Counting = CounterSettings{PageViews: true, TotalClicks: true}
+	ctx := context.Background()
+	s.counters.IncrementCounter(ctx, "webhook")
+	s.counters.IncrementCounter(ctx, "totalClicks")
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	conn := dial(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var hello Envelope
+	if err := conn.ReadJSON(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello.Count != nil || countOf(hello.TotalClicks) != 1 {
+		t.Errorf("first message = %+v, want total clicks without the webhook count", hello)
+	}
+
+	if err := conn.WriteJSON(ClientMessage{Type: "sync"}); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot Envelope
+	if err := conn.ReadJSON(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Type != messageTypeSnapshot || snapshot.Count != nil {
+		t.Errorf("snapshot = %+v, want one without the webhook count", snapshot)
+	}
+
+	// Nor does a reset of the uncounted webhook counter broadcast its count
+	s.resetCounters(ctx, []string{"webhook"})
+	var reset Envelope
+	if err := conn.ReadJSON(&reset); err != nil {
+		t.Fatal(err)
+	}
+	if reset.Count != nil || countOf(reset.TotalClicks) != 1 {
+		t.Errorf("reset broadcast = %+v, want total clicks without the webhook count", reset)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Envelope decodes any message sent by the hub. Type is "update" for counter updates,
+// whose fields are a subset of a snapshot's, "snapshot" for replies to a sync command, and
+// "reactions" for a quote's reaction counts, which only set QuoteID and Reactions.
+// TotalClicks is nil when total clicks aren't counted, and MaxSeen is only set on updates
+// from an increment or decrement.
+type Envelope struct {
+	Type        string         `json:"type"`
+	Count       *int           `json:"count,omitempty"`
+	MaxSeen     int            `json:"maxSeen,omitempty"`
+	TotalClicks *int           `json:"totalClicks,omitempty"`
+	Velocity    *float64       `json:"velocity,omitempty"`
+	Seq         uint64         `json:"seq"`
+	Quotes      []Quote        `json:"quotes,omitempty"`
+	Clients     int            `json:"clients,omitempty"`
+	QuoteID     string         `json:"quoteId,omitempty"`
+	Reactions   map[string]int `json:"reactions,omitempty"`
+}
+
+// HubClient is a minimal client for the /ws endpoint, so tests can watch broadcasts without
+// hand-rolling the read loop
+type HubClient struct {
+	conn *websocket.Conn
+}
+
+// DialHub connects to the hub at url, which may be the site's http(s) base URL or a ws(s) URL
+func DialHub(url string) (*HubClient, error) {
+	switch {
+	case strings.HasPrefix(url, "http://"):
+		url = "ws://" + strings.TrimPrefix(url, "http://")
+	case strings.HasPrefix(url, "https://"):
+		url = "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	if !strings.HasSuffix(url, "/ws") {
+		url = strings.TrimSuffix(url, "/") + "/ws"
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dialing hub: %w", err)
+	}
+	return &HubClient{conn: conn}, nil
+}
+
+// NextUpdate waits up to timeout for the next message from the hub
+func (c *HubClient) NextUpdate(timeout time.Duration) (Envelope, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return Envelope{}, err
+	}
+
+	var envelope Envelope
+	if err := c.conn.ReadJSON(&envelope); err != nil {
+		return Envelope{}, fmt.Errorf("reading hub message: %w", err)
+	}
+	return envelope, nil
+}
+
+// RequestSync asks the hub for a snapshot, which arrives as a later NextUpdate
+func (c *HubClient) RequestSync() error {
+	return c.conn.WriteJSON(ClientMessage{Type: "sync"})
+}
+
+// dialHub connects a HubClient to the hub served at url, closing it when the test ends
+func dialHub(t *testing.T, url string) *HubClient {
+	t.Helper()
+	client, err := DialHub(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Close closes the connection
+func (c *HubClient) Close() error {
+	return c.conn.Close()
+}
+
+func TestHubClientReceivesCounterBroadcasts(t *testing.T) {
+	s := newTestServer(t)
+	h := s.routes()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	// The site's base URL is enough to find the hub
+	client := dialHub(t, server.URL)
+	if update, err := client.NextUpdate(2 * time.Second); err != nil || update.Type != messageTypeUpdate || countOf(update.Count) != 0 {
+		t.Fatalf("first message = %+v, %v; want an update with the current count", update, err)
+	}
+
+	if w := serveRequest(h, http.MethodPost, "/increment", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("POST /increment status = %d, want %d", w.Code, http.StatusOK)
+	}
+	update, err := client.NextUpdate(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Type != messageTypeUpdate || countOf(update.Count) != 1 || update.Seq == 0 {
+		t.Errorf("broadcast = %+v, want a numbered update with count 1", update)
+	}
+
+	// With nothing more to read, NextUpdate gives up at the timeout
+	if update, err := client.NextUpdate(50 * time.Millisecond); err == nil {
+		t.Errorf("NextUpdate with nothing sent = %+v, want a timeout error", update)
+	}
+}
+
+func TestHubClientReceivesQuotesInSnapshots(t *testing.T) {
+	s := newTestServer(t)
+	quote, _ := newQuote("Ada", "Simplicity is prerequisite for reliability", "", nil)
+	if err := s.quotes.InsertQuote(context.Background(), quote); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	client := dialHub(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws")
+	if _, err := client.NextUpdate(2 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RequestSync(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := client.NextUpdate(2 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Type != messageTypeSnapshot || len(snapshot.Quotes) != 1 || snapshot.Quotes[0].Quote != quote.Quote || snapshot.Clients != 1 {
+		t.Errorf("snapshot = %+v, want the quote and one client", snapshot)
+	}
+}